@@ -0,0 +1,147 @@
+// Package audit keeps an append-only trail of the DNS changes made by the controller, as JSON lines.
+// It is separate from the operational logs, so that the history of the records can be kept and parsed on its own.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Stdout is the audit log path which writes the audit log to the standard output.
+// The operational logs go to the standard error, so that both streams stay apart.
+const Stdout = "-"
+
+// Operations recorded in the audit log
+const (
+	OperationCreate = "create" // a record was created
+	OperationUpdate = "update" // the settings of a record were updated
+	OperationDelete = "delete" // a record was deleted
+	OperationAdopt  = "adopt"  // an existing record was marked as created by the controller
+)
+
+// Entry is a line of the audit log
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Controller string    `json:"controller"`
+	SyncID     string    `json:"sync_id,omitempty"`
+	ZoneID     string    `json:"zone_id"`
+	Operation  string    `json:"operation"`
+	Name       string    `json:"name"` // name of the record
+	RecordID   string    `json:"record_id,omitempty"`
+	OldContent string    `json:"old_content,omitempty"` // content of the record before the change, if it existed
+	NewContent string    `json:"new_content,omitempty"` // content of the record after the change, if it still exists
+}
+
+// The audit log of the process. Guarded by mu.
+var (
+	mu   sync.Mutex
+	path string    // path of the audit log file
+	out  io.Writer // nil unless the audit log is set up
+	file *os.File  // nil unless the audit log is a file
+)
+
+// Setup opens the audit log at the path, appending to the file if it exists. Stdout writes it to the standard output.
+// With an empty path the audit log is disabled, and recording entries costs nothing.
+// The returned function closes the audit log.
+func Setup(p string) (func() error, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch p {
+	case "":
+		return func() error { return nil }, nil
+	case Stdout:
+		path, out, file = p, os.Stdout, nil
+	default:
+		f, err := openFile(p)
+		if err != nil {
+			return nil, err
+		}
+		path, out, file = p, f, f
+	}
+
+	return closeLog, nil
+}
+
+// closeLog closes the audit log, after which entries are no longer recorded
+func closeLog() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var err error
+	if file != nil {
+		err = file.Close()
+	}
+	path, out, file = "", nil, nil
+	return err
+}
+
+// Reopen reopens the audit log file, so that a file moved away, e.g. by logrotate, is replaced by a new one.
+// It does nothing unless the audit log is a file.
+// If the file cannot be reopened, the entries keep going to the previous one.
+func Reopen() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if file == nil {
+		return nil
+	}
+	f, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	previous := file
+	out, file = f, f
+	return previous.Close()
+}
+
+func openFile(p string) (*os.File, error) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open the audit log: %w", err)
+	}
+	return f, nil
+}
+
+type syncIDKey struct{}
+
+// WithSyncID returns a context whose changes are recorded with the ID of the sync making them
+func WithSyncID(ctx context.Context, syncID string) context.Context {
+	return context.WithValue(ctx, syncIDKey{}, syncID)
+}
+
+// Record appends an entry to the audit log, and flushes it to disk. It does nothing unless the audit log is set up.
+// The time and the ID of the sync are filled in, the latter from the context.
+// Failures are logged: the change was made, and failing the sync would not undo it.
+func Record(ctx context.Context, entry Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if out == nil {
+		return
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.SyncID, _ = ctx.Value(syncIDKey{}).(string)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to encode audit log entry", "error", err)
+		return
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		log.FromContext(ctx).Error("Failed to write audit log entry", "error", err, "entry", string(line))
+		return
+	}
+	if file != nil {
+		if err := file.Sync(); err != nil {
+			log.FromContext(ctx).Error("Failed to flush audit log", "error", err)
+		}
+	}
+}
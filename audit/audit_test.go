@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readEntries returns the entries of the audit log file
+func readEntries(t *testing.T, p string) []Entry {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("audit log line %q is not JSON: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRecord(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "audit.log")
+	closeAudit, err := Setup(p)
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	defer closeAudit()
+
+	ctx := WithSyncID(context.Background(), "0123456789abcdef")
+	Record(ctx, Entry{Controller: "test", ZoneID: "zone", Operation: OperationCreate, Name: "test.example.com", NewContent: "1.1.1.1"})
+	Record(ctx, Entry{Controller: "test", ZoneID: "zone", Operation: OperationDelete, Name: "test.example.com", RecordID: "stale", OldContent: "2.2.2.2"})
+
+	entries := readEntries(t, p)
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want 2", len(entries))
+	}
+	if entries[0].Operation != OperationCreate || entries[0].NewContent != "1.1.1.1" || entries[0].SyncID != "0123456789abcdef" || entries[0].Time.IsZero() {
+		t.Errorf("first entry = %+v, want the creation of 1.1.1.1 by the sync", entries[0])
+	}
+	if entries[1].Operation != OperationDelete || entries[1].OldContent != "2.2.2.2" || entries[1].RecordID != "stale" {
+		t.Errorf("second entry = %+v, want the deletion of 2.2.2.2", entries[1])
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "audit.log")
+	closeAudit, err := Setup(p)
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	defer closeAudit()
+
+	Record(context.Background(), Entry{Operation: OperationCreate, NewContent: "1.1.1.1"})
+
+	// logrotate moves the file away, then asks for it to be reopened
+	rotated := filepath.Join(dir, "audit.log.1")
+	if err := os.Rename(p, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reopen(); err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	Record(context.Background(), Entry{Operation: OperationCreate, NewContent: "2.2.2.2"})
+
+	if entries := readEntries(t, rotated); len(entries) != 1 || entries[0].NewContent != "1.1.1.1" {
+		t.Errorf("rotated audit log = %+v, want the first entry only", entries)
+	}
+	if entries := readEntries(t, p); len(entries) != 1 || entries[0].NewContent != "2.2.2.2" {
+		t.Errorf("new audit log = %+v, want the second entry only", entries)
+	}
+}
+
+func TestRecordDisabled(t *testing.T) {
+	closeAudit, err := Setup("")
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	defer closeAudit()
+
+	// Nothing is set up, so nothing is written nor fails
+	Record(context.Background(), Entry{Operation: OperationCreate})
+	if err := Reopen(); err != nil {
+		t.Errorf("Reopen() error = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which only moves when advanced
+type fakeClock struct {
+	mu         sync.Mutex
+	now        time.Time
+	waiters    []fakeWaiter
+	tickers    []*fakeTicker
+	afterCalls int
+}
+
+// fakeWaiter is a pending call to After
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// fakeTicker is a ticker of the fake clock
+type fakeTicker struct {
+	period  time.Duration
+	next    time.Time
+	ch      chan time.Time
+	stopped atomic.Bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() { t.stopped.Store(true) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.afterCalls++
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{period: d, next: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing the waiters and tickers which are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+
+	var pending []fakeWaiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+
+	for _, t := range c.tickers {
+		for !t.stopped.Load() && !t.next.After(c.now) {
+			// Like time.Ticker, drop ticks for slow receivers
+			select {
+			case t.ch <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// waitFor waits until the condition on the clock holds, e.g. until the code under test called After
+func (c *fakeClock) waitFor(t *testing.T, condition func(*fakeClock) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		ok := condition(c)
+		c.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the clock to be used")
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	after := c.After(2 * time.Second)
+	tick := c.NewTicker(time.Second)
+
+	c.Advance(time.Second)
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	default:
+	}
+	if got := <-tick.C(); !got.Equal(c.Now()) {
+		t.Errorf("tick = %v, want %v", got, c.Now())
+	}
+
+	c.Advance(time.Second)
+	select {
+	case <-after:
+	default:
+		t.Fatal("After did not fire once due")
+	}
+}
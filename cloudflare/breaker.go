@@ -0,0 +1,190 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+	"github.com/charmbracelet/log"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// breakerState is the state of a circuit breaker.
+// The values are exported as is by the circuit state metric.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // calls go through
+	breakerHalfOpen                     // a single trial call goes through to test recovery
+	breakerOpen                         // calls are short-circuited
+)
+
+// String returns the name of the state, for logging
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// breaker is a circuit breaker which opens after a number of consecutive transient failures,
+// short-circuits calls for a cool-down period, then lets a single call through to test recovery.
+type breaker struct {
+	threshold  int           // consecutive transient failures opening the circuit
+	cooldown   time.Duration // time the circuit stays open before a trial call
+	controller string        // name of the controller instance, used to label the metric
+	now        func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool // whether the trial call of the half-open state is in flight
+}
+
+// newBreaker returns a closed circuit breaker
+func newBreaker(threshold int, cooldown time.Duration, controller string) *breaker {
+	b := &breaker{
+		threshold:  threshold,
+		cooldown:   cooldown,
+		controller: controller,
+		now:        time.Now,
+	}
+	metrics.SetCloudflareCircuitState(controller, float64(breakerClosed))
+	return b
+}
+
+// allow reports whether a call may go through.
+// An open circuit becomes half-open once the cool-down has elapsed, and lets the next call through.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a call which was allowed through.
+// Only transient failures count: other errors mean that Cloudflare is answering.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	// Calls refused by the retry budget of the sync tell nothing about Cloudflare
+	if errors.Is(err, retrybudget.ErrExhausted) {
+		return
+	}
+	if !errors.Is(classify(err), ErrTransient) {
+		b.failures = 0
+		if b.state != breakerClosed {
+			log.Info("Cloudflare circuit breaker closed", "controller", b.controller)
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		log.Warn("Cloudflare circuit breaker opened", "controller", b.controller, "consecutive_failures", b.failures, "cooldown", b.cooldown, "error", err)
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// setState changes the state and exports it. The caller must hold the lock.
+func (b *breaker) setState(state breakerState) {
+	b.state = state
+	metrics.SetCloudflareCircuitState(b.controller, float64(state))
+}
+
+// breakerAPI is a dnsAPI which guards every call with a circuit breaker, and counts the failed calls
+type breakerAPI struct {
+	api     dnsAPI
+	breaker *breaker
+}
+
+// errCircuitOpen is returned instead of calling the API while the circuit is open
+var errCircuitOpen = fmt.Errorf("%w: %w", ErrTransient, ErrCircuitOpen)
+
+func (a *breakerAPI) ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	if !a.breaker.allow() {
+		return nil, nil, errCircuitOpen
+	}
+	recordCall := metrics.RecordCloudflareAPICall(ctx, a.breaker.controller, "list")
+	records, info, err := a.api.ListDNSRecords(ctx, rc, params)
+	recordCall()
+	a.record("list", err)
+	return records, info, err
+}
+
+func (a *breakerAPI) CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	if !a.breaker.allow() {
+		return cloudflare.DNSRecord{}, errCircuitOpen
+	}
+	recordCall := metrics.RecordCloudflareAPICall(ctx, a.breaker.controller, "create")
+	record, err := a.api.CreateDNSRecord(ctx, rc, params)
+	recordCall()
+	a.record("create", err)
+	return record, err
+}
+
+func (a *breakerAPI) UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	if !a.breaker.allow() {
+		return cloudflare.DNSRecord{}, errCircuitOpen
+	}
+	recordCall := metrics.RecordCloudflareAPICall(ctx, a.breaker.controller, "update")
+	record, err := a.api.UpdateDNSRecord(ctx, rc, params)
+	recordCall()
+	a.record("update", err)
+	return record, err
+}
+
+func (a *breakerAPI) DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error {
+	if !a.breaker.allow() {
+		return errCircuitOpen
+	}
+	recordCall := metrics.RecordCloudflareAPICall(ctx, a.breaker.controller, "delete")
+	err := a.api.DeleteDNSRecord(ctx, rc, recordID)
+	recordCall()
+	a.record("delete", err)
+	return err
+}
+
+// record updates the breaker with the outcome of a call, and counts it if it failed, by category and Cloudflare error code.
+// Calls refused by the retry budget of the sync are not counted, as they did not reach Cloudflare.
+func (a *breakerAPI) record(operation string, err error) {
+	a.breaker.record(err)
+	if err != nil && !errors.Is(err, retrybudget.ErrExhausted) {
+		category, code := errorCategory(err)
+		metrics.RecordCloudflareAPIError(a.breaker.controller, operation, category, code)
+	}
+}
+
+// VerifyAPIToken is not guarded: it does not touch the zone, and only runs at startup
+func (a *breakerAPI) VerifyAPIToken(ctx context.Context) (cloudflare.APITokenVerifyBody, error) {
+	return a.api.VerifyAPIToken(ctx)
+}
@@ -0,0 +1,182 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeClock is a clock which only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestBreaker(clock *fakeClock) *breaker {
+	b := newBreaker(3, time.Minute, "test")
+	b.now = clock.Now
+	return b
+}
+
+var errServer = cloudflare.NewServiceError(&cloudflare.Error{StatusCode: 503, Type: cloudflare.ErrorTypeService})
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := newTestBreaker(clock)
+
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d should be allowed before the threshold is reached", i+1)
+		}
+		b.record(errServer)
+	}
+	if b.state != breakerClosed {
+		t.Errorf("state after 2 failures = %v, want closed", b.state)
+	}
+
+	b.allow()
+	b.record(errServer)
+	if b.state != breakerOpen {
+		t.Fatalf("state after 3 failures = %v, want open", b.state)
+	}
+	if b.allow() {
+		t.Error("calls should be short-circuited while the circuit is open")
+	}
+}
+
+func TestBreakerIgnoresNonTransientErrors(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := newTestBreaker(clock)
+
+	b.record(errServer)
+	b.record(errServer)
+	// Cloudflare answered, even if it refused the request
+	b.record(cloudflare.NewRequestError(&cloudflare.Error{StatusCode: 400, Type: cloudflare.ErrorTypeRequest}))
+	b.record(errServer)
+	b.record(errServer)
+
+	if b.state != breakerClosed {
+		t.Errorf("state = %v, want closed since failures were not consecutive", b.state)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name     string
+		trialErr error
+		expected breakerState
+	}{
+		{name: "trial call succeeds", trialErr: nil, expected: breakerClosed},
+		{name: "trial call fails", trialErr: errServer, expected: breakerOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			b := newTestBreaker(clock)
+			for i := 0; i < 3; i++ {
+				b.record(errServer)
+			}
+
+			clock.now = clock.now.Add(time.Minute)
+			if !b.allow() {
+				t.Fatal("a trial call should be allowed after the cool-down")
+			}
+			if b.state != breakerHalfOpen {
+				t.Errorf("state during the trial call = %v, want half-open", b.state)
+			}
+			if b.allow() {
+				t.Error("only one trial call should be allowed while half-open")
+			}
+
+			b.record(tt.trialErr)
+			if b.state != tt.expected {
+				t.Errorf("state after the trial call = %v, want %v", b.state, tt.expected)
+			}
+		})
+	}
+}
+
+// failingDNSAPI is a fakeDNSAPI whose listing always fails
+type failingDNSAPI struct {
+	fakeDNSAPI
+	err   error
+	calls int
+}
+
+func (f *failingDNSAPI) ListDNSRecords(_ context.Context, _ *cloudflare.ResourceContainer, _ cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	f.calls++
+	return nil, nil, f.err
+}
+
+func TestBreakerAPIShortCircuits(t *testing.T) {
+	api := &failingDNSAPI{err: errServer}
+	guarded := &breakerAPI{api: api, breaker: newTestBreaker(&fakeClock{now: time.Now()})}
+
+	for i := 0; i < 5; i++ {
+		_, _, err := guarded.ListDNSRecords(context.Background(), cloudflare.ZoneIdentifier("zone"), cloudflare.ListDNSRecordsParams{})
+		if err == nil {
+			t.Fatal("ListDNSRecords() expected error but got none")
+		}
+		if i >= 3 && (!errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrTransient)) {
+			t.Errorf("ListDNSRecords() error = %v, want the circuit open error", err)
+		}
+	}
+
+	if api.calls != 3 {
+		t.Errorf("API called %d times, want 3", api.calls)
+	}
+}
+
+// apiCallDurations returns the number of durations of Cloudflare API calls observed for the operation of the controller
+func apiCallDurations(t *testing.T, controller, operation string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.AppMetrics.CloudflareAPIDuration.WithLabelValues(controller, operation).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("failed to read the durations of %s calls: %v", operation, err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestBreakerAPIRecordsDurations(t *testing.T) {
+	metrics.NewServer(8090)
+	api := &fakeDNSAPI{}
+	guarded := &breakerAPI{api: api, breaker: newBreaker(1, time.Minute, "breaker-durations-test")}
+	ctx, zone := context.Background(), cloudflare.ZoneIdentifier("zone")
+
+	record, err := guarded.CreateDNSRecord(ctx, zone, cloudflare.CreateDNSRecordParams{Type: "A", Name: "test.example.com", Content: "1.1.1.1"})
+	if err != nil {
+		t.Fatalf("CreateDNSRecord() unexpected error = %v", err)
+	}
+	if _, err := guarded.UpdateDNSRecord(ctx, zone, cloudflare.UpdateDNSRecordParams{ID: record.ID, Content: "2.2.2.2"}); err != nil {
+		t.Fatalf("UpdateDNSRecord() unexpected error = %v", err)
+	}
+	if _, _, err := guarded.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{}); err != nil {
+		t.Fatalf("ListDNSRecords() unexpected error = %v", err)
+	}
+	if err := guarded.DeleteDNSRecord(ctx, zone, record.ID); err != nil {
+		t.Fatalf("DeleteDNSRecord() unexpected error = %v", err)
+	}
+
+	// Calls rejected by the open circuit do not reach Cloudflare, so they take no time worth observing
+	guarded.breaker.record(errServer)
+	if _, _, err := guarded.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("ListDNSRecords() error = %v, want the circuit open error", err)
+	}
+	if api.listed != 1 {
+		t.Errorf("API listed %d times, want 1", api.listed)
+	}
+
+	for _, operation := range []string{"list", "create", "update", "delete"} {
+		if count := apiCallDurations(t, "breaker-durations-test", operation); count != 1 {
+			t.Errorf("durations of %s calls = %d, want 1", operation, count)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package cloudflare
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/reconcile"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+// recordCache keeps the A records last listed for each name, so that the syncs following each other, e.g. on a busy cluster,
+// do not list them again while they are fresh (CLOUDFLARE_CACHE_TTL).
+// The records of a name are dropped whenever the controller changes one of them. A nil cache caches nothing.
+type recordCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedRecords // by zone and canonical name, see cacheKey
+}
+
+// cachedRecords are the records of a name, as listed at a time
+type cachedRecords struct {
+	records  []internaltypes.DNSRecord
+	listedAt time.Time
+}
+
+// newRecordCache returns a cache keeping the records for ttl, or nil if ttl is zero
+func newRecordCache(ttl time.Duration) *recordCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &recordCache{ttl: ttl, now: time.Now, entries: make(map[string]cachedRecords)}
+}
+
+// cacheKey identifies the records of a name in a zone
+func cacheKey(zoneID, name string) string {
+	return zoneID + "/" + reconcile.CanonicalName(name)
+}
+
+// get returns the records of the name if they were listed less than the TTL ago
+func (c *recordCache) get(zoneID, name string) ([]internaltypes.DNSRecord, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(zoneID, name)]
+	if !ok || c.now().Sub(entry.listedAt) >= c.ttl {
+		return nil, false
+	}
+	return slices.Clone(entry.records), true
+}
+
+// put keeps the records of the name, as just listed
+func (c *recordCache) put(zoneID, name string, records []internaltypes.DNSRecord) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(zoneID, name)] = cachedRecords{records: slices.Clone(records), listedAt: c.now()}
+}
+
+// invalidate drops the records of the name, once the controller changed one of them
+func (c *recordCache) invalidate(zoneID, name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, cacheKey(zoneID, name))
+}
+
+type bypassCacheKey struct{}
+
+// WithoutCache returns a context whose listings bypass the record cache, e.g. for the syncs meant to catch the drift
+// of the records changed by someone else. The records they list are cached for the next syncs.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+// bypassesCache reports whether the listings of the context bypass the record cache
+func bypassesCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
@@ -0,0 +1,89 @@
+package cloudflare
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/reconcile"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestRecordCache(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	cache := newRecordCache(30 * time.Second)
+	cache.now = clock.Now
+	records := []internaltypes.DNSRecord{{ID: "a", Name: "test.example.com", Content: "1.1.1.1"}}
+
+	cache.put("zone", "test.example.com", records)
+	if cached, ok := cache.get("zone", "test.example.com."); !ok || len(cached) != 1 {
+		t.Errorf("get() = %v, %v for the same name, want the records", cached, ok)
+	}
+	if _, ok := cache.get("other-zone", "test.example.com"); ok {
+		t.Error("get() found the records of the name in another zone")
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	if _, ok := cache.get("zone", "test.example.com"); ok {
+		t.Error("get() found the records once the TTL elapsed")
+	}
+
+	cache.put("zone", "test.example.com", records)
+	cache.invalidate("zone", "test.example.com.")
+	if _, ok := cache.get("zone", "test.example.com"); ok {
+		t.Error("get() found the records once invalidated")
+	}
+
+	// Without a TTL, nothing is cached
+	disabled := newRecordCache(0)
+	disabled.put("zone", "test.example.com", records)
+	if _, ok := disabled.get("zone", "test.example.com"); ok {
+		t.Error("get() found the records in a disabled cache")
+	}
+}
+
+func TestGetARecordsCache(t *testing.T) {
+	owned := newFakeRecord("a", "test.example.com", "1.1.1.1", false)
+	owned.Comment = reconcile.OwnerComment
+	api := &fakeDNSAPI{records: []cloudflare.DNSRecord{owned}}
+	client := &Client{
+		api:    api,
+		config: &config.Config{CloudflareZoneID: "test-zone-id", DNSRecordName: "test.example.com"},
+		cache:  newRecordCache(time.Minute),
+	}
+	ctx := context.Background()
+
+	// A sync which changes nothing lists the records once, and the next one reuses them
+	for range 2 {
+		if _, err := client.SyncARecords(ctx, []string{"1.1.1.1"}); err != nil {
+			t.Fatalf("SyncARecords() unexpected error = %v", err)
+		}
+	}
+	if api.listed != 1 {
+		t.Errorf("listed the records %d times, want 1", api.listed)
+	}
+
+	// A sync changing a record drops them, so the next one lists them again
+	if _, err := client.SyncARecords(ctx, []string{"1.1.1.1", "2.2.2.2"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	ips, err := client.ARecordIPs(ctx, "test.example.com")
+	if err != nil {
+		t.Fatalf("ARecordIPs() unexpected error = %v", err)
+	}
+	if api.listed != 2 || len(ips) != 2 {
+		t.Errorf("listed the records %d times and found %v after a change, want 2 times and both IPs", api.listed, ips)
+	}
+
+	// A sync bypassing the cache catches the records changed by someone else
+	api.records = api.records[:1]
+	ips, err = client.ARecordIPs(WithoutCache(ctx), "test.example.com")
+	if err != nil {
+		t.Fatalf("ARecordIPs() unexpected error = %v", err)
+	}
+	if api.listed != 3 || len(ips) != 1 {
+		t.Errorf("listed the records %d times and found %v bypassing the cache, want 3 times and a single IP", api.listed, ips)
+	}
+}
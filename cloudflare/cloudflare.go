@@ -3,164 +3,496 @@ package cloudflare
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/audit"
 	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/reconcile"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/tracing"
 	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
 	"github.com/charmbracelet/log"
 	"github.com/cloudflare/cloudflare-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// dnsAPI is the subset of the Cloudflare API used by the client.
+// It is satisfied by *cloudflare.API and lets tests substitute a fake provider.
+type dnsAPI interface {
+	ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+	CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error)
+	UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error)
+	DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error
+	VerifyAPIToken(ctx context.Context) (cloudflare.APITokenVerifyBody, error)
+}
+
 // Client wraps the Cloudflare API client
 type Client struct {
-	api    *cloudflare.API
+	api    dnsAPI
 	config *config.Config
+	shadow *Client // mirrors every sync to the shadow zone. nil unless a shadow zone is configured.
+	cache  *recordCache
 }
 
 // NewClient is a function which returns a new cloudflare client and an optional error
 func NewClient(cfg *config.Config) (*Client, error) {
-	api, err := cloudflare.NewWithAPIToken(cfg.CloudflareToken)
+	api, err := cloudflare.NewWithAPIToken(cfg.CloudflareToken, cloudflare.HTTPClient(newHTTPClient(cfg)))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create cloudflare client: %w", err)
 	}
 
-	return &Client{
-		api:    api,
+	client := &Client{
+		api: &breakerAPI{
+			api:     api,
+			breaker: newBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown, cfg.Name),
+		},
 		config: cfg,
-	}, nil
-}
+		cache:  newRecordCache(cfg.CloudflareCacheTTL),
+	}
 
-// getARecords is a function of type cloudflare client which takes a context and returns all A records in a zone
-func (c *Client) getARecords(ctx context.Context) ([]internaltypes.DNSRecord, error) {
-	records, _, err := c.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), cloudflare.ListDNSRecordsParams{
-		Name: c.config.DNSRecordName,
-		Type: "A",
-	})
+	if cfg.ShadowZoneID != "" {
+		if client.shadow, err = newShadowClient(cfg); err != nil {
+			return nil, err
+		}
+	}
 
+	return client, nil
+}
+
+// VerifyToken checks with a single call that Cloudflare is reachable and that the API token is active
+func (c *Client) VerifyToken(ctx context.Context) error {
+	token, err := c.api.VerifyAPIToken(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to list DNS records: %w", err)
+		return fmt.Errorf("Failed to verify the API token: %w", classify(err))
+	}
+	if token.Status != "active" {
+		return fmt.Errorf("%w: the API token is %s", ErrAuth, token.Status)
+	}
+	return nil
+}
+
+// listPageSize is the number of records listed per page, the default of the Cloudflare API
+const listPageSize = 100
+
+// getARecords is a function of type cloudflare client which takes a context and a record name and returns all A records of that name in the zone.
+// The records listed less than CLOUDFLARE_CACHE_TTL ago are returned from the cache, unless the context bypasses it.
+func (c *Client) getARecords(ctx context.Context, name string) (_ []internaltypes.DNSRecord, err error) {
+	name = reconcile.CanonicalName(name)
+	if !bypassesCache(ctx) {
+		if records, ok := c.cache.get(c.config.CloudflareZoneID, name); ok {
+			log.FromContext(ctx).Debug("Using the cached A records", "name", name, "count", len(records))
+			return records, nil
+		}
+	}
+
+	ctx, span := c.startSpan(ctx, "cloudflare.ListDNSRecords", name)
+	defer func() { tracing.End(span, err) }()
+
+	params := cloudflare.ListDNSRecordsParams{
+		Name:       name,
+		Type:       "A",
+		ResultInfo: cloudflare.ResultInfo{Page: 1, PerPage: listPageSize},
+	}
+	// Every page is listed, otherwise the records on the later pages would be missing from the plan and their targets get duplicates
+	var records []cloudflare.DNSRecord
+	for {
+		page, info, err := c.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), params)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list DNS records: %w", classify(err))
+		}
+		records = append(records, page...)
+		if info == nil || !info.HasMorePages() {
+			break
+		}
+		params.Page = info.Page + 1
 	}
 
 	// result is a list of DNSRecords to contain the results of the lookup
 	var result []internaltypes.DNSRecord
 	// Loop over all of the records we've found and add them to the list of results
 	for _, record := range records {
+		// Only A records are managed. Other types under the same name, such as AAAA records, must never be planned for deletion,
+		// even if the API ignored the type filter.
+		if record.Type != "A" {
+			continue
+		}
+		// Names are compared without their trailing dot, which Cloudflare may or may not report
+		if reconcile.CanonicalName(record.Name) != name {
+			continue
+		}
 		result = append(result, internaltypes.DNSRecord{
 			ID:      record.ID,
 			Name:    record.Name,
 			Type:    record.Type,
 			Content: record.Content,
 			TTL:     record.TTL,
+			Proxied: record.Proxied != nil && *record.Proxied,
+			Comment: record.Comment,
 		})
 	}
 
+	c.cache.put(c.config.CloudflareZoneID, name, result)
 	return result, nil
 }
 
+// ARecordIPs returns the IPs the A records of the name currently point to, in their canonical form
+func (c *Client) ARecordIPs(ctx context.Context, name string) ([]string, error) {
+	records, err := c.getARecords(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(records))
+	for _, record := range records {
+		ips = append(ips, reconcile.CanonicalIP(record.Content))
+	}
+	return ips, nil
+}
+
 // CreateARecord is a function of type cloudflare client
-// which takes a context and a string as parameters
+// which takes a context, a record name and a target as parameters
 // and returns an error.
 // It creates a A record in Cloudflare with the specified target as content.
-func (c *Client) CreateARecord(ctx context.Context, target string) error {
-	proxy := true
-	record := cloudflare.CreateDNSRecordParams{
+func (c *Client) CreateARecord(ctx context.Context, name, target string) (err error) {
+	ctx, span := c.startSpan(ctx, "cloudflare.CreateDNSRecord", name, attribute.String("dns.record_content", target))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(c.config.CloudflareZoneID, name)
+
+	settings := c.settings(name)
+	record, err := c.createParams(internaltypes.DNSRecord{
 		Type:    "A",
-		Name:    c.config.DNSRecordName,
+		Name:    name,
 		Content: target,
-		TTL:     0,
-		Proxied: &proxy,
+		TTL:     settings.EffectiveTTL(),
+		Proxied: settings.Proxied,
+		Comment: reconcile.OwnerComment,
+	})
+	if err != nil {
+		return err
 	}
 
-	_, err := c.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), record)
+	_, err = c.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), record)
 	if err != nil {
-		return fmt.Errorf("Failed to create A record %w", err)
+		return fmt.Errorf("Failed to create A record %w", classify(err))
 	}
 
-	log.Info("Created A record", "name", c.config.DNSRecordName, "target", target)
+	log.FromContext(ctx).Info("Created A record", "name", name, "target", target)
 	return nil
 }
 
 // UpdateARecord is a function of type Cloudflare client
-// which takes a context, a recordID and a target as parameters
+// which takes a context, a recordID, a record name and a target as parameters
 // and returns an error
-// It updates an existing record with a new target.
-func (c *Client) UpdateARecord(ctx context.Context, recordID, target string) error {
-	record := cloudflare.UpdateDNSRecordParams{
+// It updates an existing record with a new target, and the desired settings and comment.
+func (c *Client) UpdateARecord(ctx context.Context, recordID, name, target string) error {
+	return c.updateARecord(ctx, recordID, name, target, c.settings(name).Comment)
+}
+
+// updateARecord updates an existing record with a new target, the desired settings and the given comment
+func (c *Client) updateARecord(ctx context.Context, recordID, name, target, comment string) (err error) {
+	ctx, span := c.startSpan(ctx, "cloudflare.UpdateDNSRecord", name, attribute.String("dns.record_id", recordID), attribute.String("dns.record_content", target))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(c.config.CloudflareZoneID, name)
+
+	settings := c.settings(name)
+	record, err := c.updateParams(internaltypes.DNSRecord{
 		ID:      recordID,
 		Type:    "A",
-		Name:    c.config.DNSRecordName,
+		Name:    name,
 		Content: target,
-		TTL:     0,
+		TTL:     settings.EffectiveTTL(),
+		Proxied: settings.Proxied,
+		Comment: comment,
+	})
+	if err != nil {
+		return err
 	}
 
-	_, err := c.api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), record)
+	_, err = c.api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), record)
 	if err != nil {
-		return fmt.Errorf("Unable to update DNS Record: %w", err)
+		return fmt.Errorf("Unable to update DNS Record: %w", classify(err))
 	}
 
-	log.Info("Updated A record", "name", c.config.DNSRecordName, "target", target)
+	log.FromContext(ctx).Info("Updated A record", "name", name, "target", target)
 	return nil
 
 }
 
-// DeleteARecord is a function of type cloudflare client which takes a context and a record ID as parameters and returns an error
-func (c *Client) DeleteARecord(ctx context.Context, recordID string) error {
-	err := c.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), recordID)
+// startSpan starts a span around a Cloudflare API call
+func (c *Client) startSpan(ctx context.Context, spanName, recordName string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes,
+		attribute.String("cloudflare.zone_id", c.config.CloudflareZoneID),
+		attribute.String("dns.record_name", recordName),
+	)
+	return tracing.Tracer().Start(ctx, spanName, trace.WithAttributes(attributes...), trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// settings returns the desired settings of the records of the name
+func (c *Client) settings(name string) reconcile.Settings {
+	ttl, proxied := c.config.RecordSettings(name)
+	return reconcile.Settings{
+		TTL:     ttl,
+		Proxied: proxied,
+		Comment: reconcile.OwnerComment,
+	}
+}
+
+// DeleteARecord is a function of type cloudflare client which takes a context, a record ID and its name as parameters and returns an error
+func (c *Client) DeleteARecord(ctx context.Context, recordID, name string) (err error) {
+	ctx, span := c.startSpan(ctx, "cloudflare.DeleteDNSRecord", name, attribute.String("dns.record_id", recordID))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(c.config.CloudflareZoneID, name)
+
+	err = c.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), recordID)
 	if err != nil {
-		return fmt.Errorf("Failed to delete A record: %w", err)
+		return fmt.Errorf("Failed to delete A record: %w", classify(err))
 	}
 	return nil
 }
 
-// SyncARecords synchronizes A records with the given target IPs
-func (c *Client) SyncARecords(ctx context.Context, targetIPs []string) error {
+// SyncARecords synchronizes the A records of the configured record name with the given target IPs
+func (c *Client) SyncARecords(ctx context.Context, targetIPs []string) (internaltypes.SyncResult, error) {
+	return c.SyncNamedARecords(ctx, c.config.DNSRecordName, targetIPs)
+}
+
+// SyncNamedARecords synchronizes the A records of the given name with the given target IPs
+func (c *Client) SyncNamedARecords(ctx context.Context, name string, targetIPs []string) (internaltypes.SyncResult, error) {
+	result, err := c.syncNamedARecords(ctx, name, targetIPs)
+
+	// The shadow zone never affects the outcome of the sync, nor spends its retry budget
+	if c.shadow != nil && ctx.Err() == nil {
+		c.compareShadow(retrybudget.WithoutBudget(ctx), name, targetIPs, result, err)
+	}
+
+	return result, err
+}
+
+// syncNamedARecords reconciles the records of the name in the zone of the client
+func (c *Client) syncNamedARecords(ctx context.Context, name string, targetIPs []string) (internaltypes.SyncResult, error) {
+	// A configured name with a trailing dot designates the same records
+	name = reconcile.CanonicalName(name)
+
 	// Get current A records
-	currentRecords, err := c.getARecords(ctx)
+	currentRecords, err := c.getARecords(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to get current A records: %w", err)
+		return internaltypes.SyncResult{Name: name}, fmt.Errorf("failed to get current A records: %w", err)
 	}
 
-	log.Info("Syncing A records", "current_count", len(currentRecords), "target_ips", targetIPs)
+	// In quiet mode, syncs which change nothing are only logged when debugging
+	level := log.InfoLevel
+	if c.config.QuietNoopSync {
+		level = log.DebugLevel
+	}
+	log.FromContext(ctx).Log(level, "Syncing A records", "name", name, "zone_id", c.config.CloudflareZoneID, "current_count", len(currentRecords), "target_ips", targetIPs)
 
-	// If no target IPs, delete all records
-	if len(targetIPs) == 0 {
-		for _, record := range currentRecords {
-			if err := c.DeleteARecord(ctx, record.ID); err != nil {
-				log.Error("Error deleting record", "record_id", record.ID, "error", err)
-			}
-		}
-		return nil
+	if c.config.AdoptExisting {
+		currentRecords = c.adoptRecords(ctx, name, currentRecords, targetIPs)
 	}
 
-	// Create maps for easier comparison
-	currentTargets := make(map[string]string) // target -> recordID
-	for _, record := range currentRecords {
-		currentTargets[record.Content] = record.ID
+	changes := reconcile.Plan(currentRecords, targetIPs, c.settings(name))
+	if !c.config.AddOnly {
+		// Pointing a stale record at a new target takes a single call, and the name never loses a record meanwhile.
+		// In add-only mode, the stale records must be kept, so new targets get new records.
+		changes = changes.InPlace()
+		warnUnowned(ctx, name, changes.ToRemove)
 	}
+	result := c.apply(ctx, name, changes)
+	applied := reconcile.Applied(currentRecords, targetIPs, result)
+	metrics.SetRecordSetHash(c.config.Name, name, reconcile.Hash(name, applied))
+	metrics.SetManagedIPs(c.config.Name, name, applied)
 
-	targetSet := make(map[string]bool)
+	// Operations failing because the sync was aborted are only logged by apply
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("sync interrupted: %w", err)
+	}
+	// So are those failing fast once the retry budget of the sync was spent, which the next sync retries
+	if retrybudget.Exhausted(ctx) {
+		return result, fmt.Errorf("%w: %w", ErrTransient, retrybudget.ErrExhausted)
+	}
+
+	return result, nil
+}
+
+// adoptRecords marks the records of the name which were not created by the controller as owned, so that they are managed from now on.
+// Adopted records which do not point to a target are kept during this sync rather than deleted, and are left out of the returned records.
+// So are the records which could not be adopted.
+func (c *Client) adoptRecords(ctx context.Context, name string, records []internaltypes.DNSRecord, targetIPs []string) []internaltypes.DNSRecord {
+	targets := make(map[string]bool)
 	for _, ip := range targetIPs {
-		targetSet[ip] = true
+		targets[reconcile.CanonicalIP(ip)] = true
 	}
 
-	// Delete records that are no longer needed
-	for target, recordID := range currentTargets {
-		if !targetSet[target] {
-			if err := c.DeleteARecord(ctx, recordID); err != nil {
-				log.Error("Error deleting record", "record_id", recordID, "error", err)
-			}
+	var managed []internaltypes.DNSRecord
+	for _, record := range records {
+		if reconcile.Owned(record) {
+			managed = append(managed, record)
+			continue
 		}
+		if err := c.adoptARecord(ctx, record); err != nil {
+			log.FromContext(ctx).Error("Error adopting record, leaving it alone", "name", name, "record_id", record.ID, "target", record.Content, "error", err)
+			continue
+		}
+		if targets[reconcile.CanonicalIP(record.Content)] {
+			managed = append(managed, record)
+		}
+	}
+	return managed
+}
+
+// adoptARecord marks an existing record as created by the controller. Its comment is kept, and nothing else is changed.
+func (c *Client) adoptARecord(ctx context.Context, record internaltypes.DNSRecord) (err error) {
+	ctx, span := c.startSpan(ctx, "cloudflare.UpdateDNSRecord", record.Name, attribute.String("dns.record_id", record.ID))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(c.config.CloudflareZoneID, record.Name)
+
+	comment := reconcile.OwnerComment
+	if record.Comment != "" {
+		comment = record.Comment + "; " + comment
+	}
+
+	_, err = c.api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.config.CloudflareZoneID), cloudflare.UpdateDNSRecordParams{
+		ID:      record.ID,
+		Comment: &comment,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to adopt DNS Record: %w", classify(err))
+	}
+
+	log.FromContext(ctx).Info("Adopted existing A record", "name", record.Name, "record_id", record.ID, "target", record.Content)
+	c.recordChange(ctx, audit.OperationAdopt, record.Name, record.ID, record.Content, record.Content)
+	return nil
+}
+
+// skipDeletions logs the records which would have been deleted in add-only mode, and counts them
+func (c *Client) skipDeletions(ctx context.Context, name string, records []internaltypes.DNSRecord) {
+	for _, record := range records {
+		log.FromContext(ctx).Info("Add-only mode: would have deleted A record", "name", name, "record_id", record.ID, "content", record.Content)
+	}
+	metrics.RecordDeletionsSkipped(c.config.Name, len(records))
+}
+
+// warnUnowned warns about the records which are about to be deleted although the controller did not create them
+func warnUnowned(ctx context.Context, name string, toRemove []internaltypes.DNSRecord) {
+	var unowned []string
+	for _, record := range toRemove {
+		if !reconcile.Owned(record) {
+			unowned = append(unowned, record.Content+" ("+record.ID+")")
+		}
+	}
+	if len(unowned) > 0 {
+		log.FromContext(ctx).Warn("Deleting A records which were not created by the controller. Set ADOPT_EXISTING to adopt them instead.",
+			"name", name, "records", unowned)
+	}
+}
+
+// CleanupOwnedRecords deletes the A records of the given name which were created by the controller.
+// Records created by someone else are left alone. It returns the number of deleted records.
+func (c *Client) CleanupOwnedRecords(ctx context.Context, name string) (int, error) {
+	records, err := c.getARecords(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get A records of %s: %w", name, err)
+	}
+
+	var errs []error
+	deleted := 0
+	for _, record := range records {
+		if !reconcile.Owned(record) {
+			log.FromContext(ctx).Warn("Not deleting record which was not created by the controller", "name", name, "record_id", record.ID, "content", record.Content)
+			continue
+		}
+		if c.config.AddOnly {
+			c.skipDeletions(ctx, name, []internaltypes.DNSRecord{record})
+			continue
+		}
+		if err := c.DeleteARecord(ctx, record.ID, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.recordChange(ctx, audit.OperationDelete, name, record.ID, record.Content, "")
+		deleted++
+		log.FromContext(ctx).Info("Deleted orphaned A record", "name", name, "record_id", record.ID, "content", record.Content)
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+// apply executes the planned changes against Cloudflare and returns what was done.
+// Records are created before stale ones are deleted, so that the name always resolves to something.
+// Failures of individual operations are logged and do not stop the remaining ones.
+func (c *Client) apply(ctx context.Context, name string, changes reconcile.Changes) internaltypes.SyncResult {
+	result := internaltypes.SyncResult{Name: name}
+
+	// Update records which are kept but whose settings (TTL, proxied, comment) have drifted.
+	// They are updated in place, so that their IDs are kept and the name keeps resolving to them.
+	settings := c.settings(name)
+	for _, record := range changes.ToUpdate {
+		comment := settings.DesiredComment(record)
+		log.FromContext(ctx).Info("Record settings drifted", "name", name, "record_id", record.ID,
+			"proxied", record.Proxied, "desired_proxied", settings.Proxied,
+			"ttl", record.TTL, "desired_ttl", settings.EffectiveTTL(),
+			"comment", record.Comment, "desired_comment", comment)
+		if err := c.updateARecord(ctx, record.ID, name, record.Content, comment); err != nil {
+			log.FromContext(ctx).Error("Error updating record", "record_id", record.ID, "error", err)
+			result.Failed = append(result.Failed, "update "+record.Content)
+			continue
+		}
+		c.recordChange(ctx, audit.OperationUpdate, name, record.ID, record.Content, record.Content)
+		result.Updated = append(result.Updated, record.Content)
+	}
+
+	// Point the records which are no longer needed at the new targets
+	for _, retarget := range changes.ToRetarget {
+		record := retarget.Record
+		if err := c.updateARecord(ctx, record.ID, name, retarget.Target, settings.DesiredComment(record)); err != nil {
+			log.FromContext(ctx).Error("Error retargeting record", "record_id", record.ID, "content", record.Content, "target", retarget.Target, "error", err)
+			result.Failed = append(result.Failed, "retarget "+retarget.String())
+			continue
+		}
+		c.recordChange(ctx, audit.OperationUpdate, name, record.ID, record.Content, retarget.Target)
+		result.Retargeted = append(result.Retargeted, retarget.String())
 	}
 
 	// Create records for new targets
-	for _, target := range targetIPs {
-		if _, exists := currentTargets[target]; !exists {
-			fmt.Print(exists)
-			if err := c.CreateARecord(ctx, target); err != nil {
-				log.Error("Error creating record", "target", target, "error", err)
-			}
+	for _, target := range changes.ToAdd {
+		if err := c.CreateARecord(ctx, name, target); err != nil {
+			log.FromContext(ctx).Error("Error creating record", "target", target, "error", err)
+			result.Failed = append(result.Failed, "create "+target)
+			continue
 		}
+		c.recordChange(ctx, audit.OperationCreate, name, "", "", target)
+		result.Created = append(result.Created, target)
 	}
 
-	return nil
+	// Delete records that are no longer needed, unless only additions are allowed
+	if c.config.AddOnly {
+		c.skipDeletions(ctx, name, changes.ToRemove)
+		return result
+	}
+	for _, record := range changes.ToRemove {
+		if err := c.DeleteARecord(ctx, record.ID, name); err != nil {
+			log.FromContext(ctx).Error("Error deleting record", "record_id", record.ID, "error", err)
+			result.Failed = append(result.Failed, "delete "+record.Content)
+			continue
+		}
+		c.recordChange(ctx, audit.OperationDelete, name, record.ID, record.Content, "")
+		result.Deleted = append(result.Deleted, record.Content)
+	}
+
+	return result
+}
+
+// recordChange records a change made to a record of the zone in the audit log
+func (c *Client) recordChange(ctx context.Context, operation, name, recordID, oldContent, newContent string) {
+	audit.Record(ctx, audit.Entry{
+		Controller: c.config.Name,
+		ZoneID:     c.config.CloudflareZoneID,
+		Operation:  operation,
+		Name:       name,
+		RecordID:   recordID,
+		OldContent: oldContent,
+		NewContent: newContent,
+	})
 }
@@ -1,9 +1,26 @@
 package cloudflare
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/audit"
 	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/reconcile"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+	"github.com/charmbracelet/log"
+	"github.com/cloudflare/cloudflare-go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // Test the sync logic without making actual API calls
@@ -230,75 +247,739 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
-// Integration-style test for the sync logic (without actual API calls)
-func TestDNSSyncLogic(t *testing.T) {
-	// Test the business logic for determining what DNS changes are needed
-	tests := []struct {
-		name             string
-		currentIPs       []string
-		targetIPs        []string
-		expectedToAdd    []string
-		expectedToRemove []string
-	}{
-		{
-			name:             "add new IPs",
-			currentIPs:       []string{},
-			targetIPs:        []string{"1.1.1.1", "2.2.2.2"},
-			expectedToAdd:    []string{"1.1.1.1", "2.2.2.2"},
-			expectedToRemove: []string{},
+// fakeDNSAPI is an in-memory stand-in for the Cloudflare API which records the calls made to it.
+type fakeDNSAPI struct {
+	records     []cloudflare.DNSRecord
+	ignoreType  bool // list the records of every type, as if the type filter was not supported
+	dottedNames bool // list the record names with a trailing dot, as some versions of the API library do
+	pageSize    int  // list the records by pages of that size, if not zero
+	listed      int  // number of pages listed
+	nextID      int
+	created     []string // contents of created records
+	updated     []string // IDs of updated records
+	deleted     []string // IDs of deleted records
+	tokenStatus string   // status of the API token, active if empty
+}
+
+func (f *fakeDNSAPI) ListDNSRecords(_ context.Context, _ *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	var result []cloudflare.DNSRecord
+	for _, record := range f.records {
+		if (params.Name == "" || record.Name == params.Name) && (params.Type == "" || f.ignoreType || record.Type == params.Type) {
+			if f.dottedNames {
+				record.Name += "."
+			}
+			result = append(result, record)
+		}
+	}
+	f.listed++
+	if f.pageSize == 0 {
+		return result, &cloudflare.ResultInfo{}, nil
+	}
+
+	info := &cloudflare.ResultInfo{Page: params.Page, PerPage: f.pageSize, Total: len(result), TotalPages: (len(result) + f.pageSize - 1) / f.pageSize}
+	start := min((params.Page-1)*f.pageSize, len(result))
+	return result[start:min(start+f.pageSize, len(result))], info, nil
+}
+
+func (f *fakeDNSAPI) CreateDNSRecord(_ context.Context, _ *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	f.nextID++
+	record := cloudflare.DNSRecord{
+		ID:      fmt.Sprintf("created-%d", f.nextID),
+		Type:    params.Type,
+		Name:    params.Name,
+		Content: params.Content,
+		TTL:     params.TTL,
+		Proxied: params.Proxied,
+		Comment: params.Comment,
+	}
+	f.records = append(f.records, record)
+	f.created = append(f.created, params.Content)
+	return record, nil
+}
+
+func (f *fakeDNSAPI) UpdateDNSRecord(_ context.Context, _ *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	for i, record := range f.records {
+		if record.ID == params.ID {
+			// Updates are partial: only the fields which are set are changed
+			if params.Content != "" {
+				f.records[i].Content = params.Content
+			}
+			if params.TTL != 0 {
+				f.records[i].TTL = params.TTL
+			}
+			if params.Proxied != nil {
+				f.records[i].Proxied = params.Proxied
+			}
+			if params.Comment != nil {
+				f.records[i].Comment = *params.Comment
+			}
+			f.updated = append(f.updated, params.ID)
+			return f.records[i], nil
+		}
+	}
+	return cloudflare.DNSRecord{}, fmt.Errorf("record %s not found", params.ID)
+}
+
+func (f *fakeDNSAPI) DeleteDNSRecord(_ context.Context, _ *cloudflare.ResourceContainer, recordID string) error {
+	for i, record := range f.records {
+		if record.ID == recordID {
+			f.records = append(f.records[:i], f.records[i+1:]...)
+			f.deleted = append(f.deleted, recordID)
+			return nil
+		}
+	}
+	return fmt.Errorf("record %s not found", recordID)
+}
+
+func (f *fakeDNSAPI) VerifyAPIToken(_ context.Context) (cloudflare.APITokenVerifyBody, error) {
+	if f.tokenStatus == "" {
+		return cloudflare.APITokenVerifyBody{Status: "active"}, nil
+	}
+	return cloudflare.APITokenVerifyBody{Status: f.tokenStatus}, nil
+}
+
+// newFakeRecord returns an A record as Cloudflare would report it
+func newFakeRecord(id, name, content string, proxied bool) cloudflare.DNSRecord {
+	return cloudflare.DNSRecord{ID: id, Type: "A", Name: name, Content: content, TTL: 1, Proxied: &proxied}
+}
+
+func TestVerifyToken(t *testing.T) {
+	client := &Client{api: &fakeDNSAPI{}, config: &config.Config{}}
+	if err := client.VerifyToken(context.Background()); err != nil {
+		t.Errorf("VerifyToken() unexpected error = %v", err)
+	}
+
+	client.api = &fakeDNSAPI{tokenStatus: "disabled"}
+	if err := client.VerifyToken(context.Background()); !errors.Is(err, ErrAuth) {
+		t.Errorf("VerifyToken() error = %v, want ErrAuth", err)
+	}
+}
+
+func TestARecordIPs(t *testing.T) {
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("a", "test.example.com", "1.1.1.1", false),
+			newFakeRecord("b", "test.example.com", "2.2.2.2", false),
+			newFakeRecord("other", "other.example.com", "9.9.9.9", false),
 		},
-		{
-			name:             "remove old IPs",
-			currentIPs:       []string{"1.1.1.1", "2.2.2.2"},
-			targetIPs:        []string{},
-			expectedToAdd:    []string{},
-			expectedToRemove: []string{"1.1.1.1", "2.2.2.2"},
+	}
+	client := &Client{api: api, config: &config.Config{CloudflareZoneID: "test-zone-id"}}
+
+	ips, err := client.ARecordIPs(context.Background(), "test.example.com")
+	if err != nil {
+		t.Fatalf("ARecordIPs() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(ips, []string{"1.1.1.1", "2.2.2.2"}) {
+		t.Errorf("ARecordIPs() = %v, want [1.1.1.1 2.2.2.2]", ips)
+	}
+}
+
+func TestGetARecordsListsEveryPage(t *testing.T) {
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("first", "test.example.com", "1.1.1.1", true),
+			newFakeRecord("other", "other.example.com", "9.9.9.9", true),
+			newFakeRecord("second", "test.example.com", "2.2.2.2", true),
+			newFakeRecord("third", "test.example.com", "3.3.3.3", true),
 		},
-		{
-			name:             "partial update",
-			currentIPs:       []string{"1.1.1.1", "2.2.2.2"},
-			targetIPs:        []string{"1.1.1.1", "3.3.3.3"},
-			expectedToAdd:    []string{"3.3.3.3"},
-			expectedToRemove: []string{"2.2.2.2"},
+		pageSize: 2,
+	}
+	client := &Client{api: api, config: &config.Config{CloudflareZoneID: "test-zone-id"}}
+
+	records, err := client.getARecords(context.Background(), "test.example.com")
+	if err != nil {
+		t.Fatalf("getARecords() unexpected error = %v", err)
+	}
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"first", "second", "third"}) {
+		t.Errorf("getARecords() = %v, want the records of both pages", ids)
+	}
+	if api.listed != 2 {
+		t.Errorf("listed %d pages, want 2", api.listed)
+	}
+}
+
+func TestSyncARecordsAppliesPlan(t *testing.T) {
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("keep", "test.example.com", "1.1.1.1", true),
+			newFakeRecord("stale", "test.example.com", "2.2.2.2", true),
+			newFakeRecord("other", "other.example.com", "9.9.9.9", true),
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+		},
+	}
+
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "3.3.3.3"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	if !reflect.DeepEqual(api.created, []string{"3.3.3.3"}) {
+		t.Errorf("created = %v, want [3.3.3.3]", api.created)
+	}
+	if !reflect.DeepEqual(api.deleted, []string{"stale"}) {
+		t.Errorf("deleted = %v, want [stale]", api.deleted)
+	}
+	if len(api.updated) != 0 {
+		t.Errorf("updated = %v, want none", api.updated)
+	}
+}
+
+func TestSyncARecordsRetryBudgetExhausted(t *testing.T) {
+	api := &fakeDNSAPI{}
+	client := &Client{api: api, config: &config.Config{DNSRecordName: "test.example.com", CloudflareZoneID: "test-zone-id"}}
+
+	// Another call of the sync spent the budget, so the sync fails, to be retried by the next one
+	ctx := retrybudget.WithBudget(context.Background(), 1)
+	retrybudget.Spend(ctx)
+	retrybudget.Spend(ctx)
+	if _, err := client.SyncARecords(ctx, []string{"1.1.1.1"}); !errors.Is(err, ErrTransient) || !errors.Is(err, retrybudget.ErrExhausted) {
+		t.Errorf("SyncARecords() error = %v, want a transient %v", err, retrybudget.ErrExhausted)
+	}
+}
+
+func TestSyncARecordsAudit(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "audit.log")
+	closeAudit, err := audit.Setup(p)
+	if err != nil {
+		t.Fatalf("audit.Setup() error = %v", err)
+	}
+	defer closeAudit()
+
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("keep", "test.example.com", "1.1.1.1", true),
+			newFakeRecord("stale", "test.example.com", "2.2.2.2", true),
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			Name:             "test",
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+		},
+	}
+
+	ctx := audit.WithSyncID(context.Background(), "0123456789abcdef")
+	if _, err := client.SyncARecords(ctx, []string{"1.1.1.1", "3.3.3.3"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	content, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var operations []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry audit.Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("audit log line %q is not JSON: %v", line, err)
+		}
+		if entry.SyncID != "0123456789abcdef" || entry.ZoneID != "test-zone-id" || entry.Name != "test.example.com" {
+			t.Errorf("audit entry = %+v, want the sync, zone and name of the change", entry)
+		}
+		operations = append(operations, entry.Operation+" "+entry.OldContent+">"+entry.NewContent)
+	}
+	if want := []string{"create >3.3.3.3", "delete 2.2.2.2>"}; !reflect.DeepEqual(operations, want) {
+		t.Errorf("audited changes = %v, want %v", operations, want)
+	}
+}
+
+func TestSyncARecordsTogglesProxiedInPlace(t *testing.T) {
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("first", "test.example.com", "1.1.1.1", false),
+			newFakeRecord("second", "test.example.com", "2.2.2.2", false),
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+		},
+	}
+
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "2.2.2.2"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	if len(api.created) != 0 || len(api.deleted) != 0 {
+		t.Errorf("created = %v, deleted = %v, want none", api.created, api.deleted)
+	}
+	if !reflect.DeepEqual(api.updated, []string{"first", "second"}) {
+		t.Errorf("updated = %v, want [first second]", api.updated)
+	}
+	for _, record := range api.records {
+		if record.Proxied == nil || !*record.Proxied {
+			t.Errorf("record %s is not proxied after the update", record.ID)
+		}
+	}
+}
+
+func TestSyncARecordsRetargetsInPlace(t *testing.T) {
+	keep := newFakeRecord("keep", "test.example.com", "1.1.1.1", true)
+	keep.Comment = reconcile.OwnerComment
+	stale := newFakeRecord("stale", "test.example.com", "2.2.2.2", true)
+	stale.Comment = reconcile.OwnerComment
+	api := &fakeDNSAPI{records: []cloudflare.DNSRecord{keep, stale}}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
 		},
 	}
 
+	// A node changed its IP: its record is updated, rather than deleted and created again
+	result, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "3.3.3.3"})
+	if err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(api.updated, []string{"stale"}) {
+		t.Errorf("updated = %v, want [stale]", api.updated)
+	}
+	if len(api.created) != 0 || len(api.deleted) != 0 {
+		t.Errorf("created = %v, deleted = %v, want none", api.created, api.deleted)
+	}
+	if !reflect.DeepEqual(result.Retargeted, []string{"2.2.2.2 -> 3.3.3.3"}) {
+		t.Errorf("result retargeted = %v, want [2.2.2.2 -> 3.3.3.3]", result.Retargeted)
+	}
+	if record := api.records[1]; record.Content != "3.3.3.3" || record.Comment != reconcile.OwnerComment {
+		t.Errorf("retargeted record = %+v, want content 3.3.3.3 and the owner comment", record)
+	}
+
+	// In add-only mode, the stale record is kept and the new target gets its own record
+	client.config.AddOnly = true
+	api.updated = nil
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "4.4.4.4"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	if len(api.updated) != 0 || !reflect.DeepEqual(api.created, []string{"4.4.4.4"}) {
+		t.Errorf("updated = %v, created = %v, want a record created for 4.4.4.4", api.updated, api.created)
+	}
+}
+
+func TestSyncNamedARecordsRecordOverrides(t *testing.T) {
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("apex", "example.com", "1.1.1.1", true),
+			newFakeRecord("api", "api.example.com", "1.1.1.1", true),
+		},
+	}
+	ttl, proxied := 300, false
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+			RecordOverrides: map[string]config.RecordOverride{
+				"api.example.com": {TTL: &ttl, Proxied: &proxied},
+			},
+		},
+	}
+
+	for _, name := range []string{"example.com", "api.example.com"} {
+		if _, err := client.SyncNamedARecords(context.Background(), name, []string{"1.1.1.1", "2.2.2.2"}); err != nil {
+			t.Fatalf("SyncNamedARecords(%s) unexpected error = %v", name, err)
+		}
+	}
+
+	// Only the record whose settings differ from its override drifted
+	if !reflect.DeepEqual(api.updated, []string{"api"}) {
+		t.Errorf("updated = %v, want [api]", api.updated)
+	}
+	for _, record := range api.records {
+		wantProxied, wantTTL := true, 1
+		if record.Name == "api.example.com" {
+			wantProxied, wantTTL = false, 300
+		}
+		if record.Proxied == nil || *record.Proxied != wantProxied || record.TTL != wantTTL {
+			t.Errorf("record %s of %s has proxied %v and TTL %d, want %v and %d", record.Content, record.Name, record.Proxied != nil && *record.Proxied, record.TTL, wantProxied, wantTTL)
+		}
+	}
+}
+
+func TestSyncNamedARecordsOnlyTouchesThatName(t *testing.T) {
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("main", "test.example.com", "1.1.1.1", true),
+			newFakeRecord("eu-stale", "eu.example.com", "2.2.2.2", true),
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+		},
+	}
+
+	if _, err := client.SyncNamedARecords(context.Background(), "eu.example.com", []string{"3.3.3.3"}); err != nil {
+		t.Fatalf("SyncNamedARecords() unexpected error = %v", err)
+	}
+
+	if !reflect.DeepEqual(api.deleted, []string{"eu-stale"}) {
+		t.Errorf("deleted = %v, want [eu-stale]", api.deleted)
+	}
+	for _, record := range api.records {
+		if record.Content == "3.3.3.3" && record.Name != "eu.example.com" {
+			t.Errorf("created record name = %q, want eu.example.com", record.Name)
+		}
+	}
+}
+
+func TestSyncARecordsIgnoresTrailingDots(t *testing.T) {
+	tests := []struct {
+		name        string
+		recordName  string
+		dottedNames bool
+	}{
+		{name: "reported with a trailing dot", recordName: "test.example.com", dottedNames: true},
+		{name: "configured with a trailing dot", recordName: "test.example.com."},
+		{name: "both", recordName: "test.example.com.", dottedNames: true},
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Convert to sets for comparison
-			currentSet := make(map[string]bool)
-			for _, ip := range tt.currentIPs {
-				currentSet[ip] = true
+			api := &fakeDNSAPI{
+				records: []cloudflare.DNSRecord{
+					newFakeRecord("existing", "test.example.com", "1.1.1.1", true),
+					newFakeRecord("other", "other.example.com", "2.2.2.2", true),
+				},
+				dottedNames: tt.dottedNames,
+			}
+			client := &Client{
+				api: api,
+				config: &config.Config{
+					DNSRecordName:    tt.recordName,
+					CloudflareZoneID: "test-zone-id",
+					Proxied:          true,
+				},
 			}
 
-			targetSet := make(map[string]bool)
-			for _, ip := range tt.targetIPs {
-				targetSet[ip] = true
+			result, err := client.SyncARecords(context.Background(), []string{"1.1.1.1"})
+			if err != nil {
+				t.Fatalf("SyncARecords() unexpected error = %v", err)
 			}
 
-			// Find IPs to add
-			var toAdd []string
-			for _, ip := range tt.targetIPs {
-				if !currentSet[ip] {
-					toAdd = append(toAdd, ip)
-				}
+			// The existing record is reused rather than duplicated
+			if result.Changed() || len(api.created) > 0 || len(api.deleted) > 0 {
+				t.Errorf("SyncARecords() = %+v, created %v, deleted %v, want no change", result, api.created, api.deleted)
+			}
+			if result.Name != "test.example.com" {
+				t.Errorf("result name = %q, want test.example.com", result.Name)
 			}
+		})
+	}
+}
 
-			// Find IPs to remove
-			var toRemove []string
-			for _, ip := range tt.currentIPs {
-				if !targetSet[ip] {
-					toRemove = append(toRemove, ip)
-				}
+func TestCreatedRecordsAreOwned(t *testing.T) {
+	api := &fakeDNSAPI{}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+		},
+	}
+
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	if len(api.records) != 1 || api.records[0].Comment != reconcile.OwnerComment {
+		t.Errorf("records = %+v, want one record with comment %q", api.records, reconcile.OwnerComment)
+	}
+}
+
+func TestSyncARecordsUpdatesStaleComments(t *testing.T) {
+	stale := newFakeRecord("stale", "test.example.com", "1.1.1.1", true)
+	stale.Comment = "Created by " + reconcile.OwnerMarker
+	adopted := newFakeRecord("adopted", "test.example.com", "2.2.2.2", true)
+	adopted.Comment = "added by hand; " + reconcile.OwnerComment
+	manual := newFakeRecord("manual", "test.example.com", "3.3.3.3", true)
+	manual.Comment = "added by hand"
+	api := &fakeDNSAPI{records: []cloudflare.DNSRecord{stale, adopted, manual}}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+		},
+	}
+
+	// The contents match the targets, only the comment of the first record is outdated
+	result, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"})
+	if err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(api.updated, []string{"stale"}) || !reflect.DeepEqual(result.Updated, []string{"1.1.1.1"}) {
+		t.Errorf("updated = %v, result updated = %v, want the record with the outdated comment only", api.updated, result.Updated)
+	}
+	comments := make(map[string]string)
+	for _, record := range api.records {
+		comments[record.ID] = record.Comment
+	}
+	expected := map[string]string{"stale": reconcile.OwnerComment, "adopted": adopted.Comment, "manual": manual.Comment}
+	if !reflect.DeepEqual(comments, expected) {
+		t.Errorf("comments = %v, want %v", comments, expected)
+	}
+
+	// Once updated, the comments no longer drift
+	api.updated = nil
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	if len(api.updated) != 0 {
+		t.Errorf("updated = %v on the second sync, want none", api.updated)
+	}
+}
+
+func TestSyncARecordsAdoptsExisting(t *testing.T) {
+	manual := newFakeRecord("manual", "test.example.com", "1.1.1.1", true)
+	manual.Comment = "added by hand"
+	owned := newFakeRecord("owned-stale", "test.example.com", "4.4.4.4", true)
+	owned.Comment = reconcile.OwnerComment
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			manual,
+			newFakeRecord("manual-stale", "test.example.com", "2.2.2.2", true),
+			owned,
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+			AdoptExisting:    true,
+		},
+	}
+
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "3.3.3.3"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	// Existing records are adopted and kept, only owned records are pointed at the new target
+	if !reflect.DeepEqual(api.updated, []string{"manual", "manual-stale", "owned-stale"}) {
+		t.Errorf("updated = %v, want [manual manual-stale owned-stale]", api.updated)
+	}
+	if len(api.created) != 0 || len(api.deleted) != 0 {
+		t.Errorf("created = %v, deleted = %v, want none", api.created, api.deleted)
+	}
+	if comment := api.records[0].Comment; comment != "added by hand; "+reconcile.OwnerComment {
+		t.Errorf("adopted record comment = %q, want the previous comment followed by the owner comment", comment)
+	}
+
+	// Adopted records are managed from the next sync on
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "3.3.3.3"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(api.deleted, []string{"manual-stale"}) {
+		t.Errorf("deleted = %v, want [manual-stale]", api.deleted)
+	}
+}
+
+func TestCleanupOwnedRecords(t *testing.T) {
+	owned := newFakeRecord("owned", "old.example.com", "1.1.1.1", true)
+	owned.Comment = reconcile.OwnerComment
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			owned,
+			newFakeRecord("manual", "old.example.com", "2.2.2.2", true),
+			newFakeRecord("current", "test.example.com", "3.3.3.3", true),
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+		},
+	}
+
+	deleted, err := client.CleanupOwnedRecords(context.Background(), "old.example.com")
+	if err != nil {
+		t.Fatalf("CleanupOwnedRecords() unexpected error = %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("CleanupOwnedRecords() deleted %d records, want 1", deleted)
+	}
+	if !reflect.DeepEqual(api.deleted, []string{"owned"}) {
+		t.Errorf("deleted = %v, want [owned]", api.deleted)
+	}
+}
+
+func TestSyncARecordsInterrupted(t *testing.T) {
+	client := &Client{
+		api: &fakeDNSAPI{},
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.SyncARecords(ctx, []string{"1.1.1.1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SyncARecords() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSyncARecordsTracesCalls(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("stale", "test.example.com", "2.2.2.2", true),
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+		},
+	}
+
+	if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	expected := []string{"cloudflare.ListDNSRecords", "cloudflare.CreateDNSRecord", "cloudflare.DeleteDNSRecord"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("spans = %v, want %v", names, expected)
+	}
+}
+
+func TestSyncARecordsLogsWithContextLogger(t *testing.T) {
+	api := &fakeDNSAPI{}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+		},
+	}
+
+	var buf bytes.Buffer
+	ctx := log.WithContext(context.Background(), log.New(&buf).With("sync_id", "abcd1234"))
+	if _, err := client.SyncARecords(ctx, []string{"1.1.1.1"}); err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "sync_id=abcd1234") {
+			t.Errorf("log line %q does not carry the sync ID", line)
+		}
+	}
+	if !strings.Contains(buf.String(), "Created A record") {
+		t.Errorf("logs = %q, want the record creation logged with the context logger", buf.String())
+	}
+}
+
+func TestSyncARecordsAddOnly(t *testing.T) {
+	owned := newFakeRecord("owned", "old.example.com", "5.5.5.5", true)
+	owned.Comment = reconcile.OwnerComment
+	api := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{
+			newFakeRecord("keep", "test.example.com", "1.1.1.1", true),
+			newFakeRecord("stale", "test.example.com", "2.2.2.2", true),
+			owned,
+		},
+	}
+	client := &Client{
+		api: api,
+		config: &config.Config{
+			DNSRecordName:    "test.example.com",
+			CloudflareZoneID: "test-zone-id",
+			Proxied:          true,
+			AddOnly:          true,
+		},
+	}
+
+	result, err := client.SyncARecords(context.Background(), []string{"1.1.1.1", "3.3.3.3"})
+	if err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(api.created, []string{"3.3.3.3"}) {
+		t.Errorf("created = %v, want [3.3.3.3]", api.created)
+	}
+	if len(api.deleted) != 0 || len(result.Deleted) != 0 {
+		t.Errorf("deleted = %v, result deleted = %v, want no deletion in add-only mode", api.deleted, result.Deleted)
+	}
+
+	if deleted, err := client.CleanupOwnedRecords(context.Background(), "old.example.com"); err != nil || deleted != 0 {
+		t.Errorf("CleanupOwnedRecords() = %d, %v, want no deletion in add-only mode", deleted, err)
+	}
+	if len(api.deleted) != 0 {
+		t.Errorf("deleted = %v, want no deletion in add-only mode", api.deleted)
+	}
+}
+
+func TestSyncARecordsLeavesOtherTypesAlone(t *testing.T) {
+	for _, ignoreType := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignoreType=%v", ignoreType), func(t *testing.T) {
+			aaaa := newFakeRecord("aaaa", "test.example.com", "2001:db8::1", true)
+			aaaa.Type = "AAAA"
+			owned := aaaa
+			owned.ID = "aaaa-owned"
+			owned.Content = "2001:db8::2"
+			owned.Comment = reconcile.OwnerComment
+			api := &fakeDNSAPI{
+				records:    []cloudflare.DNSRecord{aaaa, owned, newFakeRecord("stale", "test.example.com", "2.2.2.2", true)},
+				ignoreType: ignoreType,
+			}
+			client := &Client{
+				api: api,
+				config: &config.Config{
+					DNSRecordName:    "test.example.com",
+					CloudflareZoneID: "test-zone-id",
+					Proxied:          true,
+				},
 			}
 
-			// Verify results
-			if len(toAdd) != len(tt.expectedToAdd) {
-				t.Errorf("Expected %d additions, got %d", len(tt.expectedToAdd), len(toAdd))
+			if _, err := client.SyncARecords(context.Background(), []string{"1.1.1.1"}); err != nil {
+				t.Fatalf("SyncARecords() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(api.deleted, []string{"stale"}) {
+				t.Errorf("deleted = %v, want only the stale A record", api.deleted)
 			}
 
-			if len(toRemove) != len(tt.expectedToRemove) {
-				t.Errorf("Expected %d removals, got %d", len(tt.expectedToRemove), len(toRemove))
+			if _, err := client.CleanupOwnedRecords(context.Background(), "test.example.com"); err != nil {
+				t.Fatalf("CleanupOwnedRecords() unexpected error = %v", err)
+			}
+			for _, id := range api.deleted {
+				if id == "aaaa" || id == "aaaa-owned" {
+					t.Errorf("AAAA record %s was deleted", id)
+				}
 			}
 		})
 	}
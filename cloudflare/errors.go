@@ -0,0 +1,100 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Errors returned by the Cloudflare client wrap one of these sentinels,
+// so that callers can tell failures apart with errors.Is.
+var (
+	// ErrZoneNotFound is returned when the configured zone does not exist or is not visible to the token.
+	ErrZoneNotFound = errors.New("cloudflare zone not found")
+	// ErrAuth is returned when the API token is invalid or lacks the required permissions.
+	ErrAuth = errors.New("cloudflare authentication failed")
+	// ErrTransient is returned for failures which may resolve by themselves, such as rate limiting or server errors.
+	ErrTransient = errors.New("transient cloudflare error")
+	// ErrCircuitOpen is returned, along with ErrTransient, when calls are short-circuited after repeated failures.
+	ErrCircuitOpen = errors.New("cloudflare circuit breaker is open")
+)
+
+// Categories of the failed Cloudflare API calls, by which they are counted
+const (
+	categoryAuth       = "auth"       // the token is invalid or lacks a permission
+	categoryNotFound   = "not_found"  // the zone or record does not exist
+	categoryRateLimit  = "rate_limit" // too many requests
+	categoryValidation = "validation" // the request was rejected as invalid
+	categoryServer     = "server"     // Cloudflare failed
+	categoryNetwork    = "network"    // Cloudflare could not be reached
+	categoryOther      = "other"
+)
+
+// errorCategory returns the category of the error of a Cloudflare API call, and the first Cloudflare error code of the response, if any
+func errorCategory(err error) (category, code string) {
+	var apiErr *cloudflare.Error
+	if errors.As(err, &apiErr) {
+		if len(apiErr.ErrorCodes) > 0 {
+			code = strconv.Itoa(apiErr.ErrorCodes[0])
+		}
+		switch {
+		case apiErr.Type == cloudflare.ErrorTypeAuthentication, apiErr.Type == cloudflare.ErrorTypeAuthorization:
+			return categoryAuth, code
+		case apiErr.Type == cloudflare.ErrorTypeNotFound, apiErr.InternalErrorCodeIs(zoneNotFoundCode):
+			return categoryNotFound, code
+		case apiErr.Type == cloudflare.ErrorTypeRateLimit:
+			return categoryRateLimit, code
+		case apiErr.Type == cloudflare.ErrorTypeService:
+			return categoryServer, code
+		case apiErr.Type == cloudflare.ErrorTypeRequest:
+			return categoryValidation, code
+		}
+		return categoryOther, code
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return categoryNetwork, ""
+	}
+	return categoryOther, ""
+}
+
+// zoneNotFoundCode is the Cloudflare error code returned when a zone identifier cannot be routed.
+const zoneNotFoundCode = 7003
+
+// classify wraps err with the sentinel matching its cause. Errors which cannot be classified are returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *cloudflare.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Type == cloudflare.ErrorTypeAuthentication, apiErr.Type == cloudflare.ErrorTypeAuthorization:
+			return fmt.Errorf("%w: %w", ErrAuth, err)
+		case apiErr.Type == cloudflare.ErrorTypeNotFound, apiErr.InternalErrorCodeIs(zoneNotFoundCode):
+			return fmt.Errorf("%w: %w", ErrZoneNotFound, err)
+		case apiErr.Type == cloudflare.ErrorTypeRateLimit, apiErr.Type == cloudflare.ErrorTypeService:
+			return fmt.Errorf("%w: %w", ErrTransient, err)
+		}
+		return err
+	}
+
+	// The sync spent its retry budget: the next one may succeed
+	if errors.Is(err, retrybudget.ErrExhausted) {
+		return fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+
+	// Network failures (timeouts, refused connections, DNS failures) are worth retrying
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+
+	return err
+}
@@ -0,0 +1,144 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			name:     "authentication error",
+			err:      cloudflare.NewAuthenticationError(&cloudflare.Error{Type: cloudflare.ErrorTypeAuthentication, StatusCode: 401}),
+			expected: ErrAuth,
+		},
+		{
+			name:     "authorization error",
+			err:      cloudflare.NewAuthorizationError(&cloudflare.Error{Type: cloudflare.ErrorTypeAuthorization, StatusCode: 403}),
+			expected: ErrAuth,
+		},
+		{
+			name:     "zone not found",
+			err:      cloudflare.NewNotFoundError(&cloudflare.Error{Type: cloudflare.ErrorTypeNotFound, StatusCode: 404}),
+			expected: ErrZoneNotFound,
+		},
+		{
+			name:     "invalid zone identifier",
+			err:      cloudflare.NewRequestError(&cloudflare.Error{Type: cloudflare.ErrorTypeRequest, StatusCode: 400, ErrorCodes: []int{7003}}),
+			expected: ErrZoneNotFound,
+		},
+		{
+			name:     "rate limited",
+			err:      cloudflare.NewRatelimitError(&cloudflare.Error{Type: cloudflare.ErrorTypeRateLimit, StatusCode: 429}),
+			expected: ErrTransient,
+		},
+		{
+			name:     "server error",
+			err:      cloudflare.NewServiceError(&cloudflare.Error{Type: cloudflare.ErrorTypeService, StatusCode: 502}),
+			expected: ErrTransient,
+		},
+		{
+			name:     "network error",
+			err:      fmt.Errorf("request failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+			expected: ErrTransient,
+		},
+		{
+			name:     "unclassified error",
+			err:      errors.New("something else"),
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := classify(tt.err)
+
+			if !errors.Is(result, tt.err) {
+				t.Errorf("classify() = %v, should wrap the original error", result)
+			}
+
+			for _, sentinel := range []error{ErrAuth, ErrZoneNotFound, ErrTransient} {
+				if errors.Is(result, sentinel) != (sentinel == tt.expected) {
+					t.Errorf("errors.Is(classify(), %v) = %v, want %v", sentinel, errors.Is(result, sentinel), sentinel == tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category string
+		code     string
+	}{
+		{
+			name:     "invalid token",
+			err:      cloudflare.NewAuthenticationError(&cloudflare.Error{Type: cloudflare.ErrorTypeAuthentication, StatusCode: 401, ErrorCodes: []int{10000}}),
+			category: categoryAuth,
+			code:     "10000",
+		},
+		{
+			name:     "missing permission",
+			err:      cloudflare.NewAuthorizationError(&cloudflare.Error{Type: cloudflare.ErrorTypeAuthorization, StatusCode: 403}),
+			category: categoryAuth,
+		},
+		{
+			name:     "invalid zone identifier",
+			err:      cloudflare.NewRequestError(&cloudflare.Error{Type: cloudflare.ErrorTypeRequest, StatusCode: 400, ErrorCodes: []int{7003}}),
+			category: categoryNotFound,
+			code:     "7003",
+		},
+		{
+			name:     "record already exists",
+			err:      cloudflare.NewRequestError(&cloudflare.Error{Type: cloudflare.ErrorTypeRequest, StatusCode: 400, ErrorCodes: []int{81057}}),
+			category: categoryValidation,
+			code:     "81057",
+		},
+		{
+			name:     "invalid content",
+			err:      cloudflare.NewRequestError(&cloudflare.Error{Type: cloudflare.ErrorTypeRequest, StatusCode: 400, ErrorCodes: []int{1004, 9005}}),
+			category: categoryValidation,
+			code:     "1004",
+		},
+		{
+			name:     "rate limited",
+			err:      cloudflare.NewRatelimitError(&cloudflare.Error{Type: cloudflare.ErrorTypeRateLimit, StatusCode: 429, ErrorCodes: []int{971}}),
+			category: categoryRateLimit,
+			code:     "971",
+		},
+		{
+			name:     "server error",
+			err:      cloudflare.NewServiceError(&cloudflare.Error{Type: cloudflare.ErrorTypeService, StatusCode: 502}),
+			category: categoryServer,
+		},
+		{
+			name:     "network error",
+			err:      fmt.Errorf("request failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+			category: categoryNetwork,
+		},
+		{
+			name:     "unclassified error",
+			err:      errors.New("something else"),
+			category: categoryOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category, code := errorCategory(tt.err)
+			if category != tt.category || code != tt.code {
+				t.Errorf("errorCategory() = %q, %q, want %q, %q", category, code, tt.category, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package cloudflare
+
+import (
+	"net/http"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+)
+
+// maxIdleConnsPerHost is the number of idle connections kept to the Cloudflare API.
+// All requests go to the same host, so the default of two would make bursts of record changes open new connections.
+const maxIdleConnsPerHost = 10
+
+// newHTTPClient returns the HTTP client used to call the Cloudflare API.
+// Its transport reuses connections, observes the rate-limit headers of every response, and spends the retry budget of the sync.
+// Requests time out after CLOUDFLARE_HTTP_TIMEOUT, if set.
+func newHTTPClient(cfg *config.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+
+	return &http.Client{
+		Transport: &rateLimitTransport{base: &retryBudgetTransport{base: transport}, controller: cfg.Name},
+		Timeout:   cfg.CloudflareHTTPTimeout,
+	}
+}
+
+// retryBudgetTransport is an http.RoundTripper which spends the retry budget of the sync, if any, on every failed request.
+// The API library retries failed requests on its own, so once the budget is exhausted, requests fail without being sent.
+type retryBudgetTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip performs the request with the wrapped transport, unless the retry budget of its context is exhausted
+func (t *retryBudgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if retrybudget.Exhausted(req.Context()) {
+		return nil, retrybudget.ErrExhausted
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		retrybudget.Spend(req.Context())
+	}
+	return resp, err
+}
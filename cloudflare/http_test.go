@@ -0,0 +1,79 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client := newHTTPClient(&config.Config{Name: "test", CloudflareHTTPTimeout: 50 * time.Millisecond})
+
+	transport, ok := client.Transport.(*rateLimitTransport)
+	if !ok {
+		t.Fatalf("transport = %T, want the rate-limit transport", client.Transport)
+	}
+	if base := transport.base.(*retryBudgetTransport).base.(*http.Transport); base.MaxIdleConnsPerHost != maxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", base.MaxIdleConnsPerHost, maxIdleConnsPerHost)
+	}
+
+	// A hung API call is abandoned after the timeout
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("request to a hung server should time out")
+	}
+}
+
+func TestNewHTTPClientWithoutTimeout(t *testing.T) {
+	if client := newHTTPClient(&config.Config{Name: "test"}); client.Timeout != 0 {
+		t.Errorf("Timeout = %v, want none by default", client.Timeout)
+	}
+}
+
+func TestRetryBudgetTransport(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &retryBudgetTransport{base: http.DefaultTransport}}
+	ctx := retrybudget.WithBudget(context.Background(), 1)
+	get := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// Both failures are sent, the second one exhausting the budget
+	for range 2 {
+		if err := get(); err != nil {
+			t.Fatalf("request failed before the budget was exhausted: %v", err)
+		}
+	}
+	if err := get(); !errors.Is(err, retrybudget.ErrExhausted) {
+		t.Errorf("request error once the budget is exhausted = %v, want %v", err, retrybudget.ErrExhausted)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests sent, want 2", requests)
+	}
+}
@@ -0,0 +1,98 @@
+package cloudflare
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Record types which carry a priority, and those which also carry a weight, besides their content
+var (
+	priorityTypes = []string{"MX", "SRV", "URI"}
+	weightTypes   = []string{"SRV", "URI"}
+)
+
+// createParams returns the parameters creating the record
+func (c *Client) createParams(record internaltypes.DNSRecord) (cloudflare.CreateDNSRecordParams, error) {
+	priority, data, err := c.typeFields(record)
+	if err != nil {
+		return cloudflare.CreateDNSRecordParams{}, err
+	}
+	params := cloudflare.CreateDNSRecordParams{
+		Type:     record.Type,
+		Name:     record.Name,
+		TTL:      record.TTL,
+		Proxied:  &record.Proxied,
+		Comment:  record.Comment,
+		Priority: priority,
+	}
+	// The content of the types with a weight is part of their data. A nil map must not be set, since it would be sent as null.
+	if data != nil {
+		params.Data = data
+	} else {
+		params.Content = record.Content
+	}
+	return params, nil
+}
+
+// updateParams returns the parameters replacing the content, the settings and the comment of the record
+func (c *Client) updateParams(record internaltypes.DNSRecord) (cloudflare.UpdateDNSRecordParams, error) {
+	priority, data, err := c.typeFields(record)
+	if err != nil {
+		return cloudflare.UpdateDNSRecordParams{}, err
+	}
+	params := cloudflare.UpdateDNSRecordParams{
+		ID:       record.ID,
+		Type:     record.Type,
+		Name:     record.Name,
+		TTL:      record.TTL,
+		Proxied:  &record.Proxied,
+		Comment:  &record.Comment,
+		Priority: priority,
+	}
+	if data != nil {
+		params.Data = data
+	} else {
+		params.Content = record.Content
+	}
+	return params, nil
+}
+
+// typeFields returns the priority and the structured data of the record, for the types which carry them.
+// Records of the other types, such as A records, have neither, whatever their Priority and Weight.
+// The weight is only part of the data, which also holds the content: "<port> <target>" for SRV records, the target for URI records.
+func (c *Client) typeFields(record internaltypes.DNSRecord) (*uint16, map[string]interface{}, error) {
+	if !slices.Contains(priorityTypes, record.Type) {
+		return nil, nil, nil
+	}
+	priority := uint16(c.config.RecordPriority)
+	if record.Priority != nil {
+		priority = *record.Priority
+	}
+	if !slices.Contains(weightTypes, record.Type) {
+		return &priority, nil, nil
+	}
+
+	weight := uint16(c.config.RecordWeight)
+	if record.Weight != nil {
+		weight = *record.Weight
+	}
+	data := map[string]interface{}{"priority": priority, "weight": weight}
+	switch record.Type {
+	case "SRV":
+		port, target, ok := strings.Cut(strings.TrimSpace(record.Content), " ")
+		portNumber, err := strconv.ParseUint(port, 10, 16)
+		if !ok || err != nil {
+			return nil, nil, fmt.Errorf("SRV record %s must have a content such as \"443 traefik.example.com\", got %q", record.Name, record.Content)
+		}
+		data["port"] = uint16(portNumber)
+		data["target"] = strings.TrimSpace(target)
+	case "URI":
+		data["target"] = record.Content
+	}
+	return &priority, data, nil
+}
@@ -0,0 +1,98 @@
+package cloudflare
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+func uint16Ptr(v uint16) *uint16 {
+	return &v
+}
+
+func TestCreateParams(t *testing.T) {
+	client := &Client{config: &config.Config{RecordPriority: 10, RecordWeight: 5}}
+
+	tests := []struct {
+		name     string
+		record   internaltypes.DNSRecord
+		priority *uint16                // nil if not sent
+		data     map[string]interface{} // nil if not sent
+		content  string
+		errorMsg string
+	}{
+		{
+			name:    "A records ignore the priority and the weight",
+			record:  internaltypes.DNSRecord{Type: "A", Name: "test.example.com", Content: "1.1.1.1", Priority: uint16Ptr(1), Weight: uint16Ptr(1)},
+			content: "1.1.1.1",
+		},
+		{
+			name:     "MX records take the default priority",
+			record:   internaltypes.DNSRecord{Type: "MX", Name: "example.com", Content: "mail.example.com"},
+			priority: uint16Ptr(10),
+			content:  "mail.example.com",
+		},
+		{
+			name:     "MX records with their own priority",
+			record:   internaltypes.DNSRecord{Type: "MX", Name: "example.com", Content: "mail.example.com", Priority: uint16Ptr(20), Weight: uint16Ptr(1)},
+			priority: uint16Ptr(20),
+			content:  "mail.example.com",
+		},
+		{
+			name:     "SRV records carry their weight in their data",
+			record:   internaltypes.DNSRecord{Type: "SRV", Name: "_https._tcp.example.com", Content: "443 traefik.example.com", Weight: uint16Ptr(50)},
+			priority: uint16Ptr(10),
+			data:     map[string]interface{}{"priority": uint16(10), "weight": uint16(50), "port": uint16(443), "target": "traefik.example.com"},
+		},
+		{
+			name:     "URI records carry their weight in their data",
+			record:   internaltypes.DNSRecord{Type: "URI", Name: "_https._tcp.example.com", Content: "https://traefik.example.com/", Priority: uint16Ptr(1)},
+			priority: uint16Ptr(1),
+			data:     map[string]interface{}{"priority": uint16(1), "weight": uint16(5), "target": "https://traefik.example.com/"},
+		},
+		{
+			name:     "SRV records without a port",
+			record:   internaltypes.DNSRecord{Type: "SRV", Name: "_https._tcp.example.com", Content: "traefik.example.com"},
+			errorMsg: `SRV record _https._tcp.example.com must have a content such as "443 traefik.example.com"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := client.createParams(tt.record)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("createParams() error = %v, want it to contain %q", err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createParams() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(params.Priority, tt.priority) {
+				t.Errorf("createParams() priority = %v, want %v", params.Priority, tt.priority)
+			}
+			if tt.data == nil && params.Data != nil {
+				t.Errorf("createParams() data = %v, want none", params.Data)
+			}
+			if tt.data != nil && !reflect.DeepEqual(params.Data, tt.data) {
+				t.Errorf("createParams() data = %v, want %v", params.Data, tt.data)
+			}
+			if params.Content != tt.content {
+				t.Errorf("createParams() content = %q, want %q", params.Content, tt.content)
+			}
+
+			// Updates send the same fields
+			update, err := client.updateParams(tt.record)
+			if err != nil {
+				t.Fatalf("updateParams() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(update.Priority, params.Priority) || !reflect.DeepEqual(update.Data, params.Data) || update.Content != params.Content {
+				t.Errorf("updateParams() = %+v, want the priority, data and content of createParams() %+v", update, params)
+			}
+		})
+	}
+}
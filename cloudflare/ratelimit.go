@@ -0,0 +1,56 @@
+package cloudflare
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+)
+
+// rateLimitTransport is an http.RoundTripper which inspects the rate-limit headers
+// returned by the Cloudflare API and records the remaining quota as a metric.
+type rateLimitTransport struct {
+	base       http.RoundTripper
+	controller string // name of the controller instance, used to label the metric
+}
+
+// RoundTrip performs the request with the wrapped transport and records the remaining quota if the response carries one.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if remaining, ok := parseRateLimitRemaining(resp.Header); ok {
+		metrics.SetCloudflareRateLimitRemaining(t.controller, float64(remaining))
+	}
+
+	return resp, nil
+}
+
+// parseRateLimitRemaining extracts the remaining request quota from the response headers.
+// Cloudflare currently sends the structured "Ratelimit" header (e.g. `"default";r=1199;t=1`),
+// while older endpoints send "X-RateLimit-Remaining" or "Ratelimit-Remaining".
+func parseRateLimitRemaining(header http.Header) (int, bool) {
+	for _, key := range []string{"X-RateLimit-Remaining", "Ratelimit-Remaining"} {
+		if value := header.Get(key); value != "" {
+			if remaining, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				return remaining, true
+			}
+		}
+	}
+
+	// The structured header is a list of parameters separated by semicolons. We only care about "r".
+	for _, param := range strings.Split(header.Get("Ratelimit"), ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || key != "r" {
+			continue
+		}
+		if remaining, err := strconv.Atoi(value); err == nil {
+			return remaining, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,82 @@
+package cloudflare
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseRateLimitRemaining(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    http.Header
+		expected  int
+		expectsOK bool
+	}{
+		{
+			name:      "structured ratelimit header",
+			header:    http.Header{"Ratelimit": []string{`"default";r=1199;t=1`}},
+			expected:  1199,
+			expectsOK: true,
+		},
+		{
+			name:      "legacy x-ratelimit-remaining header",
+			header:    http.Header{"X-Ratelimit-Remaining": []string{"42"}},
+			expected:  42,
+			expectsOK: true,
+		},
+		{
+			name:      "draft ratelimit-remaining header",
+			header:    http.Header{"Ratelimit-Remaining": []string{" 7 "}},
+			expected:  7,
+			expectsOK: true,
+		},
+		{
+			name:      "no rate-limit headers",
+			header:    http.Header{},
+			expectsOK: false,
+		},
+		{
+			name:      "malformed header",
+			header:    http.Header{"Ratelimit": []string{`"default";r=lots`}},
+			expectsOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining, ok := parseRateLimitRemaining(tt.header)
+			if ok != tt.expectsOK {
+				t.Fatalf("parseRateLimitRemaining() ok = %v, want %v", ok, tt.expectsOK)
+			}
+			if ok && remaining != tt.expected {
+				t.Errorf("parseRateLimitRemaining() = %d, want %d", remaining, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransportRecordsRemaining(t *testing.T) {
+	// Initialize metrics by creating a server
+	_ = metrics.NewServer(8090)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Ratelimit", `"default";r=321;t=5`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, controller: "test"}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(metrics.AppMetrics.CloudflareRateLimitRemaining.WithLabelValues("test")); got != 321 {
+		t.Errorf("rate limit remaining = %v, want 321", got)
+	}
+}
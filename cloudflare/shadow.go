@@ -0,0 +1,78 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/charmbracelet/log"
+)
+
+// newShadowClient returns a client for the shadow zone of the configuration.
+// It has its own circuit breaker and metrics, labelled with the controller name and a "-shadow" suffix.
+func newShadowClient(cfg *config.Config) (*Client, error) {
+	shadowConfig := *cfg
+	shadowConfig.Name = cfg.Name + "-shadow"
+	shadowConfig.CloudflareZoneID = cfg.ShadowZoneID
+	if cfg.ShadowCloudflareToken != "" {
+		shadowConfig.CloudflareToken = cfg.ShadowCloudflareToken
+	}
+	shadowConfig.ShadowZoneID = ""
+
+	shadow, err := NewClient(&shadowConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create shadow zone client: %w", err)
+	}
+	return shadow, nil
+}
+
+// compareShadow applies the same reconcile to the shadow zone and reports any divergence from the production result.
+// Failures in the shadow zone are only logged.
+func (c *Client) compareShadow(ctx context.Context, name string, targetIPs []string, result internaltypes.SyncResult, err error) {
+	shadowResult, shadowErr := c.shadow.syncNamedARecords(ctx, name, targetIPs)
+
+	if diverged(result, err, shadowResult, shadowErr) {
+		log.FromContext(ctx).Warn("Shadow zone sync diverged from production",
+			"name", name,
+			"result", result, "error", err,
+			"shadow_result", shadowResult, "shadow_error", shadowErr)
+		metrics.RecordShadowDivergence(c.config.Name)
+		return
+	}
+
+	log.FromContext(ctx).Debug("Shadow zone sync matched production", "name", name, "shadow_zone_id", c.shadow.config.CloudflareZoneID)
+}
+
+// diverged reports whether two syncs made different changes, or only one of them failed.
+// The order of the operations does not matter.
+func diverged(result internaltypes.SyncResult, err error, shadowResult internaltypes.SyncResult, shadowErr error) bool {
+	if (err == nil) != (shadowErr == nil) {
+		return true
+	}
+	for _, pair := range [][2][]string{
+		{result.Created, shadowResult.Created},
+		{result.Updated, shadowResult.Updated},
+		{result.Deleted, shadowResult.Deleted},
+		{result.Retargeted, shadowResult.Retargeted},
+		{result.Failed, shadowResult.Failed},
+	} {
+		if !reflect.DeepEqual(sorted(pair[0]), sorted(pair[1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns a sorted copy of the values, with nil for no values
+func sorted(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := append([]string(nil), values...)
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,83 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestSyncMirrorsToShadowZone(t *testing.T) {
+	production := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{newFakeRecord("prod-stale", "test.example.com", "2.2.2.2", true)},
+	}
+	staging := &fakeDNSAPI{
+		records: []cloudflare.DNSRecord{newFakeRecord("shadow-stale", "test.example.com", "2.2.2.2", true)},
+	}
+	cfg := &config.Config{
+		DNSRecordName:    "test.example.com",
+		CloudflareZoneID: "prod-zone",
+		ShadowZoneID:     "shadow-zone",
+		Proxied:          true,
+	}
+	shadowCfg := *cfg
+	shadowCfg.CloudflareZoneID = "shadow-zone"
+	client := &Client{
+		api:    production,
+		config: cfg,
+		shadow: &Client{api: staging, config: &shadowCfg},
+	}
+
+	result, err := client.SyncARecords(context.Background(), []string{"1.1.1.1"})
+	if err != nil {
+		t.Fatalf("SyncARecords() unexpected error = %v", err)
+	}
+
+	expected := internaltypes.SyncResult{Name: "test.example.com", Created: []string{"1.1.1.1"}, Deleted: []string{"2.2.2.2"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("SyncARecords() = %+v, want %+v", result, expected)
+	}
+	if !reflect.DeepEqual(staging.created, []string{"1.1.1.1"}) || !reflect.DeepEqual(staging.deleted, []string{"shadow-stale"}) {
+		t.Errorf("shadow zone created = %v, deleted = %v, want the production changes mirrored", staging.created, staging.deleted)
+	}
+}
+
+func TestDiverged(t *testing.T) {
+	result := internaltypes.SyncResult{Name: "test.example.com", Created: []string{"1.1.1.1", "2.2.2.2"}}
+
+	tests := []struct {
+		name         string
+		shadowResult internaltypes.SyncResult
+		shadowErr    error
+		expected     bool
+	}{
+		{
+			name:         "same changes in another order",
+			shadowResult: internaltypes.SyncResult{Name: "test.example.com", Created: []string{"2.2.2.2", "1.1.1.1"}},
+			expected:     false,
+		},
+		{
+			name:         "different changes",
+			shadowResult: internaltypes.SyncResult{Name: "test.example.com", Created: []string{"1.1.1.1"}, Failed: []string{"create 2.2.2.2"}},
+			expected:     true,
+		},
+		{
+			name:         "only the shadow zone failed",
+			shadowResult: internaltypes.SyncResult{Name: "test.example.com", Created: []string{"1.1.1.1", "2.2.2.2"}},
+			shadowErr:    errors.New("boom"),
+			expected:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diverged(result, nil, tt.shadowResult, tt.shadowErr); got != tt.expected {
+				t.Errorf("diverged() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
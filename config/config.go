@@ -3,27 +3,274 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultInstanceName is the name of the controller instance when a single instance is configured.
+const DefaultInstanceName = "default"
+
 // Config holds all of the configuration for the application.
 type Config struct {
+	// Name of the controller instance. It is used to label metrics and logs when
+	// several controllers run in the same process.
+	Name string
+
 	// Nomad configuration
-	NomadAddress string
-	NomadToken   string
+	NomadAddress   string
+	NomadToken     string
+	NomadNamespace string // Namespace of the Traefik job
+
+	// Namespace of the event stream subscription. Node events are not namespaced, so "*" receives them along with the
+	// job and allocation events of every namespace. It defaults to NomadNamespace.
+	EventStreamNamespace string
 
 	// Cloudflare configuration
 	CloudflareToken  string
 	CloudflareZoneID string
+	Proxied          bool // Whether records are proxied through Cloudflare (orange cloud)
+
+	CloudflareHTTPTimeout time.Duration // Timeout of the Cloudflare API requests. Zero means no timeout.
+
+	// How long the A records listed for a name are reused by the next syncs, unless the controller changes one of them.
+	// The periodic syncs, those requested by an operator and the full reconciles always list them. Zero disables the cache.
+	CloudflareCacheTTL time.Duration
+
+	// Shadow zone, to which every sync is mirrored in order to compare the outcomes.
+	// It never affects production. The token defaults to CloudflareToken.
+	ShadowZoneID          string
+	ShadowCloudflareToken string
+
+	DNSRecordTTL int // TTL of the records in seconds, 1 means automatic. Ignored by Cloudflare for proxied records.
+
+	// Settings of some of the records overriding DNSRecordTTL and Proxied, by canonical record name
+	RecordOverrides map[string]RecordOverride
 
 	// Application configuration
-	TraefikJobName string // Name of the Traefik job in the Nomad cluster that we are watching
-	DNSRecordName  string // Name of the DNS A Record we need to create. This is the same as the "instance" variable in the Terraform module
-	LogLevel       string
-	MetricsPort    string // Port for metrics and health endpoints
+	TraefikJobNames []string // Names of the Traefik jobs in the Nomad cluster that we are watching, e.g. one per datacenter
+	DNSRecordName   string   // Name of the DNS A Record we need to create. This is the same as the "instance" variable in the Terraform module
+	DNSRecordNames  []string // Every name pointing at all the healthy nodes, DNSRecordName first
+	LogLevel        string
+	MetricsEnabled  bool   // Whether to serve the metrics and health endpoints
+	MetricsPort     string // Port for metrics and health endpoints
+	MetricsBind     string // Address the metrics and health endpoints listen on, every interface if empty
+	ScrapePort      string // Port of a second listener serving only the metrics, empty to serve them on MetricsPort
+	ScrapeBind      string // Address the second listener listens on, every interface if empty
+	HealthPath      string // Path of the health endpoint
+	ReadyPath       string // Path of the ready endpoint
+	DebugToken      string // Bearer token required to get the diagnostics bundle, unless empty
+	AuditLogPath    string // File to which every DNS change is appended as a JSON line, "-" for stdout. Empty disables the audit log.
+
+	// Path of the Nomad variable to which the result of every sync is written. Empty disables it.
+	NomadStateVariable string
+
+	// Statuses of the Nomad nodes whose IPs are published
+	ReadyNodeStatuses []string
+
+	// How long the Nomad events must settle before syncing, so that a burst of events results in a single sync
+	EventDebounce time.Duration
+
+	// Maximum time a sync may be postponed while Nomad events keep arriving
+	EventDebounceMax time.Duration
+
+	// Interval of the periodic sync, which catches up with missed events
+	PeriodicSyncInterval time.Duration
+
+	// How long the Nomad event stream may stay silent, without events or heartbeats, before it is reconnected. Zero disables the check.
+	EventStreamStallTimeout time.Duration
+
+	// Consecutive failures of the Nomad event stream after which the controller gives up and stops. Zero never gives up.
+	EventStreamMaxFailures int
+
+	// Interval of the periodic sync when Nomad does not allow the event stream, e.g. because of the ACL token
+	PollInterval time.Duration
+
+	// Daily window during which the syncs following the cluster are paused, e.g. during planned maintenance. Nil disables it.
+	MaintenanceWindow *MaintenanceWindow
+
+	// Interval of the full reconciles, which publish the nodes as they are, ignoring the hysteresis and the grace period
+	// of the disconnected nodes, so that drift held by them heals. Zero disables them.
+	FullReconcileInterval time.Duration
+
+	// Number of Nomad nodes looked up at the same time when discovering the Traefik nodes
+	NodeInfoConcurrency int
+
+	// Number of nodes running Traefik from which the nodes are listed with a single call instead of looked up one by one.
+	// Listed nodes are published at their advertised HTTP address and carry no node meta. Zero always looks them up.
+	NodeListThreshold int
+
+	// Exclude nodes which are not eligible for scheduling.
+	// This is useful for system jobs, where Traefik will not be (re)started on ineligible nodes.
+	ExcludeIneligibleNodes bool
+
+	// Node meta which the nodes must carry to be published, e.g. role=edge, so that Traefik may run on nodes
+	// which are not part of the DNS pool. Nodes must match every pair. Empty publishes the nodes whatever their meta.
+	RequiredNodeMeta map[string]string
+
+	// Node class which the nodes must have to be published, e.g. edge, along with RequiredNodeMeta.
+	// Empty publishes the nodes whatever their class.
+	NodeClassFilter string
+
+	// Node attribute holding the IP which is published, e.g. unique.platform.aws.public-ipv4, or node meta with a "meta." prefix,
+	// e.g. meta.public_ip. Nodes without it are published at DefaultNodeIPAttribute.
+	NodeIPAttribute string
+
+	// Where the IPs of the Traefik nodes are found: IPSourceNomad reads them from the nodes running the Traefik job,
+	// IPSourceConsul from the passing instances of ConsulService, at the address they registered.
+	IPSource      string
+	ConsulAddress string
+	ConsulToken   string
+	ConsulService string
+
+	// HTTP health check of the Traefik of each node, at its IP as found in Nomad, before IP_MAP. Nodes which do not answer
+	// with the expected status are not published. An empty path disables the check.
+	NodeHealthCheckPath    string
+	NodeHealthCheckPort    int
+	NodeHealthCheckStatus  int
+	NodeHealthCheckTimeout time.Duration
+
+	// Propagation verification.
+	// This only makes sense for DNS-only records, since proxied records resolve to Cloudflare's edge.
+	VerifyPropagation      bool          // Resolve the record after each sync and compare it to the target IPs
+	VerifyPropagationDelay time.Duration // How long to wait after a sync before resolving the record
+	VerifyResolver         string        // Resolver (host:port) used for the propagation check
+
+	// Quorum of healthy nodes required before applying changes, so that DNS does not track a transient mass-failure.
+	// A sync is skipped if either threshold is not met. Zero disables the check.
+	MinHealthyNodes    int     // Minimum number of healthy Traefik nodes
+	MinHealthyFraction float64 // Minimum fraction (0-1) of the Traefik nodes reported by Nomad which must be healthy
+
+	// How the nodes which Nomad reports as disconnected, during a network partition, are published:
+	// DisconnectedKeep publishes them, DisconnectedRemove removes them at once, and DisconnectedGrace keeps them
+	// for DisconnectedNodeGrace before removing them.
+	DisconnectedNodePolicy string
+	DisconnectedNodeGrace  time.Duration
+
+	// Number of consecutive syncs in which a node must be healthy before it is published, or unhealthy before it is removed.
+	// One publishes the nodes as soon as they are healthy.
+	NodeHysteresis int
+
+	// Circuit breaker around the Cloudflare API: after CircuitBreakerThreshold consecutive transient failures,
+	// calls are short-circuited for CircuitBreakerCooldown before a single call tests recovery.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+
+	// File listing the IPs to publish instead of the IPs of the Traefik nodes, while it exists. Empty disables it.
+	DesiredStateFile string
+
+	// How long the records are assumed to be as the last full sync left them: until then, a sync publishing the same IPs
+	// skips Cloudflare. The syncs requested by an operator and the full reconciles always reconcile. Zero disables the cache.
+	SyncCacheTTL time.Duration
+
+	// Maximum duration of a sync. Slower syncs are aborted, so that the next one can be attempted. Zero disables the limit.
+	MaxSyncDuration time.Duration
+
+	// Failed Nomad and Cloudflare calls a sync tolerates, all calls together, after which its remaining calls fail fast
+	// and it is left to the next sync. Zero disables the limit, leaving each call to its own retries.
+	SyncRetryBudget int
+
+	// Startup gate, for clusters booting together with the controller: the controller waits for StartupDelay,
+	// then for Nomad and Cloudflare to accept its credentials for at most StartupWaitTimeout. Zero disables either.
+	StartupDelay       time.Duration
+	StartupWaitTimeout time.Duration
+
+	// Log the syncs which change nothing at debug level, with an hourly heartbeat at info level
+	QuietNoopSync bool
+
+	// Only create and update records. Deletions are logged and counted instead.
+	AddOnly bool
+
+	// Remove every record of the names when no Traefik node is healthy, e.g. once the Traefik job is deregistered.
+	// Otherwise such syncs are skipped, so that an empty answer of Nomad during an outage does not wipe DNS.
+	DeleteAllOnEmpty bool
+
+	// Adopt the records of the managed names which were not created by the controller, instead of deleting them.
+	// Adopted records are owned from then on.
+	AdoptExisting bool
+
+	// Names previously used as DNSRecordName. The records created by the controller under these names
+	// are deleted once, on the first sync.
+	PreviousDNSRecordNames []string
+
+	// Per-region records: maps a Nomad datacenter to the name of an additional record
+	// which only points at the nodes of that datacenter.
+	RegionRecordMap map[string]string
+
+	// Per-entrypoint records: maps a Traefik entrypoint to the name of an additional record
+	// which only points at the nodes serving that entrypoint. Nodes list their entrypoints in a node meta key.
+	EntrypointRecordMap map[string]string
+	EntrypointMetaKey   string
+
+	// Failover: node meta pairs designating the primary nodes. The other nodes are failover nodes, which DNSRecordNames
+	// only point at while no primary node is healthy. FailoverRecordName, unless empty, always points at the failover nodes.
+	// Empty publishes every node.
+	PrimaryNodeMeta    map[string]string
+	FailoverRecordName string
+
+	// IPs which are never published, even if Traefik runs on their node. Single IPs are stored as /32 (or /128) prefixes.
+	DenyTargetIPs []netip.Prefix
+
+	// Publish the IPs which are not publicly routable, e.g. 10.0.0.5, for on-premises networks. Otherwise they are never published.
+	AllowPrivateIPs bool
+
+	// Translation of node IPs into the IPs which are published, for nodes behind NAT. IPs are in their canonical form.
+	// Unmapped IPs are published as they are, unless IPMapStrict is set, in which case they are not published at all.
+	IPMap       map[string]string
+	IPMapStrict bool
+
+	// Maximum number of IPs published under DNSRecordNames. Zero publishes every healthy node.
+	MaxRecords int
+	// Which nodes are published when there are more than MaxRecords: SelectIPSort, SelectNameSort or SelectDCPreferred,
+	// which publishes the nodes of PreferredDatacenter first. Ties are broken by IP.
+	RecordSelection     string
+	PreferredDatacenter string
+
+	// Priority and weight of the records of the types which carry them, e.g. MX and SRV, unless a record sets its own.
+	// The A records managed today carry neither, so these only matter to the other record types.
+	RecordPriority int
+	RecordWeight   int
+}
+
+// RecordOverride overrides the settings of a record. Nil fields keep the settings of every record.
+type RecordOverride struct {
+	TTL     *int
+	Proxied *bool
 }
 
+// DefaultNodeIPAttribute is the Nomad node attribute holding the IP address of a node, as fingerprinted by Nomad
+const DefaultNodeIPAttribute = "unique.network.ip-address"
+
+// Sources of IP_SOURCE
+const (
+	IPSourceNomad  = "nomad"  // the nodes running the Traefik job, at NodeIPAttribute
+	IPSourceConsul = "consul" // the instances of a Consul service, at their service address
+)
+
+// Policies of DISCONNECTED_NODE_POLICY
+const (
+	DisconnectedKeep   = "keep"   // disconnected nodes are published until Nomad gives up on them
+	DisconnectedRemove = "remove" // disconnected nodes are removed at once
+	DisconnectedGrace  = "grace"  // disconnected nodes are published for a while, set as "grace:<duration>"
+)
+
+// Policies choosing the published nodes when there are more than MAX_RECORDS
+const (
+	SelectIPSort      = "ip-sort"      // the lowest IPs
+	SelectNameSort    = "name-sort"    // the nodes whose names come first
+	SelectDCPreferred = "dc-preferred" // the nodes of a datacenter, set as "dc-preferred:<datacenter>", then the lowest IPs
+)
+
 // getEnvOrDefault is a helper function to use default values for environment variables if they are not explicitly passed.
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -32,40 +279,833 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// env looks up environment variables for a single controller instance.
+// Variables prefixed with the instance prefix take precedence over the unprefixed ones,
+// so that settings shared by all instances only need to be set once.
+// Variables which are not set fall back to the config file, if any.
+type env struct {
+	prefix   string
+	instance string      // name of the instance, to look up its section of the config file
+	file     *fileConfig // nil unless a config file is used
+}
+
+// get returns the value of the prefixed variable if set, or else the unprefixed one, or else the value in the config file.
+func (e env) get(key string) string {
+	if e.prefix != "" {
+		if value := os.Getenv(e.prefix + key); value != "" {
+			return value
+		}
+	}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return e.file.get(e.instance, key)
+}
+
+// global returns the environment of the process-wide settings, which are never prefixed nor set per instance.
+func (e env) global() env {
+	return env{file: e.file}
+}
+
+// getOrDefault is like get, but returns defaultValue if neither variable is set.
+func (e env) getOrDefault(key, defaultValue string) string {
+	if value := e.get(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getBool parses a boolean variable, recording an error if the value is not a valid boolean.
+func (e env) getBool(key string, defaultValue bool, errs *[]error) bool {
+	value := e.get(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("variable %s must be a boolean, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getInt parses an integer variable, recording an error if the value is not a valid integer.
+func (e env) getInt(key string, defaultValue int, errs *[]error) int {
+	value := e.get(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("variable %s must be an integer, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getFloat parses a floating point variable, recording an error if the value is not a valid number.
+func (e env) getFloat(key string, defaultValue float64, errs *[]error) float64 {
+	value := e.get(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("variable %s must be a number, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getPrefixes parses a comma-separated list of IP addresses and CIDRs, recording an error for every invalid entry.
+// Single addresses are returned as prefixes covering only that address.
+func (e env) getPrefixes(key string, errs *[]error) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(e.get(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("variable %s must list IP addresses or CIDRs, got %q", key, entry))
+				continue
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("variable %s must list IP addresses or CIDRs, got %q", key, entry))
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes
+}
+
+// getList parses a comma-separated list, ignoring empty entries.
+func (e env) getList(key string) []string {
+	var result []string
+	for _, entry := range strings.Split(e.get(key), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// getListOrDefault is like getList, but returns the default values if neither variable is set.
+func (e env) getListOrDefault(key string, defaultValues ...string) []string {
+	if e.get(key) == "" {
+		return defaultValues
+	}
+	return e.getList(key)
+}
+
+// getDNSName parses a DNS record name, recording an error if it is not a valid DNS name.
+// The name is lowercased, as Cloudflare stores it.
+func (e env) getDNSName(key string, errs *[]error) string {
+	name := e.get(key)
+	if name == "" {
+		return ""
+	}
+	normalized, err := normalizeDNSName(name)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("variable %s must be a valid DNS name, got %q: %w", key, name, err))
+	}
+	return normalized
+}
+
+// getDNSNames parses a comma-separated list of DNS record names, recording an error for every invalid name.
+// The names are lowercased, as Cloudflare stores them.
+func (e env) getDNSNames(key string, errs *[]error) []string {
+	var result []string
+	for _, name := range e.getList(key) {
+		normalized, err := normalizeDNSName(name)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("variable %s must list valid DNS names, got %q: %w", key, name, err))
+			continue
+		}
+		result = append(result, normalized)
+	}
+	return result
+}
+
+// getRecordOverrides parses a semicolon-separated list of name:setting=value,... entries overriding the settings of records,
+// recording an error for every invalid entry. The settings are ttl and proxied.
+func (e env) getRecordOverrides(key string, errs *[]error) map[string]RecordOverride {
+	var result map[string]RecordOverride
+	for _, entry := range strings.Split(e.get(key), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, settings, ok := strings.Cut(entry, ":")
+		if _, err := normalizeDNSName(strings.TrimSpace(name)); !ok || err != nil {
+			*errs = append(*errs, fmt.Errorf("variable %s must list name:setting=value,... entries, got %q", key, entry))
+			continue
+		}
+		var override RecordOverride
+		for _, setting := range strings.Split(settings, ",") {
+			k, v, _ := strings.Cut(setting, "=")
+			switch k, v = strings.TrimSpace(k), strings.TrimSpace(v); k {
+			case "ttl":
+				ttl, err := strconv.Atoi(v)
+				if err != nil || ttl < 0 {
+					*errs = append(*errs, fmt.Errorf("variable %s must set a non-negative ttl, got %q", key, entry))
+					continue
+				}
+				override.TTL = &ttl
+			case "proxied":
+				proxied, err := strconv.ParseBool(v)
+				if err != nil {
+					*errs = append(*errs, fmt.Errorf("variable %s must set proxied to a boolean, got %q", key, entry))
+					continue
+				}
+				override.Proxied = &proxied
+			default:
+				*errs = append(*errs, fmt.Errorf("variable %s can only set ttl and proxied, got %q", key, entry))
+			}
+		}
+		if result == nil {
+			result = make(map[string]RecordOverride)
+		}
+		result[recordKey(name)] = override
+	}
+	return result
+}
+
+// getMap parses a comma-separated list of key=value pairs, recording an error for every invalid entry.
+func (e env) getMap(key string, errs *[]error) map[string]string {
+	var result map[string]string
+	for _, entry := range strings.Split(e.get(key), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(entry, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			*errs = append(*errs, fmt.Errorf("variable %s must list key=value pairs, got %q", key, entry))
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// getNameMap parses a comma-separated list of key=name pairs, recording an error for each pair which does not map to a valid DNS name.
+// The names are normalized like those of getDNSNames.
+func (e env) getNameMap(key string, errs *[]error) map[string]string {
+	var result map[string]string
+	for k, v := range e.getMap(key, errs) {
+		name, err := normalizeDNSName(v)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("variable %s must map to valid DNS names, got %q: %w", key, k+"="+v, err))
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[k] = name
+	}
+	return result
+}
+
+// getIPMap parses a comma-separated list of ip=ip pairs, recording an error for each pair which does not map an IP address to another.
+// The addresses are stored in their canonical form.
+func (e env) getIPMap(key string, errs *[]error) map[string]string {
+	var result map[string]string
+	for k, v := range e.getMap(key, errs) {
+		from, fromErr := netip.ParseAddr(k)
+		to, toErr := netip.ParseAddr(v)
+		if fromErr != nil || toErr != nil {
+			*errs = append(*errs, fmt.Errorf("variable %s must map IP addresses to IP addresses, got %q", key, k+"="+v))
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[from.Unmap().String()] = to.Unmap().String()
+	}
+	return result
+}
+
+// getDuration parses a duration variable (e.g. "30s", "5m"), recording an error if the value is not a valid duration.
+func (e env) getDuration(key string, defaultValue time.Duration, errs *[]error) time.Duration {
+	value := e.get(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		*errs = append(*errs, fmt.Errorf("variable %s must be a non-negative duration, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getSeconds parses a duration variable given as a number of seconds (e.g. "5"), as its name says.
+// Durations such as "5s" are accepted as well, as they were documented first.
+func (e env) getSeconds(key string, defaultValue time.Duration, errs *[]error) time.Duration {
+	if seconds, err := strconv.Atoi(e.get(key)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return e.getDuration(key, defaultValue, errs)
+}
+
 // LoadConfig is a function which loads the configuration from envirionment variables.
 // The configuration is loaded into the struct created above.
 func LoadConfig() (*Config, error) {
+	return loadConfig(DefaultInstanceName, env{})
+}
+
+// LoadConfigs loads the configuration of every controller instance listed in the
+// comma-separated CONTROLLER_INSTANCES variable.
+// Each instance reads its variables with the upper-cased instance name as a prefix
+// (e.g. EU_DNS_RECORD_NAME for instance "eu"), falling back to the unprefixed variable.
+// When CONTROLLER_INSTANCES is not set, a single instance is loaded.
+//
+// If CONFIG_FILE is set, variables which are not set are read from that YAML file, which is validated first.
+// The instances of its "instances" section are loaded when CONTROLLER_INSTANCES is not set.
+func LoadConfigs() ([]*Config, error) {
+	var file *fileConfig
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		if file, err = loadConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	if instances := os.Getenv("CONTROLLER_INSTANCES"); instances != "" {
+		names = strings.Split(instances, ",")
+	} else if file != nil && len(file.names) > 0 {
+		names = file.names
+	} else {
+		cfg, err := loadConfig(DefaultInstanceName, env{file: file})
+		if err != nil {
+			return nil, err
+		}
+		return []*Config{cfg}, nil
+	}
+
+	var configs []*Config
+	var errs []error
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("instance %s is listed more than once in CONTROLLER_INSTANCES", name))
+			continue
+		}
+		seen[name] = true
+
+		prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg, err := loadConfig(name, env{prefix: prefix, instance: name, file: file})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", name, err))
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if len(configs) == 0 {
+		return nil, errors.New("variable CONTROLLER_INSTANCES does not list any instance")
+	}
+
+	return configs, nil
+}
+
+// loadConfig loads the configuration of the named instance using the given environment.
+func loadConfig(name string, e env) (*Config, error) {
+	// Every failure is collected so that operators can fix all of them in one pass.
+	var errs []error
+
 	config := &Config{
-		NomadAddress:     getEnvOrDefault("NOMAD_ADDR", "http://localhost:8686"), // This could be nomad.service.consul in a service-discovery cluster.
-		NomadToken:       os.Getenv("NOMAD_TOKEN"),
-		CloudflareToken:  os.Getenv("CLOUDFLARE_API_TOKEN"),
-		CloudflareZoneID: os.Getenv("CLOUDFLARE_ZONE_ID"),
-		TraefikJobName:   getEnvOrDefault("TRAEFIK_JOB_NAME", "ingress"),
-		DNSRecordName:    os.Getenv("DNS_RECORD_NAME"),
-		LogLevel:         getEnvOrDefault("LOG_LEVEL", "info"),
-		MetricsPort:      getEnvOrDefault("METRICS_PORT", "8080"),
+		Name:                  name,
+		NomadAddress:          e.getOrDefault("NOMAD_ADDR", "http://localhost:8686"), // This could be nomad.service.consul in a service-discovery cluster.
+		NomadToken:            e.get("NOMAD_TOKEN"),
+		NomadNamespace:        e.getOrDefault("NOMAD_NAMESPACE", "default"),
+		CloudflareToken:       e.get("CLOUDFLARE_API_TOKEN"),
+		CloudflareZoneID:      e.get("CLOUDFLARE_ZONE_ID"),
+		CloudflareHTTPTimeout: e.getDuration("CLOUDFLARE_HTTP_TIMEOUT", 0, &errs),
+		CloudflareCacheTTL:    e.getDuration("CLOUDFLARE_CACHE_TTL", 30*time.Second, &errs),
+		ShadowZoneID:          e.get("SHADOW_ZONE_ID"),
+		ShadowCloudflareToken: e.get("SHADOW_CLOUDFLARE_API_TOKEN"),
+		Proxied:               e.getBool("CLOUDFLARE_PROXIED", true, &errs),
+		DNSRecordTTL:          e.getInt("DNS_RECORD_TTL", 1, &errs),
+		TraefikJobNames:       e.getListOrDefault("TRAEFIK_JOB_NAME", "ingress"),
+		LogLevel:              e.global().getOrDefault("LOG_LEVEL", "info"),       // Process-wide setting
+		MetricsEnabled:        e.global().getBool("METRICS_ENABLED", true, &errs), // Process-wide setting
+		MetricsPort:           e.global().getOrDefault("METRICS_PORT", "8080"),    // Process-wide setting
+		MetricsBind:           e.global().get("METRICS_BIND_ADDRESS"),             // Process-wide setting
+		ScrapePort:            e.global().get("METRICS_SCRAPE_PORT"),              // Process-wide setting
+		ScrapeBind:            e.global().get("METRICS_SCRAPE_BIND_ADDRESS"),      // Process-wide setting
+		HealthPath:            e.global().getOrDefault("HEALTH_PATH", "/health"),  // Process-wide setting
+		ReadyPath:             e.global().getOrDefault("READY_PATH", "/ready"),    // Process-wide setting
+		DebugToken:            e.global().get("DEBUG_TOKEN"),                      // Process-wide setting
+		AuditLogPath:          e.global().get("AUDIT_LOG_PATH"),                   // Process-wide setting
+
+		NomadStateVariable:      e.get("NOMAD_STATE_VARIABLE"),
+		ReadyNodeStatuses:       e.getList("READY_NODE_STATUSES"),
+		EventDebounce:           e.getSeconds("EVENT_DEBOUNCE_SECONDS", 2*time.Second, &errs),
+		EventDebounceMax:        e.getDuration("EVENT_DEBOUNCE_MAX", 30*time.Second, &errs),
+		PeriodicSyncInterval:    e.getDuration("PERIODIC_SYNC_INTERVAL", 5*time.Minute, &errs),
+		EventStreamStallTimeout: e.getDuration("EVENT_STREAM_STALL_TIMEOUT", time.Minute, &errs),
+		EventStreamMaxFailures:  e.getInt("EVENT_STREAM_MAX_FAILURES", 10, &errs),
+		PollInterval:            e.getDuration("POLL_INTERVAL", 30*time.Second, &errs),
+		FullReconcileInterval:   e.getDuration("FULL_RECONCILE_INTERVAL", 0, &errs),
+
+		NodeInfoConcurrency:    e.getInt("NODE_INFO_CONCURRENCY", 8, &errs),
+		NodeListThreshold:      e.getInt("NODE_LIST_THRESHOLD", 0, &errs),
+		ExcludeIneligibleNodes: e.getBool("EXCLUDE_INELIGIBLE_NODES", false, &errs),
+		RequiredNodeMeta:       e.getMap("REQUIRED_NODE_META", &errs),
+		NodeClassFilter:        e.get("NOMAD_NODE_CLASS"),
+		NodeIPAttribute:        e.getOrDefault("NODE_IP_ATTRIBUTE", DefaultNodeIPAttribute),
+
+		IPSource:      e.getOrDefault("IP_SOURCE", IPSourceNomad),
+		ConsulAddress: e.getOrDefault("CONSUL_HTTP_ADDR", "http://127.0.0.1:8500"),
+		ConsulToken:   e.get("CONSUL_HTTP_TOKEN"),
+		ConsulService: e.getOrDefault("CONSUL_SERVICE_NAME", "traefik"),
+
+		NodeHealthCheckPath:    e.get("NODE_HEALTH_CHECK_PATH"),
+		NodeHealthCheckPort:    e.getInt("NODE_HEALTH_CHECK_PORT", 80, &errs),
+		NodeHealthCheckStatus:  e.getInt("NODE_HEALTH_CHECK_STATUS", 200, &errs),
+		NodeHealthCheckTimeout: e.getDuration("NODE_HEALTH_CHECK_TIMEOUT", 2*time.Second, &errs),
+
+		VerifyPropagation:      e.getBool("VERIFY_PROPAGATION", false, &errs),
+		VerifyPropagationDelay: e.getDuration("VERIFY_PROPAGATION_DELAY", time.Minute, &errs),
+		VerifyResolver:         e.getOrDefault("VERIFY_RESOLVER", "1.1.1.1:53"),
+
+		MinHealthyNodes:    e.getInt("MIN_HEALTHY_NODES", 0, &errs),
+		MinHealthyFraction: e.getFloat("MIN_HEALTHY_FRACTION", 0, &errs),
+		NodeHysteresis:     e.getInt("NODE_HYSTERESIS", 1, &errs),
+
+		DisconnectedNodePolicy: e.getOrDefault("DISCONNECTED_NODE_POLICY", DisconnectedGrace+":1m"),
+
+		DesiredStateFile:   e.get("DESIRED_STATE_FILE"),
+		MaxSyncDuration:    e.getDuration("MAX_SYNC_DURATION", 2*time.Minute, &errs),
+		SyncCacheTTL:       e.getDuration("SYNC_CACHE_TTL", 0, &errs),
+		SyncRetryBudget:    e.getInt("SYNC_RETRY_BUDGET", 0, &errs),
+		StartupDelay:       e.getDuration("STARTUP_DELAY", 0, &errs),
+		StartupWaitTimeout: e.getDuration("STARTUP_WAIT_TIMEOUT", 0, &errs),
+		QuietNoopSync:      e.getBool("QUIET_NOOP_SYNC", false, &errs),
+
+		CircuitBreakerThreshold: e.getInt("CLOUDFLARE_BREAKER_THRESHOLD", 5, &errs),
+		CircuitBreakerCooldown:  e.getDuration("CLOUDFLARE_BREAKER_COOLDOWN", 5*time.Minute, &errs),
+
+		AddOnly:                e.getBool("ADD_ONLY", false, &errs),
+		DeleteAllOnEmpty:       e.getBool("DELETE_ALL_ON_EMPTY", false, &errs),
+		AdoptExisting:          e.getBool("ADOPT_EXISTING", false, &errs),
+		PreviousDNSRecordNames: e.getDNSNames("PREVIOUS_DNS_RECORD_NAMES", &errs),
+		RegionRecordMap:        e.getNameMap("REGION_RECORD_MAP", &errs),
+		EntrypointRecordMap:    e.getNameMap("ENTRYPOINT_RECORD_MAP", &errs),
+		EntrypointMetaKey:      e.getOrDefault("ENTRYPOINT_META_KEY", "traefik_entrypoints"),
+		PrimaryNodeMeta:        e.getMap("PRIMARY_NODE_META", &errs),
+		FailoverRecordName:     e.getDNSName("FAILOVER_RECORD_NAME", &errs),
+		RecordOverrides:        e.getRecordOverrides("RECORD_OVERRIDES", &errs),
+		DenyTargetIPs:          e.getPrefixes("DENY_TARGET_IPS", &errs),
+		AllowPrivateIPs:        e.getBool("ALLOW_PRIVATE_IPS", false, &errs),
+		IPMap:                  e.getIPMap("IP_MAP", &errs),
+		IPMapStrict:            e.getBool("IP_MAP_STRICT", false, &errs),
+		MaxRecords:             e.getInt("MAX_RECORDS", 0, &errs),
+		RecordSelection:        e.getOrDefault("RECORD_SELECTION", SelectIPSort),
+		RecordPriority:         e.getInt("RECORD_PRIORITY", 10, &errs),
+		RecordWeight:           e.getInt("RECORD_WEIGHT", 0, &errs),
+	}
+
+	// The grace period is part of the policy, e.g. grace:5m
+	if grace, ok := strings.CutPrefix(config.DisconnectedNodePolicy, DisconnectedGrace+":"); ok {
+		config.DisconnectedNodePolicy = DisconnectedGrace
+		config.DisconnectedNodeGrace, _ = time.ParseDuration(grace) // validated below
+	}
+
+	// The preferred datacenter is part of the policy, e.g. dc-preferred:eu-west
+	if datacenter, ok := strings.CutPrefix(config.RecordSelection, SelectDCPreferred+":"); ok {
+		config.RecordSelection = SelectDCPreferred
+		config.PreferredDatacenter = datacenter
+	}
+
+	// DNS_RECORD_NAME may also list several names, the first of which is the main one
+	var names []string
+	if strings.Contains(e.get("DNS_RECORD_NAME"), ",") {
+		names = e.getDNSNames("DNS_RECORD_NAME", &errs)
+	} else if name := e.getDNSName("DNS_RECORD_NAME", &errs); name != "" {
+		names = []string{name}
+	}
+	// DNS_RECORD_NAMES adds names to DNS_RECORD_NAME, or replaces it, in which case its first name is the main one
+	names = append(names, e.getDNSNames("DNS_RECORD_NAMES", &errs)...)
+	if len(names) > 0 {
+		config.DNSRecordName = names[0]
+	}
+	for _, name := range names {
+		if !containsName(config.DNSRecordNames, name) {
+			config.DNSRecordNames = append(config.DNSRecordNames, name)
+		}
+	}
+
+	if window := e.get("MAINTENANCE_WINDOW"); window != "" {
+		var err error
+		if config.MaintenanceWindow, err = parseMaintenanceWindow(window, e.getOrDefault("MAINTENANCE_WINDOW_TIMEZONE", "UTC")); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	// Check if required values are not set
+	// The event stream is scoped like the Traefik job, unless told otherwise
+	config.EventStreamNamespace = e.getOrDefault("EVENT_STREAM_NAMESPACE", config.NomadNamespace)
+
+	if len(config.ReadyNodeStatuses) == 0 {
+		config.ReadyNodeStatuses = []string{"ready"}
+	}
+
+	// Check if required values are not set.
 	if config.CloudflareToken == "" {
-		return nil, fmt.Errorf("variable CLOUDFLARE_API_TOKEN is not set and is required")
+		errs = append(errs, errors.New("variable CLOUDFLARE_API_TOKEN is not set and is required"))
 	}
 
 	if config.CloudflareZoneID == "" {
-		return nil, fmt.Errorf("variable CLOUDFLARE_ZONE_ID is not set and is required")
+		errs = append(errs, errors.New("variable CLOUDFLARE_ZONE_ID is not set and is required"))
+	}
+
+	if config.ShadowZoneID != "" && config.ShadowZoneID == config.CloudflareZoneID {
+		errs = append(errs, errors.New("variable SHADOW_ZONE_ID must not be the production zone CLOUDFLARE_ZONE_ID"))
 	}
 
-	if config.TraefikJobName == "" {
-		return nil, fmt.Errorf("variable TRAEFIK_JOB_NAME is not set and is required")
+	if len(config.TraefikJobNames) == 0 {
+		errs = append(errs, errors.New("variable TRAEFIK_JOB_NAME is not set and is required"))
 	}
 
 	if config.DNSRecordName == "" {
-		return nil, fmt.Errorf("variable DNS_RECORD_NAME is not set and is required")
+		errs = append(errs, errors.New("variable DNS_RECORD_NAME is not set and is required, unless DNS_RECORD_NAMES is set"))
 	}
 
 	if config.NomadToken == "" {
-		return nil, fmt.Errorf("nomad token is not set and is required")
+		errs = append(errs, errors.New("nomad token is not set and is required"))
+	}
+
+	if config.DNSRecordTTL < 0 {
+		errs = append(errs, fmt.Errorf("variable DNS_RECORD_TTL must not be negative, got %d", config.DNSRecordTTL))
+	}
+
+	if config.SyncRetryBudget < 0 {
+		errs = append(errs, fmt.Errorf("variable SYNC_RETRY_BUDGET must not be negative, got %d", config.SyncRetryBudget))
+	}
+
+	if config.MinHealthyNodes < 0 {
+		errs = append(errs, fmt.Errorf("variable MIN_HEALTHY_NODES must not be negative, got %d", config.MinHealthyNodes))
+	}
+
+	if config.MinHealthyFraction < 0 || config.MinHealthyFraction > 1 {
+		errs = append(errs, fmt.Errorf("variable MIN_HEALTHY_FRACTION must be between 0 and 1, got %g", config.MinHealthyFraction))
+	}
+
+	if config.PollInterval == 0 {
+		errs = append(errs, errors.New("variable POLL_INTERVAL must be positive"))
+	}
+
+	if config.PeriodicSyncInterval == 0 {
+		errs = append(errs, errors.New("variable PERIODIC_SYNC_INTERVAL must be positive"))
+	}
+
+	if config.NodeHysteresis < 1 {
+		errs = append(errs, fmt.Errorf("variable NODE_HYSTERESIS must be at least 1, got %d", config.NodeHysteresis))
+	}
+
+	if config.NodeInfoConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("variable NODE_INFO_CONCURRENCY must be at least 1, got %d", config.NodeInfoConcurrency))
+	}
+
+	if config.EventStreamMaxFailures < 0 {
+		errs = append(errs, fmt.Errorf("variable EVENT_STREAM_MAX_FAILURES must not be negative, got %d", config.EventStreamMaxFailures))
+	}
+
+	if config.NodeListThreshold < 0 {
+		errs = append(errs, fmt.Errorf("variable NODE_LIST_THRESHOLD must not be negative, got %d", config.NodeListThreshold))
+	}
+
+	// The entrypoints of the nodes are in their node meta, which the node list does not include
+	if config.NodeListThreshold > 0 && len(config.EntrypointRecordMap) > 0 {
+		errs = append(errs, errors.New("variable NODE_LIST_THRESHOLD cannot be set along with ENTRYPOINT_RECORD_MAP"))
+	}
+	if config.NodeListThreshold > 0 && len(config.RequiredNodeMeta) > 0 {
+		errs = append(errs, errors.New("variable NODE_LIST_THRESHOLD cannot be set along with REQUIRED_NODE_META"))
+	}
+	if config.NodeListThreshold > 0 && len(config.PrimaryNodeMeta) > 0 {
+		errs = append(errs, errors.New("variable NODE_LIST_THRESHOLD cannot be set along with PRIMARY_NODE_META"))
+	}
+	if config.NodeListThreshold > 0 && config.NodeIPAttribute != DefaultNodeIPAttribute {
+		errs = append(errs, errors.New("variable NODE_LIST_THRESHOLD cannot be set along with NODE_IP_ATTRIBUTE"))
+	}
+	switch config.IPSource {
+	case IPSourceNomad:
+	case IPSourceConsul:
+		// The service instances carry neither the node attributes nor the node meta
+		for variable, set := range map[string]bool{
+			"NODE_IP_ATTRIBUTE":     config.NodeIPAttribute != DefaultNodeIPAttribute,
+			"NOMAD_NODE_CLASS":      config.NodeClassFilter != "",
+			"REQUIRED_NODE_META":    len(config.RequiredNodeMeta) > 0,
+			"PRIMARY_NODE_META":     len(config.PrimaryNodeMeta) > 0,
+			"ENTRYPOINT_RECORD_MAP": len(config.EntrypointRecordMap) > 0,
+		} {
+			if set {
+				errs = append(errs, fmt.Errorf("variable %s cannot be set along with IP_SOURCE=consul", variable))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("variable IP_SOURCE must be %s or %s, got %q", IPSourceNomad, IPSourceConsul, config.IPSource))
+	}
+	if config.FailoverRecordName != "" && len(config.PrimaryNodeMeta) == 0 {
+		errs = append(errs, errors.New("variable FAILOVER_RECORD_NAME requires PRIMARY_NODE_META, which tells the failover nodes apart"))
+	}
+
+	if config.NodeHealthCheckPath != "" {
+		if !isEndpointPath(config.NodeHealthCheckPath) {
+			errs = append(errs, fmt.Errorf("variable NODE_HEALTH_CHECK_PATH must be a path starting with /, got %q", config.NodeHealthCheckPath))
+		}
+		if config.NodeHealthCheckPort < 1 || config.NodeHealthCheckPort > 65535 {
+			errs = append(errs, fmt.Errorf("variable NODE_HEALTH_CHECK_PORT must be a port number, got %d", config.NodeHealthCheckPort))
+		}
+		if config.NodeHealthCheckStatus < 100 || config.NodeHealthCheckStatus > 599 {
+			errs = append(errs, fmt.Errorf("variable NODE_HEALTH_CHECK_STATUS must be an HTTP status code, got %d", config.NodeHealthCheckStatus))
+		}
+		if config.NodeHealthCheckTimeout == 0 {
+			errs = append(errs, errors.New("variable NODE_HEALTH_CHECK_TIMEOUT must be positive"))
+		}
+	}
+
+	if config.CircuitBreakerThreshold < 1 {
+		errs = append(errs, fmt.Errorf("variable CLOUDFLARE_BREAKER_THRESHOLD must be at least 1, got %d", config.CircuitBreakerThreshold))
+	}
+
+	if config.MaxRecords < 0 {
+		errs = append(errs, fmt.Errorf("variable MAX_RECORDS must not be negative, got %d", config.MaxRecords))
+	}
+
+	for variable, value := range map[string]int{"RECORD_PRIORITY": config.RecordPriority, "RECORD_WEIGHT": config.RecordWeight} {
+		if value < 0 || value > math.MaxUint16 {
+			errs = append(errs, fmt.Errorf("variable %s must be between 0 and %d, got %d", variable, math.MaxUint16, value))
+		}
+	}
+
+	switch config.DisconnectedNodePolicy {
+	case DisconnectedKeep, DisconnectedRemove:
+	case DisconnectedGrace:
+		if config.DisconnectedNodeGrace <= 0 {
+			errs = append(errs, errors.New("variable DISCONNECTED_NODE_POLICY must set a positive grace period, e.g. grace:1m"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("variable DISCONNECTED_NODE_POLICY must be %s, %s or %s:<duration>, got %q", DisconnectedKeep, DisconnectedRemove, DisconnectedGrace, config.DisconnectedNodePolicy))
+	}
+
+	switch config.RecordSelection {
+	case SelectIPSort, SelectNameSort:
+	case SelectDCPreferred:
+		if config.PreferredDatacenter == "" {
+			errs = append(errs, errors.New("variable RECORD_SELECTION must name the preferred datacenter, e.g. dc-preferred:eu-west"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("variable RECORD_SELECTION must be %s, %s or %s:<datacenter>, got %q", SelectIPSort, SelectNameSort, SelectDCPreferred, config.RecordSelection))
+	}
+
+	if config.NomadStateVariable != "" && !variablePath.MatchString(config.NomadStateVariable) {
+		errs = append(errs, fmt.Errorf("variable NOMAD_STATE_VARIABLE must be a Nomad variable path such as nomad/jobs/ingress/dns-state, got %q", config.NomadStateVariable))
+	}
+
+	for _, status := range config.ReadyNodeStatuses {
+		if !slices.Contains(nodeStatuses, status) {
+			errs = append(errs, fmt.Errorf("variable READY_NODE_STATUSES must only list Nomad node statuses (%s), got %q", strings.Join(nodeStatuses, ", "), status))
+		}
+	}
+
+	for variable, path := range map[string]string{"HEALTH_PATH": config.HealthPath, "READY_PATH": config.ReadyPath} {
+		if !isEndpointPath(path) {
+			errs = append(errs, fmt.Errorf("variable %s must be a path starting with /, got %q", variable, path))
+		}
+	}
+	reserved := []string{"/metrics", "/state", "/debug/bundle", "/sync"}
+	if config.HealthPath == config.ReadyPath || slices.Contains(reserved, config.HealthPath) || slices.Contains(reserved, config.ReadyPath) {
+		errs = append(errs, errors.New("variables HEALTH_PATH and READY_PATH must differ from each other and from "+strings.Join(reserved, ", ")))
+	}
+
+	for variable, address := range map[string]string{"METRICS_BIND_ADDRESS": config.MetricsBind, "METRICS_SCRAPE_BIND_ADDRESS": config.ScrapeBind} {
+		if _, err := netip.ParseAddr(address); address != "" && err != nil {
+			errs = append(errs, fmt.Errorf("variable %s must be an IP address, got %q", variable, address))
+		}
+	}
+	if config.ScrapePort != "" {
+		if port, err := strconv.Atoi(config.ScrapePort); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("variable METRICS_SCRAPE_PORT must be a port number, got %q", config.ScrapePort))
+		} else if config.ScrapePort == config.MetricsPort && config.ScrapeBind == config.MetricsBind {
+			errs = append(errs, errors.New("variable METRICS_SCRAPE_PORT must differ from METRICS_PORT, unless the listeners have different bind addresses"))
+		}
+	}
+
+	if !isResolverAddress(config.VerifyResolver) {
+		errs = append(errs, fmt.Errorf("variable VERIFY_RESOLVER must be udp:host:port, tcp:host:port or an https:// URL, got %q", config.VerifyResolver))
+	}
+
+	// Cleaning up a name which is still managed would delete the records just created.
+	// Names are compared as Cloudflare does, so that e.g. a trailing dot does not make a managed name look different.
+	regionNames := slices.Collect(maps.Values(config.RegionRecordMap))
+	entrypointNames := slices.Collect(maps.Values(config.EntrypointRecordMap))
+	for _, previous := range config.PreviousDNSRecordNames {
+		if containsName(config.DNSRecordNames, previous) {
+			errs = append(errs, fmt.Errorf("variable PREVIOUS_DNS_RECORD_NAMES must not contain DNS_RECORD_NAME %s", previous))
+		}
+		if containsName(regionNames, previous) {
+			errs = append(errs, fmt.Errorf("variable PREVIOUS_DNS_RECORD_NAMES must not contain region record %s", previous))
+		}
+		if containsName(entrypointNames, previous) {
+			errs = append(errs, fmt.Errorf("variable PREVIOUS_DNS_RECORD_NAMES must not contain entrypoint record %s", previous))
+		}
+		if config.FailoverRecordName != "" && recordKey(previous) == recordKey(config.FailoverRecordName) {
+			errs = append(errs, fmt.Errorf("variable PREVIOUS_DNS_RECORD_NAMES must not contain FAILOVER_RECORD_NAME %s", previous))
+		}
+	}
+
+	for datacenter, name := range config.RegionRecordMap {
+		if containsName(config.DNSRecordNames, name) {
+			errs = append(errs, fmt.Errorf("variable REGION_RECORD_MAP must not map datacenter %s to DNS_RECORD_NAME", datacenter))
+		}
+	}
+
+	// Records reconciled with different sets of nodes would undo each other's changes
+	for entrypoint, name := range config.EntrypointRecordMap {
+		if containsName(config.DNSRecordNames, name) {
+			errs = append(errs, fmt.Errorf("variable ENTRYPOINT_RECORD_MAP must not map entrypoint %s to DNS_RECORD_NAME", entrypoint))
+		}
+		if containsName(regionNames, name) {
+			errs = append(errs, fmt.Errorf("variable ENTRYPOINT_RECORD_MAP must not map entrypoint %s to region record %s", entrypoint, name))
+		}
+	}
+
+	if name := config.FailoverRecordName; name != "" {
+		if containsName(config.DNSRecordNames, name) || containsName(regionNames, name) || containsName(entrypointNames, name) {
+			errs = append(errs, fmt.Errorf("variable FAILOVER_RECORD_NAME must differ from DNS_RECORD_NAME and the region and entrypoint records, got %s", name))
+		}
+	}
+
+	// An override of a name which is not managed would silently do nothing
+	managed := make(map[string]bool)
+	for _, names := range [][]string{config.DNSRecordNames, regionNames, entrypointNames} {
+		for _, name := range names {
+			managed[recordKey(name)] = true
+		}
+	}
+	if config.FailoverRecordName != "" {
+		managed[recordKey(config.FailoverRecordName)] = true
+	}
+	for name := range config.RecordOverrides {
+		if !managed[name] {
+			errs = append(errs, fmt.Errorf("variable RECORD_OVERRIDES must only override managed records, got %s", name))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
+
+// RecordSettings returns the TTL and proxied setting of the named record:
+// DNSRecordTTL and Proxied, unless RECORD_OVERRIDES overrides them.
+func (c *Config) RecordSettings(name string) (ttl int, proxied bool) {
+	ttl, proxied = c.DNSRecordTTL, c.Proxied
+	override := c.RecordOverrides[recordKey(name)]
+	if override.TTL != nil {
+		ttl = *override.TTL
+	}
+	if override.Proxied != nil {
+		proxied = *override.Proxied
+	}
+	return ttl, proxied
+}
+
+// recordKey returns the name under which the overrides of a record are stored: lowercase, without a trailing dot
+func recordKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// redacted is the value replacing the secrets of a redacted configuration
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration whose secrets are replaced, so that it can be logged or exported
+func (c Config) Redacted() Config {
+	for _, secret := range []*string{&c.NomadToken, &c.CloudflareToken, &c.ShadowCloudflareToken, &c.ConsulToken, &c.DebugToken} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return c
+}
+
+// containsName reports whether the name is one of the names, compared as Cloudflare does: case-insensitively and without a trailing dot
+func containsName(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(n string) bool { return recordKey(n) == recordKey(name) })
+}
+
+// variablePath matches the paths of Nomad variables
+var variablePath = regexp.MustCompile(`^[a-zA-Z0-9_~-]+(/[a-zA-Z0-9_~-]+)*$`)
+
+// nodeStatuses are the statuses a Nomad node can have
+var nodeStatuses = []string{"initializing", "ready", "down", "disconnected"}
+
+// dnsLabel matches a label of a DNS name: at most 63 letters, digits and hyphens, not starting or ending with a hyphen
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeDNSName lowercases a DNS name, strips its trailing dot and checks that it is valid.
+// The name may be a zone apex, start with the * wildcard label, and end with a dot, which Cloudflare does not store.
+func normalizeDNSName(name string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if len(name) > 253 {
+		return name, errors.New("longer than 253 characters")
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "*" && i == 0 && len(labels) > 1 {
+			continue
+		}
+		if !dnsLabel.MatchString(label) {
+			return name, fmt.Errorf("invalid label %q", label)
+		}
+	}
+	return name, nil
+}
+
+// isResolverAddress reports whether address is a resolver the propagation check can query:
+// host:port, optionally prefixed with udp: or tcp:, or the URL of a DNS over HTTPS resolver
+func isResolverAddress(address string) bool {
+	if strings.HasPrefix(address, "https://") {
+		u, err := url.ParseRequestURI(address)
+		return err == nil && u.Host != ""
+	}
+	if prefix, rest, ok := strings.Cut(address, ":"); ok && (prefix == "udp" || prefix == "tcp") {
+		address = rest
+	}
+	_, port, err := net.SplitHostPort(address)
+	n, portErr := strconv.Atoi(port)
+	return err == nil && portErr == nil && n > 0 && n <= 65535
+}
+
+// isEndpointPath reports whether path can be served as an HTTP endpoint: an absolute path without spaces or wildcards
+func isEndpointPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.ContainsAny(path, " \t{}")
+}
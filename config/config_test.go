@@ -3,8 +3,12 @@ package config
 // Unit tests for the config package.
 
 import (
+	"net/netip"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // The GetEnvOrDefault function should set defaults for required environment variables if they are not set
@@ -58,12 +62,12 @@ func TestLoadConfig(t *testing.T) {
 	// tests is a list of test scenarios
 	// Each scenario has a name, a list of environment variables to be set in the scenario,
 	// whether or not to expect an error
-	// and an associated error message
+	// and the error messages which should all be present in the combined error
 	tests := []struct {
 		name        string
 		envVars     map[string]string
 		expectError bool
-		errorMsg    string
+		errorMsgs   []string
 	}{
 		{
 			// We should be able to set all variables explicitly and create a valid configuration.
@@ -99,7 +103,7 @@ func TestLoadConfig(t *testing.T) {
 				"DNS_RECORD_NAME":    "test.example.com",
 			},
 			expectError: true,
-			errorMsg:    "variable CLOUDFLARE_API_TOKEN is not set and is required",
+			errorMsgs:   []string{"variable CLOUDFLARE_API_TOKEN is not set and is required"},
 		},
 		{
 			name: "Missing cloudflare zone id is an invalid configuration since there is no default",
@@ -108,7 +112,10 @@ func TestLoadConfig(t *testing.T) {
 				"NOMAD_TOKEN":          "test_nomad_token",
 			},
 			expectError: true,
-			errorMsg:    "variable CLOUDFLARE_ZONE_ID is not set and is required",
+			errorMsgs: []string{
+				"variable CLOUDFLARE_ZONE_ID is not set and is required",
+				"variable DNS_RECORD_NAME is not set and is required",
+			},
 		},
 		{
 			name: "Missing Nomad token is an invalid configuration since there is no default.",
@@ -118,7 +125,286 @@ func TestLoadConfig(t *testing.T) {
 				"DNS_RECORD_NAME":      "test.example.com",
 			},
 			expectError: true,
-			errorMsg:    "nomad token is not set and is required",
+			errorMsgs:   []string{"nomad token is not set and is required"},
+		},
+		{
+			name: "Invalid record settings are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"DNS_RECORD_TTL":       "-5",
+				"CLOUDFLARE_PROXIED":   "orange",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				`variable CLOUDFLARE_PROXIED must be a boolean, got "orange"`,
+				"variable DNS_RECORD_TTL must not be negative, got -5",
+			},
+		},
+		{
+			name: "Invalid circuit breaker settings are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN":         "test_token",
+				"CLOUDFLARE_ZONE_ID":           "test_zone_id",
+				"NOMAD_TOKEN":                  "test_nomad_token",
+				"DNS_RECORD_NAME":              "test.example.com",
+				"CLOUDFLARE_BREAKER_THRESHOLD": "0",
+				"CLOUDFLARE_BREAKER_COOLDOWN":  "later",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				"variable CLOUDFLARE_BREAKER_THRESHOLD must be at least 1, got 0",
+				`variable CLOUDFLARE_BREAKER_COOLDOWN must be a non-negative duration, got "later"`,
+			},
+		},
+		{
+			name: "A shadow zone which is the production zone is an invalid configuration.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"SHADOW_ZONE_ID":       "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+			},
+			expectError: true,
+			errorMsgs:   []string{"variable SHADOW_ZONE_ID must not be the production zone CLOUDFLARE_ZONE_ID"},
+		},
+		{
+			name: "Invalid endpoint paths are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"HEALTH_PATH":          "healthz",
+				"READY_PATH":           "/metrics",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				`variable HEALTH_PATH must be a path starting with /, got "healthz"`,
+				"variables HEALTH_PATH and READY_PATH must differ from each other and from /metrics, /state, /debug/bundle, /sync",
+			},
+		},
+		{
+			name: "Unknown node statuses are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"READY_NODE_STATUSES":  "ready, draining",
+			},
+			expectError: true,
+			errorMsgs:   []string{`variable READY_NODE_STATUSES must only list Nomad node statuses (initializing, ready, down, disconnected), got "draining"`},
+		},
+		{
+			name: "Invalid metrics listeners are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN":        "test_token",
+				"CLOUDFLARE_ZONE_ID":          "test_zone_id",
+				"NOMAD_TOKEN":                 "test_nomad_token",
+				"DNS_RECORD_NAME":             "test.example.com",
+				"METRICS_BIND_ADDRESS":        "eth0",
+				"METRICS_SCRAPE_PORT":         "8080",
+				"METRICS_SCRAPE_BIND_ADDRESS": "eth0",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				`variable METRICS_BIND_ADDRESS must be an IP address, got "eth0"`,
+				`variable METRICS_SCRAPE_BIND_ADDRESS must be an IP address, got "eth0"`,
+				"variable METRICS_SCRAPE_PORT must differ from METRICS_PORT, unless the listeners have different bind addresses",
+			},
+		},
+		{
+			name: "A negative retry budget is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"SYNC_RETRY_BUDGET":    "-1",
+			},
+			expectError: true,
+			errorMsgs:   []string{"variable SYNC_RETRY_BUDGET must not be negative, got -1"},
+		},
+		{
+			name: "A negative number of event stream failures is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN":      "test_token",
+				"CLOUDFLARE_ZONE_ID":        "test_zone_id",
+				"NOMAD_TOKEN":               "test_nomad_token",
+				"DNS_RECORD_NAME":           "test.example.com",
+				"EVENT_STREAM_MAX_FAILURES": "-1",
+			},
+			expectError: true,
+			errorMsgs:   []string{"variable EVENT_STREAM_MAX_FAILURES must not be negative, got -1"},
+		},
+		{
+			name: "An invalid maintenance window and time zone are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN":        "test_token",
+				"CLOUDFLARE_ZONE_ID":          "test_zone_id",
+				"NOMAD_TOKEN":                 "test_nomad_token",
+				"DNS_RECORD_NAME":             "test.example.com",
+				"MAINTENANCE_WINDOW":          "22:00-02:00",
+				"MAINTENANCE_WINDOW_TIMEZONE": "Mars/Olympus",
+			},
+			expectError: true,
+			errorMsgs:   []string{`variable MAINTENANCE_WINDOW_TIMEZONE must be a time zone such as Europe/Paris, got "Mars/Olympus"`},
+		},
+		{
+			name: "An invalid node health check is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN":      "test_token",
+				"CLOUDFLARE_ZONE_ID":        "test_zone_id",
+				"NOMAD_TOKEN":               "test_nomad_token",
+				"DNS_RECORD_NAME":           "test.example.com",
+				"NODE_HEALTH_CHECK_PATH":    "ping",
+				"NODE_HEALTH_CHECK_PORT":    "0",
+				"NODE_HEALTH_CHECK_STATUS":  "2000",
+				"NODE_HEALTH_CHECK_TIMEOUT": "0s",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				`variable NODE_HEALTH_CHECK_PATH must be a path starting with /, got "ping"`,
+				"variable NODE_HEALTH_CHECK_PORT must be a port number, got 0",
+				"variable NODE_HEALTH_CHECK_STATUS must be an HTTP status code, got 2000",
+				"variable NODE_HEALTH_CHECK_TIMEOUT must be positive",
+			},
+		},
+		{
+			name: "Out of range record priority and weight are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"RECORD_PRIORITY":      "-1",
+				"RECORD_WEIGHT":        "65536",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				"variable RECORD_PRIORITY must be between 0 and 65535, got -1",
+				"variable RECORD_WEIGHT must be between 0 and 65535, got 65536",
+			},
+		},
+		{
+			name: "A resolver which is neither host:port nor a URL is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"VERIFY_RESOLVER":      "quic:1.1.1.1",
+			},
+			expectError: true,
+			errorMsgs:   []string{`variable VERIFY_RESOLVER must be udp:host:port, tcp:host:port or an https:// URL, got "quic:1.1.1.1"`},
+		},
+		{
+			name: "A failover record without primary nodes, or clashing with another record, is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"FAILOVER_RECORD_NAME": "Test.example.com.",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				"variable FAILOVER_RECORD_NAME requires PRIMARY_NODE_META",
+				"variable FAILOVER_RECORD_NAME must differ from DNS_RECORD_NAME and the region and entrypoint records, got test.example.com",
+			},
+		},
+		{
+			name: "Primary nodes cannot be told apart in the node list.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"PRIMARY_NODE_META":    "dns_tier=primary",
+				"NODE_LIST_THRESHOLD":  "100",
+			},
+			expectError: true,
+			errorMsgs:   []string{"variable NODE_LIST_THRESHOLD cannot be set along with PRIMARY_NODE_META"},
+		},
+		{
+			name: "A scrape port which is not a port number is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"METRICS_SCRAPE_PORT":  "70000",
+			},
+			expectError: true,
+			errorMsgs:   []string{`variable METRICS_SCRAPE_PORT must be a port number, got "70000"`},
+		},
+		{
+			name: "An invalid Nomad variable path is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"NOMAD_STATE_VARIABLE": "/nomad/jobs/ingress state",
+			},
+			expectError: true,
+			errorMsgs:   []string{`variable NOMAD_STATE_VARIABLE must be a Nomad variable path such as nomad/jobs/ingress/dns-state, got "/nomad/jobs/ingress state"`},
+		},
+		{
+			name: "A node hysteresis of zero is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"NODE_HYSTERESIS":      "0",
+			},
+			expectError: true,
+			errorMsgs:   []string{"variable NODE_HYSTERESIS must be at least 1, got 0"},
+		},
+		{
+			name: "A poll interval of zero is reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN": "test_token",
+				"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+				"NOMAD_TOKEN":          "test_nomad_token",
+				"DNS_RECORD_NAME":      "test.example.com",
+				"POLL_INTERVAL":        "0s",
+			},
+			expectError: true,
+			errorMsgs:   []string{"variable POLL_INTERVAL must be positive"},
+		},
+		{
+			name: "Invalid sync timings are reported.",
+			envVars: map[string]string{
+				"CLOUDFLARE_API_TOKEN":   "test_token",
+				"CLOUDFLARE_ZONE_ID":     "test_zone_id",
+				"NOMAD_TOKEN":            "test_nomad_token",
+				"DNS_RECORD_NAME":        "test.example.com",
+				"EVENT_DEBOUNCE_SECONDS": "soon",
+				"PERIODIC_SYNC_INTERVAL": "0s",
+			},
+			expectError: true,
+			errorMsgs: []string{
+				`variable EVENT_DEBOUNCE_SECONDS must be a non-negative duration, got "soon"`,
+				"variable PERIODIC_SYNC_INTERVAL must be positive",
+			},
+		},
+		{
+			// All missing fields are reported together rather than one per run.
+			name:        "Missing all required variables reports every missing variable at once.",
+			envVars:     map[string]string{},
+			expectError: true,
+			errorMsgs: []string{
+				"variable CLOUDFLARE_API_TOKEN is not set and is required",
+				"variable CLOUDFLARE_ZONE_ID is not set and is required",
+				"variable DNS_RECORD_NAME is not set and is required",
+				"nomad token is not set and is required",
+			},
 		},
 	}
 
@@ -129,6 +415,8 @@ func TestLoadConfig(t *testing.T) {
 			envKeys := []string{
 				"NOMAD_ADDR", "NOMAD_TOKEN", "CLOUDFLARE_API_TOKEN",
 				"CLOUDFLARE_ZONE_ID", "TRAEFIK_JOB_NAME", "DNS_RECORD_NAME", "LOG_LEVEL",
+				"DNS_RECORD_TTL", "CLOUDFLARE_PROXIED",
+				"CLOUDFLARE_BREAKER_THRESHOLD", "CLOUDFLARE_BREAKER_COOLDOWN",
 			}
 			// For each key, unset it so that we revert to defaults
 			for _, key := range envKeys {
@@ -155,8 +443,13 @@ func TestLoadConfig(t *testing.T) {
 					t.Errorf("LoadConfig() expected error but got none")
 					return
 				}
-				if tt.errorMsg != "" && err.Error() != tt.errorMsg {
-					t.Errorf("LoadConfig() error = %q, want %q", err.Error(), tt.errorMsg)
+				for _, msg := range tt.errorMsgs {
+					if !strings.Contains(err.Error(), msg) {
+						t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+					}
+				}
+				if lines := strings.Split(err.Error(), "\n"); len(lines) != len(tt.errorMsgs) {
+					t.Errorf("LoadConfig() returned %d errors, want %d", len(lines), len(tt.errorMsgs))
 				}
 				return
 			}
@@ -179,7 +472,7 @@ func TestLoadConfig(t *testing.T) {
 				t.Error("CloudflareToken should be set")
 			}
 			if config.CloudflareZoneID == "" {
-				t.Error("CloudflareZoneId should be set")
+				t.Error("CloudflareZoneID should be set")
 			}
 			if config.NomadToken == "" {
 				t.Error("NomadToken should be set")
@@ -235,4 +528,1078 @@ func TestLoadConfigDefaults(t *testing.T) {
 	if config.LogLevel != expectedDefaults["LogLevel"] {
 		t.Errorf("LogLevel default = %q, want %q", config.LogLevel, expectedDefaults["LogLevel"])
 	}
+	if config.NomadNamespace != "default" || config.EventStreamNamespace != "default" {
+		t.Errorf("namespace defaults = %q, %q, want default, default", config.NomadNamespace, config.EventStreamNamespace)
+	}
+	if config.EventDebounce != 2*time.Second || config.PeriodicSyncInterval != 5*time.Minute {
+		t.Errorf("EventDebounce, PeriodicSyncInterval defaults = %v, %v, want 2s, 5m", config.EventDebounce, config.PeriodicSyncInterval)
+	}
+	if config.NodeIPAttribute != DefaultNodeIPAttribute {
+		t.Errorf("NodeIPAttribute default = %q, want %q", config.NodeIPAttribute, DefaultNodeIPAttribute)
+	}
+	if config.AllowPrivateIPs {
+		t.Error("AllowPrivateIPs default = true, want false")
+	}
+	if config.DeleteAllOnEmpty {
+		t.Error("DeleteAllOnEmpty default = true, want false")
+	}
+	if config.CloudflareCacheTTL != 30*time.Second {
+		t.Errorf("CloudflareCacheTTL default = %v, want %v", config.CloudflareCacheTTL, 30*time.Second)
+	}
+	if config.MaxSyncDuration != 2*time.Minute {
+		t.Errorf("MaxSyncDuration default = %v, want %v", config.MaxSyncDuration, 2*time.Minute)
+	}
+	if config.SyncRetryBudget != 0 {
+		t.Errorf("SyncRetryBudget default = %d, want 0", config.SyncRetryBudget)
+	}
+	if config.FullReconcileInterval != 0 {
+		t.Errorf("FullReconcileInterval default = %v, want 0", config.FullReconcileInterval)
+	}
+	if config.NodeHealthCheckPath != "" || config.NodeHealthCheckPort != 80 || config.NodeHealthCheckStatus != 200 || config.NodeHealthCheckTimeout != 2*time.Second {
+		t.Errorf("node health check defaults = %q, %d, %d, %v, want disabled, 80, 200, 2s", config.NodeHealthCheckPath, config.NodeHealthCheckPort, config.NodeHealthCheckStatus, config.NodeHealthCheckTimeout)
+	}
+	if config.RecordPriority != 10 || config.RecordWeight != 0 {
+		t.Errorf("record priority and weight defaults = %d, %d, want 10, 0", config.RecordPriority, config.RecordWeight)
+	}
+	if config.SyncCacheTTL != 0 {
+		t.Errorf("SyncCacheTTL default = %v, want 0", config.SyncCacheTTL)
+	}
+	if config.QuietNoopSync {
+		t.Error("QuietNoopSync default = true, want false")
+	}
+	if config.NodeHysteresis != 1 {
+		t.Errorf("NodeHysteresis default = %d, want 1", config.NodeHysteresis)
+	}
+	if config.DisconnectedNodePolicy != DisconnectedGrace || config.DisconnectedNodeGrace != time.Minute {
+		t.Errorf("disconnected node policy default = %s, %v, want %s, %v", config.DisconnectedNodePolicy, config.DisconnectedNodeGrace, DisconnectedGrace, time.Minute)
+	}
+	if config.NodeInfoConcurrency != 8 {
+		t.Errorf("NodeInfoConcurrency default = %d, want 8", config.NodeInfoConcurrency)
+	}
+	if config.CircuitBreakerThreshold != 5 || config.CircuitBreakerCooldown != 5*time.Minute {
+		t.Errorf("circuit breaker defaults = %d, %v, want 5, %v", config.CircuitBreakerThreshold, config.CircuitBreakerCooldown, 5*time.Minute)
+	}
+	if !reflect.DeepEqual(config.ReadyNodeStatuses, []string{"ready"}) {
+		t.Errorf("ReadyNodeStatuses default = %v, want [ready]", config.ReadyNodeStatuses)
+	}
+	if config.EventDebounceMax != 30*time.Second {
+		t.Errorf("EventDebounceMax default = %v, want %v", config.EventDebounceMax, 30*time.Second)
+	}
+	if config.EventStreamStallTimeout != time.Minute {
+		t.Errorf("EventStreamStallTimeout default = %v, want %v", config.EventStreamStallTimeout, time.Minute)
+	}
+	if config.EventStreamMaxFailures != 10 {
+		t.Errorf("EventStreamMaxFailures default = %d, want 10", config.EventStreamMaxFailures)
+	}
+	if config.PollInterval != 30*time.Second {
+		t.Errorf("PollInterval default = %v, want %v", config.PollInterval, 30*time.Second)
+	}
+	if !config.MetricsEnabled {
+		t.Error("MetricsEnabled default = false, want true")
+	}
+	if config.HealthPath != "/health" || config.ReadyPath != "/ready" {
+		t.Errorf("endpoint path defaults = %q, %q, want /health, /ready", config.HealthPath, config.ReadyPath)
+	}
+}
+
+// TestLoadConfigs tests loading several controller instances from prefixed environment variables.
+func TestLoadConfigs(t *testing.T) {
+	envVars := map[string]string{
+		"CONTROLLER_INSTANCES":       "eu, us-east",
+		"CLOUDFLARE_API_TOKEN":       "shared_token",
+		"NOMAD_TOKEN":                "shared_nomad_token",
+		"EU_CLOUDFLARE_ZONE_ID":      "eu_zone",
+		"EU_DNS_RECORD_NAME":         "eu.example.com",
+		"EU_TRAEFIK_JOB_NAME":        "ingress-eu",
+		"US_EAST_CLOUDFLARE_ZONE_ID": "us_zone",
+		"US_EAST_DNS_RECORD_NAME":    "us.example.com",
+	}
+	for key, value := range envVars {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range envVars {
+			os.Unsetenv(key)
+		}
+	}()
+
+	configs, err := LoadConfigs()
+	if err != nil {
+		t.Fatalf("LoadConfigs() error = %v", err)
+	}
+
+	if len(configs) != 2 {
+		t.Fatalf("LoadConfigs() returned %d configs, want 2", len(configs))
+	}
+
+	expected := []struct {
+		name, zone, record, job string
+	}{
+		{"eu", "eu_zone", "eu.example.com", "ingress-eu"},
+		{"us-east", "us_zone", "us.example.com", "ingress"},
+	}
+
+	for i, want := range expected {
+		cfg := configs[i]
+		if cfg.Name != want.name {
+			t.Errorf("configs[%d].Name = %q, want %q", i, cfg.Name, want.name)
+		}
+		if cfg.CloudflareZoneID != want.zone {
+			t.Errorf("configs[%d].CloudflareZoneID = %q, want %q", i, cfg.CloudflareZoneID, want.zone)
+		}
+		if cfg.DNSRecordName != want.record {
+			t.Errorf("configs[%d].DNSRecordName = %q, want %q", i, cfg.DNSRecordName, want.record)
+		}
+		if !reflect.DeepEqual(cfg.TraefikJobNames, []string{want.job}) {
+			t.Errorf("configs[%d].TraefikJobNames = %v, want [%s]", i, cfg.TraefikJobNames, want.job)
+		}
+		// Shared variables are used when no prefixed variable is set
+		if cfg.CloudflareToken != "shared_token" {
+			t.Errorf("configs[%d].CloudflareToken = %q, want %q", i, cfg.CloudflareToken, "shared_token")
+		}
+	}
+}
+
+// TestLoadConfigsErrors tests that errors of all instances are reported together.
+func TestLoadConfigsErrors(t *testing.T) {
+	envVars := map[string]string{
+		"CONTROLLER_INSTANCES": "eu,us,eu",
+		"CLOUDFLARE_API_TOKEN": "shared_token",
+		"NOMAD_TOKEN":          "shared_nomad_token",
+		"CLOUDFLARE_ZONE_ID":   "shared_zone",
+	}
+	for key, value := range envVars {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range envVars {
+			os.Unsetenv(key)
+		}
+	}()
+
+	_, err := LoadConfigs()
+	if err == nil {
+		t.Fatal("LoadConfigs() expected error but got none")
+	}
+
+	for _, msg := range []string{
+		"instance eu: variable DNS_RECORD_NAME is not set and is required",
+		"instance us: variable DNS_RECORD_NAME is not set and is required",
+		"instance eu is listed more than once",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfigs() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+// TestLoadConfigPropagation tests parsing of the propagation verification settings.
+func TestLoadConfigPropagation(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("VERIFY_PROPAGATION")
+		os.Unsetenv("VERIFY_PROPAGATION_DELAY")
+	}()
+
+	// Defaults
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.VerifyPropagation {
+		t.Error("VerifyPropagation should be disabled by default")
+	}
+	if config.VerifyPropagationDelay != time.Minute {
+		t.Errorf("VerifyPropagationDelay default = %v, want %v", config.VerifyPropagationDelay, time.Minute)
+	}
+	if config.VerifyResolver != "1.1.1.1:53" {
+		t.Errorf("VerifyResolver default = %q, want %q", config.VerifyResolver, "1.1.1.1:53")
+	}
+
+	// Invalid values are reported together
+	os.Setenv("VERIFY_PROPAGATION", "maybe")
+	os.Setenv("VERIFY_PROPAGATION_DELAY", "soon")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		`variable VERIFY_PROPAGATION must be a boolean, got "maybe"`,
+		`variable VERIFY_PROPAGATION_DELAY must be a non-negative duration, got "soon"`,
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigQuorum(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("MIN_HEALTHY_NODES")
+		os.Unsetenv("MIN_HEALTHY_FRACTION")
+	}()
+
+	// The check is disabled by default
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.MinHealthyNodes != 0 || config.MinHealthyFraction != 0 {
+		t.Errorf("quorum defaults = %d, %g, want 0, 0", config.MinHealthyNodes, config.MinHealthyFraction)
+	}
+
+	os.Setenv("MIN_HEALTHY_NODES", "2")
+	os.Setenv("MIN_HEALTHY_FRACTION", "0.5")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.MinHealthyNodes != 2 || config.MinHealthyFraction != 0.5 {
+		t.Errorf("quorum = %d, %g, want 2, 0.5", config.MinHealthyNodes, config.MinHealthyFraction)
+	}
+
+	// Invalid values are reported together
+	os.Setenv("MIN_HEALTHY_NODES", "-1")
+	os.Setenv("MIN_HEALTHY_FRACTION", "1.5")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		"variable MIN_HEALTHY_NODES must not be negative, got -1",
+		"variable MIN_HEALTHY_FRACTION must be between 0 and 1, got 1.5",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigDenyTargetIPs(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("DENY_TARGET_IPS")
+	}()
+
+	os.Setenv("DENY_TARGET_IPS", "203.0.113.7, 198.51.100.0/24,,2001:db8::1")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := []netip.Prefix{
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+	}
+	if !reflect.DeepEqual(config.DenyTargetIPs, expected) {
+		t.Errorf("DenyTargetIPs = %v, want %v", config.DenyTargetIPs, expected)
+	}
+
+	os.Setenv("DENY_TARGET_IPS", "203.0.113.7,bastion,10.0.0.0/33")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		`variable DENY_TARGET_IPS must list IP addresses or CIDRs, got "bastion"`,
+		`variable DENY_TARGET_IPS must list IP addresses or CIDRs, got "10.0.0.0/33"`,
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	config := Config{NomadToken: "nomad-secret", CloudflareToken: "cloudflare-secret", ConsulToken: "consul-secret", DNSRecordName: "test.example.com"}
+
+	redacted := config.Redacted()
+	if redacted.NomadToken != "REDACTED" || redacted.CloudflareToken != "REDACTED" || redacted.ConsulToken != "REDACTED" {
+		t.Errorf("Redacted() tokens = %q, %q, %q, want them redacted", redacted.NomadToken, redacted.CloudflareToken, redacted.ConsulToken)
+	}
+	if redacted.ShadowCloudflareToken != "" || redacted.DebugToken != "" {
+		t.Error("Redacted() set secrets which were empty")
+	}
+	if redacted.DNSRecordName != "test.example.com" || config.NomadToken != "nomad-secret" {
+		t.Error("Redacted() changed other settings, or the original configuration")
+	}
+}
+
+func TestLoadConfigNamespaces(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+		"NOMAD_NAMESPACE":      "platform",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("EVENT_STREAM_NAMESPACE")
+	}()
+
+	// The event stream defaults to the namespace of the job
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.NomadNamespace != "platform" || config.EventStreamNamespace != "platform" {
+		t.Errorf("namespaces = %q, %q, want platform, platform", config.NomadNamespace, config.EventStreamNamespace)
+	}
+
+	os.Setenv("EVENT_STREAM_NAMESPACE", "*")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.NomadNamespace != "platform" || config.EventStreamNamespace != "*" {
+		t.Errorf("namespaces = %q, %q, want platform, *", config.NomadNamespace, config.EventStreamNamespace)
+	}
+}
+
+func TestLoadConfigIPMap(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("IP_MAP")
+	}()
+
+	os.Setenv("IP_MAP", "10.0.0.5=203.0.113.5, ::ffff:10.0.0.6=203.0.113.6")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := map[string]string{"10.0.0.5": "203.0.113.5", "10.0.0.6": "203.0.113.6"}
+	if !reflect.DeepEqual(config.IPMap, expected) {
+		t.Errorf("IPMap = %v, want %v", config.IPMap, expected)
+	}
+	if config.IPMapStrict {
+		t.Error("IPMapStrict default = true, want false")
+	}
+
+	os.Setenv("IP_MAP", "10.0.0.5=edge.example.com")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	if msg := `variable IP_MAP must map IP addresses to IP addresses, got "10.0.0.5=edge.example.com"`; !strings.Contains(err.Error(), msg) {
+		t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+	}
+}
+
+func TestLoadConfigRecordSelection(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("MAX_RECORDS")
+		os.Unsetenv("RECORD_SELECTION")
+	}()
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.MaxRecords != 0 || config.RecordSelection != SelectIPSort {
+		t.Errorf("MaxRecords, RecordSelection defaults = %d, %q, want 0, %q", config.MaxRecords, config.RecordSelection, SelectIPSort)
+	}
+
+	os.Setenv("MAX_RECORDS", "3")
+	os.Setenv("RECORD_SELECTION", "dc-preferred:eu-west")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.MaxRecords != 3 || config.RecordSelection != SelectDCPreferred || config.PreferredDatacenter != "eu-west" {
+		t.Errorf("MaxRecords, RecordSelection, PreferredDatacenter = %d, %q, %q, want 3, %q, %q",
+			config.MaxRecords, config.RecordSelection, config.PreferredDatacenter, SelectDCPreferred, "eu-west")
+	}
+
+	for value, msg := range map[string]string{
+		"random":        `variable RECORD_SELECTION must be ip-sort, name-sort or dc-preferred:<datacenter>, got "random"`,
+		"dc-preferred:": "variable RECORD_SELECTION must name the preferred datacenter",
+	} {
+		os.Setenv("RECORD_SELECTION", value)
+		_, err = LoadConfig()
+		if err == nil {
+			t.Fatalf("LoadConfig() with RECORD_SELECTION=%s expected error but got none", value)
+		}
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigDisconnectedNodePolicy(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("DISCONNECTED_NODE_POLICY")
+	}()
+
+	valid := []struct {
+		value  string
+		policy string
+		grace  time.Duration
+	}{
+		{value: "keep", policy: DisconnectedKeep},
+		{value: "remove", policy: DisconnectedRemove},
+		{value: "grace:5m", policy: DisconnectedGrace, grace: 5 * time.Minute},
+	}
+	for _, tt := range valid {
+		os.Setenv("DISCONNECTED_NODE_POLICY", tt.value)
+		config, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() with DISCONNECTED_NODE_POLICY=%s error = %v", tt.value, err)
+		}
+		if config.DisconnectedNodePolicy != tt.policy || config.DisconnectedNodeGrace != tt.grace {
+			t.Errorf("DISCONNECTED_NODE_POLICY=%s = %s, %v, want %s, %v", tt.value, config.DisconnectedNodePolicy, config.DisconnectedNodeGrace, tt.policy, tt.grace)
+		}
+	}
+
+	for value, msg := range map[string]string{
+		"forget":     `variable DISCONNECTED_NODE_POLICY must be keep, remove or grace:<duration>, got "forget"`,
+		"grace":      "variable DISCONNECTED_NODE_POLICY must set a positive grace period",
+		"grace:soon": "variable DISCONNECTED_NODE_POLICY must set a positive grace period",
+		"grace:-1m":  "variable DISCONNECTED_NODE_POLICY must set a positive grace period",
+	} {
+		os.Setenv("DISCONNECTED_NODE_POLICY", value)
+		_, err := LoadConfig()
+		if err == nil {
+			t.Fatalf("LoadConfig() with DISCONNECTED_NODE_POLICY=%s expected error but got none", value)
+		}
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigRegionRecordMap(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("REGION_RECORD_MAP")
+	}()
+
+	os.Setenv("REGION_RECORD_MAP", "eu-west=eu.example.com, us-east = us.example.com")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := map[string]string{"eu-west": "eu.example.com", "us-east": "us.example.com"}
+	if !reflect.DeepEqual(config.RegionRecordMap, expected) {
+		t.Errorf("RegionRecordMap = %v, want %v", config.RegionRecordMap, expected)
+	}
+
+	// The names are stored as Cloudflare reports them
+	os.Setenv("REGION_RECORD_MAP", "eu-west=EU.example.com.")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if name := config.RegionRecordMap["eu-west"]; name != "eu.example.com" {
+		t.Errorf("RegionRecordMap[eu-west] = %q, want eu.example.com", name)
+	}
+
+	os.Setenv("REGION_RECORD_MAP", "eu-west,us-east=Test.example.com.,ap-south=ap_south.example.com")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		`variable REGION_RECORD_MAP must list key=value pairs, got "eu-west"`,
+		"variable REGION_RECORD_MAP must not map datacenter us-east to DNS_RECORD_NAME",
+		`variable REGION_RECORD_MAP must map to valid DNS names, got "ap-south=ap_south.example.com"`,
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigEntrypointRecordMap(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("ENTRYPOINT_RECORD_MAP")
+		os.Unsetenv("REGION_RECORD_MAP")
+		os.Unsetenv("NODE_LIST_THRESHOLD")
+	}()
+
+	os.Setenv("ENTRYPOINT_RECORD_MAP", "web=web.example.com,tcp=tcp.example.com")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := map[string]string{"web": "web.example.com", "tcp": "tcp.example.com"}
+	if !reflect.DeepEqual(config.EntrypointRecordMap, expected) {
+		t.Errorf("EntrypointRecordMap = %v, want %v", config.EntrypointRecordMap, expected)
+	}
+	if config.EntrypointMetaKey != "traefik_entrypoints" {
+		t.Errorf("EntrypointMetaKey default = %q, want traefik_entrypoints", config.EntrypointMetaKey)
+	}
+
+	// A trailing dot or another case designates the same records
+	os.Setenv("ENTRYPOINT_RECORD_MAP", "web=TEST.example.com.,tcp=EU.example.com,udp=udp..example.com")
+	os.Setenv("REGION_RECORD_MAP", "eu-west=eu.example.com.")
+	os.Setenv("NODE_LIST_THRESHOLD", "100")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		"variable ENTRYPOINT_RECORD_MAP must not map entrypoint web to DNS_RECORD_NAME",
+		"variable ENTRYPOINT_RECORD_MAP must not map entrypoint tcp to region record eu.example.com",
+		`variable ENTRYPOINT_RECORD_MAP must map to valid DNS names, got "udp=udp..example.com"`,
+		"variable NODE_LIST_THRESHOLD cannot be set along with ENTRYPOINT_RECORD_MAP",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigRequiredNodeMeta(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("REQUIRED_NODE_META")
+		os.Unsetenv("NOMAD_NODE_CLASS")
+		os.Unsetenv("NODE_IP_ATTRIBUTE")
+		os.Unsetenv("NODE_LIST_THRESHOLD")
+	}()
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.RequiredNodeMeta != nil || config.NodeClassFilter != "" {
+		t.Errorf("node filters = %v, %q, want none by default", config.RequiredNodeMeta, config.NodeClassFilter)
+	}
+
+	os.Setenv("REQUIRED_NODE_META", "role=edge, tier = public")
+	os.Setenv("NOMAD_NODE_CLASS", "edge")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := map[string]string{"role": "edge", "tier": "public"}
+	if !reflect.DeepEqual(config.RequiredNodeMeta, expected) {
+		t.Errorf("RequiredNodeMeta = %v, want %v", config.RequiredNodeMeta, expected)
+	}
+	if config.NodeClassFilter != "edge" {
+		t.Errorf("NodeClassFilter = %q, want edge", config.NodeClassFilter)
+	}
+
+	os.Setenv("REQUIRED_NODE_META", "role=edge,tier")
+	os.Setenv("NODE_IP_ATTRIBUTE", "meta.public_ip")
+	os.Setenv("NODE_LIST_THRESHOLD", "100")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		`variable REQUIRED_NODE_META must list key=value pairs, got "tier"`,
+		"variable NODE_LIST_THRESHOLD cannot be set along with REQUIRED_NODE_META",
+		"variable NODE_LIST_THRESHOLD cannot be set along with NODE_IP_ATTRIBUTE",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigIPSource(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("IP_SOURCE")
+		os.Unsetenv("CONSUL_HTTP_ADDR")
+		os.Unsetenv("CONSUL_HTTP_TOKEN")
+		os.Unsetenv("CONSUL_SERVICE_NAME")
+		os.Unsetenv("NODE_IP_ATTRIBUTE")
+		os.Unsetenv("ENTRYPOINT_RECORD_MAP")
+	}()
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.IPSource != IPSourceNomad || config.ConsulAddress != "http://127.0.0.1:8500" || config.ConsulService != "traefik" {
+		t.Errorf("IP source = %q, %q, %q, want nomad, http://127.0.0.1:8500, traefik by default", config.IPSource, config.ConsulAddress, config.ConsulService)
+	}
+
+	os.Setenv("IP_SOURCE", "consul")
+	os.Setenv("CONSUL_HTTP_ADDR", "https://consul.example.com")
+	os.Setenv("CONSUL_HTTP_TOKEN", "consul-secret")
+	os.Setenv("CONSUL_SERVICE_NAME", "traefik-public")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.IPSource != IPSourceConsul || config.ConsulAddress != "https://consul.example.com" || config.ConsulToken != "consul-secret" || config.ConsulService != "traefik-public" {
+		t.Errorf("IP source = %q, %q, %q, %q, want the configured Consul service", config.IPSource, config.ConsulAddress, config.ConsulToken, config.ConsulService)
+	}
+
+	// The service instances carry no node attributes nor node meta
+	os.Setenv("NODE_IP_ATTRIBUTE", "meta.public_ip")
+	os.Setenv("ENTRYPOINT_RECORD_MAP", "websecure=secure.example.com")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		"variable NODE_IP_ATTRIBUTE cannot be set along with IP_SOURCE=consul",
+		"variable ENTRYPOINT_RECORD_MAP cannot be set along with IP_SOURCE=consul",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+
+	os.Unsetenv("NODE_IP_ATTRIBUTE")
+	os.Unsetenv("ENTRYPOINT_RECORD_MAP")
+	os.Setenv("IP_SOURCE", "dns")
+	if _, err = LoadConfig(); err == nil || !strings.Contains(err.Error(), `variable IP_SOURCE must be nomad or consul, got "dns"`) {
+		t.Errorf("LoadConfig() error = %v, want IP_SOURCE to be rejected", err)
+	}
+}
+
+func TestLoadConfigDNSRecordNames(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("DNS_RECORD_NAME")
+		os.Unsetenv("DNS_RECORD_NAMES")
+	}()
+
+	tests := []struct {
+		name     string
+		main     string
+		names    string
+		wantMain string
+		want     []string
+	}{
+		{"main name only", "a.example.com", "", "a.example.com", []string{"a.example.com"}},
+		{"additional names", "a.example.com", "b.example.com, a.example.com,c.example.com", "a.example.com", []string{"a.example.com", "b.example.com", "c.example.com"}},
+		{"names only", "", "b.example.com,c.example.com", "b.example.com", []string{"b.example.com", "c.example.com"}},
+		{"lowercased", "A.Example.COM", "a.example.com,B.example.com", "a.example.com", []string{"a.example.com", "b.example.com"}},
+		{"main name listing several names", "a.example.com, b.example.com", "c.example.com,b.example.com", "a.example.com", []string{"a.example.com", "b.example.com", "c.example.com"}},
+		{"trailing dots", "a.example.com.", "a.example.com,B.example.com.", "a.example.com", []string{"a.example.com", "b.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("DNS_RECORD_NAME", tt.main)
+			os.Setenv("DNS_RECORD_NAMES", tt.names)
+			config, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if config.DNSRecordName != tt.wantMain {
+				t.Errorf("DNSRecordName = %q, want %q", config.DNSRecordName, tt.wantMain)
+			}
+			if !reflect.DeepEqual(config.DNSRecordNames, tt.want) {
+				t.Errorf("DNSRecordNames = %v, want %v", config.DNSRecordNames, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEventDebounceSeconds(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("EVENT_DEBOUNCE_SECONDS")
+	}()
+
+	// A number of seconds, as the name says, or a duration, as first documented
+	for value, want := range map[string]time.Duration{
+		"5":     5 * time.Second,
+		"0":     0,
+		"5s":    5 * time.Second,
+		"500ms": 500 * time.Millisecond,
+	} {
+		os.Setenv("EVENT_DEBOUNCE_SECONDS", value)
+		config, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v with EVENT_DEBOUNCE_SECONDS=%q", err, value)
+		}
+		if config.EventDebounce != want {
+			t.Errorf("EventDebounce = %v with EVENT_DEBOUNCE_SECONDS=%q, want %v", config.EventDebounce, value, want)
+		}
+	}
+
+	os.Setenv("EVENT_DEBOUNCE_SECONDS", "-5")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), `variable EVENT_DEBOUNCE_SECONDS must be a non-negative duration, got "-5"`) {
+		t.Errorf("LoadConfig() error = %v, want the negative debounce to be rejected", err)
+	}
+}
+
+func TestLoadConfigTraefikJobNames(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("TRAEFIK_JOB_NAME")
+	}()
+
+	for value, want := range map[string][]string{
+		"":                        {"ingress"},
+		"traefik":                 {"traefik"},
+		"ingress-eu, ingress-us,": {"ingress-eu", "ingress-us"},
+	} {
+		os.Setenv("TRAEFIK_JOB_NAME", value)
+		config, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v with TRAEFIK_JOB_NAME=%q", err, value)
+		}
+		if !reflect.DeepEqual(config.TraefikJobNames, want) {
+			t.Errorf("TraefikJobNames = %v with TRAEFIK_JOB_NAME=%q, want %v", config.TraefikJobNames, value, want)
+		}
+	}
+
+	os.Setenv("TRAEFIK_JOB_NAME", " , ")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "variable TRAEFIK_JOB_NAME is not set and is required") {
+		t.Errorf("LoadConfig() error = %v, want it to require TRAEFIK_JOB_NAME", err)
+	}
+}
+
+func TestLoadConfigDNSRecordNameValidation(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("DNS_RECORD_NAME")
+		os.Unsetenv("DNS_RECORD_NAMES")
+	}()
+
+	valid := map[string]string{
+		"ingress.example.com":                    "ingress.example.com",
+		"example.com":                            "example.com",
+		"*.example.com":                          "*.example.com",
+		"ingress.example.com.":                   "ingress.example.com",
+		"Ingress-1.Example.com":                  "ingress-1.example.com",
+		strings.Repeat("a", 63) + ".example.com": strings.Repeat("a", 63) + ".example.com",
+	}
+	for name, want := range valid {
+		os.Setenv("DNS_RECORD_NAME", name)
+		config, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() with DNS_RECORD_NAME=%s error = %v", name, err)
+		}
+		if config.DNSRecordName != want {
+			t.Errorf("DNS_RECORD_NAME=%s gives %q, want %q", name, config.DNSRecordName, want)
+		}
+	}
+
+	invalid := []string{
+		"ingress example.com",
+		"ingress..example.com",
+		"-ingress.example.com",
+		"ingress-.example.com",
+		"ingress.*.example.com",
+		"*",
+		"in_gress.example.com",
+		strings.Repeat("a", 64) + ".example.com",
+		strings.Repeat("a.", 127) + "com",
+	}
+	for _, name := range invalid {
+		os.Setenv("DNS_RECORD_NAME", name)
+		_, err := LoadConfig()
+		if err == nil {
+			t.Fatalf("LoadConfig() with DNS_RECORD_NAME=%s expected error but got none", name)
+		}
+		if !strings.Contains(err.Error(), "variable DNS_RECORD_NAME must be a valid DNS name") {
+			t.Errorf("LoadConfig() error = %q, want it to reject DNS_RECORD_NAME", err.Error())
+		}
+	}
+
+	os.Setenv("DNS_RECORD_NAME", "a.example.com,a example.com")
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), `variable DNS_RECORD_NAME must list valid DNS names, got "a example.com"`) {
+		t.Errorf("LoadConfig() error = %v, want it to reject DNS_RECORD_NAME", err)
+	}
+
+	os.Setenv("DNS_RECORD_NAME", "a.example.com")
+	os.Setenv("DNS_RECORD_NAMES", "b.example.com,b example.com")
+	_, err = LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), `variable DNS_RECORD_NAMES must list valid DNS names, got "b example.com"`) {
+		t.Errorf("LoadConfig() error = %v, want it to reject DNS_RECORD_NAMES", err)
+	}
+}
+
+func TestLoadConfigRecordOverrides(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "example.com",
+		"DNS_RECORD_NAMES":     "api.example.com",
+		"DNS_RECORD_TTL":       "120",
+		"REGION_RECORD_MAP":    "eu-west=eu.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("CLOUDFLARE_PROXIED")
+		os.Unsetenv("RECORD_OVERRIDES")
+	}()
+
+	os.Setenv("CLOUDFLARE_PROXIED", "false")
+	os.Setenv("RECORD_OVERRIDES", "example.com:proxied=true; API.example.com.:ttl=300 ;eu.example.com:ttl=3600,proxied=false")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ttl     int
+		proxied bool
+	}{
+		{name: "example.com", ttl: 120, proxied: true},
+		{name: "api.example.com", ttl: 300, proxied: false},
+		{name: "eu.example.com", ttl: 3600, proxied: false},
+		{name: "other.example.com", ttl: 120, proxied: false},
+	}
+	for _, tt := range tests {
+		ttl, proxied := config.RecordSettings(tt.name)
+		if ttl != tt.ttl || proxied != tt.proxied {
+			t.Errorf("RecordSettings(%s) = %d, %v, want %d, %v", tt.name, ttl, proxied, tt.ttl, tt.proxied)
+		}
+	}
+
+	for value, msg := range map[string]string{
+		"example.com":                    `variable RECORD_OVERRIDES must list name:setting=value,... entries, got "example.com"`,
+		"example.com:ttl=soon":           `variable RECORD_OVERRIDES must set a non-negative ttl, got "example.com:ttl=soon"`,
+		"example.com:proxied=maybe":      `variable RECORD_OVERRIDES must set proxied to a boolean, got "example.com:proxied=maybe"`,
+		"example.com:priority=1":         `variable RECORD_OVERRIDES can only set ttl and proxied, got "example.com:priority=1"`,
+		"other.example.com:proxied=true": "variable RECORD_OVERRIDES must only override managed records, got other.example.com",
+	} {
+		os.Setenv("RECORD_OVERRIDES", value)
+		_, err := LoadConfig()
+		if err == nil {
+			t.Fatalf("LoadConfig() with RECORD_OVERRIDES=%s expected error but got none", value)
+		}
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadConfigPreviousDNSRecordNames(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "test.example.com",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("PREVIOUS_DNS_RECORD_NAMES")
+		os.Unsetenv("REGION_RECORD_MAP")
+	}()
+
+	os.Setenv("PREVIOUS_DNS_RECORD_NAMES", "old.example.com, older.example.com,")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	expected := []string{"old.example.com", "older.example.com"}
+	if !reflect.DeepEqual(config.PreviousDNSRecordNames, expected) {
+		t.Errorf("PreviousDNSRecordNames = %v, want %v", config.PreviousDNSRecordNames, expected)
+	}
+
+	os.Setenv("PREVIOUS_DNS_RECORD_NAMES", "test.example.com,eu.example.com")
+	os.Setenv("REGION_RECORD_MAP", "eu-west=eu.example.com,eu-central=eu.example.com")
+	_, err = LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() expected error but got none")
+	}
+	for _, msg := range []string{
+		"variable PREVIOUS_DNS_RECORD_NAMES must not contain DNS_RECORD_NAME test.example.com",
+		"variable PREVIOUS_DNS_RECORD_NAMES must not contain region record eu.example.com",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("LoadConfig() error = %q, want it to contain %q", err.Error(), msg)
+		}
+	}
+
+	// A trailing dot or another case designates the same records, which the cleanup would delete
+	os.Unsetenv("REGION_RECORD_MAP")
+	for _, previous := range []string{"test.example.com.", "TEST.Example.com"} {
+		os.Setenv("PREVIOUS_DNS_RECORD_NAMES", previous)
+		_, err = LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), "variable PREVIOUS_DNS_RECORD_NAMES must not contain DNS_RECORD_NAME") {
+			t.Errorf("LoadConfig() error = %v for previous name %q, want the managed name to be rejected", err, previous)
+		}
+	}
+
+	// Previous names are stored as Cloudflare reports them, so that the cleanup finds their records
+	os.Setenv("PREVIOUS_DNS_RECORD_NAMES", "Old.Example.com")
+	config, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !reflect.DeepEqual(config.PreviousDNSRecordNames, []string{"old.example.com"}) {
+		t.Errorf("PreviousDNSRecordNames = %v, want [old.example.com]", config.PreviousDNSRecordNames)
+	}
+
+	os.Setenv("PREVIOUS_DNS_RECORD_NAMES", "old_name..example.com")
+	if _, err = LoadConfig(); err == nil || !strings.Contains(err.Error(), "variable PREVIOUS_DNS_RECORD_NAMES must list valid DNS names") {
+		t.Errorf("LoadConfig() error = %v, want the invalid previous name to be rejected", err)
+	}
 }
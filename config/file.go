@@ -0,0 +1,345 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// kind is the type of the value of a config file field
+type kind int
+
+const (
+	kindString   kind = iota
+	kindBool          // true or false
+	kindInt           // integer
+	kindFloat         // number
+	kindDuration      // non-negative duration string, e.g. "30s"
+	kindEnum          // one of the allowed values
+	kindList          // list of strings, or a comma-separated string
+	kindIPList        // list of IP addresses or CIDRs, or a comma-separated string
+	kindMap           // mapping of strings to strings
+)
+
+// field describes a config file field
+type field struct {
+	kind        kind
+	values      []string // allowed values of an enum
+	processWide bool     // whether the field is shared by all instances and cannot be set per instance
+}
+
+// schema lists the fields of the config file. Each field is named after the environment variable it replaces, in lower case.
+var schema = map[string]field{
+	"nomad_addr":                   {kind: kindString},
+	"nomad_token":                  {kind: kindString},
+	"nomad_namespace":              {kind: kindString},
+	"event_stream_namespace":       {kind: kindString},
+	"cloudflare_api_token":         {kind: kindString},
+	"cloudflare_zone_id":           {kind: kindString},
+	"cloudflare_http_timeout":      {kind: kindDuration},
+	"cloudflare_cache_ttl":         {kind: kindDuration},
+	"shadow_zone_id":               {kind: kindString},
+	"shadow_cloudflare_api_token":  {kind: kindString},
+	"cloudflare_proxied":           {kind: kindBool},
+	"cloudflare_breaker_threshold": {kind: kindInt},
+	"cloudflare_breaker_cooldown":  {kind: kindDuration},
+	"dns_record_name":              {kind: kindString},
+	"dns_record_names":             {kind: kindList},
+	"record_overrides":             {kind: kindString},
+	"dns_record_ttl":               {kind: kindInt},
+	"add_only":                     {kind: kindBool},
+	"delete_all_on_empty":          {kind: kindBool},
+	"adopt_existing":               {kind: kindBool},
+	"previous_dns_record_names":    {kind: kindList},
+	"region_record_map":            {kind: kindMap},
+	"entrypoint_record_map":        {kind: kindMap},
+	"entrypoint_meta_key":          {kind: kindString},
+	"primary_node_meta":            {kind: kindMap},
+	"failover_record_name":         {kind: kindString},
+	"deny_target_ips":              {kind: kindIPList},
+	"allow_private_ips":            {kind: kindBool},
+	"ip_map":                       {kind: kindMap},
+	"ip_map_strict":                {kind: kindBool},
+	"max_records":                  {kind: kindInt},
+	"record_selection":             {kind: kindString},
+	"record_priority":              {kind: kindInt},
+	"record_weight":                {kind: kindInt},
+	"traefik_job_name":             {kind: kindList},
+	"nomad_state_variable":         {kind: kindString},
+	"ready_node_statuses":          {kind: kindList},
+	"event_debounce_seconds":       {kind: kindDuration},
+	"event_debounce_max":           {kind: kindDuration},
+	"periodic_sync_interval":       {kind: kindDuration},
+	"event_stream_stall_timeout":   {kind: kindDuration},
+	"event_stream_max_failures":    {kind: kindInt},
+	"poll_interval":                {kind: kindDuration},
+	"full_reconcile_interval":      {kind: kindDuration},
+	"maintenance_window":           {kind: kindString},
+	"maintenance_window_timezone":  {kind: kindString},
+	"exclude_ineligible_nodes":     {kind: kindBool},
+	"required_node_meta":           {kind: kindMap},
+	"nomad_node_class":             {kind: kindString},
+	"node_ip_attribute":            {kind: kindString},
+	"ip_source":                    {kind: kindEnum, values: []string{"nomad", "consul"}},
+	"consul_http_addr":             {kind: kindString},
+	"consul_http_token":            {kind: kindString},
+	"consul_service_name":          {kind: kindString},
+	"node_health_check_path":       {kind: kindString},
+	"node_health_check_port":       {kind: kindInt},
+	"node_health_check_status":     {kind: kindInt},
+	"node_health_check_timeout":    {kind: kindDuration},
+	"node_info_concurrency":        {kind: kindInt},
+	"node_list_threshold":          {kind: kindInt},
+	"min_healthy_nodes":            {kind: kindInt},
+	"min_healthy_fraction":         {kind: kindFloat},
+	"node_hysteresis":              {kind: kindInt},
+	"disconnected_node_policy":     {kind: kindString},
+	"desired_state_file":           {kind: kindString},
+	"max_sync_duration":            {kind: kindDuration},
+	"sync_cache_ttl":               {kind: kindDuration},
+	"sync_retry_budget":            {kind: kindInt},
+	"startup_delay":                {kind: kindDuration},
+	"startup_wait_timeout":         {kind: kindDuration},
+	"quiet_noop_sync":              {kind: kindBool},
+	"verify_propagation":           {kind: kindBool},
+	"verify_propagation_delay":     {kind: kindDuration},
+	"verify_resolver":              {kind: kindString},
+	"log_level":                    {kind: kindEnum, values: []string{"debug", "info", "warn", "warning", "error", "fatal"}, processWide: true},
+	"metrics_enabled":              {kind: kindBool, processWide: true},
+	"metrics_port":                 {kind: kindInt, processWide: true},
+	"metrics_bind_address":         {kind: kindString, processWide: true},
+	"metrics_scrape_port":          {kind: kindInt, processWide: true},
+	"metrics_scrape_bind_address":  {kind: kindString, processWide: true},
+	"health_path":                  {kind: kindString, processWide: true},
+	"ready_path":                   {kind: kindString, processWide: true},
+	"debug_token":                  {kind: kindString, processWide: true},
+	"audit_log_path":               {kind: kindString, processWide: true},
+}
+
+// instancesKey is the config file section holding the settings of each controller instance
+const instancesKey = "instances"
+
+// fileConfig holds the values read from a config file, as the strings the environment variables would hold
+type fileConfig struct {
+	values    map[string]string            // by environment variable name
+	instances map[string]map[string]string // by instance name, then environment variable name
+	names     []string                     // instance names, in file order
+}
+
+// loadConfigFile reads and validates the YAML config file at path.
+// Every invalid field is reported in the returned error, with its path in the file.
+func loadConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %w", err)
+	}
+
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("config file %s is not valid YAML: %w", path, err)
+	}
+
+	file := &fileConfig{
+		values:    make(map[string]string),
+		instances: make(map[string]map[string]string),
+	}
+	var errs []error
+	for _, item := range document {
+		key := fmt.Sprint(item.Key)
+		if key != instancesKey {
+			if value, ok := validateField(key, key, item.Value, false, &errs); ok {
+				file.values[strings.ToUpper(key)] = value
+			}
+			continue
+		}
+
+		instances, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: must be a mapping of instance names to settings", key))
+			continue
+		}
+		for _, instance := range instances {
+			name := fmt.Sprint(instance.Key)
+			path := key + "." + name
+			settings, ok := instance.Value.(yaml.MapSlice)
+			if !ok && instance.Value != nil {
+				errs = append(errs, fmt.Errorf("%s: must be a mapping of settings", path))
+				continue
+			}
+
+			values := make(map[string]string)
+			for _, setting := range settings {
+				settingKey := fmt.Sprint(setting.Key)
+				if value, ok := validateField(settingKey, path+"."+settingKey, setting.Value, true, &errs); ok {
+					values[strings.ToUpper(settingKey)] = value
+				}
+			}
+			file.instances[name] = values
+			file.names = append(file.names, name)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("config file %s is invalid:\n%w", path, err)
+	}
+
+	return file, nil
+}
+
+// validateField checks the value of the named field against the schema, recording an error with the path of the field if it is invalid.
+// It returns the value as the environment variable would hold it.
+func validateField(key, path string, value interface{}, instance bool, errs *[]error) (string, bool) {
+	f, ok := schema[key]
+	if !ok {
+		*errs = append(*errs, fmt.Errorf("%s: unknown field", path))
+		return "", false
+	}
+	if instance && f.processWide {
+		*errs = append(*errs, fmt.Errorf("%s: is shared by all instances and cannot be set per instance", path))
+		return "", false
+	}
+	if value == nil {
+		*errs = append(*errs, fmt.Errorf("%s: must have a value", path))
+		return "", false
+	}
+
+	invalid := func(expected string) (string, bool) {
+		*errs = append(*errs, fmt.Errorf("%s: must be %s, got %v", path, expected, value))
+		return "", false
+	}
+
+	switch f.kind {
+	case kindString:
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+		return invalid("a string")
+
+	case kindBool:
+		if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), true
+		}
+		return invalid("a boolean")
+
+	case kindInt:
+		if i, ok := value.(int); ok {
+			return strconv.Itoa(i), true
+		}
+		return invalid("an integer")
+
+	case kindFloat:
+		switch n := value.(type) {
+		case int:
+			return strconv.Itoa(n), true
+		case float64:
+			return strconv.FormatFloat(n, 'g', -1, 64), true
+		}
+		return invalid("a number")
+
+	case kindDuration:
+		if s, ok := value.(string); ok {
+			if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+				return s, true
+			}
+		}
+		return invalid(`a non-negative duration such as "30s"`)
+
+	case kindEnum:
+		if s, ok := value.(string); ok {
+			for _, allowed := range f.values {
+				if s == allowed {
+					return s, true
+				}
+			}
+		}
+		return invalid("one of " + strings.Join(f.values, ", "))
+
+	case kindList, kindIPList:
+		items, ok := listItems(value)
+		if !ok {
+			return invalid("a list of strings")
+		}
+		valid := true
+		for i, item := range items {
+			if f.kind == kindIPList && !isIPOrCIDR(item) {
+				*errs = append(*errs, fmt.Errorf("%s[%d]: must be an IP address or CIDR, got %v", path, i, item))
+				valid = false
+			}
+		}
+		return strings.Join(items, ","), valid
+
+	case kindMap:
+		mapping, ok := value.(yaml.MapSlice)
+		if !ok {
+			return invalid("a mapping")
+		}
+		var pairs []string
+		valid := true
+		for _, item := range mapping {
+			k := fmt.Sprint(item.Key)
+			v, ok := item.Value.(string)
+			if !ok || v == "" {
+				*errs = append(*errs, fmt.Errorf("%s.%s: must be a string, got %v", path, k, item.Value))
+				valid = false
+				continue
+			}
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), valid
+	}
+
+	return invalid("a known kind of value")
+}
+
+// listItems returns the items of a YAML list of scalars, or of a comma-separated string
+func listItems(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case string:
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, true
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			items = append(items, s)
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// isIPOrCIDR reports whether s is an IP address or a CIDR
+func isIPOrCIDR(s string) bool {
+	if _, err := netip.ParsePrefix(s); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// get returns the value the file sets for the variable, in the section of the instance if any, or else at the top level.
+func (f *fileConfig) get(instance, key string) string {
+	if f == nil {
+		return ""
+	}
+	if value, ok := f.instances[instance][key]; ok {
+		return value
+	}
+	return f.values[key]
+}
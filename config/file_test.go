@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes the content to a config file in a temporary directory and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		errorMsgs []string
+	}{
+		{
+			name:      "not YAML",
+			content:   "dns_record_name: [unclosed",
+			errorMsgs: []string{"is not valid YAML"},
+		},
+		{
+			name: "unknown fields",
+			content: `
+dns_record_nmae: test.example.com
+record_type: AAA
+`,
+			errorMsgs: []string{
+				"dns_record_nmae: unknown field",
+				"record_type: unknown field",
+			},
+		},
+		{
+			name: "wrong types",
+			content: `
+cloudflare_proxied: "orange"
+dns_record_ttl: 1.5
+min_healthy_fraction: half
+nomad_token: 12345
+`,
+			errorMsgs: []string{
+				"cloudflare_proxied: must be a boolean, got orange",
+				"dns_record_ttl: must be an integer, got 1.5",
+				"min_healthy_fraction: must be a number, got half",
+				"nomad_token: must be a string, got 12345",
+			},
+		},
+		{
+			name: "invalid durations and enums",
+			content: `
+max_sync_duration: 2 minutes
+verify_propagation_delay: -1m
+log_level: verbose
+`,
+			errorMsgs: []string{
+				`max_sync_duration: must be a non-negative duration such as "30s", got 2 minutes`,
+				`verify_propagation_delay: must be a non-negative duration such as "30s", got -1m`,
+				"log_level: must be one of debug, info, warn, warning, error, fatal, got verbose",
+			},
+		},
+		{
+			name: "invalid lists and mappings",
+			content: `
+deny_target_ips:
+  - 203.0.113.7
+  - bastion
+region_record_map:
+  eu-west: eu.example.com
+  us-east: 42
+previous_dns_record_names: 7
+`,
+			errorMsgs: []string{
+				"deny_target_ips[1]: must be an IP address or CIDR, got bastion",
+				"region_record_map.us-east: must be a string, got 42",
+				"previous_dns_record_names: must be a list of strings, got 7",
+			},
+		},
+		{
+			name: "invalid instances",
+			content: `
+instances:
+  eu:
+    dns_record_ttl: soon
+    metrics_port: 9090
+  us: just a string
+`,
+			errorMsgs: []string{
+				"instances.eu.dns_record_ttl: must be an integer, got soon",
+				"instances.eu.metrics_port: is shared by all instances and cannot be set per instance",
+				"instances.us: must be a mapping of settings",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfigFile(writeConfigFile(t, tt.content))
+			if err == nil {
+				t.Fatal("loadConfigFile() expected error but got none")
+			}
+			for _, msg := range tt.errorMsgs {
+				if !strings.Contains(err.Error(), msg) {
+					t.Errorf("loadConfigFile() error = %q, want it to contain %q", err.Error(), msg)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigsFromFile(t *testing.T) {
+	path := writeConfigFile(t, `
+cloudflare_api_token: file_token
+cloudflare_zone_id: file_zone
+nomad_token: file_nomad_token
+max_sync_duration: 30s
+deny_target_ips: [203.0.113.7, 198.51.100.0/24]
+instances:
+  eu:
+    dns_record_name: eu.example.com
+    region_record_map:
+      eu-west: west.example.com
+  us:
+    dns_record_name: us.example.com
+    cloudflare_proxied: false
+`)
+	os.Setenv("CONFIG_FILE", path)
+	// Environment variables take precedence over the file
+	os.Setenv("US_DNS_RECORD_TTL", "300")
+	os.Setenv("NOMAD_TOKEN", "env_nomad_token")
+	defer func() {
+		os.Unsetenv("CONFIG_FILE")
+		os.Unsetenv("US_DNS_RECORD_TTL")
+		os.Unsetenv("NOMAD_TOKEN")
+	}()
+
+	configs, err := LoadConfigs()
+	if err != nil {
+		t.Fatalf("LoadConfigs() error = %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("LoadConfigs() returned %d configs, want 2", len(configs))
+	}
+
+	eu, us := configs[0], configs[1]
+	if eu.Name != "eu" || us.Name != "us" {
+		t.Errorf("instance names = %q, %q, want eu, us in file order", eu.Name, us.Name)
+	}
+	if eu.DNSRecordName != "eu.example.com" || us.DNSRecordName != "us.example.com" {
+		t.Errorf("DNSRecordName = %q, %q", eu.DNSRecordName, us.DNSRecordName)
+	}
+	if eu.CloudflareToken != "file_token" || eu.NomadToken != "env_nomad_token" {
+		t.Errorf("eu tokens = %q, %q, want the file token and the environment Nomad token", eu.CloudflareToken, eu.NomadToken)
+	}
+	if eu.MaxSyncDuration != 30*time.Second || len(us.DenyTargetIPs) != 2 {
+		t.Errorf("shared settings = %v, %v", eu.MaxSyncDuration, us.DenyTargetIPs)
+	}
+	if eu.RegionRecordMap["eu-west"] != "west.example.com" || us.RegionRecordMap != nil {
+		t.Errorf("RegionRecordMap = %v, %v", eu.RegionRecordMap, us.RegionRecordMap)
+	}
+	if !eu.Proxied || us.Proxied {
+		t.Errorf("Proxied = %v, %v, want true, false", eu.Proxied, us.Proxied)
+	}
+	if us.DNSRecordTTL != 300 {
+		t.Errorf("us DNSRecordTTL = %d, want 300 from the environment", us.DNSRecordTTL)
+	}
+}
+
+func TestLoadConfigsMissingFile(t *testing.T) {
+	os.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	defer os.Unsetenv("CONFIG_FILE")
+
+	if _, err := LoadConfigs(); err == nil {
+		t.Error("LoadConfigs() expected error but got none")
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // so that MAINTENANCE_WINDOW_TIMEZONE does not depend on the time zone database of the host
+)
+
+// MaintenanceWindow is a daily time range, in a time zone, during which the syncs following the cluster are paused.
+// It may span midnight, e.g. from 22:00 to 02:00.
+type MaintenanceWindow struct {
+	Start    time.Duration // time of day at which the window starts
+	End      time.Duration // time of day at which the window ends
+	Location *time.Location
+}
+
+// parseMaintenanceWindow parses a window such as "22:00-02:00", in the named time zone
+func parseMaintenanceWindow(value, timezone string) (*MaintenanceWindow, error) {
+	invalid := fmt.Errorf("variable MAINTENANCE_WINDOW must be a daily time range such as 22:00-02:00, got %q", value)
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, invalid
+	}
+	startTime, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return nil, invalid
+	}
+	endTime, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return nil, invalid
+	}
+	if startTime.Equal(endTime) {
+		return nil, fmt.Errorf("variable MAINTENANCE_WINDOW must end at another time than it starts, got %q", value)
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("variable MAINTENANCE_WINDOW_TIMEZONE must be a time zone such as Europe/Paris, got %q", timezone)
+	}
+
+	return &MaintenanceWindow{
+		Start:    timeOfDay(startTime),
+		End:      timeOfDay(endTime),
+		Location: location,
+	}, nil
+}
+
+// timeOfDay returns the time elapsed since midnight, as read on a clock
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// Contains reports whether t is within the window
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	now := timeOfDay(t.In(w.Location))
+	if w.Start < w.End {
+		return now >= w.Start && now < w.End
+	}
+	return now >= w.Start || now < w.End
+}
+
+// EndsAt returns when the window containing t ends, or the next one if t is not within a window
+func (w *MaintenanceWindow) EndsAt(t time.Time) time.Time {
+	t = t.In(w.Location)
+	hour, minute := int(w.End.Hours()), int(w.End.Minutes())%60
+	end := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, w.Location)
+	if !end.After(t) {
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, hour, minute, 0, 0, w.Location)
+	}
+	return end
+}
+
+// String returns the window as configured, e.g. "22:00-02:00 Europe/Paris"
+func (w *MaintenanceWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End) + " " + w.Location.String()
+}
+
+// MarshalText exports the window in the diagnostics bundle as it is configured
+func (w *MaintenanceWindow) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
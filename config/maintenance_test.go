@@ -0,0 +1,80 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		value    string
+		timezone string
+		expected string // as formatted by String, empty if invalid
+		errorMsg string
+	}{
+		{value: "22:00-02:00", timezone: "UTC", expected: "22:00-02:00 UTC"},
+		{value: " 09:30 - 11:00 ", timezone: "Europe/Paris", expected: "09:30-11:00 Europe/Paris"},
+		{value: "22:00", timezone: "UTC", errorMsg: "must be a daily time range"},
+		{value: "22:00-25:00", timezone: "UTC", errorMsg: "must be a daily time range"},
+		{value: "22:00-22:00", timezone: "UTC", errorMsg: "must end at another time than it starts"},
+		{value: "22:00-02:00", timezone: "Mars/Olympus", errorMsg: "variable MAINTENANCE_WINDOW_TIMEZONE must be a time zone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.timezone, func(t *testing.T) {
+			window, err := parseMaintenanceWindow(tt.value, tt.timezone)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("parseMaintenanceWindow() error = %v, want it to contain %q", err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMaintenanceWindow() unexpected error = %v", err)
+			}
+			if window.String() != tt.expected {
+				t.Errorf("parseMaintenanceWindow() = %q, want %q", window.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	overnight, err := parseMaintenanceWindow("22:00-02:00", "Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daytime, err := parseMaintenanceWindow("09:00-17:00", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paris, _ := time.LoadLocation("Europe/Paris")
+
+	tests := []struct {
+		name     string
+		window   *MaintenanceWindow
+		at       time.Time
+		contains bool
+		endsAt   time.Time
+	}{
+		{name: "before an overnight window", window: overnight, at: time.Date(2025, 6, 1, 21, 59, 0, 0, paris), contains: false, endsAt: time.Date(2025, 6, 2, 2, 0, 0, 0, paris)},
+		{name: "start of an overnight window", window: overnight, at: time.Date(2025, 6, 1, 22, 0, 0, 0, paris), contains: true, endsAt: time.Date(2025, 6, 2, 2, 0, 0, 0, paris)},
+		{name: "after midnight in an overnight window", window: overnight, at: time.Date(2025, 6, 2, 1, 30, 0, 0, paris), contains: true, endsAt: time.Date(2025, 6, 2, 2, 0, 0, 0, paris)},
+		{name: "end of an overnight window", window: overnight, at: time.Date(2025, 6, 2, 2, 0, 0, 0, paris), contains: false, endsAt: time.Date(2025, 6, 3, 2, 0, 0, 0, paris)},
+		{name: "overnight window, in another time zone", window: overnight, at: time.Date(2025, 6, 1, 20, 30, 0, 0, time.UTC), contains: true, endsAt: time.Date(2025, 6, 2, 2, 0, 0, 0, paris)},
+		{name: "within a daytime window", window: daytime, at: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), contains: true, endsAt: time.Date(2025, 6, 1, 17, 0, 0, 0, time.UTC)},
+		{name: "after a daytime window", window: daytime, at: time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC), contains: false, endsAt: time.Date(2025, 6, 2, 17, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if contains := tt.window.Contains(tt.at); contains != tt.contains {
+				t.Errorf("Contains(%v) = %v, want %v", tt.at, contains, tt.contains)
+			}
+			if endsAt := tt.window.EndsAt(tt.at); !endsAt.Equal(tt.endsAt) {
+				t.Errorf("EndsAt(%v) = %v, want %v", tt.at, endsAt, tt.endsAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// reloadable lists the settings which can be changed while the controller runs, by variable.
+// Each one copies its setting from a configuration to another.
+var reloadable = []struct {
+	variable string
+	copy     func(dst, src *Config)
+}{
+	{"LOG_LEVEL", func(dst, src *Config) { dst.LogLevel = src.LogLevel }},
+	{"CLOUDFLARE_API_TOKEN", func(dst, src *Config) { dst.CloudflareToken = src.CloudflareToken }},
+	{"CLOUDFLARE_PROXIED", func(dst, src *Config) { dst.Proxied = src.Proxied }},
+	{"DNS_RECORD_TTL", func(dst, src *Config) { dst.DNSRecordTTL = src.DNSRecordTTL }},
+	{"RECORD_OVERRIDES", func(dst, src *Config) { dst.RecordOverrides = src.RecordOverrides }},
+	{"DNS_RECORD_NAME", func(dst, src *Config) { dst.DNSRecordName = src.DNSRecordName }},
+	{"DNS_RECORD_NAMES", func(dst, src *Config) { dst.DNSRecordNames = src.DNSRecordNames }},
+}
+
+// Reload copies the settings of next which can be changed while the controller runs into c,
+// and returns the variables of those which changed.
+// If next changes any other setting, which needs a restart, it fails without changing c.
+// Only the reloadable settings of c are written, so that the others can be read meanwhile.
+func (c *Config) Reload(next *Config) ([]string, error) {
+	applied := *c
+	var changed, variables []string
+	for _, setting := range reloadable {
+		before := applied
+		setting.copy(&applied, next)
+		if !reflect.DeepEqual(before, applied) {
+			changed = append(changed, setting.variable)
+		}
+		variables = append(variables, setting.variable)
+	}
+
+	if fields := differingFields(applied, *next); len(fields) > 0 {
+		return nil, fmt.Errorf("only %s can be changed without a restart, got changes to %s",
+			strings.Join(variables, ", "), strings.Join(fields, ", "))
+	}
+
+	for _, setting := range reloadable {
+		setting.copy(c, next)
+	}
+	return changed, nil
+}
+
+// differingFields returns the names of the fields whose values differ between the configurations
+func differingFields(a, b Config) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !sameValue(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+// sameValue reports whether two values of a setting are the same.
+// A time zone caches the offset in effect when it is loaded, so maintenance windows are compared as they are configured.
+func sameValue(a, b interface{}) bool {
+	if wa, ok := a.(*MaintenanceWindow); ok {
+		wb := b.(*MaintenanceWindow)
+		return (wa == nil) == (wb == nil) && (wa == nil || wa.String() == wb.String())
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigReload(t *testing.T) {
+	required := map[string]string{
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"DNS_RECORD_NAME":      "a.example.com",
+		"MAINTENANCE_WINDOW":   "22:00-02:00",
+	}
+	for key, value := range required {
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key := range required {
+			os.Unsetenv(key)
+		}
+		os.Unsetenv("DNS_RECORD_TTL")
+		os.Unsetenv("TRAEFIK_JOB_NAME")
+	}()
+
+	current, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	// Loading the same settings again changes nothing
+	same, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if changed, err := current.Reload(same); err != nil || len(changed) != 0 {
+		t.Errorf("Reload() = %v, %v for the same settings, want no change", changed, err)
+	}
+
+	// A setting which needs a restart fails the whole reload
+	os.Setenv("DNS_RECORD_NAME", "b.example.com")
+	os.Setenv("DNS_RECORD_TTL", "300")
+	os.Setenv("TRAEFIK_JOB_NAME", "traefik")
+	next, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if _, err := current.Reload(next); err == nil || !strings.Contains(err.Error(), "got changes to TraefikJobNames") {
+		t.Errorf("Reload() error = %v, want it to report the change of TraefikJobNames", err)
+	}
+	if current.DNSRecordName != "a.example.com" || current.DNSRecordTTL != 1 {
+		t.Errorf("Reload() changed DNSRecordName to %q and DNSRecordTTL to %d, want them unchanged", current.DNSRecordName, current.DNSRecordTTL)
+	}
+
+	os.Unsetenv("TRAEFIK_JOB_NAME")
+	next, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	changed, err := current.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload() unexpected error = %v", err)
+	}
+	if expected := []string{"DNS_RECORD_TTL", "DNS_RECORD_NAME", "DNS_RECORD_NAMES"}; !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Reload() changed = %v, want %v", changed, expected)
+	}
+	if current.DNSRecordName != "b.example.com" || !reflect.DeepEqual(current.DNSRecordNames, []string{"b.example.com"}) || current.DNSRecordTTL != 300 {
+		t.Errorf("Reload() config = %q %v %d, want b.example.com with a TTL of 300", current.DNSRecordName, current.DNSRecordNames, current.DNSRecordTTL)
+	}
+}
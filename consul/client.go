@@ -0,0 +1,135 @@
+// Package consul finds the Traefik nodes in Consul, from the instances of the service Traefik registers, for IP_SOURCE=consul.
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestTimeout bounds a call to the Consul API, in case the context has no deadline
+const requestTimeout = 30 * time.Second
+
+// Errors returned by the Consul client wrap one of these sentinels, so that callers can tell failures apart with errors.Is.
+var (
+	// ErrAuth is returned when the Consul token is invalid or lacks the required permissions.
+	ErrAuth = errors.New("consul authentication failed")
+	// ErrTransient is returned for failures which may resolve by themselves, such as server errors during leader elections.
+	ErrTransient = errors.New("transient consul error")
+)
+
+// ServiceAddress is an instance of a Consul service passing its health checks
+type ServiceAddress struct {
+	ID         string // ID of the instance, unique within its node
+	Node       string // name of the Consul node running the instance
+	Datacenter string // Consul datacenter of the node
+	Address    string // address of the instance, or of its node if the instance registered none
+}
+
+// Client is a minimal client of the Consul HTTP API
+type Client struct {
+	address string // base URL of the agent, e.g. http://127.0.0.1:8500
+	token   string
+	client  *http.Client
+}
+
+// NewClient creates a Consul client for the agent at CONSUL_HTTP_ADDR, authenticated with CONSUL_HTTP_TOKEN.
+// Like the Consul CLI, an address without a scheme is reached over HTTP.
+func NewClient(cfg *config.Config) (*Client, error) {
+	address := cfg.ConsulAddress
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Consul address %q", cfg.ConsulAddress)
+	}
+	return &Client{
+		address: strings.TrimSuffix(u.String(), "/"),
+		token:   cfg.ConsulToken,
+		client:  &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// healthEntry is the part of an entry of /v1/health/service/:service which the controller reads
+type healthEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+	}
+	Service struct {
+		ID      string
+		Address string
+	}
+}
+
+// GetServiceAddresses returns the instances of the service which pass their health checks.
+func (c *Client) GetServiceAddresses(ctx context.Context, serviceName string) (_ []ServiceAddress, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "GetServiceAddresses", trace.WithAttributes(attribute.String("consul.service", serviceName)))
+	defer func() { tracing.End(span, err) }()
+
+	endpoint := c.address + "/v1/health/service/" + url.PathEscape(serviceName) + "?passing=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Network failures (timeouts, refused connections, DNS failures) are worth retrying
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return nil, fmt.Errorf("%w: failed to get the instances of service %s: %w", ErrTransient, serviceName, err)
+		}
+		return nil, fmt.Errorf("failed to get the instances of service %s: %w", serviceName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("failed to get the instances of service %s: Consul answered %s: %s", serviceName, resp.Status, strings.TrimSpace(string(body)))
+		switch code := resp.StatusCode; {
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return nil, fmt.Errorf("%w: %w", ErrAuth, err)
+		case code == http.StatusTooManyRequests, code >= http.StatusInternalServerError:
+			return nil, fmt.Errorf("%w: %w", ErrTransient, err)
+		}
+		return nil, err
+	}
+
+	var entries []healthEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid instances of service %s: %w", serviceName, err)
+	}
+
+	addresses := make([]ServiceAddress, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		addresses = append(addresses, ServiceAddress{
+			ID:         entry.Service.ID,
+			Node:       entry.Node.Node,
+			Datacenter: entry.Node.Datacenter,
+			Address:    address,
+		})
+	}
+	span.SetAttributes(attribute.Int("consul.instances", len(addresses)))
+	return addresses, nil
+}
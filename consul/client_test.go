@@ -0,0 +1,100 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+)
+
+func TestGetServiceAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/traefik" || r.URL.Query().Get("passing") != "true" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if token := r.Header.Get("X-Consul-Token"); token != "secret" {
+			t.Errorf("X-Consul-Token = %q, want %q", token, "secret")
+		}
+		w.Write([]byte(`[
+			{"Node": {"Node": "edge-1", "Address": "10.0.0.1", "Datacenter": "dc1"}, "Service": {"ID": "traefik-1", "Address": "203.0.113.1"}},
+			{"Node": {"Node": "edge-2", "Address": "203.0.113.2", "Datacenter": "dc2"}, "Service": {"ID": "traefik-2", "Address": ""}}
+		]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{ConsulAddress: server.URL, ConsulToken: "secret"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	addresses, err := client.GetServiceAddresses(context.Background(), "traefik")
+	if err != nil {
+		t.Fatalf("GetServiceAddresses() error = %v", err)
+	}
+
+	// The instance registered without an address is reached at the address of its node
+	expected := []ServiceAddress{
+		{ID: "traefik-1", Node: "edge-1", Datacenter: "dc1", Address: "203.0.113.1"},
+		{ID: "traefik-2", Node: "edge-2", Datacenter: "dc2", Address: "203.0.113.2"},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("GetServiceAddresses() = %+v, want %+v", addresses, expected)
+	}
+}
+
+func TestGetServiceAddressesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		expected error
+	}{
+		{name: "forbidden", status: http.StatusForbidden, expected: ErrAuth},
+		{name: "server error", status: http.StatusInternalServerError, expected: ErrTransient},
+		{name: "rate limited", status: http.StatusTooManyRequests, expected: ErrTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "nope", tt.status)
+			}))
+			defer server.Close()
+
+			client, err := NewClient(&config.Config{ConsulAddress: server.URL})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if _, err := client.GetServiceAddresses(context.Background(), "traefik"); !errors.Is(err, tt.expected) {
+				t.Errorf("GetServiceAddresses() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewClientAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected string
+		wantErr  bool
+	}{
+		{address: "http://127.0.0.1:8500", expected: "http://127.0.0.1:8500"},
+		{address: "https://consul.example.com/", expected: "https://consul.example.com"},
+		{address: "127.0.0.1:8500", expected: "http://127.0.0.1:8500"},
+		{address: "http://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			client, err := NewClient(&config.Config{ConsulAddress: tt.address})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && client.address != tt.expected {
+				t.Errorf("address = %q, want %q", client.address, tt.expected)
+			}
+		})
+	}
+}
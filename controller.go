@@ -0,0 +1,1321 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/audit"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/cloudflare"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/consul"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/healthcheck"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/nomad"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/reconcile"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/tracing"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/verify"
+	"github.com/charmbracelet/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// initialSyncAttempts is the number of times the initial sync is attempted when it fails with a transient error
+	initialSyncAttempts = 3
+	// initialSyncRetryDelay is the delay between attempts of the initial sync, multiplied by the attempt number
+	initialSyncRetryDelay = 2 * time.Second
+	// noopSyncHeartbeat is how often a sync which changed nothing is logged at info level in quiet mode
+	noopSyncHeartbeat = time.Hour
+	// startupCheckInterval is the interval between the checks of Nomad and Cloudflare at startup
+	startupCheckInterval = 5 * time.Second
+	// nodeStatusDisconnected is the status of a node which Nomad lost contact with, and expects to reconnect
+	nodeStatusDisconnected = "disconnected"
+	// nodeStatusReady is the status of a ready node, which the passing instances of the Consul service are given
+	nodeStatusReady = "ready"
+	// eventJobDeregistered is the type of the Nomad event of a job being stopped and purged
+	eventJobDeregistered = "JobDeregistered"
+)
+
+// Triggers of the syncs, recorded with every sync to tell what caused it.
+// Syncs following Nomad events are triggered by "event:" and the type of the last event, see eventTrigger.
+const (
+	triggerInitial  = "initial"  // the first sync, when the controller starts
+	triggerPeriodic = "periodic" // the periodic sync, catching up with missed events
+	triggerManual   = "manual"   // a sync requested by an operator
+	triggerSignal   = "signal"   // a sync requested with SIGUSR1
+
+	triggerDesiredState = "desired_state" // the desired state file was created, changed or removed
+
+	triggerFullReconcile  = "full_reconcile"  // the full reconcile every FULL_RECONCILE_INTERVAL, see runSync
+	triggerMaintenanceEnd = "maintenance_end" // the maintenance window ended, after syncs were skipped during it
+)
+
+// followsCluster reports whether a sync follows the changes of the cluster, rather than being requested by an operator.
+// Those syncs are skipped during the maintenance window.
+func followsCluster(trigger string) bool {
+	return trigger == triggerPeriodic || trigger == triggerFullReconcile || strings.HasPrefix(trigger, "event:")
+}
+
+// eventTrigger returns the trigger of a sync following Nomad events
+func eventTrigger(eventType string) string {
+	return "event:" + eventType
+}
+
+// Controller is the main wrapper for the nomad and cloudflare APIs.
+// Each controller reconciles a single (zone, job, record) tuple.
+type Controller struct {
+	name             string
+	nomadClient      *nomad.Client
+	cloudflareClient *cloudflare.Client
+	consulClient     *consul.Client // nil unless IP_SOURCE is consul
+	config           *config.Config
+	configMu         sync.RWMutex // guards the reloadable settings of config and cloudflareClient, outside syncs. See Reload.
+	logger           *log.Logger
+	onReady          func() // called once the initial sync succeeded
+	clock            clock
+
+	syncMu       sync.Mutex  // serializes syncs, whatever triggered them
+	syncWaiting  atomic.Bool // whether a sync requested on the /sync endpoint waits for syncMu
+	syncRequests chan string // triggers of the requested syncs, buffered so that requests made during a sync coalesce
+
+	previousNamesCleaned bool                 // whether the records under PREVIOUS_DNS_RECORD_NAMES were cleaned up. Guarded by syncMu.
+	lastSyncLogged       time.Time            // when the completion of a sync was last logged at info level. Guarded by syncMu.
+	health               *hysteresis          // which nodes are published, given their recent health. Guarded by syncMu.
+	disconnected         map[string]time.Time // when each disconnected node was first seen disconnected. Guarded by syncMu.
+	syncCache            syncCache            // what the last full sync published. Guarded by syncMu.
+
+	healthChecker *healthcheck.Checker // nil unless the HTTP health check of the nodes is enabled
+
+	verifier         *verify.Verifier // nil unless propagation verification is enabled
+	verifyGeneration atomic.Uint64    // incremented on every sync, so that only the latest sync is verified
+
+	// State exported in the diagnostics bundle. It has its own lock, so that the bundle does not wait for a running sync.
+	diagMu      sync.Mutex
+	recentSyncs []internaltypes.SyncResult // oldest first. Guarded by diagMu.
+	nodes       []internaltypes.NodeInfo   // as found by the last sync. Guarded by diagMu.
+	polling     atomic.Bool                // whether the controller fell back to polling
+
+	lifetime lifetime // what the controller did since it started, logged when it stops
+}
+
+// NewController creates a controller for the given configuration, with its own Nomad and Cloudflare clients.
+func NewController(cfg *config.Config, onReady func()) (*Controller, error) {
+	// Create Nomad client
+	nomadClient, err := nomad.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nomad client: %w", err)
+	}
+
+	// Create Cloudflare client
+	cloudflareClient, err := cloudflare.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloudflare client: %w", err)
+	}
+
+	controller := &Controller{
+		name:             cfg.Name,
+		nomadClient:      nomadClient,
+		cloudflareClient: cloudflareClient,
+		config:           cfg,
+		logger:           log.With("controller", cfg.Name),
+		onReady:          onReady,
+		clock:            realClock{},
+		syncRequests:     make(chan string, 1),
+		health:           newHysteresis(cfg.NodeHysteresis),
+	}
+
+	if cfg.IPSource == config.IPSourceConsul {
+		if controller.consulClient, err = consul.NewClient(cfg); err != nil {
+			return nil, fmt.Errorf("failed to create consul client: %w", err)
+		}
+	}
+
+	if cfg.NodeHealthCheckPath != "" {
+		controller.healthChecker = healthcheck.NewChecker(cfg.NodeHealthCheckPath, cfg.NodeHealthCheckPort, cfg.NodeHealthCheckStatus, cfg.NodeHealthCheckTimeout)
+	}
+
+	if controller.verifier, err = newVerifier(cfg, controller.logger); err != nil {
+		return nil, err
+	}
+
+	return controller, nil
+}
+
+// newVerifier returns the verifier of the propagation of the main name, or nil if it is not verified.
+// Whether the main name is proxied may change with a reload, so it is called again by Reload.
+func newVerifier(cfg *config.Config, logger *log.Logger) (*verify.Verifier, error) {
+	if !cfg.VerifyPropagation {
+		return nil, nil
+	}
+	if _, proxied := cfg.RecordSettings(cfg.DNSRecordName); proxied {
+		// Proxied records resolve to Cloudflare's edge, never to the target IPs
+		logger.Warn("Propagation verification is not possible for proxied records and is disabled")
+		return nil, nil
+	}
+	return verify.NewVerifier(cfg.VerifyResolver)
+}
+
+// Run is the main work function
+func (c *Controller) Run(ctx context.Context) error {
+	c.lifetime.started = c.clock.Now()
+	c.configMu.RLock()
+	c.logger.Info("Controller starting",
+		"nomad", c.config.NomadAddress,
+		"jobs", c.config.TraefikJobNames,
+		"dns", c.config.DNSRecordNames)
+
+	c.logger.Debug("Running with config", "config", c.config.Redacted())
+	c.configMu.RUnlock()
+
+	// Give the systems booting together with the controller time to come up, instead of failing the first calls
+	if err := c.waitForStartup(ctx, c.checkDependencies); err != nil {
+		return err
+	}
+
+	// Initial sync
+	//
+	if err := c.initialSync(ctx); err != nil {
+		// Authentication failures will not resolve by themselves, so there is no point in carrying on.
+		if isAuthError(err) {
+			return fmt.Errorf("initial sync failed: %w", err)
+		}
+		c.logger.Error("Initial sync failed", "error", err)
+	} else if c.onReady != nil {
+		// Mark application as ready after successful initial sync
+		c.onReady()
+	}
+
+	// Set up event watching
+	// Until the first event, the time since the last event counts from now
+	metrics.SetLastEvent(c.name, c.clock.Now())
+	eventChan := make(chan internaltypes.Event, 100)
+	eventErrorChan := make(chan error, 1)
+	go func() {
+		if err := c.nomadClient.WatchEvents(ctx, eventChan); err != nil {
+			if !errors.Is(err, nomad.ErrEventsUnavailable) {
+				c.logger.Error("Event watcher fatal error", "error", err)
+			}
+			select {
+			case eventErrorChan <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return c.loop(ctx, eventChan, eventErrorChan, c.syncDNSRecords)
+}
+
+// loop is the main event loop. It calls sync once the Nomad events settled, when a sync is requested, and periodically.
+func (c *Controller) loop(ctx context.Context, eventChan <-chan internaltypes.Event, eventErrorChan <-chan error, syncFunc func(context.Context, string) error) error {
+	// Set up periodic sync (fallback mechanism)
+	ticker := c.clock.NewTicker(c.config.PeriodicSyncInterval)
+	defer func() { ticker.Stop() }()
+
+	debounce := newDebouncer(c.clock, c.config.EventDebounce, c.config.EventDebounceMax)
+	var lastEvent string // type of the last event of the pending burst
+
+	// Changes to the desired state file are synced without waiting for the periodic sync
+	var desiredStateChecks <-chan time.Time
+	var desiredState *desiredStateWatcher
+	if c.config.DesiredStateFile != "" {
+		desiredState = newDesiredStateWatcher(c.config.DesiredStateFile)
+		desiredStateTicker := c.clock.NewTicker(desiredStateCheckInterval)
+		defer desiredStateTicker.Stop()
+		desiredStateChecks = desiredStateTicker.C()
+	}
+
+	// The full reconciles have their own, longer, interval
+	var fullReconciles <-chan time.Time
+	if c.config.FullReconcileInterval > 0 {
+		fullReconcileTicker := c.clock.NewTicker(c.config.FullReconcileInterval)
+		defer fullReconcileTicker.Stop()
+		fullReconciles = fullReconcileTicker.C()
+	}
+
+	// The syncs following the cluster are skipped during the maintenance window, while the events keep being watched.
+	// Once the window ends, a sync catches up with what was skipped.
+	var maintenanceEnds <-chan time.Time
+
+	// queued returns the trigger of the sync to run once a sync completed, or "" if nothing triggered one meanwhile.
+	// The triggers which fired during the sync are coalesced into this single sync, which sees the state they signal.
+	// A full reconcile is never coalesced into a regular sync, since it does more.
+	queued := func() string {
+		var triggers []string
+		for {
+			select {
+			case trigger := <-c.syncRequests:
+				triggers = append(triggers, trigger)
+			case <-desiredStateChecks:
+				if desiredState.changed() {
+					triggers = append(triggers, triggerDesiredState)
+				}
+			case <-debounce.C():
+				debounce.done()
+				triggers = append(triggers, eventTrigger(lastEvent))
+			case <-ticker.C():
+				triggers = append(triggers, triggerPeriodic)
+			case <-fullReconciles:
+				triggers = append(triggers, triggerFullReconcile)
+			case <-maintenanceEnds:
+				maintenanceEnds = nil
+				triggers = append(triggers, triggerMaintenanceEnd)
+			default:
+				if len(triggers) == 0 {
+					return ""
+				}
+				if len(triggers) > 1 {
+					c.logger.Debug("Coalescing the syncs triggered during the last sync", "triggers", triggers)
+				}
+				if slices.Contains(triggers, triggerFullReconcile) {
+					return triggerFullReconcile
+				}
+				return triggers[0]
+			}
+		}
+	}
+
+	// Main event loop
+	for {
+		var trigger string
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		// Without the event stream, the periodic sync is all there is, so it is made more frequent
+		case err := <-eventErrorChan:
+			if errors.Is(err, nomad.ErrEventsUnavailable) {
+				c.logger.Warn("Nomad event stream unavailable, falling back to polling", "poll_interval", c.config.PollInterval, "error", err)
+				c.polling.Store(true)
+				ticker.Stop()
+				ticker = c.clock.NewTicker(c.config.PollInterval)
+				eventErrorChan = nil
+				continue
+			}
+			// Event watcher fatal error - shut down gracefully
+			c.logger.Error("Event watcher gave up reconnecting the event stream, shutting down", "error", err)
+			return err
+
+		// Nomad event in channel.
+		// Debounce events by waiting for them to settle before syncing.
+		case event := <-eventChan:
+			c.logger.Info("Received event", "type", event.Type)
+			if event.Type == eventJobDeregistered && slices.Contains(c.config.TraefikJobNames, event.JobID) {
+				if c.config.DeleteAllOnEmpty {
+					c.logger.Warn("Traefik job deregistered, the DNS records will be removed if no Traefik node is left", "job", event.JobID)
+				} else {
+					c.logger.Warn("Traefik job deregistered, the DNS records will be kept even if no Traefik node is left, unless DELETE_ALL_ON_EMPTY is set", "job", event.JobID)
+				}
+			}
+			debounce.event()
+			lastEvent = event.Type
+		case <-debounce.C():
+			debounce.done()
+			trigger = eventTrigger(lastEvent)
+		// Manual sync requested, e.g. with SIGUSR1
+		case trigger = <-c.syncRequests:
+			c.logger.Info("Sync requested", "trigger", trigger)
+		case <-desiredStateChecks:
+			if !desiredState.changed() {
+				continue
+			}
+			c.logger.Info("Desired state file changed", "path", c.config.DesiredStateFile)
+			trigger = triggerDesiredState
+		// Ticker event in channel
+		case <-ticker.C():
+			c.logger.Info("Performing periodic sync...")
+			trigger = triggerPeriodic
+		case <-fullReconciles:
+			c.logger.Info("Performing full reconcile...")
+			trigger = triggerFullReconcile
+		case <-maintenanceEnds:
+			maintenanceEnds = nil
+			c.logger.Info("Maintenance window ended, syncing")
+			trigger = triggerMaintenanceEnd
+		}
+
+		// A single sync runs at a time, and at most one more is queued behind it, whatever triggered them
+		for trigger != "" && ctx.Err() == nil {
+			now := c.clock.Now()
+			if window := c.config.MaintenanceWindow; window != nil && followsCluster(trigger) && window.Contains(now) {
+				endsAt := window.EndsAt(now)
+				c.logger.Info("Maintenance window, skipping the sync", "trigger", trigger, "window", window, "until", endsAt)
+				metrics.RecordSyncSkipped(c.name, "maintenance")
+				if maintenanceEnds == nil {
+					maintenanceEnds = c.clock.After(endsAt.Sub(now))
+				}
+			} else if err := syncFunc(ctx, trigger); err != nil {
+				c.logger.Error("Sync failed", "trigger", trigger, "error", err)
+			}
+			trigger = queued()
+		}
+	}
+}
+
+// TriggerSync requests an immediate sync, recording the trigger (triggerManual or triggerSignal) with it.
+// It does not block: if a request is already pending, the new one is coalesced with it, and it returns false.
+func (c *Controller) TriggerSync(trigger string) bool {
+	select {
+	case c.syncRequests <- trigger:
+		return true
+	default:
+		c.logger.Debug("Sync already requested, coalescing")
+		return false
+	}
+}
+
+// managedName returns the name of DNS_RECORD_NAMES designating the same records as name, if any
+func (c *Controller) managedName(name string) (string, bool) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	name = reconcile.CanonicalName(strings.ToLower(name))
+	for _, managed := range c.config.DNSRecordNames {
+		if reconcile.CanonicalName(managed) == name {
+			return managed, true
+		}
+	}
+	return "", false
+}
+
+// requestSync handles a sync requested on the /sync endpoint.
+// Without a scope, every controller is asked for a full sync, which runs in the background.
+// It returns metrics.ErrSyncAlreadyQueued if every controller already had one queued.
+// A scoped sync runs right away on the controllers managing the record, so that its outcome is returned,
+// with the result of every name it synced.
+func requestSync(ctx context.Context, controllers []*Controller, scope syncScope) ([]internaltypes.SyncResult, error) {
+	if scope == (syncScope{}) {
+		queued := false
+		for _, controller := range controllers {
+			if controller.TriggerSync(triggerManual) {
+				queued = true
+			}
+		}
+		if !queued {
+			return nil, metrics.ErrSyncAlreadyQueued
+		}
+		return nil, nil
+	}
+
+	var errs []error
+	var results []internaltypes.SyncResult
+	synced := 0
+	for _, controller := range controllers {
+		controllerScope := scope
+		if scope.record != "" {
+			name, ok := controller.managedName(scope.record)
+			if !ok {
+				continue
+			}
+			controllerScope.record = name
+		}
+		synced++
+		controllerResults, err := controller.syncRequested(ctx, controllerScope)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("controller %s: %w", controller.name, err))
+		}
+		results = append(results, controllerResults...)
+	}
+	if synced == 0 {
+		return nil, fmt.Errorf("%w: no controller manages the record %s", metrics.ErrUnknownSyncScope, scope.record)
+	}
+	return results, errors.Join(errs...)
+}
+
+// syncResultsKey is the context key of the results collected by withSyncResults
+type syncResultsKey struct{}
+
+// withSyncResults returns a context collecting the result of every name synced with it, see collectSyncResult
+func withSyncResults(ctx context.Context) (context.Context, *[]internaltypes.SyncResult) {
+	results := new([]internaltypes.SyncResult)
+	return context.WithValue(ctx, syncResultsKey{}, results), results
+}
+
+// collectSyncResult adds the result of the sync of a name to those collected by the context, if any.
+// The names of a sync are synced one after the other, so the results need no lock.
+func collectSyncResult(ctx context.Context, result internaltypes.SyncResult) {
+	if results, ok := ctx.Value(syncResultsKey{}).(*[]internaltypes.SyncResult); ok {
+		*results = append(*results, result)
+	}
+}
+
+// waitForStartup waits for STARTUP_DELAY, then until check succeeds, for at most STARTUP_WAIT_TIMEOUT.
+// Once the timeout expires, the controller starts anyway and relies on the retries of every sync.
+// It only fails if the context is done.
+func (c *Controller) waitForStartup(ctx context.Context, check func(context.Context) error) error {
+	if c.config.StartupDelay > 0 {
+		c.logger.Info("Delaying startup", "startup_delay", c.config.StartupDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(c.config.StartupDelay):
+		}
+	}
+
+	if c.config.StartupWaitTimeout <= 0 {
+		return nil
+	}
+	timeout := c.clock.After(c.config.StartupWaitTimeout)
+	for attempt := 1; ; attempt++ {
+		err := check(ctx)
+		if err == nil {
+			c.logger.Info("Nomad and Cloudflare are available", "attempts", attempt)
+			return nil
+		}
+
+		c.logger.Warn("Waiting for Nomad and Cloudflare", "error", err, "attempt", attempt, "retry_delay", startupCheckInterval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			c.logger.Warn("Nomad or Cloudflare still unavailable, starting anyway", "startup_wait_timeout", c.config.StartupWaitTimeout, "error", err)
+			return nil
+		case <-c.clock.After(startupCheckInterval):
+		}
+	}
+}
+
+// checkDependencies checks that Nomad and Cloudflare are reachable and accept the credentials of the controller
+func (c *Controller) checkDependencies(ctx context.Context) error {
+	c.configMu.RLock()
+	cloudflareClient := c.cloudflareClient
+	c.configMu.RUnlock()
+	return errors.Join(c.nomadClient.Ping(), cloudflareClient.VerifyToken(ctx))
+}
+
+// initialSync performs the first sync, retrying a few times if it fails with a transient error.
+func (c *Controller) initialSync(ctx context.Context) error {
+	var err error
+	for attempt := 1; attempt <= initialSyncAttempts; attempt++ {
+		err = c.syncDNSRecords(ctx, triggerInitial)
+		if err == nil || !isTransientError(err) || attempt == initialSyncAttempts {
+			return err
+		}
+
+		delay := time.Duration(attempt) * initialSyncRetryDelay
+		c.logger.Warn("Initial sync failed with a transient error, retrying", "error", err, "attempt", attempt, "retry_delay", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(delay):
+		}
+	}
+	return err
+}
+
+// isTransientError reports whether err is a Nomad or Cloudflare failure which may resolve by itself
+func isTransientError(err error) bool {
+	return errors.Is(err, nomad.ErrTransient) || errors.Is(err, cloudflare.ErrTransient) || errors.Is(err, consul.ErrTransient)
+}
+
+// isAuthError reports whether err is a Nomad or Cloudflare authentication failure
+func isAuthError(err error) bool {
+	return errors.Is(err, nomad.ErrAuth) || errors.Is(err, cloudflare.ErrAuth) || errors.Is(err, consul.ErrAuth)
+}
+
+// syncScope restricts a sync requested on the /sync endpoint to a record or to a node. The zero scope is a full sync.
+type syncScope struct {
+	record string // the name of DNS_RECORD_NAMES to sync, every name if empty
+	node   string // the ID of the node to re-evaluate, every node if empty
+}
+
+// syncDNSRecords synchronizes every record with the Traefik nodes
+func (c *Controller) syncDNSRecords(ctx context.Context, trigger string) error {
+	return c.syncScoped(ctx, trigger, syncScope{})
+}
+
+// syncScoped synchronizes the records within the scope with the Traefik nodes, once the running sync, if any, completed
+func (c *Controller) syncScoped(ctx context.Context, trigger string, scope syncScope) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	return c.runSync(ctx, trigger, scope)
+}
+
+// syncRequested runs a sync scoped to a record or a node, requested on the /sync endpoint.
+// It waits for the running sync, if any, unless another requested sync already waits for it:
+// then it returns metrics.ErrSyncAlreadyQueued, so that a burst of requests does not queue up.
+// It returns the result of every name synced, even if the sync failed.
+func (c *Controller) syncRequested(ctx context.Context, scope syncScope) ([]internaltypes.SyncResult, error) {
+	if !c.syncMu.TryLock() {
+		if !c.syncWaiting.CompareAndSwap(false, true) {
+			return nil, metrics.ErrSyncAlreadyQueued
+		}
+		c.syncMu.Lock()
+		c.syncWaiting.Store(false)
+	}
+	defer c.syncMu.Unlock()
+	ctx, results := withSyncResults(ctx)
+	err := c.runSync(ctx, triggerManual, scope)
+	return *results, err
+}
+
+// runSync synchronizes the records within the scope with the Traefik nodes. It must be called with syncMu held.
+func (c *Controller) runSync(ctx context.Context, trigger string, scope syncScope) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "syncDNSRecords", trace.WithAttributes(
+		attribute.String("controller", c.name),
+		attribute.String("dns.record_name", c.config.DNSRecordName),
+	))
+	defer func() { tracing.End(span, err) }()
+	defer func() { c.lifetime.recordSyncOutcome(err) }()
+
+	// Every log line of the sync carries its ID, including those of the Nomad and Cloudflare clients
+	syncID := newSyncID()
+	logger := c.logger.With("sync_id", syncID, "trigger", trigger)
+	span.SetAttributes(attribute.String("sync.id", syncID), attribute.String("sync.trigger", trigger))
+
+	names := c.config.DNSRecordNames
+	if scope.record != "" {
+		names = []string{scope.record}
+		logger = logger.With("scope_record", scope.record)
+		span.SetAttributes(attribute.String("sync.scope.record", scope.record))
+	}
+	if scope.node != "" {
+		logger = logger.With("scope_node", scope.node)
+		span.SetAttributes(attribute.String("sync.scope.node", scope.node))
+	}
+	ctx = audit.WithSyncID(log.WithContext(ctx, logger), syncID)
+
+	// Bound the sync, so that a hung API call does not hold the lock forever.
+	// The propagation check outlives the sync, so it keeps the parent context.
+	syncCtx := ctx
+	if c.config.MaxSyncDuration > 0 {
+		var cancel context.CancelFunc
+		syncCtx, cancel = context.WithTimeout(ctx, c.config.MaxSyncDuration)
+		defer cancel()
+		defer func() {
+			if err != nil && errors.Is(syncCtx.Err(), context.DeadlineExceeded) {
+				logger.Error("Sync aborted: it took longer than the maximum sync duration", "max_sync_duration", c.config.MaxSyncDuration)
+			}
+		}()
+	}
+
+	// Bound the retries of all the calls of the sync, so that a degraded API does not multiply them
+	syncCtx = retrybudget.WithBudget(syncCtx, c.config.SyncRetryBudget)
+	if bypassesRecordCache(trigger) {
+		syncCtx = cloudflare.WithoutCache(syncCtx)
+	}
+	defer func() {
+		if retrybudget.Exhausted(syncCtx) {
+			logger.Warn("Sync gave up: its calls failed more often than the retry budget allows", "sync_retry_budget", c.config.SyncRetryBudget)
+		}
+	}()
+
+	// In quiet mode, the routine log lines are only logged when debugging, so that syncs which change nothing stay silent
+	routine := log.InfoLevel
+	if c.config.QuietNoopSync {
+		routine = log.DebugLevel
+	}
+
+	logger.Log(routine, "Syncing DNS records...")
+
+	// Every sync which may change records invalidates the cache, which only a full sync succeeding fills again
+	cached := c.syncCache
+	c.syncCache = syncCache{}
+
+	// Record sync metrics
+	recordMetrics := metrics.RecordSyncStart(ctx, c.name)
+
+	// A desired state file overrides the IPs of the Traefik nodes, e.g. to pin the records to a failover IP during an incident.
+	// Nomad is not queried, and the per-region and per-entrypoint records are left as they are.
+	if c.config.DesiredStateFile != "" {
+		pinned, err := readDesiredState(c.config.DesiredStateFile)
+		if err != nil {
+			recordMetrics(err, 0, 0)
+			return err
+		}
+		if pinned != nil {
+			logger.Warn("Publishing the IPs of the desired state file instead of the Traefik nodes", "path", c.config.DesiredStateFile, "ips", pinned)
+			span.SetAttributes(attribute.Bool("sync.desired_state", true))
+			_, err := c.syncNames(syncCtx, names, pinned, syncID, trigger)
+			recordMetrics(err, len(pinned), 0)
+			return err
+		}
+	}
+
+	if scope.node != "" {
+		return c.syncNode(syncCtx, scope.node, names, syncID, trigger)
+	}
+
+	// Get current Traefik nodes
+	nodes, err := c.traefikNodes(syncCtx)
+	if err != nil {
+		if isTransientError(err) {
+			logger.Warn("Nomad or Consul is temporarily unavailable, keeping the current DNS records", "error", err)
+		}
+		recordMetrics(err, 0, 0)
+		return err
+	}
+
+	logger.Log(routine, "Found Traefik nodes", "count", len(nodes))
+	c.recordNodes(nodes)
+	metrics.SetNodeInfo(c.name, nodeInfoLabels(nodes))
+
+	// Extract IP addresses
+	var ips []string
+	var candidates []candidateNode             // the nodes behind ips, from which MAX_RECORDS are selected
+	regionIPs := make(map[string][]string)     // by datacenter
+	entrypointIPs := make(map[string][]string) // by Traefik entrypoint
+	denied := 0
+	// A full reconcile publishes the nodes as they are, so that a node held by the hysteresis or the grace period, e.g. because its
+	// health kept flapping, does not stay out of sync forever. It resets the hysteresis, which the next syncs build upon.
+	fullReconcile := trigger == triggerFullReconcile
+	healthy := make(map[string]bool, len(nodes))
+	disconnected := make(map[string]time.Time)
+	now := c.clock.Now()
+	for _, node := range nodes {
+		ready := slices.Contains(c.config.ReadyNodeStatuses, node.Status)
+		if node.Status == nodeStatusDisconnected {
+			// Nomad expects a disconnected node to come back, so its IP is not yanked during a transient partition
+			since, ok := c.disconnected[node.ID]
+			if !ok {
+				since = now
+			}
+			disconnected[node.ID] = since
+			grace := c.config.DisconnectedNodeGrace
+			if fullReconcile {
+				grace = 0
+			}
+			ready = ready || keepDisconnected(c.config.DisconnectedNodePolicy, grace, since, now)
+			logger.Debug("Node disconnected", "name", node.Name, "id", node.ID, "since", since, "published", ready)
+		}
+		healthy[node.ID] = ready && node.PublicIPAddress != ""
+	}
+	c.disconnected = disconnected
+	if c.healthChecker != nil {
+		// A failing check counts as any other unhealthy state, so it goes through the hysteresis too
+		c.checkNodeHealth(syncCtx, nodes, healthy)
+	}
+	var published map[string]bool
+	if fullReconcile {
+		published = c.health.reset(healthy)
+	} else {
+		published = c.health.observe(healthy)
+	}
+	for _, node := range nodes {
+		if published[node.ID] != healthy[node.ID] {
+			logger.Debug("Node health held by hysteresis", "name", node.Name, "id", node.ID, "healthy", healthy[node.ID], "published", published[node.ID])
+		}
+		if published[node.ID] && node.PublicIPAddress != "" {
+			ip, ok := mapIP(node.PublicIPAddress, c.config.IPMap, c.config.IPMapStrict)
+			if !ok {
+				logger.Debug("Excluding unmapped IP", "name", node.Name, "id", node.ID, "ip", node.PublicIPAddress)
+				denied++
+				continue
+			}
+			if isDenied(ip, c.config.DenyTargetIPs) {
+				logger.Debug("Excluding denied IP", "name", node.Name, "id", node.ID, "ip", ip)
+				denied++
+				continue
+			}
+			if !c.config.AllowPrivateIPs && !isPublic(ip) {
+				logger.Warn("Excluding IP which is not publicly routable, set IP_MAP or ALLOW_PRIVATE_IPS to publish it", "name", node.Name, "id", node.ID, "ip", ip)
+				denied++
+				continue
+			}
+			ips = append(ips, ip)
+			candidates = append(candidates, candidateNode{ip: ip, node: node})
+			regionIPs[node.Datacenter] = append(regionIPs[node.Datacenter], ip)
+			for _, entrypoint := range node.Entrypoints {
+				entrypointIPs[entrypoint] = append(entrypointIPs[entrypoint], ip)
+			}
+			logger.Debug("Traefik node", "name", node.Name, "id", node.ID, "ip", ip, "node_ip", node.PublicIPAddress, "datacenter", node.Datacenter)
+		}
+	}
+
+	// Every datacenter running Traefik is reported, even without any healthy node
+	healthyByDatacenter := make(map[string]int)
+	for _, node := range nodes {
+		healthyByDatacenter[node.Datacenter] = len(regionIPs[node.Datacenter])
+	}
+	metrics.SetHealthyNodes(c.name, healthyByDatacenter)
+
+	// Do not shrink DNS to follow a partial outage
+	// Denied and unmapped nodes are not expected to be published, so they do not count towards the quorum.
+	if ok, reason := hasQuorum(len(ips), len(nodes)-denied, c.config.MinHealthyNodes, c.config.MinHealthyFraction); !ok {
+		logger.Warn("Not enough healthy Traefik nodes, keeping the current DNS records", "reason", reason, "healthy", len(ips), "nodes", len(nodes))
+		metrics.RecordSyncSkipped(c.name, "quorum")
+		span.SetAttributes(attribute.String("sync.skipped", "quorum"))
+		return nil
+	}
+
+	// Without any healthy node, e.g. once the Traefik job is deregistered, the names would be left without records.
+	// Nomad may also answer with no node during an outage, so the records are only removed when told to.
+	if len(ips) == 0 {
+		if !c.config.DeleteAllOnEmpty {
+			logger.Warn("No healthy Traefik node, keeping the current DNS records: set DELETE_ALL_ON_EMPTY to remove them", "nodes", len(nodes))
+			metrics.RecordSyncSkipped(c.name, "empty")
+			span.SetAttributes(attribute.String("sync.skipped", "empty"))
+			return nil
+		}
+		logger.Warn("No healthy Traefik node, removing every DNS record of the names", "nodes", len(nodes), "names", names)
+	}
+
+	span.SetAttributes(attribute.Int("traefik.nodes", len(nodes)), attribute.Int("traefik.healthy_nodes", len(ips)))
+
+	// With PRIMARY_NODE_META, the names only point at the failover nodes while no primary node is healthy
+	var failoverIPs []string
+	if len(c.config.PrimaryNodeMeta) > 0 {
+		primary, failover := partitionFailover(candidates)
+		failoverIPs = candidateIPs(failover)
+		if len(primary) > 0 {
+			candidates = primary
+		} else {
+			logger.Warn("No healthy primary node, publishing the failover nodes", "failover", len(failover))
+			span.SetAttributes(attribute.Bool("sync.failover", true))
+			candidates = failover
+		}
+		ips = candidateIPs(candidates)
+	}
+
+	// Publish at most MAX_RECORDS IPs under the names, chosen by RECORD_SELECTION
+	targetIPs := ips
+	if c.config.MaxRecords > 0 && len(candidates) > c.config.MaxRecords {
+		targetIPs = selectIPs(candidates, c.config.RecordSelection, c.config.PreferredDatacenter, c.config.MaxRecords)
+		logger.Debug("Capping the published IPs", "max_records", c.config.MaxRecords, "selection", c.config.RecordSelection, "healthy", len(ips), "selected", targetIPs)
+	}
+
+	// A sync publishing what the last one published, shortly after it, would find the records as they are
+	fingerprint := syncFingerprint(targetIPs, regionIPs, entrypointIPs, map[string][]string{"failover": failoverIPs})
+	if scope.record == "" && !bypassesSyncCache(trigger) && cached.fresh(fingerprint, c.clock.Now(), c.config.SyncCacheTTL) {
+		c.syncCache = cached
+		logger.Log(routine, "Target IPs unchanged since the last sync, skipping Cloudflare", "ip_count", len(targetIPs), "synced_at", cached.at)
+		metrics.RecordSyncSkipped(c.name, "cached")
+		span.SetAttributes(attribute.String("sync.skipped", "cached"))
+		recordMetrics(nil, len(targetIPs), len(nodes))
+		return nil
+	}
+
+	// Sync with Cloudflare
+	results, err := c.syncNames(syncCtx, names, targetIPs, syncID, trigger)
+	if err != nil {
+		recordMetrics(err, len(targetIPs), len(nodes))
+		return err
+	}
+
+	// A sync scoped to a record leaves the other records, the state variable and the propagation check to the full syncs
+	if scope.record != "" {
+		recordMetrics(nil, len(targetIPs), len(nodes))
+		logger.Info("DNS sync of the record completed", "ip_count", len(targetIPs),
+			"created", len(results[0].Created), "updated", len(results[0].Updated), "retargeted", len(results[0].Retargeted), "deleted", len(results[0].Deleted), "failed", len(results[0].Failed))
+		return nil
+	}
+
+	// Sync the per-region and per-entrypoint records
+	regionsChanged, regionErr := c.syncGroupRecords(syncCtx, "region", c.config.RegionRecordMap, regionIPs)
+	entrypointsChanged, entrypointErr := c.syncGroupRecords(syncCtx, "entrypoint", c.config.EntrypointRecordMap, entrypointIPs)
+	var failoverRecord map[string]string
+	if c.config.FailoverRecordName != "" {
+		failoverRecord = map[string]string{"failover": c.config.FailoverRecordName}
+	}
+	failoverChanged, failoverErr := c.syncGroupRecords(syncCtx, "failover", failoverRecord, map[string][]string{"failover": failoverIPs})
+	if err := errors.Join(regionErr, entrypointErr, failoverErr); err != nil {
+		recordMetrics(err, len(targetIPs), len(nodes))
+		return err
+	}
+
+	// Clean up the records left under the previous names once. Failures are retried on the next sync.
+	if !c.previousNamesCleaned {
+		if err := c.cleanupPreviousNames(syncCtx); err != nil {
+			logger.Error("Failed to clean up the records of the previous record names", "error", err)
+		} else {
+			c.previousNamesCleaned = true
+		}
+	}
+
+	// Record successful sync
+	recordMetrics(nil, len(targetIPs), len(nodes))
+	c.syncCache = syncCache{fingerprint: fingerprint, at: c.clock.Now()}
+
+	var created, updated, retargeted, deleted, failed int
+	namesChanged := false
+	for _, result := range results {
+		created += len(result.Created)
+		updated += len(result.Updated)
+		retargeted += len(result.Retargeted)
+		deleted += len(result.Deleted)
+		failed += len(result.Failed)
+		namesChanged = namesChanged || result.Changed()
+	}
+	completed := []interface{}{"ip_count", len(targetIPs), "names", len(results),
+		"created", created, "updated", updated, "retargeted", retargeted, "deleted", deleted, "failed", failed}
+	switch c.syncLogKind(namesChanged || regionsChanged || entrypointsChanged || failoverChanged) {
+	case syncLogFull:
+		logger.Info("DNS sync completed", completed...)
+	case syncLogHeartbeat:
+		logger.Info("DNS records unchanged", "ip_count", len(targetIPs))
+	default:
+		logger.Debug("DNS sync completed", completed...)
+	}
+
+	// Publishing the state is best effort: the records are in sync whether or not it succeeds.
+	// The state is the result of the main name, DNS_RECORD_NAME, which comes first.
+	if c.config.NomadStateVariable != "" {
+		if err := c.nomadClient.WriteSyncResult(syncCtx, c.config.NomadStateVariable, results[0]); err != nil {
+			logger.Warn("Failed to write the sync result to the Nomad variable", "path", c.config.NomadStateVariable, "error", err)
+		}
+	}
+
+	if c.verifier != nil {
+		go c.verifyPropagation(ctx, c.verifier, c.verifyGeneration.Add(1), c.config.DNSRecordName, targetIPs)
+	}
+
+	return nil
+}
+
+// newSyncID returns a short random ID, used to correlate the log lines of a sync
+func newSyncID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// syncNames synchronizes the records of the names, from DNS_RECORD_NAMES, with the IPs of all the healthy nodes.
+// Every name is synced, even if another one failed. The results are in the order of the names.
+func (c *Controller) syncNames(ctx context.Context, names, ips []string, syncID, trigger string) ([]internaltypes.SyncResult, error) {
+	var errs []error
+	results := make([]internaltypes.SyncResult, 0, len(names))
+	for _, name := range names {
+		result, err := c.cloudflareClient.SyncNamedARecords(ctx, name, ips)
+		result.SyncID = syncID
+		result.Trigger = trigger
+		c.recordSync(result)
+		collectSyncResult(ctx, result)
+		metrics.RecordNameSync(c.name, name, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", name, err))
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
+
+// syncNode re-evaluates a single node, which is the only one looked up in Nomad:
+// its IP is added to the records of the names if it is healthy, and removed otherwise, while the IPs of the other nodes are kept.
+// The hysteresis, the quorum and MAX_RECORDS depend on every node, so they are left to the full syncs, as are the sync metrics.
+func (c *Controller) syncNode(ctx context.Context, nodeID string, names []string, syncID, trigger string) error {
+	nodes, err := c.traefikNodes(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	// The IPs the node may be published with are those found by the last full sync and its current one,
+	// unless another node, e.g. behind the same NAT, is published with them too.
+	nodeIPs := make(map[string]bool)
+	otherIPs := make(map[string]bool)
+	for _, node := range append(c.lastNodes(), nodes...) {
+		ip, ok := mapIP(node.PublicIPAddress, c.config.IPMap, c.config.IPMapStrict)
+		if !ok || node.PublicIPAddress == "" {
+			continue
+		}
+		if node.ID == nodeID {
+			nodeIPs[ip] = true
+		} else {
+			otherIPs[ip] = true
+		}
+	}
+	for ip := range otherIPs {
+		delete(nodeIPs, ip)
+	}
+
+	var publish string // the IP of the node if it is healthy
+	for _, node := range nodes {
+		if len(c.config.PrimaryNodeMeta) > 0 && !node.Primary {
+			return fmt.Errorf("node %s is a failover node, whose IP depends on the health of the primary nodes: a full sync is needed", nodeID)
+		}
+		ready := slices.Contains(c.config.ReadyNodeStatuses, node.Status)
+		if node.Status == nodeStatusDisconnected {
+			since, ok := c.disconnected[node.ID]
+			if !ok {
+				since = c.clock.Now()
+			}
+			ready = ready || keepDisconnected(c.config.DisconnectedNodePolicy, c.config.DisconnectedNodeGrace, since, c.clock.Now())
+		}
+		ip, ok := mapIP(node.PublicIPAddress, c.config.IPMap, c.config.IPMapStrict)
+		if !ready || !ok || node.PublicIPAddress == "" || isDenied(ip, c.config.DenyTargetIPs) {
+			continue
+		}
+		if !c.config.AllowPrivateIPs && !isPublic(ip) {
+			log.FromContext(ctx).Warn("Excluding IP which is not publicly routable, set IP_MAP or ALLOW_PRIVATE_IPS to publish it", "name", node.Name, "ip", ip)
+			continue
+		}
+		publish = ip
+	}
+	if publish != "" && c.healthChecker != nil {
+		healthy := map[string]bool{nodeID: true}
+		if c.checkNodeHealth(ctx, nodes, healthy); !healthy[nodeID] {
+			publish = ""
+		}
+	}
+	log.FromContext(ctx).Info("Re-evaluating node", "running_traefik", len(nodes) > 0, "published_ip", publish)
+
+	var errs []error
+	for _, name := range names {
+		current, err := c.cloudflareClient.ARecordIPs(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", name, err))
+			continue
+		}
+		targets := nodeTargets(current, nodeIPs, publish)
+		if len(targets) == 0 {
+			errs = append(errs, fmt.Errorf("record %s: removing the node would leave no IP, a full sync is needed", name))
+			continue
+		}
+		result, err := c.cloudflareClient.SyncNamedARecords(ctx, name, targets)
+		result.SyncID = syncID
+		result.Trigger = trigger
+		c.recordSync(result)
+		collectSyncResult(ctx, result)
+		metrics.RecordNameSync(c.name, name, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// traefikNodes returns the Traefik nodes, or only those with the IDs, from Nomad or, with IP_SOURCE=consul,
+// from the passing instances of the Consul service. Each instance is a ready node, published at its service address.
+func (c *Controller) traefikNodes(ctx context.Context, onlyNodeIDs ...string) ([]internaltypes.NodeInfo, error) {
+	if c.consulClient == nil {
+		return c.nomadClient.GetTraefikNodes(ctx, onlyNodeIDs...)
+	}
+
+	instances, err := c.consulClient.GetServiceAddresses(ctx, c.config.ConsulService)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []internaltypes.NodeInfo
+	for _, instance := range instances {
+		node := internaltypes.NodeInfo{
+			ID:         instance.Node + "/" + instance.ID,
+			Name:       instance.Node,
+			Status:     nodeStatusReady,
+			Datacenter: instance.Datacenter,
+		}
+		if len(onlyNodeIDs) > 0 && !slices.Contains(onlyNodeIDs, node.ID) {
+			continue
+		}
+		// Services may register a hostname, which an A record cannot point at
+		if _, err := netip.ParseAddr(instance.Address); err == nil {
+			node.PublicIPAddress = instance.Address
+		} else {
+			log.FromContext(ctx).Warn("Service instance registered an address which is not an IP, it is not published", "service", c.config.ConsulService, "instance", node.ID, "address", instance.Address)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// checkNodeHealth runs the HTTP health check against the IPs of the healthy nodes, and marks the nodes failing it unhealthy.
+// Nodes sharing an IP, e.g. behind the same NAT, are checked once.
+func (c *Controller) checkNodeHealth(ctx context.Context, nodes []internaltypes.NodeInfo, healthy map[string]bool) {
+	var ips []string
+	for _, node := range nodes {
+		if healthy[node.ID] && !slices.Contains(ips, node.PublicIPAddress) {
+			ips = append(ips, node.PublicIPAddress)
+		}
+	}
+	if len(ips) == 0 {
+		return
+	}
+
+	failures := c.healthChecker.Check(ctx, ips)
+	for _, node := range nodes {
+		if !healthy[node.ID] {
+			continue
+		}
+		if err, failed := failures[node.PublicIPAddress]; failed {
+			log.FromContext(ctx).Info("Excluding node failing the health check", "name", node.Name, "id", node.ID, "ip", node.PublicIPAddress, "reason", err)
+			healthy[node.ID] = false
+			metrics.RecordNodeHealthCheck(c.name, "fail")
+		} else {
+			metrics.RecordNodeHealthCheck(c.name, "pass")
+		}
+	}
+}
+
+// nodeInfoLabels returns the labels of the node info metric of the nodes
+func nodeInfoLabels(nodes []internaltypes.NodeInfo) []metrics.Node {
+	labels := make([]metrics.Node, 0, len(nodes))
+	for _, node := range nodes {
+		labels = append(labels, metrics.Node{ID: node.ID, Name: node.Name, IP: node.PublicIPAddress, Status: node.Status})
+	}
+	return labels
+}
+
+// nodeTargets returns the IPs of a record once a node is re-evaluated:
+// its current IPs without those the node may be published with, and the IP of the node unless it is empty.
+func nodeTargets(current []string, nodeIPs map[string]bool, ip string) []string {
+	var targets []string
+	for _, target := range current {
+		if !nodeIPs[target] && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	if ip != "" && !slices.Contains(targets, ip) {
+		targets = append(targets, ip)
+	}
+	return targets
+}
+
+// syncGroupRecords synchronizes the records of a group of nodes, such as the nodes of a region (REGION_RECORD_MAP)
+// or serving an entrypoint (ENTRYPOINT_RECORD_MAP), with the IPs of the nodes in the group.
+// Every record is synced, even if another one failed.
+// It reports whether any record was changed.
+func (c *Controller) syncGroupRecords(ctx context.Context, kind string, recordMap map[string]string, ipsByGroup map[string][]string) (bool, error) {
+	var errs []error
+	changed := false
+	for _, record := range recordTargets(recordMap, ipsByGroup) {
+		result, err := c.cloudflareClient.SyncNamedARecords(ctx, record.name, record.ips)
+		changed = changed || result.Changed()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s record %s: %w", kind, record.name, err))
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// cleanupPreviousNames deletes the records created by the controller under the names in PREVIOUS_DNS_RECORD_NAMES
+func (c *Controller) cleanupPreviousNames(ctx context.Context) error {
+	var errs []error
+	for _, name := range c.config.PreviousDNSRecordNames {
+		deleted, err := c.cloudflareClient.CleanupOwnedRecords(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("previous record %s: %w", name, err))
+			continue
+		}
+		log.FromContext(ctx).Info("Cleaned up previous record name", "name", name, "deleted", deleted)
+	}
+	return errors.Join(errs...)
+}
+
+// recordTarget is the list of IPs a record of a group of nodes should point to
+type recordTarget struct {
+	name string
+	ips  []string
+}
+
+// recordTargets groups the IPs by record, following the group (datacenter or entrypoint) to record name mapping.
+// A node in several groups mapped to the same record is only listed once.
+// Records are sorted by name, so that they are always synced in the same order.
+func recordTargets(recordMap map[string]string, ipsByGroup map[string][]string) []recordTarget {
+	byName := make(map[string][]string)
+	for group, name := range recordMap {
+		byName[name] = append(byName[name], ipsByGroup[group]...)
+	}
+
+	targets := make([]recordTarget, 0, len(byName))
+	for name, ips := range byName {
+		sort.Strings(ips)
+		targets = append(targets, recordTarget{name: name, ips: slices.Compact(ips)})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets
+}
+
+// partitionFailover splits the candidates into the primary nodes, matching PRIMARY_NODE_META, and the failover nodes
+func partitionFailover(candidates []candidateNode) (primary, failover []candidateNode) {
+	for _, candidate := range candidates {
+		if candidate.node.Primary {
+			primary = append(primary, candidate)
+		} else {
+			failover = append(failover, candidate)
+		}
+	}
+	return primary, failover
+}
+
+// candidateIPs returns the IPs of the candidates, in their order
+func candidateIPs(candidates []candidateNode) []string {
+	ips := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		ips = append(ips, candidate.ip)
+	}
+	return ips
+}
+
+// candidateNode is a healthy node whose IP may be published
+type candidateNode struct {
+	ip   string // IP to publish, after IP_MAP
+	node internaltypes.NodeInfo
+}
+
+// selectIPs returns the IPs of the nodes chosen by the selection policy (RECORD_SELECTION), at most max of them.
+// Nodes sharing an IP count once.
+func selectIPs(candidates []candidateNode, selection, datacenter string, max int) []string {
+	sorted := slices.Clone(candidates)
+	slices.SortStableFunc(sorted, func(a, b candidateNode) int {
+		switch selection {
+		case config.SelectNameSort:
+			if c := strings.Compare(a.node.Name, b.node.Name); c != 0 {
+				return c
+			}
+		case config.SelectDCPreferred:
+			if aPreferred, bPreferred := a.node.Datacenter == datacenter, b.node.Datacenter == datacenter; aPreferred != bPreferred {
+				if aPreferred {
+					return -1
+				}
+				return 1
+			}
+		}
+		return compareIPs(a.ip, b.ip)
+	})
+
+	var ips []string
+	for _, candidate := range sorted {
+		if len(ips) == max {
+			break
+		}
+		if !slices.Contains(ips, candidate.ip) {
+			ips = append(ips, candidate.ip)
+		}
+	}
+	return ips
+}
+
+// compareIPs orders IP addresses numerically, and anything which does not parse as an IP address as a string
+func compareIPs(a, b string) int {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return addrA.Compare(addrB)
+}
+
+// mapIP translates the IP of a node into the IP to publish, with the map of IPs in canonical form.
+// Unmapped IPs are published as they are, unless strict is set.
+func mapIP(ip string, ipMap map[string]string, strict bool) (string, bool) {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		if mapped, ok := ipMap[addr.Unmap().String()]; ok {
+			return mapped, true
+		}
+	}
+	return ip, !strict
+}
+
+// isDenied reports whether the IP address is covered by one of the denied prefixes
+func isDenied(ip string, denylist []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range denylist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublic reports whether the IP address may be reached from the internet: private (RFC 1918 and unique local),
+// loopback, link-local and unspecified addresses are not. Invalid addresses are left for Cloudflare to reject.
+func isPublic(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+	return !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast() && !addr.IsUnspecified()
+}
+
+// keepDisconnected reports whether a node first seen disconnected at since is still published at now,
+// according to DISCONNECTED_NODE_POLICY.
+func keepDisconnected(policy string, grace time.Duration, since, now time.Time) bool {
+	switch policy {
+	case config.DisconnectedKeep:
+		return true
+	case config.DisconnectedGrace:
+		return now.Sub(since) < grace
+	default:
+		return false
+	}
+}
+
+// hasQuorum reports whether enough of the nodes are healthy to apply their IPs to DNS.
+// If not, the reason says which threshold was not met.
+// When a minimum fraction is set and Nomad reports no nodes at all, there is no quorum.
+func hasQuorum(healthy, total, minNodes int, minFraction float64) (bool, string) {
+	if healthy < minNodes {
+		return false, fmt.Sprintf("%d healthy nodes, %d required", healthy, minNodes)
+	}
+	if minFraction > 0 && (total == 0 || float64(healthy)/float64(total) < minFraction) {
+		return false, fmt.Sprintf("%d of %d nodes healthy, a fraction of %g required", healthy, total, minFraction)
+	}
+	return true, ""
+}
+
+// verifyPropagation waits for the configured delay and then checks that the record of the name resolves to the target IPs.
+// It runs in its own goroutine so that the delay does not block the sync loop.
+// If another sync happened in the meantime, the check is skipped since the later sync will be verified instead.
+// The verifier is that of the sync, as a reload may replace the verifier of the controller meanwhile.
+func (c *Controller) verifyPropagation(ctx context.Context, verifier *verify.Verifier, generation uint64, name string, ips []string) {
+	logger := log.FromContext(ctx)
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-c.clock.After(c.config.VerifyPropagationDelay):
+	}
+
+	if c.verifyGeneration.Load() != generation {
+		logger.Debug("Skipping propagation check superseded by a later sync")
+		return
+	}
+
+	result, err := verifier.Check(ctx, name, ips)
+	if err != nil {
+		logger.Warn("Propagation check failed", "error", err)
+		metrics.RecordPropagationCheck(c.name, "error")
+		return
+	}
+
+	if result.Mismatch() {
+		logger.Warn("DNS record does not resolve to the expected IPs",
+			"dns", name,
+			"resolver", c.config.VerifyResolver,
+			"missing", result.Missing,
+			"unexpected", result.Unexpected)
+		metrics.RecordPropagationCheck(c.name, "mismatch")
+		return
+	}
+
+	logger.Debug("DNS record resolves to the expected IPs", "dns", name, "resolved", result.Resolved)
+	metrics.RecordPropagationCheck(c.name, "match")
+}
+
+// syncLogKind is how the completion of a sync is logged
+type syncLogKind int
+
+const (
+	syncLogFull      syncLogKind = iota // at info level
+	syncLogHeartbeat                    // as a heartbeat at info level, showing that quiet syncs still happen
+	syncLogQuiet                        // at debug level
+)
+
+// syncLogKind returns how to log the completion of a sync. In quiet mode, syncs which changed nothing are logged
+// at debug level, except for a heartbeat every noopSyncHeartbeat. It must be called with syncMu held.
+func (c *Controller) syncLogKind(changed bool) syncLogKind {
+	now := c.clock.Now()
+	switch {
+	case !c.config.QuietNoopSync || changed:
+		c.lastSyncLogged = now
+		return syncLogFull
+	case now.Sub(c.lastSyncLogged) >= noopSyncHeartbeat:
+		c.lastSyncLogged = now
+		return syncLogHeartbeat
+	}
+	return syncLogQuiet
+}
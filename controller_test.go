@@ -0,0 +1,986 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/consul"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/healthcheck"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/nomad"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/reconcile"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestController returns a controller without Nomad or Cloudflare clients
+func newTestController() *Controller {
+	return &Controller{
+		name:         "test",
+		config:       &config.Config{EventDebounce: 2 * time.Second, EventDebounceMax: 30 * time.Second, PeriodicSyncInterval: 5 * time.Minute},
+		logger:       log.With("controller", "test"),
+		clock:        newFakeClock(),
+		syncRequests: make(chan string, 1),
+		health:       newHysteresis(1),
+	}
+}
+
+// runLoop runs the event loop of the controller until the test ends.
+// Events and event watcher errors are sent on the returned channels, and the trigger of every sync is reported on the last one.
+func runLoop(t *testing.T, controller *Controller) (chan<- internaltypes.Event, chan<- error, <-chan string) {
+	t.Helper()
+	events := make(chan internaltypes.Event)
+	eventErrors := make(chan error)
+	syncs := make(chan string, 10)
+	syncFunc := func(_ context.Context, trigger string) error {
+		syncs <- trigger
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		controller.loop(ctx, events, eventErrors, syncFunc)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Wait for the periodic sync ticker, so that the loop is running. The other tickers may already be created too.
+	controller.clock.(*fakeClock).waitFor(t, func(c *fakeClock) bool { return len(c.tickers) >= 1 })
+	return events, eventErrors, syncs
+}
+
+// expectSyncs checks that syncs with the expected triggers happened, and no more
+func expectSyncs(t *testing.T, syncs <-chan string, expected ...string) {
+	t.Helper()
+	for i, trigger := range expected {
+		select {
+		case got := <-syncs:
+			if got != trigger {
+				t.Errorf("sync %d trigger = %q, want %q", i, got, trigger)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d syncs, want %d", i, len(expected))
+		}
+	}
+	select {
+	case got := <-syncs:
+		t.Fatalf("got an unexpected sync triggered by %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestLoopDebouncesEvents(t *testing.T) {
+	controller := newTestController()
+	clock := controller.clock.(*fakeClock)
+	events, _, syncs := runLoop(t, controller)
+
+	// A burst of events results in a single sync, once the events settled
+	for i, eventType := range []string{"NodeUpdated", "AllocationUpdated", "NodeUpdated"} {
+		events <- internaltypes.Event{Type: eventType}
+		clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == i+1 })
+		clock.Advance(time.Second)
+	}
+	expectSyncs(t, syncs)
+
+	clock.Advance(time.Second)
+	expectSyncs(t, syncs, "event:NodeUpdated")
+}
+
+func TestLoopCoalescesRollingDeployment(t *testing.T) {
+	controller := newTestController()
+	clock := controller.clock.(*fakeClock)
+	events, _, syncs := runLoop(t, controller)
+
+	// A deployment rolling its allocations sends events back-to-back, which all end up in a single sync
+	for i := 1; i <= 10; i++ {
+		events <- internaltypes.Event{Type: "AllocationUpdated"}
+		clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == i })
+	}
+	expectSyncs(t, syncs)
+
+	clock.Advance(controller.config.EventDebounce)
+	expectSyncs(t, syncs, "event:AllocationUpdated")
+}
+
+func TestLoopConfiguredDebounce(t *testing.T) {
+	controller := newTestController()
+	controller.config.EventDebounce = 10 * time.Second
+	clock := controller.clock.(*fakeClock)
+	events, _, syncs := runLoop(t, controller)
+
+	// Events arriving within the window postpone the sync, which follows the last one by the whole window
+	for i := 1; i <= 3; i++ {
+		events <- internaltypes.Event{Type: "NodeUpdated"}
+		clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == i })
+		clock.Advance(5 * time.Second)
+	}
+	expectSyncs(t, syncs)
+
+	clock.Advance(5 * time.Second)
+	expectSyncs(t, syncs, "event:NodeUpdated")
+}
+
+func TestLoopSyncsDuringContinuousEvents(t *testing.T) {
+	controller := newTestController()
+	controller.config.EventDebounceMax = 5 * time.Second
+	clock := controller.clock.(*fakeClock)
+	events, _, syncs := runLoop(t, controller)
+
+	// An event every second would postpone the sync forever without the maximum wait
+	for i := 1; i <= 5; i++ {
+		events <- internaltypes.Event{Type: "AllocationUpdated"}
+		clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == i })
+		clock.Advance(time.Second)
+	}
+	expectSyncs(t, syncs, "event:AllocationUpdated")
+}
+
+func TestLoopPeriodicSync(t *testing.T) {
+	controller := newTestController()
+	controller.config.PeriodicSyncInterval = time.Minute
+	clock := controller.clock.(*fakeClock)
+	_, _, syncs := runLoop(t, controller)
+
+	clock.Advance(controller.config.PeriodicSyncInterval - time.Second)
+	expectSyncs(t, syncs)
+
+	clock.Advance(time.Second)
+	expectSyncs(t, syncs, triggerPeriodic)
+
+	clock.Advance(controller.config.PeriodicSyncInterval)
+	expectSyncs(t, syncs, triggerPeriodic)
+}
+
+// startupResult is the outcome of waitForStartup
+type startupResult struct {
+	checks int
+	err    error
+}
+
+// startWaitForStartup runs waitForStartup with a check which fails until it was called succeedAt times, or always if succeedAt is 0.
+// The outcome is sent on the returned channel once waitForStartup returned.
+func startWaitForStartup(controller *Controller, succeedAt int) <-chan startupResult {
+	var checks atomic.Int32
+	check := func(context.Context) error {
+		if n := int(checks.Add(1)); succeedAt == 0 || n < succeedAt {
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+
+	done := make(chan startupResult, 1)
+	go func() {
+		err := controller.waitForStartup(context.Background(), check)
+		done <- startupResult{checks: int(checks.Load()), err: err}
+	}()
+	return done
+}
+
+func TestWaitForStartup(t *testing.T) {
+	controller := newTestController()
+	controller.config.StartupDelay = 10 * time.Second
+	controller.config.StartupWaitTimeout = time.Minute
+	clock := controller.clock.(*fakeClock)
+	done := startWaitForStartup(controller, 3)
+
+	// Nothing is checked during the delay
+	clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == 1 })
+	clock.Advance(10 * time.Second)
+
+	// The dependencies are checked until they are available
+	for calls := 3; calls <= 4; calls++ {
+		clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == calls })
+		clock.Advance(startupCheckInterval)
+	}
+	select {
+	case got := <-done:
+		if got.checks != 3 || got.err != nil {
+			t.Errorf("waitForStartup() made %d checks and returned %v, want 3 checks and no error", got.checks, got.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitForStartup() did not return once the dependencies were available")
+	}
+}
+
+func TestWaitForStartupTimeout(t *testing.T) {
+	controller := newTestController()
+	controller.config.StartupWaitTimeout = 7 * time.Second
+	clock := controller.clock.(*fakeClock)
+	done := startWaitForStartup(controller, 0)
+
+	clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == 2 })
+	clock.Advance(startupCheckInterval)
+	clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == 3 })
+	clock.Advance(2 * time.Second)
+
+	// The controller starts anyway once the timeout expired
+	select {
+	case got := <-done:
+		if got.checks != 2 || got.err != nil {
+			t.Errorf("waitForStartup() made %d checks and returned %v, want 2 checks and no error", got.checks, got.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitForStartup() did not return once the timeout expired")
+	}
+}
+
+func TestLoopFullReconcile(t *testing.T) {
+	controller := newTestController()
+	controller.config.FullReconcileInterval = controller.config.PeriodicSyncInterval + time.Minute
+	clock := controller.clock.(*fakeClock)
+	_, _, syncs := runLoop(t, controller)
+	clock.waitFor(t, func(c *fakeClock) bool { return len(c.tickers) == 2 })
+
+	// The full reconciles do not replace the periodic syncs
+	clock.Advance(controller.config.PeriodicSyncInterval)
+	expectSyncs(t, syncs, triggerPeriodic)
+	clock.Advance(time.Minute)
+	expectSyncs(t, syncs, triggerFullReconcile)
+}
+
+func TestLoopMaintenanceWindow(t *testing.T) {
+	controller := newTestController()
+	// The fake clock starts at midnight UTC, within the window
+	controller.config.MaintenanceWindow = &config.MaintenanceWindow{Start: 0, End: 58 * time.Minute, Location: time.UTC}
+	clock := controller.clock.(*fakeClock)
+	_, _, syncs := runLoop(t, controller)
+
+	// The syncs following the cluster are skipped, while those requested by an operator still run
+	clock.Advance(controller.config.PeriodicSyncInterval)
+	expectSyncs(t, syncs)
+	controller.TriggerSync(triggerManual)
+	expectSyncs(t, syncs, triggerManual)
+	clock.Advance(50 * time.Minute)
+	expectSyncs(t, syncs)
+
+	// Once the window ended, a sync catches up, and the periodic syncs resume
+	clock.Advance(3 * time.Minute)
+	expectSyncs(t, syncs, triggerMaintenanceEnd)
+	clock.Advance(2 * time.Minute)
+	expectSyncs(t, syncs, triggerPeriodic)
+}
+
+func TestLoopFallsBackToPolling(t *testing.T) {
+	controller := newTestController()
+	controller.config.PollInterval = 30 * time.Second
+	clock := controller.clock.(*fakeClock)
+	_, eventErrors, syncs := runLoop(t, controller)
+
+	eventErrors <- fmt.Errorf("%w: %w", nomad.ErrEventsUnavailable, nomad.ErrAuth)
+	clock.waitFor(t, func(c *fakeClock) bool { return len(c.tickers) == 2 })
+
+	clock.Advance(30 * time.Second)
+	expectSyncs(t, syncs, triggerPeriodic)
+	clock.Advance(30 * time.Second)
+	expectSyncs(t, syncs, triggerPeriodic)
+}
+
+func TestLoopStopsOnEventWatcherError(t *testing.T) {
+	controller := newTestController()
+	events := make(chan internaltypes.Event)
+	eventErrors := make(chan error, 1)
+	eventErrors <- errors.New("event stream error rate exceeded threshold")
+
+	err := controller.loop(context.Background(), events, eventErrors, func(context.Context, string) error { return nil })
+	if err == nil {
+		t.Error("loop() expected error but got none")
+	}
+}
+
+func TestLoopRequestedSync(t *testing.T) {
+	controller := newTestController()
+	_, _, syncs := runLoop(t, controller)
+
+	controller.TriggerSync(triggerSignal)
+	expectSyncs(t, syncs, triggerSignal)
+}
+
+func TestTriggerSyncCoalesces(t *testing.T) {
+	controller := newTestController()
+
+	// Several requests made while a sync is running must not block, and result in a single pending sync
+	for i := 0; i < 5; i++ {
+		if queued := controller.TriggerSync(triggerManual); queued != (i == 0) {
+			t.Errorf("TriggerSync() #%d = %v, want %v", i, queued, i == 0)
+		}
+	}
+
+	if pending := len(controller.syncRequests); pending != 1 {
+		t.Errorf("pending sync requests = %d, want 1", pending)
+	}
+
+	<-controller.syncRequests
+	controller.TriggerSync(triggerManual)
+	if pending := len(controller.syncRequests); pending != 1 {
+		t.Errorf("pending sync requests after the first was consumed = %d, want 1", pending)
+	}
+}
+
+func TestLoopCoalescesTriggersDuringSync(t *testing.T) {
+	controller := newTestController()
+	clock := controller.clock.(*fakeClock)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		controller.loop(ctx, make(chan internaltypes.Event), make(chan error), func(_ context.Context, trigger string) error {
+			started <- trigger
+			<-release
+			return nil
+		})
+	}()
+	defer func() {
+		cancel()
+		close(release)
+		<-done
+	}()
+	clock.waitFor(t, func(c *fakeClock) bool { return len(c.tickers) == 1 })
+
+	controller.TriggerSync(triggerSignal)
+	expectSyncs(t, started, triggerSignal)
+
+	// Every trigger firing during the sync is coalesced into a single queued sync
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			controller.TriggerSync(triggerManual)
+		}()
+		go func() {
+			defer wg.Done()
+			controller.TriggerSync(triggerSignal)
+		}()
+	}
+	wg.Wait()
+	clock.Advance(controller.config.PeriodicSyncInterval)
+
+	release <- struct{}{}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the queued sync did not run")
+	}
+	release <- struct{}{}
+	expectSyncs(t, started)
+}
+
+func TestSyncRequestedAlreadyQueued(t *testing.T) {
+	controller := newTestController()
+
+	// A sync is running, and another requested sync waits for it
+	controller.syncMu.Lock()
+	defer controller.syncMu.Unlock()
+	controller.syncWaiting.Store(true)
+
+	if _, err := controller.syncRequested(context.Background(), syncScope{node: "node-1"}); !errors.Is(err, metrics.ErrSyncAlreadyQueued) {
+		t.Errorf("syncRequested() error = %v, want %v", err, metrics.ErrSyncAlreadyQueued)
+	}
+}
+
+func TestNodeTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []string
+		nodeIPs  map[string]bool
+		ip       string
+		expected []string
+	}{
+		{name: "healthy node added", current: []string{"1.1.1.1"}, nodeIPs: map[string]bool{"2.2.2.2": true}, ip: "2.2.2.2", expected: []string{"1.1.1.1", "2.2.2.2"}},
+		{name: "healthy node already published", current: []string{"1.1.1.1", "2.2.2.2"}, nodeIPs: map[string]bool{"2.2.2.2": true}, ip: "2.2.2.2", expected: []string{"1.1.1.1", "2.2.2.2"}},
+		{name: "unhealthy node removed", current: []string{"1.1.1.1", "2.2.2.2"}, nodeIPs: map[string]bool{"2.2.2.2": true}, expected: []string{"1.1.1.1"}},
+		{name: "node moved to a new IP", current: []string{"1.1.1.1", "2.2.2.2"}, nodeIPs: map[string]bool{"2.2.2.2": true, "3.3.3.3": true}, ip: "3.3.3.3", expected: []string{"1.1.1.1", "3.3.3.3"}},
+		{name: "last node removed", current: []string{"2.2.2.2"}, nodeIPs: map[string]bool{"2.2.2.2": true}, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if targets := nodeTargets(tt.current, tt.nodeIPs, tt.ip); !reflect.DeepEqual(targets, tt.expected) {
+				t.Errorf("nodeTargets() = %v, want %v", targets, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRequestSync(t *testing.T) {
+	eu := newTestController()
+	eu.config.DNSRecordNames = []string{"eu.example.com"}
+	us := newTestController()
+	us.config.DNSRecordNames = []string{"us.example.com"}
+	controllers := []*Controller{eu, us}
+
+	if name, ok := eu.managedName("EU.example.com."); !ok || name != "eu.example.com" {
+		t.Errorf("managedName() = %q, %v, want eu.example.com", name, ok)
+	}
+
+	// A full sync is requested from every controller
+	if _, err := requestSync(context.Background(), controllers, syncScope{}); err != nil {
+		t.Fatalf("requestSync() unexpected error = %v", err)
+	}
+	for _, controller := range controllers {
+		if pending := len(controller.syncRequests); pending != 1 {
+			t.Errorf("pending sync requests = %d, want 1", pending)
+		}
+	}
+	if _, err := requestSync(context.Background(), controllers, syncScope{}); !errors.Is(err, metrics.ErrSyncAlreadyQueued) {
+		t.Errorf("requestSync() with a sync already queued error = %v, want %v", err, metrics.ErrSyncAlreadyQueued)
+	}
+
+	if _, err := requestSync(context.Background(), controllers, syncScope{record: "ap.example.com"}); !errors.Is(err, metrics.ErrUnknownSyncScope) {
+		t.Errorf("requestSync() for an unmanaged record error = %v, want %v", err, metrics.ErrUnknownSyncScope)
+	}
+}
+
+func TestCollectSyncResult(t *testing.T) {
+	// Results are only collected for the syncs requested on the /sync endpoint
+	collectSyncResult(context.Background(), internaltypes.SyncResult{Name: "ignored.example.com"})
+
+	ctx, results := withSyncResults(context.Background())
+	collectSyncResult(ctx, internaltypes.SyncResult{Name: "a.example.com", Created: []string{"1.1.1.1"}})
+	collectSyncResult(ctx, internaltypes.SyncResult{Name: "b.example.com"})
+
+	var names []string
+	for _, result := range *results {
+		names = append(names, result.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("collected results = %v, want those of a.example.com and b.example.com", names)
+	}
+}
+
+func TestNewSyncID(t *testing.T) {
+	id := newSyncID()
+	if len(id) != 8 {
+		t.Errorf("newSyncID() = %q, want 8 hex digits", id)
+	}
+	if other := newSyncID(); other == id {
+		t.Errorf("newSyncID() returned %q twice", id)
+	}
+}
+
+func TestSyncLogKind(t *testing.T) {
+	controller := newTestController()
+	clock := controller.clock.(*fakeClock)
+
+	if kind := controller.syncLogKind(false); kind != syncLogFull {
+		t.Errorf("syncLogKind() = %v without quiet mode, want %v", kind, syncLogFull)
+	}
+
+	controller.config.QuietNoopSync = true
+	steps := []struct {
+		advance time.Duration
+		changed bool
+		want    syncLogKind
+	}{
+		{advance: time.Minute, changed: false, want: syncLogQuiet},
+		{advance: time.Minute, changed: true, want: syncLogFull},
+		{advance: noopSyncHeartbeat - time.Minute, changed: false, want: syncLogQuiet},
+		{advance: time.Minute, changed: false, want: syncLogHeartbeat},
+		{advance: time.Minute, changed: false, want: syncLogQuiet},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if kind := controller.syncLogKind(step.changed); kind != step.want {
+			t.Errorf("step %d: syncLogKind(%v) = %v, want %v", i, step.changed, kind, step.want)
+		}
+	}
+}
+
+func TestHasQuorum(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthy     int
+		total       int
+		minNodes    int
+		minFraction float64
+		expected    bool
+	}{
+		{name: "disabled", healthy: 0, total: 3, expected: true},
+		{name: "enough nodes", healthy: 2, total: 3, minNodes: 2, expected: true},
+		{name: "too few nodes", healthy: 1, total: 3, minNodes: 2, expected: false},
+		{name: "enough of the nodes", healthy: 2, total: 4, minFraction: 0.5, expected: true},
+		{name: "too few of the nodes", healthy: 1, total: 4, minFraction: 0.5, expected: false},
+		{name: "no nodes with a fraction set", healthy: 0, total: 0, minFraction: 0.5, expected: false},
+		{name: "both thresholds must be met", healthy: 3, total: 10, minNodes: 2, minFraction: 0.5, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reason := hasQuorum(tt.healthy, tt.total, tt.minNodes, tt.minFraction)
+			if ok != tt.expected {
+				t.Errorf("hasQuorum() = %v, want %v", ok, tt.expected)
+			}
+			if !ok && reason == "" {
+				t.Error("hasQuorum() should give a reason when there is no quorum")
+			}
+		})
+	}
+}
+
+func TestKeepDisconnected(t *testing.T) {
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		policy   string
+		elapsed  time.Duration
+		expected bool
+	}{
+		{name: "keep", policy: config.DisconnectedKeep, elapsed: time.Hour, expected: true},
+		{name: "remove", policy: config.DisconnectedRemove, elapsed: 0, expected: false},
+		{name: "within the grace period", policy: config.DisconnectedGrace, elapsed: 30 * time.Second, expected: true},
+		{name: "after the grace period", policy: config.DisconnectedGrace, elapsed: time.Minute, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keepDisconnected(tt.policy, time.Minute, since, since.Add(tt.elapsed)); got != tt.expected {
+				t.Errorf("keepDisconnected() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckNodeHealth(t *testing.T) {
+	// The server only listens on 127.0.0.1, so the checks of 127.0.0.2 are refused
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	controller := newTestController()
+	controller.healthChecker = healthcheck.NewChecker("/ping", port, http.StatusNoContent, time.Second)
+	nodes := []internaltypes.NodeInfo{
+		{ID: "serving", PublicIPAddress: "127.0.0.1"},
+		{ID: "same-nat", PublicIPAddress: "127.0.0.1"},
+		{ID: "refusing", PublicIPAddress: "127.0.0.2"},
+		{ID: "down", PublicIPAddress: "127.0.0.3"},
+	}
+	healthy := map[string]bool{"serving": true, "same-nat": true, "refusing": true, "down": false}
+
+	controller.checkNodeHealth(context.Background(), nodes, healthy)
+	expected := map[string]bool{"serving": true, "same-nat": true, "refusing": false, "down": false}
+	if !reflect.DeepEqual(healthy, expected) {
+		t.Errorf("checkNodeHealth() = %v, want %v", healthy, expected)
+	}
+}
+
+func TestTraefikNodesConsul(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`[
+			{"Node": {"Node": "edge-1", "Address": "10.0.0.1", "Datacenter": "dc1"}, "Service": {"ID": "traefik", "Address": "203.0.113.1"}},
+			{"Node": {"Node": "edge-2", "Address": "10.0.0.2", "Datacenter": "dc2"}, "Service": {"ID": "traefik", "Address": "edge-2.example.com"}}
+		]`))
+	}))
+	defer server.Close()
+
+	controller := newTestController()
+	controller.config.ConsulService = "traefik"
+	var err error
+	if controller.consulClient, err = consul.NewClient(&config.Config{ConsulAddress: server.URL}); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	// Every passing instance is a ready node, but a hostname cannot be published
+	nodes, err := controller.traefikNodes(context.Background())
+	if err != nil {
+		t.Fatalf("traefikNodes() error = %v", err)
+	}
+	expected := []internaltypes.NodeInfo{
+		{ID: "edge-1/traefik", Name: "edge-1", PublicIPAddress: "203.0.113.1", Status: "ready", Datacenter: "dc1"},
+		{ID: "edge-2/traefik", Name: "edge-2", Status: "ready", Datacenter: "dc2"},
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("traefikNodes() = %+v, want %+v", nodes, expected)
+	}
+
+	nodes, err = controller.traefikNodes(context.Background(), "edge-2/traefik")
+	if err != nil {
+		t.Fatalf("traefikNodes() error = %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != "edge-2/traefik" {
+		t.Errorf("traefikNodes(edge-2/traefik) = %+v, want only that instance", nodes)
+	}
+}
+
+func TestIsDenied(t *testing.T) {
+	denylist := []netip.Prefix{
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+	}
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "203.0.113.7", expected: true},
+		{ip: "203.0.113.8", expected: false},
+		{ip: "198.51.100.42", expected: true},
+		{ip: "::ffff:198.51.100.42", expected: true},
+		{ip: "192.0.2.1", expected: false},
+		{ip: "not-an-ip", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if denied := isDenied(tt.ip, denylist); denied != tt.expected {
+				t.Errorf("isDenied(%q) = %v, want %v", tt.ip, denied, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "203.0.113.7", expected: true},
+		{ip: "1.1.1.1", expected: true},
+		{ip: "2606:4700::1111", expected: true},
+		{ip: "10.0.0.5", expected: false},
+		{ip: "172.16.4.2", expected: false},
+		{ip: "192.168.1.20", expected: false},
+		{ip: "::ffff:192.168.1.20", expected: false},
+		{ip: "fd00::1", expected: false},
+		{ip: "127.0.0.1", expected: false},
+		{ip: "::1", expected: false},
+		{ip: "169.254.169.254", expected: false},
+		{ip: "fe80::1", expected: false},
+		{ip: "0.0.0.0", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if public := isPublic(tt.ip); public != tt.expected {
+				t.Errorf("isPublic(%q) = %v, want %v", tt.ip, public, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMapIP(t *testing.T) {
+	ipMap := map[string]string{"10.0.0.5": "203.0.113.5"}
+
+	tests := []struct {
+		ip       string
+		strict   bool
+		expected string
+		ok       bool
+	}{
+		{ip: "10.0.0.5", expected: "203.0.113.5", ok: true},
+		{ip: "::ffff:10.0.0.5", expected: "203.0.113.5", ok: true},
+		{ip: "10.0.0.6", expected: "10.0.0.6", ok: true},
+		{ip: "10.0.0.5", strict: true, expected: "203.0.113.5", ok: true},
+		{ip: "10.0.0.6", strict: true, expected: "10.0.0.6", ok: false},
+	}
+
+	for _, tt := range tests {
+		if ip, ok := mapIP(tt.ip, ipMap, tt.strict); ip != tt.expected || ok != tt.ok {
+			t.Errorf("mapIP(%q, strict %v) = %q, %v, want %q, %v", tt.ip, tt.strict, ip, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestSelectIPs(t *testing.T) {
+	candidates := []candidateNode{
+		{ip: "10.0.0.10", node: internaltypes.NodeInfo{Name: "a", Datacenter: "us-east"}},
+		{ip: "10.0.0.9", node: internaltypes.NodeInfo{Name: "d", Datacenter: "eu-west"}},
+		{ip: "10.0.0.2", node: internaltypes.NodeInfo{Name: "c", Datacenter: "us-east"}},
+		{ip: "10.0.0.2", node: internaltypes.NodeInfo{Name: "b", Datacenter: "us-east"}}, // behind the same IP as c
+		{ip: "10.0.0.5", node: internaltypes.NodeInfo{Name: "e", Datacenter: "eu-west"}},
+	}
+
+	tests := []struct {
+		selection  string
+		datacenter string
+		expected   []string
+	}{
+		{selection: config.SelectIPSort, expected: []string{"10.0.0.2", "10.0.0.5", "10.0.0.9"}},
+		{selection: config.SelectNameSort, expected: []string{"10.0.0.10", "10.0.0.2", "10.0.0.9"}},
+		{selection: config.SelectDCPreferred, datacenter: "eu-west", expected: []string{"10.0.0.5", "10.0.0.9", "10.0.0.2"}},
+		{selection: config.SelectDCPreferred, datacenter: "ap-south", expected: []string{"10.0.0.2", "10.0.0.5", "10.0.0.9"}},
+	}
+
+	for _, tt := range tests {
+		if ips := selectIPs(candidates, tt.selection, tt.datacenter, 3); !reflect.DeepEqual(ips, tt.expected) {
+			t.Errorf("selectIPs(%s, %q) = %v, want %v", tt.selection, tt.datacenter, ips, tt.expected)
+		}
+	}
+}
+
+func TestPartitionFailover(t *testing.T) {
+	primary1 := candidateNode{ip: "10.0.0.1", node: internaltypes.NodeInfo{Name: "primary-1", Primary: true}}
+	primary2 := candidateNode{ip: "10.0.0.2", node: internaltypes.NodeInfo{Name: "primary-2", Primary: true}}
+	failover1 := candidateNode{ip: "10.0.1.1", node: internaltypes.NodeInfo{Name: "failover-1"}}
+	failover2 := candidateNode{ip: "10.0.1.2", node: internaltypes.NodeInfo{Name: "failover-2"}}
+
+	tests := []struct {
+		name             string
+		candidates       []candidateNode
+		expectedPrimary  []string
+		expectedFailover []string
+	}{
+		{name: "primary and failover nodes", candidates: []candidateNode{failover1, primary1, failover2, primary2}, expectedPrimary: []string{"10.0.0.1", "10.0.0.2"}, expectedFailover: []string{"10.0.1.1", "10.0.1.2"}},
+		{name: "no healthy primary node", candidates: []candidateNode{failover2, failover1}, expectedPrimary: []string{}, expectedFailover: []string{"10.0.1.2", "10.0.1.1"}},
+		{name: "no failover node", candidates: []candidateNode{primary2}, expectedPrimary: []string{"10.0.0.2"}, expectedFailover: []string{}},
+		{name: "no healthy node", candidates: nil, expectedPrimary: []string{}, expectedFailover: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, failover := partitionFailover(tt.candidates)
+			if ips := candidateIPs(primary); !reflect.DeepEqual(ips, tt.expectedPrimary) {
+				t.Errorf("partitionFailover() primary = %v, want %v", ips, tt.expectedPrimary)
+			}
+			if ips := candidateIPs(failover); !reflect.DeepEqual(ips, tt.expectedFailover) {
+				t.Errorf("partitionFailover() failover = %v, want %v", ips, tt.expectedFailover)
+			}
+		})
+	}
+}
+
+func TestRecordTargets(t *testing.T) {
+	regionRecordMap := map[string]string{
+		"eu-west":    "eu.example.com",
+		"eu-central": "eu.example.com",
+		"us-east":    "us.example.com",
+		"ap-south":   "ap.example.com",
+	}
+	ipsByDatacenter := map[string][]string{
+		"eu-west":    {"3.3.3.3", "1.1.1.1"},
+		"eu-central": {"2.2.2.2"},
+		"us-east":    {"4.4.4.4"},
+		"unmapped":   {"5.5.5.5"},
+	}
+
+	expected := []recordTarget{
+		{name: "ap.example.com", ips: nil}, // no healthy node, so the record is emptied
+		{name: "eu.example.com", ips: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}},
+		{name: "us.example.com", ips: []string{"4.4.4.4"}},
+	}
+
+	if targets := recordTargets(regionRecordMap, ipsByDatacenter); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("recordTargets() = %v, want %v", targets, expected)
+	}
+}
+
+func TestRecordTargetsByEntrypoint(t *testing.T) {
+	entrypointRecordMap := map[string]string{
+		"web":       "web.example.com",
+		"websecure": "web.example.com",
+		"tcp":       "tcp.example.com",
+	}
+	// Nodes serving both web entrypoints appear in both groups
+	ipsByEntrypoint := map[string][]string{
+		"web":       {"1.1.1.1", "2.2.2.2"},
+		"websecure": {"2.2.2.2", "1.1.1.1"},
+		"tcp":       {"3.3.3.3"},
+	}
+
+	expected := []recordTarget{
+		{name: "tcp.example.com", ips: []string{"3.3.3.3"}},
+		{name: "web.example.com", ips: []string{"1.1.1.1", "2.2.2.2"}},
+	}
+
+	if targets := recordTargets(entrypointRecordMap, ipsByEntrypoint); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("recordTargets() = %v, want %v", targets, expected)
+	}
+}
+
+// fakeCloudflare serves the DNS records API of Cloudflare for the records of a zone, and counts the calls
+type fakeCloudflare struct {
+	mu      sync.Mutex
+	records map[string]string // contents by record ID
+	calls   int
+	deleted []string // IDs of the deleted records
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/dns_records"):
+		var records []string
+		for _, id := range slices.Sorted(maps.Keys(f.records)) {
+			records = append(records, fmt.Sprintf(`{"id": %q, "type": "A", "name": %q, "content": %q, "ttl": 1, "comment": %q}`,
+				id, r.URL.Query().Get("name"), f.records[id], reconcile.OwnerComment))
+		}
+		fmt.Fprintf(w, `{"success": true, "result": [%s], "result_info": {"page": 1, "per_page": 100, "count": %d, "total_count": %d, "total_pages": 1}}`,
+			strings.Join(records, ","), len(records), len(records))
+	case r.Method == http.MethodDelete:
+		id := path.Base(r.URL.Path)
+		delete(f.records, id)
+		f.deleted = append(f.deleted, id)
+		fmt.Fprintf(w, `{"success": true, "result": {"id": %q}}`, id)
+	default:
+		http.Error(w, `{"success": false, "errors": [{"code": 1000, "message": "unexpected request"}]}`, http.StatusBadRequest)
+	}
+}
+
+// newSyncController returns a controller configured by the environment variables, whose Nomad finds no allocation of the
+// Traefik job and whose Cloudflare is cf. The Cloudflare API is reached through the default transport, which is redirected.
+func newSyncController(t *testing.T, cf *fakeCloudflare, env map[string]string) *Controller {
+	t.Helper()
+	metrics.NewServer(0)
+
+	nomadServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/job/ingress/allocations" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(nomadServer.Close)
+
+	cloudflareServer := httptest.NewTLSServer(cf)
+	t.Cleanup(cloudflareServer.Close)
+	transport := cloudflareServer.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.ServerName = "example.com" // the name of the certificate of the test server
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, cloudflareServer.Listener.Addr().String())
+	}
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	for key, value := range map[string]string{
+		"NOMAD_ADDR":           nomadServer.URL,
+		"NOMAD_TOKEN":          "test_nomad_token",
+		"CLOUDFLARE_API_TOKEN": "test_token",
+		"CLOUDFLARE_ZONE_ID":   "test_zone_id",
+		"DNS_RECORD_NAME":      "test.example.com",
+	} {
+		t.Setenv(key, value)
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	cfg.Name = t.Name()
+
+	controller, err := NewController(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+	controller.clock = newFakeClock()
+	return controller
+}
+
+func TestSyncWithoutHealthyNode(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		skipped     string // reason of the skipped sync, empty if the sync ran
+		wantDeleted bool
+	}{
+		{name: "records kept by default", skipped: "empty"},
+		{name: "records deleted with DELETE_ALL_ON_EMPTY", env: map[string]string{"DELETE_ALL_ON_EMPTY": "true"}, wantDeleted: true},
+		{name: "quorum of nodes before DELETE_ALL_ON_EMPTY", env: map[string]string{"DELETE_ALL_ON_EMPTY": "true", "MIN_HEALTHY_NODES": "1"}, skipped: "quorum"},
+		{name: "quorum fraction before DELETE_ALL_ON_EMPTY", env: map[string]string{"DELETE_ALL_ON_EMPTY": "true", "MIN_HEALTHY_FRACTION": "0.5"}, skipped: "quorum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := &fakeCloudflare{records: map[string]string{"record-1": "1.1.1.1", "record-2": "2.2.2.2"}}
+			controller := newSyncController(t, cf, tt.env)
+
+			if err := controller.syncDNSRecords(context.Background(), triggerPeriodic); err != nil {
+				t.Fatalf("syncDNSRecords() error = %v", err)
+			}
+
+			for _, reason := range []string{"empty", "quorum"} {
+				expected := 0.0
+				if reason == tt.skipped {
+					expected = 1
+				}
+				if got := testutil.ToFloat64(metrics.AppMetrics.SyncsSkipped.WithLabelValues(controller.name, reason)); got != expected {
+					t.Errorf("syncs skipped with reason %s = %v, want %v", reason, got, expected)
+				}
+			}
+
+			cf.mu.Lock()
+			defer cf.mu.Unlock()
+			if tt.wantDeleted {
+				if deleted := slices.Sorted(slices.Values(cf.deleted)); !reflect.DeepEqual(deleted, []string{"record-1", "record-2"}) {
+					t.Errorf("deleted records = %v, want every record", deleted)
+				}
+			} else if cf.calls != 0 {
+				t.Errorf("Cloudflare was called %d times, want no call", cf.calls)
+			}
+		})
+	}
+}
+
+// syncBuffer is a bytes.Buffer which the loop can log to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLoopJobDeregistered(t *testing.T) {
+	controller := newTestController()
+	controller.config.TraefikJobNames = []string{"ingress"}
+	var logs syncBuffer
+	controller.logger = log.New(&logs).With("controller", "test")
+	clock := controller.clock.(*fakeClock)
+	events, _, syncs := runLoop(t, controller)
+
+	// The deregistration of another job is not worth a warning
+	events <- internaltypes.Event{Type: "JobDeregistered", JobID: "other"}
+	clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == 1 })
+	events <- internaltypes.Event{Type: "JobDeregistered", JobID: "ingress"}
+	clock.waitFor(t, func(c *fakeClock) bool { return c.afterCalls == 2 })
+	expectSyncs(t, syncs)
+
+	clock.Advance(controller.config.EventDebounce)
+	expectSyncs(t, syncs, "event:JobDeregistered")
+
+	output := logs.String()
+	if count := strings.Count(output, "Traefik job deregistered"); count != 1 {
+		t.Errorf("logged %d deregistration warnings, want 1:\n%s", count, output)
+	}
+	if !strings.Contains(output, "WARN") || !strings.Contains(output, "job=ingress") || !strings.Contains(output, "DELETE_ALL_ON_EMPTY") {
+		t.Errorf("deregistration warning = %q, want a warning naming the job and DELETE_ALL_ON_EMPTY", output)
+	}
+}
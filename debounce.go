@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// debouncer delays the sync following a burst of events until no event was received for wait,
+// but no longer than maxWait after the first event of the burst, so that a continuous stream of events still results in syncs.
+// It is the trailing debounce with a maximum wait: there is no sync on the leading edge of a burst.
+type debouncer struct {
+	clock   clock
+	wait    time.Duration
+	maxWait time.Duration
+
+	pending bool      // whether a sync is due
+	first   time.Time // when the first event of the pending burst was received
+	fire    <-chan time.Time
+}
+
+// newDebouncer returns a debouncer with no sync pending
+func newDebouncer(clock clock, wait, maxWait time.Duration) *debouncer {
+	return &debouncer{clock: clock, wait: wait, maxWait: maxWait}
+}
+
+// event records an event, postponing the sync
+func (d *debouncer) event() {
+	now := d.clock.Now()
+	if !d.pending {
+		d.pending = true
+		d.first = now
+	}
+	d.fire = d.clock.After(d.delay(now))
+}
+
+// delay returns how long to wait from now before syncing
+func (d *debouncer) delay(now time.Time) time.Duration {
+	remaining := d.first.Add(d.maxWait).Sub(now)
+	if remaining < d.wait {
+		return max(remaining, 0)
+	}
+	return d.wait
+}
+
+// C returns the channel on which the time to sync is delivered, or nil when no sync is pending
+func (d *debouncer) C() <-chan time.Time {
+	if !d.pending {
+		return nil
+	}
+	return d.fire
+}
+
+// done records that the pending sync happened
+func (d *debouncer) done() {
+	d.pending = false
+	d.fire = nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncerDelay(t *testing.T) {
+	clock := newFakeClock()
+	d := newDebouncer(clock, 2*time.Second, 5*time.Second)
+
+	tests := []struct {
+		name     string
+		advance  time.Duration // before the event
+		expected time.Duration
+	}{
+		{name: "first event", advance: 0, expected: 2 * time.Second},
+		{name: "event during the wait", advance: time.Second, expected: 2 * time.Second},
+		{name: "event close to the maximum wait", advance: 3 * time.Second, expected: time.Second},
+		{name: "event past the maximum wait", advance: 2 * time.Second, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			d.event()
+			if got := d.delay(clock.Now()); got != tt.expected {
+				t.Errorf("delay() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDebouncerFiresUnderContinuousEvents(t *testing.T) {
+	clock := newFakeClock()
+	d := newDebouncer(clock, 2*time.Second, 5*time.Second)
+
+	if d.C() != nil {
+		t.Fatal("C() should be nil when no sync is pending")
+	}
+
+	// Events arriving faster than the wait would postpone the sync forever without the maximum wait
+	for elapsed := time.Duration(0); elapsed < 5*time.Second; elapsed += time.Second {
+		d.event()
+		clock.Advance(time.Second)
+		if elapsed < 4*time.Second {
+			select {
+			case <-d.C():
+				t.Fatalf("sync fired after %v, before the maximum wait", elapsed+time.Second)
+			default:
+			}
+		}
+	}
+
+	select {
+	case <-d.C():
+	default:
+		t.Fatal("sync did not fire after the maximum wait")
+	}
+	d.done()
+	if d.C() != nil {
+		t.Error("C() should be nil once the sync happened")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// desiredStateCheckInterval is how often the desired state file is checked for changes
+const desiredStateCheckInterval = 5 * time.Second
+
+// readDesiredState reads the IPs listed in the desired state file, which override the IPs of the Traefik nodes.
+// The IPs are separated by whitespace or commas, and lines starting with # are comments.
+// If the file does not exist, it returns no IPs and no error: the IPs come from Nomad.
+// A file listing no IP is an error, so that emptying it by mistake does not delete every record.
+func readDesiredState(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the desired state file: %w", err)
+	}
+
+	var ips []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			addr, err := netip.ParseAddr(field)
+			if err != nil || !addr.Is4() {
+				return nil, fmt.Errorf("desired state file %s must list IPv4 addresses, got %q", path, field)
+			}
+			ips = append(ips, addr.String())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("desired state file %s lists no IP, remove it to publish the Traefik nodes again", path)
+	}
+	return ips, nil
+}
+
+// desiredStateWatcher tells when the desired state file was created, changed or removed
+type desiredStateWatcher struct {
+	path    string
+	content []byte // as last read, nil if the file did not exist
+}
+
+func newDesiredStateWatcher(path string) *desiredStateWatcher {
+	w := &desiredStateWatcher{path: path}
+	w.changed()
+	return w
+}
+
+// changed reports whether the file changed since the last call
+func (w *desiredStateWatcher) changed() bool {
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		// A file which cannot be read is reported by the sync
+		content = nil
+	}
+	if bytes.Equal(content, w.content) && (content == nil) == (w.content == nil) {
+		return false
+	}
+	w.content = content
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+func TestReadDesiredState(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		content  *string // nil if the file does not exist
+		expected []string
+		wantErr  bool
+	}{
+		{name: "no file, the IPs come from Nomad", content: nil, expected: nil},
+		{name: "one failover IP", content: ptr("203.0.113.5\n"), expected: []string{"203.0.113.5"}},
+		{name: "comments and separators", content: ptr("# failover during the incident\n203.0.113.5, 203.0.113.6\n\n198.51.100.1\t198.51.100.2\n"), expected: []string{"203.0.113.5", "203.0.113.6", "198.51.100.1", "198.51.100.2"}},
+		{name: "invalid IP", content: ptr("203.0.113.5\nfailover\n"), wantErr: true},
+		{name: "IPv6 address", content: ptr("2001:db8::1\n"), wantErr: true},
+		{name: "empty file", content: ptr("# nothing yet\n"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if tt.content != nil {
+				if err := os.WriteFile(path, []byte(*tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ips, err := readDesiredState(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readDesiredState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ips, tt.expected) {
+				t.Errorf("readDesiredState() = %v, want %v", ips, tt.expected)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestDesiredStateWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "desired")
+	watcher := newDesiredStateWatcher(path)
+	if watcher.changed() {
+		t.Error("changed() = true while the file still does not exist")
+	}
+
+	for _, step := range []struct {
+		name    string
+		content *string
+	}{
+		{name: "created", content: ptr("203.0.113.5\n")},
+		{name: "changed", content: ptr("203.0.113.6\n")},
+		{name: "emptied", content: ptr("")},
+		{name: "removed", content: nil},
+	} {
+		if step.content != nil {
+			if err := os.WriteFile(path, []byte(*step.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		if !watcher.changed() {
+			t.Errorf("changed() = false once the file was %s", step.name)
+		}
+		if watcher.changed() {
+			t.Errorf("changed() = true twice once the file was %s", step.name)
+		}
+	}
+}
+
+func TestLoopSyncsDesiredStateChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "desired")
+	controller := newTestController()
+	controller.config.DesiredStateFile = path
+	clock := controller.clock.(*fakeClock)
+
+	syncs := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		controller.loop(ctx, make(chan internaltypes.Event), make(chan error), func(_ context.Context, trigger string) error {
+			syncs <- trigger
+			return nil
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	clock.waitFor(t, func(c *fakeClock) bool { return len(c.tickers) == 2 })
+
+	// Nothing changed
+	clock.Advance(desiredStateCheckInterval)
+	expectSyncs(t, syncs)
+
+	// The file is created during an incident, and removed once it is over
+	if err := os.WriteFile(path, []byte("203.0.113.5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(desiredStateCheckInterval)
+	expectSyncs(t, syncs, triggerDesiredState)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(desiredStateCheckInterval)
+	expectSyncs(t, syncs, triggerDesiredState)
+}
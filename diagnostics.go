@@ -0,0 +1,92 @@
+package main
+
+import (
+	"runtime"
+	"slices"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+// recentSyncsSize is the number of sync results kept for the diagnostics bundle
+const recentSyncsSize = 10
+
+// diagnostics is the state of a controller, as exported in the diagnostics bundle
+type diagnostics struct {
+	Config               config.Config              `json:"config"`       // with its secrets redacted
+	RecentSyncs          []internaltypes.SyncResult `json:"recent_syncs"` // oldest first
+	Nodes                []internaltypes.NodeInfo   `json:"nodes"`        // as found by the last sync
+	EventStreamConnected bool                       `json:"event_stream_connected"`
+	Polling              bool                       `json:"polling"` // whether the controller fell back to polling, without the event stream
+}
+
+// bundle is the diagnostics bundle, served on /debug/bundle
+type bundle struct {
+	Version     string                 `json:"version"`
+	GoVersion   string                 `json:"go_version"`
+	Controllers map[string]diagnostics `json:"controllers"` // by controller name
+}
+
+// newBundle assembles the diagnostics bundle of the controllers
+func newBundle(controllers []*Controller) bundle {
+	b := bundle{
+		Version:     version,
+		GoVersion:   runtime.Version(),
+		Controllers: make(map[string]diagnostics, len(controllers)),
+	}
+	for _, controller := range controllers {
+		b.Controllers[controller.name] = controller.diagnostics()
+	}
+	return b
+}
+
+// diagnostics returns the state of the controller. It does not wait for a running sync.
+func (c *Controller) diagnostics() diagnostics {
+	c.configMu.RLock()
+	cfg := c.config.Redacted()
+	c.configMu.RUnlock()
+
+	c.diagMu.Lock()
+	defer c.diagMu.Unlock()
+
+	d := diagnostics{
+		Config:      cfg,
+		RecentSyncs: append([]internaltypes.SyncResult(nil), c.recentSyncs...),
+		Nodes:       c.nodes,
+		Polling:     c.polling.Load(),
+	}
+	if c.nomadClient != nil {
+		d.EventStreamConnected = c.nomadClient.EventStreamConnected()
+	}
+	return d
+}
+
+// recordSync keeps the result of a sync for the diagnostics bundle, dropping the oldest one beyond recentSyncsSize,
+// and counts its changes for the lifetime summary
+func (c *Controller) recordSync(result internaltypes.SyncResult) {
+	c.lifetime.recordChanges(result)
+
+	c.diagMu.Lock()
+	defer c.diagMu.Unlock()
+
+	c.recentSyncs = append(c.recentSyncs, result)
+	if len(c.recentSyncs) > recentSyncsSize {
+		c.recentSyncs = c.recentSyncs[len(c.recentSyncs)-recentSyncsSize:]
+	}
+}
+
+// recordNodes keeps the nodes found by a sync for the diagnostics bundle
+func (c *Controller) recordNodes(nodes []internaltypes.NodeInfo) {
+	c.diagMu.Lock()
+	defer c.diagMu.Unlock()
+
+	c.nodes = nodes
+}
+
+// lastNodes returns the nodes found by the last full sync
+func (c *Controller) lastNodes() []internaltypes.NodeInfo {
+	c.diagMu.Lock()
+	defer c.diagMu.Unlock()
+
+	return slices.Clone(c.nodes)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+func TestDiagnostics(t *testing.T) {
+	controller := newTestController()
+	controller.config.CloudflareToken = "secret"
+
+	for i := 0; i < recentSyncsSize+2; i++ {
+		controller.recordSync(internaltypes.SyncResult{SyncID: fmt.Sprint(i)})
+	}
+	controller.recordNodes([]internaltypes.NodeInfo{{ID: "node-1"}})
+
+	b := newBundle([]*Controller{controller})
+	d, ok := b.Controllers["test"]
+	if !ok {
+		t.Fatalf("bundle controllers = %v, want test", b.Controllers)
+	}
+	if len(d.RecentSyncs) != recentSyncsSize || d.RecentSyncs[0].SyncID != "2" || d.RecentSyncs[recentSyncsSize-1].SyncID != "11" {
+		t.Errorf("recent syncs = %v, want the last %d", d.RecentSyncs, recentSyncsSize)
+	}
+	if len(d.Nodes) != 1 {
+		t.Errorf("nodes = %v, want the nodes of the last sync", d.Nodes)
+	}
+	if d.Config.CloudflareToken == "secret" {
+		t.Error("the bundle exports the Cloudflare token")
+	}
+	if b.Version != version {
+		t.Errorf("bundle version = %q, want %q", b.Version, version)
+	}
+}
@@ -0,0 +1,92 @@
+// Package healthcheck checks over HTTP that the Traefik of each node serves traffic, beyond what Nomad reports about its allocation.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxConcurrentChecks bounds the number of nodes checked at the same time
+const maxConcurrentChecks = 16
+
+// Checker sends a GET request to the same path and port of every node, and expects a given status.
+type Checker struct {
+	client  *http.Client
+	path    string
+	port    string
+	status  int
+	timeout time.Duration
+}
+
+// NewChecker returns a Checker for the given path, port and expected status. Each check fails after timeout.
+func NewChecker(path string, port, status int, timeout time.Duration) *Checker {
+	return &Checker{
+		client: &http.Client{
+			// A redirect is an answer of Traefik, whose status is checked like any other
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		path:    path,
+		port:    strconv.Itoa(port),
+		status:  status,
+		timeout: timeout,
+	}
+}
+
+// Check checks every IP concurrently, and returns why each of the failing ones failed.
+// IPs which are not in the result passed the check.
+func (c *Checker) Check(ctx context.Context, ips []string) map[string]error {
+	var (
+		mu       sync.Mutex
+		failures = make(map[string]error)
+		wg       sync.WaitGroup
+	)
+	pending := make(chan string)
+	for range min(maxConcurrentChecks, len(ips)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range pending {
+				if err := c.check(ctx, ip); err != nil {
+					mu.Lock()
+					failures[ip] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, ip := range ips {
+		pending <- ip
+	}
+	close(pending)
+	wg.Wait()
+	return failures
+}
+
+// check sends the request to a single IP
+func (c *Checker) check(ctx context.Context, ip string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	url := "http://" + net.JoinHostPort(ip, c.port) + c.path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid health check request %s: %w", url, err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) // so that the connection is reused
+
+	if resp.StatusCode != c.status {
+		return fmt.Errorf("health check %s answered %d, want %d", url, resp.StatusCode, c.status)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package healthcheck
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			w.WriteHeader(http.StatusOK)
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		case "/moved":
+			http.Redirect(w, r, "/ping", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	host, portText, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portText)
+
+	// An address on which nothing listens
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		port     int
+		status   int
+		errorMsg string // empty if the check passes
+	}{
+		{name: "expected status", path: "/ping", port: port, status: http.StatusOK},
+		{name: "unexpected status", path: "/down", port: port, status: http.StatusOK, errorMsg: "answered 503, want 200"},
+		{name: "redirects are not followed", path: "/moved", port: port, status: http.StatusOK, errorMsg: "answered 302, want 200"},
+		{name: "timeout", path: "/slow", port: port, status: http.StatusOK, errorMsg: "deadline exceeded"},
+		{name: "connection refused", path: "/ping", port: closedPort, status: http.StatusOK, errorMsg: "refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(tt.path, tt.port, tt.status, 100*time.Millisecond)
+			failures := checker.Check(context.Background(), []string{host})
+			err := failures[host]
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Errorf("Check() = %v, want no failure", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Check() = %v, want it to contain %q", err, tt.errorMsg)
+			}
+		})
+	}
+}
+
+func TestCheckConcurrently(t *testing.T) {
+	// Listening on every interface, so that each IP of 127.0.0.0/8 reaches the server
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Skipf("cannot listen on every interface: %v", err)
+	}
+	// Every node answers after the same delay, so checking them one after the other would take much longer
+	server := &httptest.Server{
+		Listener: listener,
+		Config: &http.Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+		})},
+	}
+	server.Start()
+	defer server.Close()
+
+	var ips []string
+	for i := 1; i <= 10; i++ {
+		ips = append(ips, "127.0.0."+strconv.Itoa(i))
+	}
+
+	checker := NewChecker("/", listener.Addr().(*net.TCPAddr).Port, http.StatusOK, time.Second)
+	start := time.Now()
+	if failures := checker.Check(context.Background(), ips); len(failures) != 0 {
+		t.Fatalf("Check() = %v, want no failure", failures)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Check() took %v, want the nodes to be checked concurrently", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+// nodeHealth is whether a node is published, and for how many consecutive observations its health contradicted it
+type nodeHealth struct {
+	published bool
+	streak    int
+}
+
+// hysteresis dampens the churn of the records when the health of nodes flaps:
+// a node is only published once it was observed healthy k consecutive times, and only removed once it was observed unhealthy k consecutive times.
+// The nodes of the first observation are taken as they are, so that a restart of the controller does not empty the records.
+type hysteresis struct {
+	k     int
+	nodes map[string]*nodeHealth // by node ID
+}
+
+// newHysteresis returns a hysteresis which has observed no node yet
+func newHysteresis(k int) *hysteresis {
+	return &hysteresis{k: k}
+}
+
+// observe records the health of every node, by ID, and returns whether each of them is published.
+// Nodes which are not observed are forgotten.
+func (h *hysteresis) observe(healthy map[string]bool) map[string]bool {
+	first := h.nodes == nil
+	nodes := make(map[string]*nodeHealth, len(healthy))
+	published := make(map[string]bool, len(healthy))
+	for id, ok := range healthy {
+		node, known := h.nodes[id]
+		switch {
+		case first:
+			node = &nodeHealth{published: ok}
+		case !known:
+			node = &nodeHealth{}
+		}
+
+		if ok == node.published {
+			node.streak = 0
+		} else if node.streak++; node.streak >= h.k {
+			node.published = ok
+			node.streak = 0
+		}
+
+		nodes[id] = node
+		published[id] = node.published
+	}
+	h.nodes = nodes
+	return published
+}
+
+// reset forgets the nodes observed so far, and takes the health of every node, by ID, as it is.
+// It returns whether each of them is published, which is whether it is healthy.
+func (h *hysteresis) reset(healthy map[string]bool) map[string]bool {
+	h.nodes = nil
+	return h.observe(healthy)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestHysteresis(t *testing.T) {
+	h := newHysteresis(2)
+	steps := []struct {
+		healthy map[string]bool
+		want    map[string]bool
+	}{
+		// The first observation is taken as it is
+		{
+			healthy: map[string]bool{"a": true, "b": false},
+			want:    map[string]bool{"a": true, "b": false},
+		},
+		// A new node, and a single change of health, are held
+		{
+			healthy: map[string]bool{"a": false, "b": true, "c": true},
+			want:    map[string]bool{"a": true, "b": false, "c": false},
+		},
+		// The second consecutive observation changes them
+		{
+			healthy: map[string]bool{"a": false, "b": true, "c": true},
+			want:    map[string]bool{"a": false, "b": true, "c": true},
+		},
+		// A flap resets the count, and a node which disappeared is forgotten
+		{
+			healthy: map[string]bool{"a": true, "b": false},
+			want:    map[string]bool{"a": false, "b": true},
+		},
+		{
+			healthy: map[string]bool{"a": false, "b": true, "c": true},
+			want:    map[string]bool{"a": false, "b": true, "c": false},
+		},
+	}
+	for i, step := range steps {
+		if got := h.observe(step.healthy); !maps.Equal(got, step.want) {
+			t.Errorf("step %d: observe(%v) = %v, want %v", i, step.healthy, got, step.want)
+		}
+	}
+}
+
+func TestHysteresisOfOne(t *testing.T) {
+	h := newHysteresis(1)
+	h.observe(map[string]bool{"a": true})
+	healthy := map[string]bool{"a": false, "b": true}
+	if got := h.observe(healthy); !maps.Equal(got, healthy) {
+		t.Errorf("observe(%v) = %v, want the health of the nodes", healthy, got)
+	}
+}
+
+func TestHysteresisReset(t *testing.T) {
+	h := newHysteresis(3)
+	h.observe(map[string]bool{"a": true, "b": false})
+	// The changes of health are held by the hysteresis
+	if got := h.observe(map[string]bool{"a": false, "b": true}); !maps.Equal(got, map[string]bool{"a": true, "b": false}) {
+		t.Fatalf("observe() = %v, want the changes held", got)
+	}
+
+	// A full reconcile ignores the held state, and the next observations build upon it
+	healthy := map[string]bool{"a": false, "b": true, "c": true}
+	if got := h.reset(healthy); !maps.Equal(got, healthy) {
+		t.Errorf("reset(%v) = %v, want the health of the nodes", healthy, got)
+	}
+	if got := h.observe(map[string]bool{"a": true, "b": true, "c": true}); !maps.Equal(got, healthy) {
+		t.Errorf("observe() after reset = %v, want %v until the change of health is confirmed", got, healthy)
+	}
+}
@@ -3,90 +3,131 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
-	"time"
 
-	"github.com/brucellino/nomad-traefik-cloudflare-controller/cloudflare"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/audit"
 	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
 	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
-	"github.com/brucellino/nomad-traefik-cloudflare-controller/nomad"
-	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/tracing"
 	"github.com/charmbracelet/log"
 )
 
-// Controller is the main wrapper for the nomad and cloudflare APIs
-type Controller struct {
-	nomadClient      *nomad.Client
-	cloudflareClient *cloudflare.Client
-	config           *config.Config
-	metricsServer    *metrics.Server
-}
-
 func main() {
 	// Configure logger.
 	// This application uses the Charm Bracelet Log package.
-	logLevel := log.InfoLevel
-	if envLevel := os.Getenv("LOG_LEVEL"); envLevel != "" {
-		switch strings.ToLower(envLevel) {
-		case "debug":
-			logLevel = log.DebugLevel
-		case "info":
-			logLevel = log.InfoLevel
-		case "warn", "warning":
-			logLevel = log.WarnLevel
-		case "error":
-			logLevel = log.ErrorLevel
-		case "fatal":
-			logLevel = log.FatalLevel
-		}
-	}
+	logLevel := parseLogLevel(os.Getenv("LOG_LEVEL"))
 
 	log.SetLevel(logLevel)
 	log.SetReportTimestamp(true)
 	log.SetReportCaller(false)
 
-	log.Info("Starting Traefik Cloudflare Controller", "log_level", logLevel)
+	build := currentBuildInfo()
+	log.Info("Starting Traefik Cloudflare Controller",
+		"version", build.Version,
+		"commit", build.Commit,
+		"build_date", build.BuildDate,
+		"go_version", build.GoVersion,
+		"log_level", logLevel)
 
-	// Load configuration
-	cfg, err := config.LoadConfig()
+	// Load the configuration of every controller instance
+	cfgs, err := config.LoadConfigs()
 
 	if err != nil {
 		log.Fatal("Failed to load configuration", "error", err)
 	}
 
-	// Create Nomad client
-	nomadClient, err := nomad.NewClient(cfg)
+	// The log level may come from the config file
+	if level := parseLogLevel(cfgs[0].LogLevel); level != logLevel {
+		logLevel = level
+		log.SetLevel(logLevel)
+		log.Info("Log level set from the configuration", "log_level", logLevel)
+	}
 
+	// Set up tracing. It is a no-op unless an OTLP endpoint is configured.
+	shutdownTracing, err := tracing.Setup(context.Background())
 	if err != nil {
-		log.Fatal("Failed to create nomad client", "error", err)
+		log.Fatal("Failed to set up tracing", "error", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error("Failed to shut down tracing", "error", err)
+		}
+	}()
+	if tracing.Enabled() {
+		log.Info("Tracing enabled")
 	}
 
-	// Create Cloudflare client
-	cloudflareClient, err := cloudflare.NewClient(cfg)
-
+	// Set up the audit log of the DNS changes, shared by all controller instances. It is a no-op unless a path is configured.
+	closeAudit, err := audit.Setup(cfgs[0].AuditLogPath)
 	if err != nil {
-		log.Fatal("Failed to create cloudflare client", "error", err)
+		log.Fatal("Failed to set up the audit log", "error", err)
 	}
+	defer func() {
+		if err := closeAudit(); err != nil {
+			log.Error("Failed to close the audit log", "error", err)
+		}
+	}()
+
+	// The controllers are created once the metrics server exists, which exports their diagnostics
+	var controllers []*Controller
+
+	// Create the metrics server, shared by all controller instances, unless disabled.
+	// The metrics port is a process-wide setting, so all instances share the same value.
+	var metricsServer *metrics.Server
+	if cfgs[0].MetricsEnabled {
+		metricsPort := 8080
+		if port, err := strconv.Atoi(cfgs[0].MetricsPort); err == nil {
+			metricsPort = port
+		}
 
-	// Get metrics port from config
-	metricsPort := 8080
-	if port, err := strconv.Atoi(cfg.MetricsPort); err == nil {
-		metricsPort = port
+		options := []metrics.Option{
+			metrics.WithBindAddress(cfgs[0].MetricsBind),
+			metrics.WithHealthPath(cfgs[0].HealthPath),
+			metrics.WithReadyPath(cfgs[0].ReadyPath),
+			metrics.WithVersion(build),
+			metrics.WithDebugBundle(cfgs[0].DebugToken, func() interface{} { return newBundle(controllers) }),
+			metrics.WithSync(cfgs[0].DebugToken, func(ctx context.Context, record, node string) (interface{}, error) {
+				return requestSync(ctx, controllers, syncScope{record: record, node: node})
+			}),
+		}
+		// The metrics can be scraped on their own port, apart from the health probes
+		if scrapePort, err := strconv.Atoi(cfgs[0].ScrapePort); err == nil {
+			options = append(options, metrics.WithScrapeListener(cfgs[0].ScrapeBind, scrapePort))
+		}
+
+		metricsServer = metrics.NewServer(metricsPort, options...)
+	} else {
+		log.Info("Metrics server disabled")
 	}
 
-	// Create metrics server
-	metricsServer := metrics.NewServer(metricsPort)
+	// The application is ready once every controller has completed its initial sync
+	var readyCount atomic.Int32
+	markReady := func() {
+		if int(readyCount.Add(1)) != len(cfgs) {
+			return
+		}
+		if metricsServer != nil {
+			metricsServer.SetReady(true)
+		} else {
+			log.Info("Application is ready")
+		}
+	}
 
-	// Create controller instance
-	controller := &Controller{
-		nomadClient:      nomadClient,
-		cloudflareClient: cloudflareClient,
-		config:           cfg,
-		metricsServer:    metricsServer,
+	// Create one controller instance per configuration
+	for _, cfg := range cfgs {
+		controller, err := NewController(cfg, markReady)
+		if err != nil {
+			log.Fatal("Failed to create controller", "controller", cfg.Name, "error", err)
+		}
+		controllers = append(controllers, controller)
 	}
 
 	// Set up a context so that we can send signals and have a graceful shutdown
@@ -98,11 +139,13 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Start metrics server
-	go func() {
-		if err := controller.metricsServer.Start(ctx); err != nil {
-			log.Error("Metrics server error", "error", err)
-		}
-	}()
+	if metricsServer != nil {
+		go func() {
+			if err := metricsServer.Start(ctx); err != nil {
+				log.Error("Metrics server error", "error", err)
+			}
+		}()
+	}
 
 	// anonymous function to receive messages in the channel
 	go func() {
@@ -111,110 +154,89 @@ func main() {
 		cancel()
 	}()
 
-	// Start the controller
-	if err := controller.Run(ctx); err != nil && err != context.Canceled {
-		log.Fatal("Controller error", "error", err)
-	}
-
-	log.Info("Controller stopped")
-}
-
-// Run is the main work function
-func (c *Controller) Run(ctx context.Context) error {
-	log.Info("Controller starting",
-		"nomad", c.config.NomadAddress,
-		"job", c.config.TraefikJobName,
-		"dns", c.config.DNSRecordName)
-
-	// Initial sync
-	//
-	log.Debug("Running with config", "config", c.config)
-	if err := c.syncDNSRecords(ctx); err != nil {
-		log.Error("Initial sync failed", "error", err)
-	} else {
-		// Mark application as ready after successful initial sync
-		c.metricsServer.SetReady(true)
-	}
-
-	// Set up event watching
-	eventChan := make(chan internaltypes.Event, 100)
-	eventErrorChan := make(chan error, 1)
+	// SIGUSR1 triggers an immediate sync of every controller,
+	// for operators who have shell access but cannot reach the HTTP endpoints.
+	syncSigChan := make(chan os.Signal, 1)
+	signal.Notify(syncSigChan, syscall.SIGUSR1)
 	go func() {
-		if err := c.nomadClient.WatchEvents(ctx, eventChan); err != nil {
-			log.Error("Event watcher fatal error", "error", err)
+		for {
 			select {
-			case eventErrorChan <- err:
 			case <-ctx.Done():
+				return
+			case <-syncSigChan:
+				log.Info("Received SIGUSR1, requesting sync")
+				for _, controller := range controllers {
+					controller.TriggerSync(triggerSignal)
+				}
 			}
 		}
 	}()
 
-	// Set up periodic sync (fallback mechanism)
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	// Main event loop
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-
-		// Event watcher fatal error - shut down gracefully
-		case err := <-eventErrorChan:
-			log.Error("Event watcher exceeded error threshold, shutting down", "error", err)
-			return err
-
-		// Nomad event in channel
-		case event := <-eventChan:
-			log.Info("Received event", "type", event.Type)
-			// Debounce events by waiting a bit before syncing
-			time.Sleep(2 * time.Second)
-			if err := c.syncDNSRecords(ctx); err != nil {
-				log.Error("Sync after event failed", "error", err)
-			}
-		// Ticker event in channel
-		case <-ticker.C:
-			log.Info("Performing periodic sync...")
-			if err := c.syncDNSRecords(ctx); err != nil {
-				log.Error("Periodic sync failed", "error", err)
+	// SIGHUP reopens the audit log, once logrotate moved it away, and reloads the configuration
+	reopenSigChan := make(chan os.Signal, 1)
+	signal.Notify(reopenSigChan, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reopenSigChan:
+				log.Info("Received SIGHUP, reopening the audit log and reloading the configuration")
+				if err := audit.Reopen(); err != nil {
+					log.Error("Failed to reopen the audit log", "error", err)
+				}
+				reloadConfig(controllers)
 			}
 		}
-	}
-}
-
-func (c *Controller) syncDNSRecords(ctx context.Context) error {
-	log.Info("Syncing DNS records...")
-
-	// Record sync metrics
-	recordMetrics := metrics.RecordSyncStart()
+	}()
 
-	// Get current Traefik nodes
-	nodes, err := c.nomadClient.GetTraefikNodes()
-	if err != nil {
-		recordMetrics(err, 0, 0)
-		return err
+	// Start the controllers. If one of them fails, all of them are stopped.
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(controllers))
+	for _, controller := range controllers {
+		wg.Add(1)
+		go func(controller *Controller) {
+			defer wg.Done()
+			if err := controller.Run(ctx); err != nil && err != context.Canceled {
+				errChan <- fmt.Errorf("controller %s: %w", controller.name, err)
+				cancel()
+			}
+		}(controller)
 	}
+	wg.Wait()
+	close(errChan)
 
-	log.Info("Found Traefik nodes", "count", len(nodes))
-
-	// Extract IP addresses
-	var ips []string
-	for _, node := range nodes {
-		if node.Status == "ready" && node.PublicIPAddress != "" {
-			ips = append(ips, node.PublicIPAddress)
-			log.Debug("Traefik node", "name", node.Name, "id", node.ID, "ip", node.PublicIPAddress)
-		}
+	if err := errors.Join(collectErrors(errChan)...); err != nil {
+		log.Fatal("Controller error", "error", err)
 	}
 
-	// Sync with Cloudflare
-	if err := c.cloudflareClient.SyncARecords(ctx, ips); err != nil {
-		recordMetrics(err, len(ips), len(nodes))
-		return err
+	// Only a graceful shutdown gets here: a failed controller exits above
+	for _, controller := range controllers {
+		controller.logSummary()
 	}
+	log.Info("Controller stopped")
+}
 
-	// Record successful sync
-	recordMetrics(nil, len(ips), len(nodes))
+// parseLogLevel returns the log level with the given name, defaulting to info
+func parseLogLevel(name string) log.Level {
+	switch strings.ToLower(name) {
+	case "debug":
+		return log.DebugLevel
+	case "warn", "warning":
+		return log.WarnLevel
+	case "error":
+		return log.ErrorLevel
+	case "fatal":
+		return log.FatalLevel
+	}
+	return log.InfoLevel
+}
 
-	log.Info("DNS sync completed", "ip_count", len(ips))
-	return nil
+// collectErrors drains the channel into a slice
+func collectErrors(errChan <-chan error) []error {
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+	return errs
 }
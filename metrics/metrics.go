@@ -3,8 +3,15 @@ package metrics
 
 import (
 	"context"
-	"fmt"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,63 +19,326 @@ import (
 	"github.com/charmbracelet/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server represents the metrics HTTP server
 type Server struct {
 	server *http.Server
+	scrape *http.Server // serves /metrics on its own listener, nil if server serves it
 	ready  *atomic.Bool
 }
 
-// Metrics holds all the Prometheus metrics for the application
+// Metrics holds all the Prometheus metrics for the application.
+// Every metric is labelled by controller instance so that several controllers can share one server.
 type Metrics struct {
-	SyncTotal       prometheus.Counter
-	SyncErrors      prometheus.Counter
-	SyncDuration    prometheus.Histogram
-	DNSRecordsTotal prometheus.Gauge
-	TraefikNodes    prometheus.Gauge
-	LastSyncTime    prometheus.Gauge
+	SyncTotal       *prometheus.CounterVec
+	SyncErrors      *prometheus.CounterVec
+	SyncDuration    *prometheus.HistogramVec
+	DNSRecordsTotal *prometheus.GaugeVec
+	TraefikNodes    *prometheus.GaugeVec
+	LastSyncTime    *prometheus.GaugeVec
+
+	CloudflareRateLimitRemaining *prometheus.GaugeVec
+	PropagationChecks            *prometheus.CounterVec
+	SyncsSkipped                 *prometheus.CounterVec
+	CloudflareCircuitState       *prometheus.GaugeVec
+	ShadowDivergences            *prometheus.CounterVec
+	NomadAPIDuration             *prometheus.HistogramVec
+	NomadAPIRequests             *prometheus.CounterVec
+	DeletionsSkipped             *prometheus.CounterVec
+	EventStreamConnected         *prometheus.GaugeVec
+	EventStreamReconnects        *prometheus.CounterVec
+	RecordSetHash                *prometheus.GaugeVec
+	NameSyncs                    *prometheus.CounterVec
+	CloudflareAPIErrors          *prometheus.CounterVec
+	CloudflareAPIDuration        *prometheus.HistogramVec
+	NodeIPConflicts              *prometheus.CounterVec
+	NodeInfo                     *prometheus.GaugeVec
+	HealthyNodes                 *prometheus.GaugeVec
+	NodeHealthChecks             *prometheus.CounterVec
+	SecondsSinceLastEvent        *sinceCollector
+}
+
+// sinceCollector exports, by controller, the seconds elapsed since a time, as a gauge computed when it is scraped
+type sinceCollector struct {
+	desc  *prometheus.Desc
+	mu    sync.Mutex
+	times map[string]time.Time // by controller
+}
+
+func newSinceCollector(name, help string) *sinceCollector {
+	return &sinceCollector{
+		desc:  prometheus.NewDesc(name, help, []string{"controller"}, nil),
+		times: make(map[string]time.Time),
+	}
+}
+
+// set records the time from which the seconds are counted for the controller
+func (s *sinceCollector) set(controller string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.times[controller] = at
+}
+
+// Describe implements prometheus.Collector
+func (s *sinceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+// Collect implements prometheus.Collector
+func (s *sinceCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for controller, at := range s.times {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, time.Since(at).Seconds(), controller)
+	}
+}
+
+// controllerState is the state of a controller, as served on /state
+type controllerState struct {
+	RecordSetHashes map[string]string               `json:"record_set_hashes"` // hash of the records, by record name
+	ManagedIPs      map[string]map[string]time.Time `json:"managed_ips"`       // when each IP was first seen in the records, by record name then IP
+}
+
+// newControllerState returns the empty state of a controller
+func newControllerState() *controllerState {
+	return &controllerState{
+		RecordSetHashes: make(map[string]string),
+		ManagedIPs:      make(map[string]map[string]time.Time),
+	}
 }
 
+// managedIP is a record an IP is in, as served on /state/ip/{ip}
+type managedIP struct {
+	Controller string    `json:"controller"`
+	Name       string    `json:"name"`  // name of the record
+	Since      time.Time `json:"since"` // when the IP was first seen in the record
+}
+
+// state holds the state of every controller, by controller name
+var (
+	stateMu sync.Mutex
+	state   = make(map[string]*controllerState)
+)
+
+// nodeInfoSeries holds the label values of the node info series of every controller, by controller name then node,
+// so that the series of the nodes which are gone can be deleted
+var (
+	nodeInfoMu     sync.Mutex
+	nodeInfoSeries = make(map[string]map[Node]bool)
+)
+
+// healthyNodesDatacenters holds the datacenters of the healthy nodes series of every controller, by controller name,
+// so that a datacenter whose nodes are all gone drops to zero rather than vanishing
+var (
+	healthyNodesMu          sync.Mutex
+	healthyNodesDatacenters = make(map[string]map[string]bool)
+)
+
 // AppMetrics is the global metrics instance
 var AppMetrics *Metrics
 
 // metricsOnce
 var metricsOnce sync.Once
 
+// Option configures the metrics server
+type Option func(*serverOptions)
+
+// serverOptions holds the settings of the metrics server which can be changed with options
+type serverOptions struct {
+	healthPath  string
+	readyPath   string
+	bindAddress string                                                              // address the server listens on, every interface if empty
+	scrapeAddr  string                                                              // host:port of the listener serving /metrics, empty to serve it with the other endpoints
+	bundle      func() interface{}                                                  // returns the diagnostics bundle. nil disables the endpoint.
+	debugToken  string                                                              // required to get the diagnostics bundle, unless empty
+	sync        func(ctx context.Context, record, node string) (interface{}, error) // requests a sync. nil disables the endpoint.
+	version     interface{}                                                         // build metadata. nil disables the endpoint.
+	syncToken   string                                                              // required to request a sync, unless empty
+}
+
+// WithHealthPath serves the health endpoint at path instead of /health
+func WithHealthPath(path string) Option {
+	return func(o *serverOptions) {
+		o.healthPath = path
+	}
+}
+
+// WithReadyPath serves the ready endpoint at path instead of /ready
+func WithReadyPath(path string) Option {
+	return func(o *serverOptions) {
+		o.readyPath = path
+	}
+}
+
+// WithBindAddress listens on the address, e.g. the IP of an interface, instead of every interface
+func WithBindAddress(address string) Option {
+	return func(o *serverOptions) {
+		o.bindAddress = address
+	}
+}
+
+// WithScrapeListener serves /metrics on a second listener, on the port of the address (every interface if empty),
+// rather than with the other endpoints, so that network policies can treat scrapes apart from probes.
+func WithScrapeListener(address string, port int) Option {
+	return func(o *serverOptions) {
+		o.scrapeAddr = net.JoinHostPort(address, strconv.Itoa(port))
+	}
+}
+
+// WithDebugBundle serves the diagnostics bundle returned by bundle at /debug/bundle.
+// When token is set, requests must carry it as a bearer token.
+func WithDebugBundle(token string, bundle func() interface{}) Option {
+	return func(o *serverOptions) {
+		o.debugToken = token
+		o.bundle = bundle
+	}
+}
+
+// WithVersion serves the build metadata of the controller, e.g. its version and commit, at /version
+func WithVersion(version interface{}) Option {
+	return func(o *serverOptions) {
+		o.version = version
+	}
+}
+
+// ErrUnknownSyncScope is returned by the sync function of WithSync when the record or node of the request is not managed
+var ErrUnknownSyncScope = errors.New("unknown sync scope")
+
+// ErrSyncAlreadyQueued is returned by the sync function of WithSync when a sync is already queued behind the running one
+var ErrSyncAlreadyQueued = errors.New("sync already queued")
+
+// WithSync serves POST /sync, which calls sync with the record name and node ID of the record and node query parameters.
+// Without either, sync requests a full sync, which runs in the background.
+// A scoped sync returns the changes it made, which are encoded as the results of the response.
+// When token is set, requests must carry it as a bearer token.
+func WithSync(token string, sync func(ctx context.Context, record, node string) (interface{}, error)) Option {
+	return func(o *serverOptions) {
+		o.syncToken = token
+		o.sync = sync
+	}
+}
+
 // NewServer creates a new metrics server
-func NewServer(port int) *Server {
+func NewServer(port int, opts ...Option) *Server {
+	options := serverOptions{
+		healthPath: "/health",
+		readyPath:  "/ready",
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	ready := &atomic.Bool{}
 	ready.Store(false)
 
 	// Initialize metrics only once
 	metricsOnce.Do(func() {
 		AppMetrics = &Metrics{
-			SyncTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			SyncTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 				Name: "nomad_traefik_controller_sync_total",
 				Help: "Total number of DNS sync operations performed",
-			}),
-			SyncErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			}, []string{"controller"}),
+			SyncErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 				Name: "nomad_traefik_controller_sync_errors_total",
 				Help: "Total number of DNS sync errors",
-			}),
-			SyncDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			}, []string{"controller"}),
+			SyncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 				Name:    "nomad_traefik_controller_sync_duration_seconds",
 				Help:    "Duration of DNS sync operations in seconds",
 				Buckets: prometheus.DefBuckets,
-			}),
-			DNSRecordsTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{"controller"}),
+			DNSRecordsTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nomad_traefik_controller_dns_records_total",
 				Help: "Current number of DNS records managed",
-			}),
-			TraefikNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{"controller"}),
+			TraefikNodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nomad_traefik_controller_traefik_nodes",
 				Help: "Current number of healthy Traefik nodes",
-			}),
-			LastSyncTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{"controller"}),
+			LastSyncTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nomad_traefik_controller_last_sync_timestamp",
 				Help: "Timestamp of the last successful sync operation",
-			}),
+			}, []string{"controller"}),
+			CloudflareRateLimitRemaining: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nomad_traefik_controller_cloudflare_rate_limit_remaining",
+				Help: "Remaining Cloudflare API request quota, as reported by the last API response",
+			}, []string{"controller"}),
+			PropagationChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_propagation_checks_total",
+				Help: "Total number of post-sync DNS propagation checks, by result (match, mismatch, error)",
+			}, []string{"controller", "result"}),
+			SyncsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_syncs_skipped_total",
+				Help: "Total number of DNS syncs skipped without changing records, by reason",
+			}, []string{"controller", "reason"}),
+			CloudflareCircuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nomad_traefik_controller_cloudflare_circuit_state",
+				Help: "State of the Cloudflare circuit breaker (0 closed, 1 half-open, 2 open)",
+			}, []string{"controller"}),
+			ShadowDivergences: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_shadow_divergences_total",
+				Help: "Total number of syncs whose outcome in the shadow zone differed from production",
+			}, []string{"controller"}),
+			NomadAPIDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "nomad_traefik_controller_nomad_api_duration_seconds",
+				Help:    "Duration of Nomad API calls in seconds, by operation (agent_self, allocations, node_info, node_list, event_stream, variable_update)",
+				Buckets: prometheus.DefBuckets,
+			}, []string{"controller", "operation"}),
+			NomadAPIRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_nomad_api_requests_total",
+				Help: "Total number of Nomad API calls, by operation and result (success, error)",
+			}, []string{"controller", "operation", "result"}),
+			DeletionsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_deletions_skipped_total",
+				Help: "Total number of record deletions skipped because the controller only adds records",
+			}, []string{"controller"}),
+			EventStreamConnected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nomad_traefik_controller_event_stream_connected",
+				Help: "Whether the Nomad event stream is connected and alive (1) or not (0)",
+			}, []string{"controller"}),
+			EventStreamReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_event_stream_reconnects_total",
+				Help: "Total number of reconnections of the Nomad event stream, after it failed, closed or stalled",
+			}, []string{"controller"}),
+			RecordSetHash: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nomad_traefik_controller_record_set_hash",
+				Help: "Always 1, labelled with the hash of the records of each name after the last sync. Controllers which agree on the records have the same hash",
+			}, []string{"controller", "name", "hash"}),
+			NameSyncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_name_syncs_total",
+				Help: "Total number of syncs of each name in DNS_RECORD_NAMES, by result (success, error)",
+			}, []string{"controller", "name", "result"}),
+			CloudflareAPIErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_cloudflare_api_errors_total",
+				Help: "Total number of failed Cloudflare API calls, by operation (list, create, update, delete), category (auth, not_found, rate_limit, validation, server, network, other) and Cloudflare error code",
+			}, []string{"controller", "operation", "category", "code"}),
+			CloudflareAPIDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "nomad_traefik_controller_cloudflare_api_request_duration_seconds",
+				Help:    "Duration of the Cloudflare API calls in seconds, failed or not, by operation (list, create, update, delete)",
+				Buckets: prometheus.DefBuckets,
+			}, []string{"controller", "operation"}),
+			NodeIPConflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_node_ip_conflicts_total",
+				Help: "Total number of nodes read with different IPs within a single fetch of the Traefik nodes",
+			}, []string{"controller"}),
+			NodeInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nomad_traefik_controller_node_info",
+				Help: "Always 1, labelled with the ID, name, IP and status of each Traefik node eligible for DNS as of the last sync",
+			}, []string{"controller", "node_id", "node_name", "ip", "status"}),
+			HealthyNodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nomad_traefik_controller_healthy_nodes",
+				Help: "Number of healthy Traefik nodes, which may be published, by Nomad datacenter as of the last sync",
+			}, []string{"controller", "datacenter"}),
+			NodeHealthChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "nomad_traefik_controller_node_health_checks_total",
+				Help: "Total number of HTTP health checks of the Traefik nodes, by result (pass, fail). Failing nodes are not published",
+			}, []string{"controller", "result"}),
+			SecondsSinceLastEvent: newSinceCollector(
+				"nomad_traefik_controller_seconds_since_last_event",
+				"Seconds since the last Nomad event was received, or since the controller started if none was. Heartbeats are not counted",
+			),
 		}
 
 		// Register metrics with Prometheus
@@ -79,73 +349,211 @@ func NewServer(port int) *Server {
 			AppMetrics.DNSRecordsTotal,
 			AppMetrics.TraefikNodes,
 			AppMetrics.LastSyncTime,
+			AppMetrics.CloudflareRateLimitRemaining,
+			AppMetrics.PropagationChecks,
+			AppMetrics.SyncsSkipped,
+			AppMetrics.CloudflareCircuitState,
+			AppMetrics.ShadowDivergences,
+			AppMetrics.NomadAPIDuration,
+			AppMetrics.NomadAPIRequests,
+			AppMetrics.DeletionsSkipped,
+			AppMetrics.EventStreamConnected,
+			AppMetrics.EventStreamReconnects,
+			AppMetrics.RecordSetHash,
+			AppMetrics.NameSyncs,
+			AppMetrics.CloudflareAPIErrors,
+			AppMetrics.CloudflareAPIDuration,
+			AppMetrics.NodeIPConflicts,
+			AppMetrics.NodeInfo,
+			AppMetrics.HealthyNodes,
+			AppMetrics.NodeHealthChecks,
+			AppMetrics.SecondsSinceLastEvent,
 		)
 	})
 
 	// Create HTTP mux
 	mux := http.NewServeMux()
-	// Health endpoint - returns 200 if the application is running
-	// We do not do anything with the actual request, so we discard it for now.
-	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status": "healthy", "timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `"}`))
+	// Health endpoint - returns 200 if the application is running.
+	// GET patterns also match HEAD requests, for probes which do not read the body.
+	mux.HandleFunc("GET "+options.healthPath, func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, http.StatusOK, "healthy")
 	})
 
 	// Ready endpoint - returns 200 if the application is ready to serve traffic
-	mux.HandleFunc("/ready", func(w http.ResponseWriter, _ *http.Request) {
+	mux.HandleFunc("GET "+options.readyPath, func(w http.ResponseWriter, r *http.Request) {
 		if ready.Load() {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"status": "ready", "timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `"}`))
+			writeStatus(w, r, http.StatusOK, "ready")
 		} else {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte(`{"status": "not ready", "timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `"}`))
+			writeStatus(w, r, http.StatusServiceUnavailable, "not ready")
 		}
 	})
 
-	// Metrics endpoint
-	mux.Handle("/metrics", promhttp.Handler())
+	// Metrics endpoint, on its own listener if configured
+	var scrape *http.Server
+	if options.scrapeAddr != "" {
+		scrapeMux := http.NewServeMux()
+		scrapeMux.Handle("/metrics", metricsHandler())
+		scrape = newHTTPServer(options.scrapeAddr, scrapeMux)
+	} else {
+		mux.Handle("/metrics", metricsHandler())
+	}
+
+	// State endpoint - returns the state of every controller, by controller name
+	mux.HandleFunc("GET /state", func(w http.ResponseWriter, r *http.Request) {
+		stateMu.Lock()
+		defer stateMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	})
+
+	// Managed IP endpoint - returns the records an IP is in, or 404 if it is in none
+	mux.HandleFunc("GET /state/ip/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		addr, err := netip.ParseAddr(r.PathValue("ip"))
+		if err != nil {
+			http.Error(w, "invalid IP address", http.StatusBadRequest)
+			return
+		}
+		records := managedRecords(addr.Unmap())
+		if len(records) == 0 {
+			http.Error(w, "IP address not managed", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"ip": addr.Unmap().String(), "records": records})
+	})
+
+	// Version endpoint - returns the build metadata, e.g. to check that a rollout completed
+	if options.version != nil {
+		mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(options.version)
+		})
+	}
+
+	// Diagnostics bundle endpoint - returns everything needed to diagnose an issue in one call
+	if options.bundle != nil {
+		mux.HandleFunc("GET /debug/bundle", func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r, options.debugToken) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(options.bundle())
+		})
+	}
+
+	// Sync endpoint - requests a full sync, or runs a sync scoped to a record or a node and returns its outcome
+	if options.sync != nil {
+		mux.HandleFunc("POST /sync", func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r, options.syncToken) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			record, node := r.URL.Query().Get("record"), r.URL.Query().Get("node")
+			results, err := options.sync(r.Context(), record, node)
+			switch {
+			case errors.Is(err, ErrUnknownSyncScope):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			// The queued full sync will run, but a scoped sync was not, so it must be requested again
+			case errors.Is(err, ErrSyncAlreadyQueued) && record == "" && node == "":
+				writeStatus(w, r, http.StatusAccepted, "sync already queued")
+			case errors.Is(err, ErrSyncAlreadyQueued):
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			case record == "" && node == "":
+				writeStatus(w, r, http.StatusAccepted, "sync requested")
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":    "synced",
+					"timestamp": time.Now().UTC().Format(time.RFC3339),
+					"results":   results,
+				})
+			}
+		})
+	}
+
+	return &Server{
+		server: newHTTPServer(net.JoinHostPort(options.bindAddress, strconv.Itoa(port)), mux),
+		scrape: scrape,
+		ready:  ready,
+	}
+}
+
+// metricsHandler serves the metrics. Scrapers asking for the OpenMetrics format also get the exemplars, see observe.
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
 
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      mux,
+// newHTTPServer creates an HTTP server listening on the address
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+}
 
-	return &Server{
-		server: server,
-		ready:  ready,
+// authorized reports whether the request carries the bearer token. Any request is authorized when the token is empty.
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
 	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
 }
 
-// Start starts the metrics server
+// writeStatus writes the JSON status response of the health endpoints. The body is left out for HEAD requests.
+func writeStatus(w http.ResponseWriter, r *http.Request, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(`{"status": "` + status + `", "timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `"}`))
+}
+
+// Start starts the metrics server, and the scrape listener if any, until the context is cancelled
 func (s *Server) Start(ctx context.Context) error {
-	log.Info("Starting metrics server", "addr", s.server.Addr)
+	servers := []*http.Server{s.server}
+	if s.scrape != nil {
+		servers = append(servers, s.scrape)
+	}
 
-	// Start server in goroutine
-	go func() {
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Error("Metrics server error", "error", err)
-		}
-	}()
+	// Start servers in goroutines
+	for _, server := range servers {
+		log.Info("Starting metrics server", "addr", server.Addr)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("Metrics server error", "addr", server.Addr, "error", err)
+			}
+		}()
+	}
 
 	// Wait for context cancellation
 	<-ctx.Done()
 
 	log.Info("Shutting down metrics server...")
 
-	// Create a context with timeout for shutdown
+	// Create a context with timeout for shutdown, shared by both servers
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Shutdown server gracefully
-	if err := s.server.Shutdown(shutdownCtx); err != nil {
-		log.Error("Metrics server shutdown error", "error", err)
+	// Shutdown servers gracefully
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error("Metrics server shutdown error", "addr", server.Addr, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
 		return err
 	}
 
@@ -163,8 +571,9 @@ func (s *Server) SetReady(ready bool) {
 	}
 }
 
-// RecordSyncStart records the start of a sync operation
-func RecordSyncStart() func(error, int, int) {
+// RecordSyncStart records the start of a sync operation of the named controller.
+// The context carries the span of the sync, if it is traced.
+func RecordSyncStart(ctx context.Context, controller string) func(error, int, int) {
 	start := time.Now()
 	return func(err error, dnsRecords, traefikNodes int) {
 		if AppMetrics == nil {
@@ -173,15 +582,325 @@ func RecordSyncStart() func(error, int, int) {
 
 		duration := time.Since(start).Seconds()
 
-		AppMetrics.SyncTotal.Inc()
-		AppMetrics.SyncDuration.Observe(duration)
-		AppMetrics.DNSRecordsTotal.Set(float64(dnsRecords))
-		AppMetrics.TraefikNodes.Set(float64(traefikNodes))
+		AppMetrics.SyncTotal.WithLabelValues(controller).Inc()
+		observe(ctx, AppMetrics.SyncDuration.WithLabelValues(controller), duration)
+		AppMetrics.DNSRecordsTotal.WithLabelValues(controller).Set(float64(dnsRecords))
+		AppMetrics.TraefikNodes.WithLabelValues(controller).Set(float64(traefikNodes))
 
+		// Always resolve the error counter so that it is exported with a zero value
+		syncErrors := AppMetrics.SyncErrors.WithLabelValues(controller)
 		if err != nil {
-			AppMetrics.SyncErrors.Inc()
+			syncErrors.Inc()
+		} else {
+			AppMetrics.LastSyncTime.WithLabelValues(controller).Set(float64(time.Now().Unix()))
+		}
+	}
+}
+
+// SetCloudflareRateLimitRemaining records the remaining Cloudflare API quota seen by the named controller
+func SetCloudflareRateLimitRemaining(controller string, remaining float64) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.CloudflareRateLimitRemaining.WithLabelValues(controller).Set(remaining)
+}
+
+// RecordPropagationCheck records the result (match, mismatch or error) of a propagation check of the named controller
+func RecordPropagationCheck(controller, result string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.PropagationChecks.WithLabelValues(controller, result).Inc()
+}
+
+// RecordSyncSkipped records that the named controller skipped a sync for the given reason
+func RecordSyncSkipped(controller, reason string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.SyncsSkipped.WithLabelValues(controller, reason).Inc()
+}
+
+// SetCloudflareCircuitState records the state of the Cloudflare circuit breaker of the named controller
+func SetCloudflareCircuitState(controller string, state float64) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.CloudflareCircuitState.WithLabelValues(controller).Set(state)
+}
+
+// RecordShadowDivergence records that a sync of the named controller had a different outcome in the shadow zone
+func RecordShadowDivergence(controller string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.ShadowDivergences.WithLabelValues(controller).Inc()
+}
+
+// RecordDeletionsSkipped records that the named controller skipped the deletion of count records in add-only mode
+func RecordDeletionsSkipped(controller string, count int) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.DeletionsSkipped.WithLabelValues(controller).Add(float64(count))
+}
+
+// SetEventStreamConnected records whether the Nomad event stream of the named controller is connected and alive
+func SetEventStreamConnected(controller string, connected bool) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	value := 0.0
+	if connected {
+		value = 1
+	}
+	AppMetrics.EventStreamConnected.WithLabelValues(controller).Set(value)
+}
+
+// RecordEventStreamReconnect counts a reconnection of the Nomad event stream of the named controller
+func RecordEventStreamReconnect(controller string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.EventStreamReconnects.WithLabelValues(controller).Inc()
+}
+
+// SetLastEvent records when the named controller last received a Nomad event.
+// A long gap while the cluster is busy means that the event stream is dead, even if it is connected.
+func SetLastEvent(controller string, at time.Time) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.SecondsSinceLastEvent.set(controller, at)
+}
+
+// SetRecordSetHash records the hash of the records of the name managed by the named controller, replacing the previous one
+func SetRecordSetHash(controller, name, hash string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.RecordSetHash.DeletePartialMatch(prometheus.Labels{"controller": controller, "name": name})
+	AppMetrics.RecordSetHash.WithLabelValues(controller, name, hash).Set(1)
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if state[controller] == nil {
+		state[controller] = newControllerState()
+	}
+	state[controller].RecordSetHashes[name] = hash
+}
+
+// SetManagedIPs records the IPs in the records of the name managed by the named controller, replacing the previous ones.
+// IPs which were already in the records keep the time they were first seen.
+func SetManagedIPs(controller, name string, ips []string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	now := time.Now().UTC()
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if state[controller] == nil {
+		state[controller] = newControllerState()
+	}
+	previous := state[controller].ManagedIPs[name]
+	current := make(map[string]time.Time, len(ips))
+	for _, ip := range ips {
+		if since, ok := previous[ip]; ok {
+			current[ip] = since
 		} else {
-			AppMetrics.LastSyncTime.Set(float64(time.Now().Unix()))
+			current[ip] = now
 		}
 	}
+	state[controller].ManagedIPs[name] = current
+}
+
+// ForgetName removes the state and the series of a name the named controller no longer manages,
+// so that neither /state nor the metrics keep reporting its last sync.
+func ForgetName(controller, name string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.RecordSetHash.DeletePartialMatch(prometheus.Labels{"controller": controller, "name": name})
+	AppMetrics.NameSyncs.DeletePartialMatch(prometheus.Labels{"controller": controller, "name": name})
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if current := state[controller]; current != nil {
+		delete(current.RecordSetHashes, name)
+		delete(current.ManagedIPs, name)
+	}
+}
+
+// managedRecords returns the records the IP is in, sorted by controller and record name
+func managedRecords(addr netip.Addr) []managedIP {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	var records []managedIP
+	for controller, current := range state {
+		for name, ips := range current.ManagedIPs {
+			for ip, since := range ips {
+				if parsed, err := netip.ParseAddr(ip); err == nil && parsed.Unmap() == addr {
+					records = append(records, managedIP{Controller: controller, Name: name, Since: since})
+				}
+			}
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Controller != records[j].Controller {
+			return records[i].Controller < records[j].Controller
+		}
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// RecordNameSync records the result of the sync of one of the names managed by the named controller
+func RecordNameSync(controller, name string, err error) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	AppMetrics.NameSyncs.WithLabelValues(controller, name, result).Inc()
+}
+
+// RecordCloudflareAPIError counts a failed Cloudflare API call of the named controller.
+// The code is the Cloudflare error code of the response, empty if there was none.
+func RecordCloudflareAPIError(controller, operation, category, code string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.CloudflareAPIErrors.WithLabelValues(controller, operation, category, code).Inc()
+}
+
+// RecordCloudflareAPICall records the duration of a Cloudflare API call of the named controller, from when it is called.
+// The returned function must be called once the call returned, whatever its outcome.
+func RecordCloudflareAPICall(ctx context.Context, controller, operation string) func() {
+	start := time.Now()
+	return func() {
+		if AppMetrics == nil {
+			return // Metrics not initialized
+		}
+
+		observe(ctx, AppMetrics.CloudflareAPIDuration.WithLabelValues(controller, operation), time.Since(start).Seconds())
+	}
+}
+
+// RecordNodeIPConflict counts a node read with different IPs within a single fetch of the named controller
+func RecordNodeIPConflict(controller string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.NodeIPConflicts.WithLabelValues(controller).Inc()
+}
+
+// RecordNodeHealthCheck records the result (pass or fail) of the HTTP health check of a node by the named controller
+func RecordNodeHealthCheck(controller, result string) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	AppMetrics.NodeHealthChecks.WithLabelValues(controller, result).Inc()
+}
+
+// Node is a Traefik node, as labelled in the node info metric
+type Node struct {
+	ID     string
+	Name   string
+	IP     string
+	Status string
+}
+
+// SetNodeInfo records the Traefik nodes eligible for DNS found by a sync of the named controller, replacing the previous ones.
+// The series of the nodes which are no longer found, or whose labels changed, are deleted.
+func SetNodeInfo(controller string, nodes []Node) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	nodeInfoMu.Lock()
+	defer nodeInfoMu.Unlock()
+
+	current := make(map[Node]bool, len(nodes))
+	for _, node := range nodes {
+		current[node] = true
+		AppMetrics.NodeInfo.WithLabelValues(controller, node.ID, node.Name, node.IP, node.Status).Set(1)
+	}
+	for node := range nodeInfoSeries[controller] {
+		if !current[node] {
+			AppMetrics.NodeInfo.DeleteLabelValues(controller, node.ID, node.Name, node.IP, node.Status)
+		}
+	}
+	nodeInfoSeries[controller] = current
+}
+
+// SetHealthyNodes records the number of healthy Traefik nodes found by a sync of the named controller, by datacenter.
+// The datacenters of the previous syncs which are missing are set to zero, so that a regional outage shows as a drop.
+func SetHealthyNodes(controller string, byDatacenter map[string]int) {
+	if AppMetrics == nil {
+		return // Metrics not initialized
+	}
+
+	healthyNodesMu.Lock()
+	defer healthyNodesMu.Unlock()
+
+	datacenters := healthyNodesDatacenters[controller]
+	if datacenters == nil {
+		datacenters = make(map[string]bool)
+		healthyNodesDatacenters[controller] = datacenters
+	}
+	for datacenter := range byDatacenter {
+		datacenters[datacenter] = true
+	}
+	for datacenter := range datacenters {
+		AppMetrics.HealthyNodes.WithLabelValues(controller, datacenter).Set(float64(byDatacenter[datacenter]))
+	}
+}
+
+// RecordNomadAPICall records the start of a Nomad API call of the named controller.
+// The returned function records its duration and result once it returns. The context carries the span of the call, if it is traced.
+func RecordNomadAPICall(ctx context.Context, controller, operation string) func(error) {
+	start := time.Now()
+	return func(err error) {
+		if AppMetrics == nil {
+			return // Metrics not initialized
+		}
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		observe(ctx, AppMetrics.NomadAPIDuration.WithLabelValues(controller, operation), time.Since(start).Seconds())
+		AppMetrics.NomadAPIRequests.WithLabelValues(controller, operation, result).Inc()
+	}
+}
+
+// observe records the value in the histogram. If the context carries a sampled span,
+// the ID of its trace is attached as an exemplar, so that dashboards can jump from a slow observation to its trace.
+func observe(ctx context.Context, histogram prometheus.Observer, value float64) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if exemplars, ok := histogram.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplars.ObserveWithExemplar(value, prometheus.Labels{"trace_id": spanContext.TraceID().String()})
+		return
+	}
+	histogram.Observe(value)
 }
@@ -3,12 +3,18 @@ package metrics
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestHealthEndpoint(t *testing.T) {
@@ -101,9 +107,326 @@ func TestReadyEndpointReady(t *testing.T) {
 	}
 }
 
+func TestStateEndpoint(t *testing.T) {
+	server := NewServer(8089)
+	SetRecordSetHash("state-test", "test.example.com", "aaaaaaaaaaaaaaaa")
+	SetRecordSetHash("state-test", "test.example.com", "bbbbbbbbbbbbbbbb")
+
+	req, err := http.NewRequest("GET", "/state", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var response map[string]controllerState
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+	if hash := response["state-test"].RecordSetHashes["test.example.com"]; hash != "bbbbbbbbbbbbbbbb" {
+		t.Errorf("record set hash = %q, want the latest one", hash)
+	}
+
+	// Only the latest hash of a name is exported
+	if AppMetrics.RecordSetHash.DeleteLabelValues("state-test", "test.example.com", "aaaaaaaaaaaaaaaa") {
+		t.Error("the previous record set hash is still exported")
+	}
+}
+
+func TestManagedIPEndpoint(t *testing.T) {
+	server := NewServer(8092)
+	SetManagedIPs("ip-test", "test.example.com", []string{"203.0.113.5", "203.0.113.6"})
+	since := state["ip-test"].ManagedIPs["test.example.com"]["203.0.113.5"]
+	SetManagedIPs("ip-test", "test.example.com", []string{"203.0.113.5"})
+
+	tests := []struct {
+		path         string
+		expectedCode int
+	}{
+		{"/state/ip/203.0.113.5", http.StatusOK},
+		{"/state/ip/::ffff:203.0.113.5", http.StatusOK},
+		{"/state/ip/203.0.113.6", http.StatusNotFound}, // removed by the latest sync
+		{"/state/ip/not-an-ip", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				IP      string      `json:"ip"`
+				Records []managedIP `json:"records"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+			expected := []managedIP{{Controller: "ip-test", Name: "test.example.com", Since: since}}
+			if response.IP != "203.0.113.5" || !reflect.DeepEqual(response.Records, expected) {
+				t.Errorf("response = %+v, want IP 203.0.113.5 in records %+v", response, expected)
+			}
+		})
+	}
+}
+
+func TestForgetName(t *testing.T) {
+	NewServer(0)
+	SetRecordSetHash("forget-test", "a.example.com", "aaaaaaaaaaaaaaaa")
+	SetRecordSetHash("forget-test", "b.example.com", "bbbbbbbbbbbbbbbb")
+	SetManagedIPs("forget-test", "a.example.com", []string{"203.0.113.7"})
+	SetManagedIPs("forget-test", "b.example.com", []string{"203.0.113.7", "203.0.113.8"})
+
+	ForgetName("forget-test", "b.example.com")
+
+	if _, ok := state["forget-test"].RecordSetHashes["b.example.com"]; ok {
+		t.Error("ForgetName() kept the record set hash of the name in the state")
+	}
+	if _, ok := state["forget-test"].ManagedIPs["b.example.com"]; ok {
+		t.Error("ForgetName() kept the IPs of the name in the state")
+	}
+	if AppMetrics.RecordSetHash.DeleteLabelValues("forget-test", "b.example.com", "bbbbbbbbbbbbbbbb") {
+		t.Error("ForgetName() kept the record set hash series of the name")
+	}
+
+	// The IPs are only reported in the records still managed
+	expected := []managedIP{{Controller: "forget-test", Name: "a.example.com", Since: state["forget-test"].ManagedIPs["a.example.com"]["203.0.113.7"]}}
+	if records := managedRecords(netip.MustParseAddr("203.0.113.7")); !reflect.DeepEqual(records, expected) {
+		t.Errorf("managedRecords() = %+v, want %+v", records, expected)
+	}
+	if records := managedRecords(netip.MustParseAddr("203.0.113.8")); len(records) != 0 {
+		t.Errorf("managedRecords() = %+v, want none for the IP of the forgotten name", records)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	server := NewServer(8093, WithVersion(map[string]string{"version": "1.2.3", "commit": "0123abc"}))
+
+	req, err := http.NewRequest("GET", "/version", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `"version":"1.2.3"`) || !strings.Contains(body, `"commit":"0123abc"`) {
+		t.Errorf("handler returned body %q, want the build metadata", body)
+	}
+
+	// Without build metadata, there is no endpoint
+	rr = httptest.NewRecorder()
+	NewServer(8094).server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code without build metadata: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestDebugBundle(t *testing.T) {
+	bundle := func() interface{} { return map[string]string{"version": "test"} }
+	server := NewServer(8090, WithDebugBundle("s3cret", bundle))
+
+	tests := []struct {
+		name          string
+		authorization string
+		expectedCode  int
+	}{
+		{name: "no token", expectedCode: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", expectedCode: http.StatusUnauthorized},
+		{name: "token", authorization: "Bearer s3cret", expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/debug/bundle", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rr := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode == http.StatusOK && !strings.Contains(rr.Body.String(), `"version":"test"`) {
+				t.Errorf("handler returned body %q, want the bundle", rr.Body.String())
+			}
+		})
+	}
+
+	// Without a bundle, there is no endpoint
+	req, err := http.NewRequest("GET", "/debug/bundle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	NewServer(8091).server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code without a bundle: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestSyncEndpoint(t *testing.T) {
+	var requested []string // record and node of every requested sync
+	fullSyncs := 0
+	sync := func(_ context.Context, record, node string) (interface{}, error) {
+		requested = append(requested, record+"/"+node)
+		switch {
+		case record == "" && node == "":
+			// The first full sync is queued, the next ones coalesce with it
+			if fullSyncs++; fullSyncs > 1 {
+				return nil, ErrSyncAlreadyQueued
+			}
+			return nil, nil
+		case node == "busy":
+			return nil, ErrSyncAlreadyQueued
+		case record == "unknown.example.com":
+			return nil, fmt.Errorf("%w: no controller manages the record %s", ErrUnknownSyncScope, record)
+		case node == "failing":
+			return nil, errors.New("cloudflare unavailable")
+		}
+		return []map[string]interface{}{{"name": "test.example.com", "created": []string{"1.1.1.1"}}}, nil
+	}
+	server := NewServer(8092, WithSync("s3cret", sync))
+
+	tests := []struct {
+		name          string
+		target        string
+		authorization string
+		expectedCode  int
+		expectedSync  string // record/node of the requested sync, empty if none
+		expectedBody  string // part of the response, if set
+	}{
+		{name: "no token", target: "/sync", expectedCode: http.StatusUnauthorized},
+		{name: "full sync", target: "/sync", authorization: "Bearer s3cret", expectedCode: http.StatusAccepted, expectedSync: "/", expectedBody: "sync requested"},
+		{name: "full sync already queued", target: "/sync", authorization: "Bearer s3cret", expectedCode: http.StatusAccepted, expectedSync: "/", expectedBody: "sync already queued"},
+		{name: "scoped sync already queued", target: "/sync?node=busy", authorization: "Bearer s3cret", expectedCode: http.StatusTooManyRequests, expectedSync: "/busy"},
+		{name: "record", target: "/sync?record=test.example.com", authorization: "Bearer s3cret", expectedCode: http.StatusOK, expectedSync: "test.example.com/", expectedBody: `"results":[{"created":["1.1.1.1"],"name":"test.example.com"}]`},
+		{name: "node", target: "/sync?node=node-1", authorization: "Bearer s3cret", expectedCode: http.StatusOK, expectedSync: "/node-1"},
+		{name: "unknown record", target: "/sync?record=unknown.example.com", authorization: "Bearer s3cret", expectedCode: http.StatusNotFound, expectedSync: "unknown.example.com/"},
+		{name: "failed sync", target: "/sync?node=failing", authorization: "Bearer s3cret", expectedCode: http.StatusInternalServerError, expectedSync: "/failing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested = nil
+			req, err := http.NewRequest("POST", tt.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rr := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if synced := strings.Join(requested, ","); synced != tt.expectedSync {
+				t.Errorf("requested syncs = %q, want %q", synced, tt.expectedSync)
+			}
+			if !strings.Contains(rr.Body.String(), tt.expectedBody) {
+				t.Errorf("handler returned %q, want it to contain %q", rr.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+
+	// Syncs are only requested with POST
+	req, err := http.NewRequest("GET", "/sync", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code for GET: got %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestCustomHealthPaths(t *testing.T) {
+	server := NewServer(8088, WithHealthPath("/healthz"), WithReadyPath("/readyz"))
+	server.SetReady(true)
+
+	tests := []struct {
+		method       string
+		path         string
+		expectedCode int
+		expectBody   bool
+	}{
+		{method: http.MethodGet, path: "/healthz", expectedCode: http.StatusOK, expectBody: true},
+		{method: http.MethodHead, path: "/healthz", expectedCode: http.StatusOK},
+		{method: http.MethodGet, path: "/readyz", expectedCode: http.StatusOK, expectBody: true},
+		{method: http.MethodHead, path: "/readyz", expectedCode: http.StatusOK},
+		{method: http.MethodPost, path: "/readyz", expectedCode: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, path: "/health", expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode == http.StatusOK {
+				if hasBody := rr.Body.Len() > 0; hasBody != tt.expectBody {
+					t.Errorf("handler returned body %q, want body: %v", rr.Body.String(), tt.expectBody)
+				}
+			}
+		})
+	}
+}
+
 func TestMetricsEndpoint(t *testing.T) {
 	server := NewServer(8083)
 
+	// Labelled metrics are only exported once they have a value for a controller
+	RecordSyncStart(context.Background(), "test")(nil, 1, 1)
+	SetCloudflareRateLimitRemaining("test", 100)
+	RecordPropagationCheck("test", "match")
+	RecordSyncSkipped("test", "quorum")
+	SetCloudflareCircuitState("test", 0)
+	RecordShadowDivergence("test")
+	RecordNomadAPICall(context.Background(), "test", "allocations")(nil)
+	RecordDeletionsSkipped("test", 1)
+	SetEventStreamConnected("test", true)
+	RecordEventStreamReconnect("test")
+	SetRecordSetHash("test", "test.example.com", "0123456789abcdef")
+	RecordNameSync("test", "test.example.com", nil)
+	SetLastEvent("test", time.Now())
+	RecordCloudflareAPIError("test", "create", "validation", "81057")
+	RecordCloudflareAPICall(context.Background(), "test", "list")()
+	RecordNodeIPConflict("test")
+	RecordNodeHealthCheck("test", "pass")
+	SetNodeInfo("test", []Node{{ID: "node-1", Name: "traefik-1", IP: "1.1.1.1", Status: "ready"}})
+	SetHealthyNodes("test", map[string]int{"eu-west": 1})
+
 	req, err := http.NewRequest("GET", "/metrics", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -127,6 +450,25 @@ func TestMetricsEndpoint(t *testing.T) {
 		"nomad_traefik_controller_dns_records_total",
 		"nomad_traefik_controller_traefik_nodes",
 		"nomad_traefik_controller_last_sync_timestamp",
+		"nomad_traefik_controller_cloudflare_rate_limit_remaining",
+		"nomad_traefik_controller_propagation_checks_total",
+		"nomad_traefik_controller_syncs_skipped_total",
+		"nomad_traefik_controller_cloudflare_circuit_state",
+		"nomad_traefik_controller_shadow_divergences_total",
+		"nomad_traefik_controller_nomad_api_duration_seconds",
+		"nomad_traefik_controller_nomad_api_requests_total",
+		"nomad_traefik_controller_deletions_skipped_total",
+		"nomad_traefik_controller_event_stream_connected",
+		"nomad_traefik_controller_event_stream_reconnects_total",
+		"nomad_traefik_controller_record_set_hash",
+		"nomad_traefik_controller_name_syncs_total",
+		"nomad_traefik_controller_seconds_since_last_event",
+		"nomad_traefik_controller_cloudflare_api_errors_total",
+		"nomad_traefik_controller_cloudflare_api_request_duration_seconds",
+		"nomad_traefik_controller_node_ip_conflicts_total",
+		"nomad_traefik_controller_node_info",
+		"nomad_traefik_controller_node_health_checks_total",
+		`nomad_traefik_controller_healthy_nodes{controller="test",datacenter="eu-west"} 1`,
 	}
 
 	for _, metric := range expectedMetrics {
@@ -136,6 +478,96 @@ func TestMetricsEndpoint(t *testing.T) {
 	}
 }
 
+func TestSetHealthyNodes(t *testing.T) {
+	NewServer(8097)
+
+	SetHealthyNodes("healthy-test", map[string]int{"eu-west": 2, "us-east": 3})
+	SetHealthyNodes("healthy-test", map[string]int{"eu-west": 2})
+
+	// The datacenter without any node any more drops to zero
+	for datacenter, expected := range map[string]float64{"eu-west": 2, "us-east": 0} {
+		if value := testutil.ToFloat64(AppMetrics.HealthyNodes.WithLabelValues("healthy-test", datacenter)); value != expected {
+			t.Errorf("healthy nodes of %s = %v, want %v", datacenter, value, expected)
+		}
+	}
+}
+
+func TestSetNodeInfo(t *testing.T) {
+	NewServer(8096)
+	others := testutil.CollectAndCount(AppMetrics.NodeInfo) // series of the other tests
+	first := Node{ID: "node-1", Name: "traefik-1", IP: "1.1.1.1", Status: "ready"}
+	second := Node{ID: "node-2", Name: "traefik-2", IP: "2.2.2.2", Status: "ready"}
+	SetNodeInfo("node-info", []Node{first, second})
+	SetNodeInfo("other", []Node{first})
+
+	// The second node is gone, and the first one moved to another IP
+	moved := first
+	moved.IP = "3.3.3.3"
+	SetNodeInfo("node-info", []Node{moved})
+
+	if count := testutil.CollectAndCount(AppMetrics.NodeInfo) - others; count != 2 {
+		t.Errorf("node info series = %d, want 2: the moved node, and the node of the other controller", count)
+	}
+	if value := testutil.ToFloat64(AppMetrics.NodeInfo.WithLabelValues("node-info", "node-1", "traefik-1", "3.3.3.3", "ready")); value != 1 {
+		t.Errorf("node info of the moved node = %v, want 1", value)
+	}
+
+	SetNodeInfo("node-info", nil)
+	SetNodeInfo("other", nil)
+	if count := testutil.CollectAndCount(AppMetrics.NodeInfo) - others; count != 0 {
+		t.Errorf("node info series = %d once no node is left, want 0", count)
+	}
+}
+
+func TestSecondsSinceLastEvent(t *testing.T) {
+	collector := newSinceCollector("test_seconds_since", "test")
+	collector.set("test", time.Now().Add(-time.Minute))
+
+	if seconds := testutil.ToFloat64(collector); seconds < 60 || seconds > 120 {
+		t.Errorf("seconds since the last event = %v, want about 60", seconds)
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	server := NewServer(8095)
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	RecordSyncStart(sampled, "traced")(nil, 1, 1)
+	RecordNomadAPICall(sampled, "traced", "allocations")(nil)
+	// Without tracing, the spans are not sampled and nothing is attached
+	RecordSyncStart(context.Background(), "untraced")(nil, 1, 1)
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rr := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rr, req)
+
+	var traced, untraced int
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		switch {
+		case strings.Contains(line, `controller="traced"`) && strings.Contains(line, `trace_id="4bf92f3577b34da6a3ce929d0e0e4736"`):
+			traced++
+		case strings.Contains(line, `controller="untraced"`) && strings.Contains(line, "trace_id"):
+			untraced++
+		}
+	}
+	if traced != 2 {
+		t.Errorf("%d exemplars of the traced sync and Nomad call, want 2", traced)
+	}
+	if untraced != 0 {
+		t.Errorf("%d exemplars of the untraced sync, want none", untraced)
+	}
+}
+
 func TestSetReady(t *testing.T) {
 	server := NewServer(8084)
 
@@ -157,7 +589,7 @@ func TestRecordSyncStart(t *testing.T) {
 	_ = NewServer(8085)
 
 	// Test successful sync
-	recordMetrics := RecordSyncStart()
+	recordMetrics := RecordSyncStart(context.Background(), "test")
 	recordMetrics(nil, 3, 2)
 
 	// Verify that AppMetrics is initialized and function doesn't panic
@@ -171,7 +603,7 @@ func TestRecordSyncStartWithError(t *testing.T) {
 	_ = NewServer(8086)
 
 	// Test failed sync
-	recordMetrics := RecordSyncStart()
+	recordMetrics := RecordSyncStart(context.Background(), "test")
 	recordMetrics(fmt.Errorf("test error"), 0, 0)
 
 	// Verify that AppMetrics is initialized and function doesn't panic
@@ -209,6 +641,65 @@ func TestServerStartStop(t *testing.T) {
 	}
 }
 
+func TestScrapeListener(t *testing.T) {
+	server := NewServer(8093, WithBindAddress("10.0.0.1"), WithScrapeListener("192.168.0.1", 9100))
+	if server.server.Addr != "10.0.0.1:8093" || server.scrape == nil || server.scrape.Addr != "192.168.0.1:9100" {
+		t.Fatalf("listeners = %q and %v, want 10.0.0.1:8093 and 192.168.0.1:9100", server.server.Addr, server.scrape)
+	}
+
+	get := func(handler http.Handler, path string) int {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Only the scrape listener serves the metrics, and only the metrics
+	if code := get(server.server.Handler, "/metrics"); code != http.StatusNotFound {
+		t.Errorf("/metrics on the health listener returned %v, want %v", code, http.StatusNotFound)
+	}
+	if code := get(server.server.Handler, "/health"); code != http.StatusOK {
+		t.Errorf("/health on the health listener returned %v, want %v", code, http.StatusOK)
+	}
+	if code := get(server.scrape.Handler, "/metrics"); code != http.StatusOK {
+		t.Errorf("/metrics on the scrape listener returned %v, want %v", code, http.StatusOK)
+	}
+	if code := get(server.scrape.Handler, "/health"); code != http.StatusNotFound {
+		t.Errorf("/health on the scrape listener returned %v, want %v", code, http.StatusNotFound)
+	}
+
+	// By default, a single listener serves everything
+	if single := NewServer(8094); single.scrape != nil || single.server.Addr != ":8094" {
+		t.Errorf("default listeners = %q and %v, want :8094 only", single.server.Addr, single.scrape)
+	}
+}
+
+func TestServerStartStopScrapeListener(t *testing.T) {
+	server := NewServer(0, WithBindAddress("127.0.0.1"), WithScrapeListener("127.0.0.1", 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.Start(ctx)
+	}()
+
+	// Give the servers a moment to start, then stop both
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Server returned unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Server did not stop within timeout")
+	}
+}
+
 func TestNewServerInitializesMetrics(t *testing.T) {
 	server := NewServer(8087)
 
@@ -240,6 +731,46 @@ func TestNewServerInitializesMetrics(t *testing.T) {
 		t.Error("LastSyncTime metric was not initialized")
 	}
 
+	if AppMetrics.CloudflareRateLimitRemaining == nil {
+		t.Error("CloudflareRateLimitRemaining metric was not initialized")
+	}
+
+	if AppMetrics.PropagationChecks == nil {
+		t.Error("PropagationChecks metric was not initialized")
+	}
+
+	if AppMetrics.SyncsSkipped == nil {
+		t.Error("SyncsSkipped metric was not initialized")
+	}
+
+	if AppMetrics.CloudflareCircuitState == nil {
+		t.Error("CloudflareCircuitState metric was not initialized")
+	}
+
+	if AppMetrics.ShadowDivergences == nil {
+		t.Error("ShadowDivergences metric was not initialized")
+	}
+
+	if AppMetrics.NomadAPIDuration == nil || AppMetrics.NomadAPIRequests == nil {
+		t.Error("Nomad API metrics were not initialized")
+	}
+
+	if AppMetrics.DeletionsSkipped == nil {
+		t.Error("DeletionsSkipped metric was not initialized")
+	}
+
+	if AppMetrics.EventStreamConnected == nil {
+		t.Error("EventStreamConnected metric was not initialized")
+	}
+
+	if AppMetrics.EventStreamReconnects == nil {
+		t.Error("EventStreamReconnects metric was not initialized")
+	}
+
+	if AppMetrics.RecordSetHash == nil {
+		t.Error("RecordSetHash metric was not initialized")
+	}
+
 	// Verify server is properly configured
 	if server.server == nil {
 		t.Error("HTTP server was not initialized")
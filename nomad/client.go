@@ -3,70 +3,156 @@ package nomad
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/tracing"
 	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
 	"github.com/charmbracelet/log"
 	nomadapi "github.com/hashicorp/nomad/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	// ErrorRateThreshold is the maximum number of errors per second before shutdown
-	ErrorRateThreshold = 10.0
-	// MaxRetries is the maximum number of consecutive retries before applying max delay
-	MaxRetries = 5
-	// BaseRetryDelay is the initial retry delay
+	// BaseRetryDelay is the delay before reconnecting the event stream after its first failure, doubled on every consecutive one
 	BaseRetryDelay = 1 * time.Second
-	// MaxRetryDelay is the maximum retry delay
+	// MaxRetryDelay is the maximum delay before reconnecting the event stream
 	MaxRetryDelay = 30 * time.Second
+	// QueryRetries is the number of attempts made for a Nomad query failing with a transient error
+	QueryRetries = 3
+	// QueryRetryDelay is the delay before retrying a Nomad query, multiplied by the attempt number
+	QueryRetryDelay = 500 * time.Millisecond
 )
 
-// errorRateTracker tracks the rate of errors over time
-type errorRateTracker struct {
-	errors    []time.Time
-	threshold float64 // errors per second threshold
+// errStreamStalled is returned when the event stream received nothing, not even a heartbeat, within the stall timeout
+var errStreamStalled = errors.New("event stream stalled")
+
+// nodeAPI is the subset of the Nomad API used to discover the Traefik nodes.
+// It lets tests substitute a fake cluster.
+type nodeAPI interface {
+	allocations(jobID string, q *nomadapi.QueryOptions) ([]*nomadapi.AllocationListStub, error)
+	nodeInfo(nodeID string, q *nomadapi.QueryOptions) (*nomadapi.Node, error)
+	listNodes(q *nomadapi.QueryOptions) ([]*nomadapi.NodeListStub, error)
 }
 
-// newErrorRateTracker creates a new error rate tracker with the given threshold
-func newErrorRateTracker(threshold float64) *errorRateTracker {
-	return &errorRateTracker{
-		errors:    make([]time.Time, 0),
-		threshold: threshold,
-	}
+// apiClient implements nodeAPI with the Nomad API client
+type apiClient struct {
+	client *nomadapi.Client
+}
+
+func (a apiClient) allocations(jobID string, q *nomadapi.QueryOptions) ([]*nomadapi.AllocationListStub, error) {
+	allocations, _, err := a.client.Jobs().Allocations(jobID, true, q)
+	return allocations, err
+}
+
+func (a apiClient) nodeInfo(nodeID string, q *nomadapi.QueryOptions) (*nomadapi.Node, error) {
+	node, _, err := a.client.Nodes().Info(nodeID, q)
+	return node, err
+}
+
+func (a apiClient) listNodes(q *nomadapi.QueryOptions) ([]*nomadapi.NodeListStub, error) {
+	nodes, _, err := a.client.Nodes().List(q)
+	return nodes, err
+}
+
+// variableAPI is the subset of the Nomad API used to publish the state of the controller
+type variableAPI interface {
+	updateVariable(v *nomadapi.Variable, q *nomadapi.WriteOptions) error
 }
 
-// addError records a new error occurrence
-func (ert *errorRateTracker) addError() {
-	now := time.Now()
-	ert.errors = append(ert.errors, now)
+func (a apiClient) updateVariable(v *nomadapi.Variable, q *nomadapi.WriteOptions) error {
+	_, _, err := a.client.Variables().Update(v, q)
+	return err
+}
 
-	// Clean up errors older than 1 second to maintain a rolling window
-	cutoff := now.Add(-time.Second)
-	var validErrors []time.Time
-	for _, errorTime := range ert.errors {
-		if errorTime.After(cutoff) {
-			validErrors = append(validErrors, errorTime)
+// eventAPI is the subset of the Nomad API used to watch the cluster
+type eventAPI interface {
+	eventStream(ctx context.Context, topics map[nomadapi.Topic][]string, index uint64, q *nomadapi.QueryOptions) (<-chan *nomadapi.Events, error)
+}
+
+// eventStream streams the events of the topics from the index, until the context is done or the stream fails.
+// Unlike the event stream of the Nomad API client, it also delivers the heartbeat frames, which show that the stream is alive.
+func (a apiClient) eventStream(ctx context.Context, topics map[nomadapi.Topic][]string, index uint64, q *nomadapi.QueryOptions) (<-chan *nomadapi.Events, error) {
+	params := url.Values{"index": {strconv.FormatUint(index, 10)}}
+	for topic, keys := range topics {
+		if len(keys) == 0 {
+			params.Add("topic", string(topic))
+		}
+		for _, key := range keys {
+			params.Add("topic", fmt.Sprintf("%s:%s", topic, key))
 		}
 	}
-	ert.errors = validErrors
+
+	body, err := a.client.Raw().Response("/v1/event/stream?"+params.Encode(), q.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make(chan *nomadapi.Events, 10)
+	go func() {
+		defer body.Close()
+		defer close(frames)
+
+		// Frames are newline-delimited JSON
+		decoder := json.NewDecoder(body)
+		for ctx.Err() == nil {
+			var events nomadapi.Events
+			if err := decoder.Decode(&events); err != nil {
+				events = nomadapi.Events{Err: err}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case frames <- &events:
+			}
+			if events.Err != nil {
+				return
+			}
+		}
+	}()
+
+	return frames, nil
 }
 
-// exceedsThreshold checks if the current error rate exceeds the threshold
-func (ert *errorRateTracker) exceedsThreshold() bool {
-	return float64(len(ert.errors)) > ert.threshold
+// agentAPI is the subset of the Nomad API used to check that Nomad is available
+type agentAPI interface {
+	self() error
 }
 
-// reset clears all recorded errors
-func (ert *errorRateTracker) reset() {
-	ert.errors = ert.errors[:0]
+func (a apiClient) self() error {
+	_, err := a.client.Agent().Self()
+	return err
 }
 
 // This Client type wraps the Nomad API
 type Client struct {
-	client *nomadapi.Client
-	config *config.Config
+	client     *nomadapi.Client
+	nodes      nodeAPI
+	variables  variableAPI
+	events     eventAPI
+	agent      agentAPI
+	config     *config.Config
+	retryDelay time.Duration
+
+	streamRetryDelay time.Duration // delay before reconnecting the event stream after its first failure
+
+	streamConnected atomic.Bool // whether the event stream is connected and alive
 }
 
 // NewClient takes a Config and returns a  client and error
@@ -74,6 +160,7 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	nomadConfig := nomadapi.DefaultConfig()
 	nomadConfig.Address = cfg.NomadAddress
 	nomadConfig.SecretID = cfg.NomadToken
+	nomadConfig.Namespace = cfg.NomadNamespace
 
 	client, err := nomadapi.NewClient(nomadConfig)
 	if err != nil {
@@ -81,66 +168,424 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	}
 
 	return &Client{
-		client: client,
-		config: cfg,
+		client:     client,
+		nodes:      apiClient{client: client},
+		variables:  apiClient{client: client},
+		events:     apiClient{client: client},
+		agent:      apiClient{client: client},
+		config:     cfg,
+		retryDelay: QueryRetryDelay,
+
+		streamRetryDelay: BaseRetryDelay,
 	}, nil
 }
 
-// GetTraefikNodes is a function of type NomadClient
-// which takes a context as argument
-// and returns a list of Nodes on which Traefik is deployed, as an error
-func (c *Client) GetTraefikNodes() ([]internaltypes.NodeInfo, error) {
-	allocations, _, err := c.client.Jobs().Allocations(c.config.TraefikJobName, true, nil)
+// retry calls query until it succeeds, fails with an error which is not transient, or QueryRetries attempts were made.
+// The returned error is classified, so that callers can tell transient failures apart.
+// Retries stop when the context is done, and are bounded by its retry budget: once spent, queries fail fast.
+// The delay between attempts is jittered, so that the lookups of several nodes failing together do not retry in lockstep.
+func (c *Client) retry(ctx context.Context, operation string, query func() error) error {
+	if retrybudget.Exhausted(ctx) {
+		return fmt.Errorf("%w: %s: %w", ErrTransient, operation, retrybudget.ErrExhausted)
+	}
+
+	var err error
+	for attempt := 1; attempt <= QueryRetries; attempt++ {
+		recordCall := metrics.RecordNomadAPICall(ctx, c.config.Name, operation)
+		err = classify(query())
+		recordCall(err)
+		if err == nil || !errors.Is(err, ErrTransient) || attempt == QueryRetries {
+			return err
+		}
+
+		if !retrybudget.Spend(ctx) {
+			return fmt.Errorf("%w: %w", retrybudget.ErrExhausted, err)
+		}
+
+		delay := retrybudget.Jitter(time.Duration(attempt) * c.retryDelay)
+		log.FromContext(ctx).Warn("Nomad query failed with a transient error, retrying", "operation", operation, "error", err, "attempt", attempt, "retry_delay", delay)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
 
+// Ping checks with a single call that the Nomad agent is reachable and accepts the token of the controller
+func (c *Client) Ping() error {
+	recordCall := metrics.RecordNomadAPICall(context.Background(), c.config.Name, "agent_self")
+	err := classify(c.agent.self())
+	recordCall(err)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get allocations for job %s: %w", c.config.TraefikJobName, err)
+		return fmt.Errorf("Nomad agent unavailable: %w", err)
 	}
+	return nil
+}
 
-	var nodes []internaltypes.NodeInfo
-	nodeMap := make(map[string]internaltypes.NodeInfo) // avoid duplicate node names?
+// GetTraefikNodes is a function of type NomadClient
+// which takes a context as argument
+// and returns a list of Nodes on which Traefik is deployed, as an error.
+// If node IDs are given, only those nodes are looked up, e.g. to re-evaluate a single node.
+func (c *Client) GetTraefikNodes(ctx context.Context, onlyNodeIDs ...string) (_ []internaltypes.NodeInfo, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "GetTraefikNodes", trace.WithAttributes(attribute.StringSlice("nomad.jobs", c.config.TraefikJobNames)))
+	defer func() { tracing.End(span, err) }()
+
+	// The allocations of every job feed the same pool
+	var allocations []*nomadapi.AllocationListStub
+	queryOptions := (&nomadapi.QueryOptions{}).WithContext(ctx)
+	for _, job := range c.config.TraefikJobNames {
+		var jobAllocations []*nomadapi.AllocationListStub
+		err = c.retry(ctx, "allocations", func() (err error) {
+			jobAllocations, err = c.nodes.allocations(job, queryOptions)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get allocations for job %s: %w", job, err)
+		}
+		allocations = append(allocations, jobAllocations...)
+	}
 
-	// loop over allocations to get nodes
+	// Only consider running allocations, and look up the nodes running several of them once,
+	// e.g. when a node runs the allocations of several jobs
+	var nodeIDs []string
+	seen := make(map[string]bool)
 	for _, alloc := range allocations {
-		// only consider running allocations
-		if alloc.ClientStatus != "running" {
+		if !c.isRunning(alloc) || seen[alloc.NodeID] {
 			continue
 		}
+		if len(onlyNodeIDs) > 0 && !slices.Contains(onlyNodeIDs, alloc.NodeID) {
+			continue
+		}
+		seen[alloc.NodeID] = true
+		nodeIDs = append(nodeIDs, alloc.NodeID)
+	}
 
-		// get node information
-		node, _, err := c.client.Nodes().Info(alloc.NodeID, nil)
-		if err != nil {
-			log.Warn("Failed to get node info", "node_id", alloc.NodeID, "error", err)
+	// On large clusters, listing every node is cheaper than looking up the nodes one by one
+	var found []*nomadapi.Node
+	if c.config.NodeListThreshold > 0 && len(nodeIDs) >= c.config.NodeListThreshold {
+		found, err = c.listedNodes(ctx, nodeIDs)
+	} else {
+		found, err = c.nodeInfos(ctx, nodeIDs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := c.traefikNodes(ctx, found)
+	span.SetAttributes(attribute.Int("traefik.nodes", len(nodes)))
+
+	return nodes, nil
+}
+
+// traefikNodes returns the candidate nodes among those found, once each, in the order they were found.
+// A node read more than once, e.g. when it was updated between the node list and a lookup, is resolved deterministically:
+// the most recent read, by Nomad modify index, is kept, and the first one on a tie.
+// A node read with different IPs is logged and counted, as its IP changed during the fetch.
+func (c *Client) traefikNodes(ctx context.Context, found []*nomadapi.Node) []internaltypes.NodeInfo {
+	var nodes []internaltypes.NodeInfo
+	position := make(map[string]int)       // of each node in nodes
+	modifyIndex := make(map[string]uint64) // of the read kept for each node
+
+	for _, node := range found {
+		if ok, reason := c.isCandidate(node); !ok {
+			log.FromContext(ctx).Debug("Excluding node", "node_id", node.ID, "name", node.Name, "reason", reason)
 			continue
 		}
 
-		// now we can create a nodeinfo object
 		nodeInfo := internaltypes.NodeInfo{
 			ID:              node.ID,
 			Name:            node.Name,
-			PublicIPAddress: node.Attributes["unique.network.ip-address"],
+			PublicIPAddress: c.nodeIP(node),
 			Status:          node.Status,
+			Datacenter:      node.Datacenter,
+			Entrypoints:     entrypoints(node.Meta[c.config.EntrypointMetaKey]),
+			Primary:         len(c.config.PrimaryNodeMeta) > 0 && unmatchedMeta(node.Meta, c.config.PrimaryNodeMeta) == "",
+		}
+
+		i, seen := position[node.ID]
+		if !seen {
+			position[node.ID] = len(nodes)
+			modifyIndex[node.ID] = node.ModifyIndex
+			nodes = append(nodes, nodeInfo)
+			continue
+		}
+
+		kept := nodes[i]
+		if node.ModifyIndex > modifyIndex[node.ID] {
+			kept = nodeInfo
+			modifyIndex[node.ID] = node.ModifyIndex
+		}
+		if nodes[i].PublicIPAddress != nodeInfo.PublicIPAddress {
+			log.FromContext(ctx).Warn("Node IP changed during the fetch, keeping the most recent read",
+				"node_id", node.ID, "name", node.Name, "ips", []string{nodes[i].PublicIPAddress, nodeInfo.PublicIPAddress}, "kept", kept.PublicIPAddress)
+			metrics.RecordNodeIPConflict(c.config.Name)
+		}
+		nodes[i] = kept
+	}
+
+	return nodes
+}
+
+// nodeInfos looks up the nodes, NODE_INFO_CONCURRENCY at a time, and returns those which were found.
+// Nodes failing with an error which is neither transient nor due to the context are skipped.
+// Otherwise, the lookups are given up rather than returning a partial set of nodes, which would remove healthy nodes from DNS.
+func (c *Client) nodeInfos(ctx context.Context, nodeIDs []string) ([]*nomadapi.Node, error) {
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queryOptions := (&nomadapi.QueryOptions{}).WithContext(lookupCtx)
+
+	nodes := make([]*nomadapi.Node, len(nodeIDs)) // in the order of the IDs
+	var (
+		failOnce sync.Once
+		failure  error // the first error which gave up the lookups
+	)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(c.config.NodeInfoConcurrency, 1), len(nodeIDs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if lookupCtx.Err() != nil {
+					continue // given up
+				}
+				var node *nomadapi.Node
+				err := c.retry(lookupCtx, "node_info", func() (err error) {
+					node, err = c.nodes.nodeInfo(nodeIDs[i], queryOptions)
+					return err
+				})
+				switch {
+				case err == nil:
+					nodes[i] = node
+				case errors.Is(err, ErrTransient) || lookupCtx.Err() != nil:
+					failOnce.Do(func() {
+						failure = fmt.Errorf("Failed to get info of node %s: %w", nodeIDs[i], err)
+						cancel()
+					})
+				default:
+					log.FromContext(ctx).Warn("Failed to get node info", "node_id", nodeIDs[i], "error", err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range nodeIDs {
+		select {
+		case indexes <- i:
+		case <-lookupCtx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+	// The sync may have been aborted between two lookups
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to get node info: %w", err)
+	}
+
+	var found []*nomadapi.Node
+	for _, node := range nodes {
+		if node != nil {
+			found = append(found, node)
 		}
-		nodeMap[node.ID] = nodeInfo
-	} // loop over allocations
+	}
+	return found, nil
+}
+
+// listedNodes lists the nodes of the cluster with a single call and returns those with the given IDs.
+// Nodes missing from the list, or whose address is not an IP address, are looked up one by one.
+func (c *Client) listedNodes(ctx context.Context, nodeIDs []string) ([]*nomadapi.Node, error) {
+	var stubs []*nomadapi.NodeListStub
+	queryOptions := (&nomadapi.QueryOptions{}).WithContext(ctx)
+	err := c.retry(ctx, "node_list", func() (err error) {
+		stubs, err = c.nodes.listNodes(queryOptions)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list nodes: %w", err)
+	}
 
-	// convert the map to a slice. Why didn't we just have a slice to start with???
-	for _, node := range nodeMap {
-		nodes = append(nodes, node)
+	listed := make(map[string]*nomadapi.NodeListStub, len(stubs))
+	for _, stub := range stubs {
+		listed[stub.ID] = stub
+	}
+
+	var nodes []*nomadapi.Node
+	var missing []string
+	for _, id := range nodeIDs {
+		if node, ok := nodeFromStub(listed[id]); ok {
+			nodes = append(nodes, node)
+		} else {
+			missing = append(missing, id)
+		}
 	}
 
+	if len(missing) > 0 {
+		log.FromContext(ctx).Debug("Looking up the nodes missing from the node list", "count", len(missing))
+		found, err := c.nodeInfos(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, found...)
+	}
 	return nodes, nil
 }
 
+// nodeFromStub returns the node as listed by Nomad. The list does not include the node attributes,
+// so the IP address of the node is taken from its advertised HTTP address, and it has no node meta.
+// It reports false if the node is not listed or its address does not hold an IP address.
+func nodeFromStub(stub *nomadapi.NodeListStub) (*nomadapi.Node, bool) {
+	if stub == nil {
+		return nil, false
+	}
+
+	host, _, err := net.SplitHostPort(stub.Address)
+	if err != nil {
+		host = stub.Address // no port
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || addr.IsUnspecified() {
+		return nil, false
+	}
+
+	return &nomadapi.Node{
+		ID:                    stub.ID,
+		Name:                  stub.Name,
+		Datacenter:            stub.Datacenter,
+		NodeClass:             stub.NodeClass,
+		HTTPAddr:              stub.Address,
+		Status:                stub.Status,
+		Drain:                 stub.Drain,
+		SchedulingEligibility: stub.SchedulingEligibility,
+		Attributes:            map[string]string{config.DefaultNodeIPAttribute: host},
+		ModifyIndex:           stub.ModifyIndex,
+	}, true
+}
+
+// WriteSyncResult writes the result of a sync, as JSON, to the Nomad variable at path, so that other jobs can read the current DNS state.
+func (c *Client) WriteSyncResult(ctx context.Context, path string, result internaltypes.SyncResult) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "WriteSyncResult", trace.WithAttributes(attribute.String("nomad.variable", path)))
+	defer func() { tracing.End(span, err) }()
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("Failed to encode sync result: %w", err)
+	}
+
+	variable := &nomadapi.Variable{
+		Namespace: nomadapi.DefaultNamespace,
+		Path:      path,
+		Items:     nomadapi.VariableItems{"result": string(data)},
+	}
+	recordCall := metrics.RecordNomadAPICall(ctx, c.config.Name, "variable_update")
+	err = classify(c.variables.updateVariable(variable, (&nomadapi.WriteOptions{Namespace: nomadapi.DefaultNamespace}).WithContext(ctx)))
+	recordCall(err)
+	if err != nil {
+		return fmt.Errorf("Failed to write variable %s: %w", path, err)
+	}
+	return nil
+}
+
+// isCandidate is a function of type Nomad client
+// which takes a node as argument
+// and returns whether the node may be added to the DNS pool, and the reason if it may not.
+func (c *Client) isCandidate(node *nomadapi.Node) (bool, string) {
+	// A draining node is about to stop running Traefik, so it leaves the pool before its allocations are migrated
+	if node.Drain {
+		return false, "node is draining"
+	}
+
+	// For system jobs, Traefik will not be (re)started on nodes which are ineligible for scheduling.
+	if c.config.ExcludeIneligibleNodes && node.SchedulingEligibility == nomadapi.NodeSchedulingIneligible {
+		return false, "node is ineligible for scheduling"
+	}
+
+	if c.config.NodeClassFilter != "" && node.NodeClass != c.config.NodeClassFilter {
+		return false, fmt.Sprintf("node class is %q, want %q", node.NodeClass, c.config.NodeClassFilter)
+	}
+
+	if reason := unmatchedMeta(node.Meta, c.config.RequiredNodeMeta); reason != "" {
+		return false, reason
+	}
+
+	return true, ""
+}
+
+// nodeIP returns the IP address of the node, from NODE_IP_ATTRIBUTE, which may name node meta with a "meta." prefix.
+// A node without it falls back to the IP fingerprinted by Nomad.
+func (c *Client) nodeIP(node *nomadapi.Node) string {
+	var ip string
+	if key, ok := strings.CutPrefix(c.config.NodeIPAttribute, "meta."); ok {
+		ip = node.Meta[key]
+	} else if c.config.NodeIPAttribute != "" {
+		ip = node.Attributes[c.config.NodeIPAttribute]
+	}
+	if ip == "" {
+		ip = node.Attributes[config.DefaultNodeIPAttribute]
+	}
+	return ip
+}
+
+// unmatchedMeta returns why the node meta does not match every wanted pair, or an empty string if it does.
+// The keys are checked in order, so that the same key is reported on every sync.
+func unmatchedMeta(meta, wanted map[string]string) string {
+	for _, key := range slices.Sorted(maps.Keys(wanted)) {
+		want := wanted[key]
+		value, ok := meta[key]
+		if !ok {
+			return fmt.Sprintf("node meta %s is not set, want %q", key, want)
+		}
+		if value != want {
+			return fmt.Sprintf("node meta %s is %q, want %q", key, value, want)
+		}
+	}
+	return ""
+}
+
+// isRunning returns whether an allocation runs Traefik.
+// The allocations on a disconnected node are unknown to Nomad, which expects them to still run,
+// unless disconnected nodes are removed at once.
+func (c *Client) isRunning(alloc *nomadapi.AllocationListStub) bool {
+	switch alloc.ClientStatus {
+	case nomadapi.AllocClientStatusRunning:
+		return true
+	case nomadapi.AllocClientStatusUnknown:
+		return c.config.DisconnectedNodePolicy != config.DisconnectedRemove
+	default:
+		return false
+	}
+}
+
+// entrypoints parses the comma-separated list of Traefik entrypoints from a node meta value
+func entrypoints(meta string) []string {
+	var result []string
+	for _, entrypoint := range strings.Split(meta, ",") {
+		if entrypoint = strings.TrimSpace(entrypoint); entrypoint != "" {
+			result = append(result, entrypoint)
+		}
+	}
+	return result
+}
+
 // WatchEvents is a function of type Nomad client
 // which takes a context and channel as arguments and returns an error
-// It consumes the Nomad Events api described in internaltypes
+// It consumes the Nomad Events api described in internaltypes.
+// A stream which fails or closes, e.g. while the Nomad servers restart, is reconnected with an exponential backoff.
+// It gives up after EVENT_STREAM_MAX_FAILURES consecutive failures, a failure following a stream which delivered
+// nothing counting as consecutive.
+// It returns an error wrapping ErrEventsUnavailable if Nomad does not allow the event stream.
 func (c *Client) WatchEvents(ctx context.Context, eventChan chan<- internaltypes.Event) error {
 
 	log.Info("Starting Nomad Event consumer")
 
-	// Create error rate tracker with configured threshold
-	errorTracker := newErrorRateTracker(ErrorRateThreshold)
-
+	failures := 0 // consecutive failures, reset once a stream delivers anything
 	for {
 		select {
 		case <-ctx.Done():
@@ -148,7 +593,7 @@ func (c *Client) WatchEvents(ctx context.Context, eventChan chan<- internaltypes
 		default:
 		}
 
-		err := c.watchEventStream(ctx, eventChan, errorTracker)
+		err := c.watchEventStream(ctx, eventChan, &failures)
 		if err == nil {
 			return nil // Clean shutdown
 		}
@@ -157,30 +602,36 @@ func (c *Client) WatchEvents(ctx context.Context, eventChan chan<- internaltypes
 			return ctx.Err() // Context cancelled
 		}
 
-		// Check if error rate exceeds threshold
-		if errorTracker.exceedsThreshold() {
-			log.Error("Event stream error rate exceeds threshold, shutting down",
-				"error_count", len(errorTracker.errors),
-				"threshold", errorTracker.threshold,
-				"last_error", err)
-			return fmt.Errorf("event stream error rate exceeded threshold: %w", err)
+		// The connection worked until it went silent, so it is reconnected at once
+		if errors.Is(err, errStreamStalled) {
+			metrics.RecordEventStreamReconnect(c.config.Name)
+			continue
 		}
 
-		// Calculate retry delay with exponential backoff
-		retryCount := len(errorTracker.errors)
-		if retryCount > MaxRetries {
-			retryCount = MaxRetries
+		// Retrying will not make the event stream available
+		if errors.Is(err, ErrEventsUnavailable) {
+			return err
 		}
 
-		delay := time.Duration(retryCount) * BaseRetryDelay
+		failures++
+		if c.config.EventStreamMaxFailures > 0 && failures >= c.config.EventStreamMaxFailures {
+			log.Error("Event stream failed too many times in a row, giving up",
+				"failures", failures,
+				"max_failures", c.config.EventStreamMaxFailures,
+				"last_error", err)
+			return fmt.Errorf("event stream failed %d times in a row: %w", failures, err)
+		}
+
+		delay := c.streamRetryDelay << min(failures-1, 16)
 		if delay > MaxRetryDelay {
 			delay = MaxRetryDelay
 		}
+		delay = retrybudget.Jitter(delay)
 
-		log.Warn("Event stream failed, retrying after delay",
+		log.Warn("Event stream failed, reconnecting after delay",
 			"error", err,
 			"retry_delay", delay,
-			"error_count", len(errorTracker.errors))
+			"failures", failures)
 
 		// Wait before retrying
 		select {
@@ -188,23 +639,23 @@ func (c *Client) WatchEvents(ctx context.Context, eventChan chan<- internaltypes
 			return ctx.Err()
 		case <-time.After(delay):
 		}
+		metrics.RecordEventStreamReconnect(c.config.Name)
 	}
 }
 
-// watchEventStream handles a single event stream connection
-func (c *Client) watchEventStream(ctx context.Context, eventChan chan<- internaltypes.Event, errorTracker *errorRateTracker) error {
-	// Create query options for event streaming
-	// We set the namespace to default namespace since that is where ingress
-	// should be running.
-	// This might be tunable later.
+// watchEventStream handles a single event stream connection.
+// It resets the consecutive failures once the stream delivers anything, event or heartbeat.
+func (c *Client) watchEventStream(ctx context.Context, eventChan chan<- internaltypes.Event, failures *int) error {
+	// Create query options for event streaming.
+	// Node events are not namespaced, so the namespace only scopes the job and allocation events.
 	queryOpts := &nomadapi.QueryOptions{
-		Namespace: nomadapi.DefaultNamespace,
+		Namespace: c.config.EventStreamNamespace,
 	}
 	queryOpts = queryOpts.WithContext(ctx)
 
 	// Set up event topics we want to monitor
 	topics := map[nomadapi.Topic][]string{
-		nomadapi.TopicJob:        []string{c.config.TraefikJobName},
+		nomadapi.TopicJob:        c.config.TraefikJobNames,
 		nomadapi.TopicAllocation: []string{"AllocationUpdate"},
 		nomadapi.TopicNode:       []string{"*"},
 	}
@@ -217,37 +668,72 @@ func (c *Client) watchEventStream(ctx context.Context, eventChan chan<- internal
 	currentIndex := uint64(100000000)
 	log.Info("Starting event processing", "from_index", currentIndex)
 
-	// Start streaming events from the current index
-	eventStream, err := c.client.EventStream().Stream(ctx, topics, currentIndex, queryOpts)
+	// Start streaming events from the current index.
+	// The stream is stopped when this function returns, which matters when it stalled.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	recordCall := metrics.RecordNomadAPICall(ctx, c.config.Name, "event_stream")
+	eventStream, err := c.events.eventStream(streamCtx, topics, currentIndex, queryOpts)
+	recordCall(err)
 	if err != nil {
-		errorTracker.addError()
+		err = classify(err)
+		if errors.Is(err, ErrAuth) || errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: %w", ErrEventsUnavailable, err)
+		}
 		return fmt.Errorf("failed to start event stream: %w", err)
 	}
 
-	// Reset error tracker on successful connection
-	errorTracker.reset()
 	log.Info("Event stream connected successfully")
+	c.setStreamConnected(true)
+	defer c.setStreamConnected(false)
+
+	// Nomad sends heartbeats on an idle stream, so a stream without any frame for the stall timeout is presumed dead,
+	// even when TCP has not noticed
+	var stallTimer *time.Timer
+	var stalled <-chan time.Time
+	if c.config.EventStreamStallTimeout > 0 {
+		stallTimer = time.NewTimer(c.config.EventStreamStallTimeout)
+		defer stallTimer.Stop()
+		stalled = stallTimer.C
+	}
 
 	// Process events
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case eventWrapper := <-eventStream:
+		case <-stalled:
+			log.Warn("Event stream stalled, reconnecting", "stall_timeout", c.config.EventStreamStallTimeout)
+			return errStreamStalled
+		case eventWrapper, ok := <-eventStream:
+			if !ok {
+				log.Warn("Event stream closed by Nomad")
+				return errors.New("event stream closed")
+			}
+			if stallTimer != nil {
+				stallTimer.Reset(c.config.EventStreamStallTimeout)
+			}
 			if eventWrapper.Err != nil {
-				errorTracker.addError()
 				log.Error("Event stream error", "error", eventWrapper.Err)
 				// Exit on error.
 				return fmt.Errorf("event stream error: %w", eventWrapper.Err)
 			}
 
+			// Every frame shows that the stream is alive, but heartbeats carry nothing else
+			*failures = 0
+			c.setStreamConnected(true)
+			if eventWrapper.IsHeartbeat() {
+				continue
+			}
+
 			// Process each event in the wrapper
 			for _, event := range eventWrapper.Events {
 				if processedEvent := c.processEvent(&event); processedEvent != nil {
+					metrics.SetLastEvent(c.config.Name, time.Now())
 					select {
 					case eventChan <- *processedEvent:
 						// log the event
-						log.Debug("Received event", "type", processedEvent.Type, "timestamp", processedEvent.Timestamp, "node_id", processedEvent.NodeID, "job_id", processedEvent.JobID)
+						log.Debug("Received event", "type", processedEvent.Type, "index", processedEvent.Index, "node_id", processedEvent.NodeID, "job_id", processedEvent.JobID)
 					case <-ctx.Done():
 						return ctx.Err()
 					}
@@ -257,6 +743,17 @@ func (c *Client) watchEventStream(ctx context.Context, eventChan chan<- internal
 	}
 }
 
+// setStreamConnected records whether the event stream is connected and alive
+func (c *Client) setStreamConnected(connected bool) {
+	c.streamConnected.Store(connected)
+	metrics.SetEventStreamConnected(c.config.Name, connected)
+}
+
+// EventStreamConnected reports whether the event stream is connected and alive
+func (c *Client) EventStreamConnected() bool {
+	return c.streamConnected.Load()
+}
+
 // processEvent is a function of type nomad client which takes a nomad event as argument and returns an internal Event type
 func (c *Client) processEvent(event *nomadapi.Event) *internaltypes.Event {
 	// filter only for events we care about
@@ -265,7 +762,8 @@ func (c *Client) processEvent(event *nomadapi.Event) *internaltypes.Event {
 	case "AllocationUpdated", "NodeUpdated", "JobRegistered", "JobDeregistered":
 		processedEvent := &internaltypes.Event{
 			Type:      event.Type,
-			Timestamp: time.Unix(0, int64(event.Index)),
+			Index:     event.Index,
+			Timestamp: time.Now(),
 			Details:   map[string]interface{}{"raw": event},
 		}
 
@@ -2,12 +2,21 @@ package nomad
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/retrybudget"
 	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
 	nomadapi "github.com/hashicorp/nomad/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestNewClient(t *testing.T) {
@@ -71,7 +80,7 @@ func TestNewClient(t *testing.T) {
 func TestProcessEvent(t *testing.T) {
 	client := &Client{
 		config: &config.Config{
-			TraefikJobName: "traefik",
+			TraefikJobNames: []string{"traefik"},
 		},
 	}
 
@@ -91,10 +100,10 @@ func TestProcessEvent(t *testing.T) {
 				},
 			},
 			expectedResult: &internaltypes.Event{
-				Type:      "AllocationUpdated",
-				Timestamp: time.Unix(0, 12345),
-				NodeID:    "test-node-id",
-				JobID:     "traefik",
+				Type:   "AllocationUpdated",
+				Index:  12345,
+				NodeID: "test-node-id",
+				JobID:  "traefik",
 			},
 		},
 		{
@@ -107,9 +116,9 @@ func TestProcessEvent(t *testing.T) {
 				},
 			},
 			expectedResult: &internaltypes.Event{
-				Type:      "NodeUpdated",
-				Timestamp: time.Unix(0, 67890),
-				NodeID:    "test-node-id-2",
+				Type:   "NodeUpdated",
+				Index:  67890,
+				NodeID: "test-node-id-2",
 			},
 		},
 		{
@@ -122,9 +131,9 @@ func TestProcessEvent(t *testing.T) {
 				},
 			},
 			expectedResult: &internaltypes.Event{
-				Type:      "JobRegistered",
-				Timestamp: time.Unix(0, 11111),
-				JobID:     "new-job",
+				Type:  "JobRegistered",
+				Index: 11111,
+				JobID: "new-job",
 			},
 		},
 		{
@@ -143,8 +152,8 @@ func TestProcessEvent(t *testing.T) {
 				Payload: nil,
 			},
 			expectedResult: &internaltypes.Event{
-				Type:      "AllocationUpdated",
-				Timestamp: time.Unix(0, 54321),
+				Type:  "AllocationUpdated",
+				Index: 54321,
 			},
 		},
 		{
@@ -158,8 +167,8 @@ func TestProcessEvent(t *testing.T) {
 				},
 			},
 			expectedResult: &internaltypes.Event{
-				Type:      "AllocationUpdated",
-				Timestamp: time.Unix(0, 13579),
+				Type:  "AllocationUpdated",
+				Index: 13579,
 				// NodeID and JobID should be empty due to type assertion failures
 			},
 		},
@@ -167,6 +176,7 @@ func TestProcessEvent(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
 			result := client.processEvent(tt.event)
 
 			if tt.expectedResult == nil {
@@ -185,8 +195,12 @@ func TestProcessEvent(t *testing.T) {
 				t.Errorf("processEvent() Type = %q, want %q", result.Type, tt.expectedResult.Type)
 			}
 
-			if result.Timestamp != tt.expectedResult.Timestamp {
-				t.Errorf("processEvent() Timestamp = %v, want %v", result.Timestamp, tt.expectedResult.Timestamp)
+			if result.Index != tt.expectedResult.Index {
+				t.Errorf("processEvent() Index = %d, want %d", result.Index, tt.expectedResult.Index)
+			}
+
+			if result.Timestamp.Before(start) || result.Timestamp.After(time.Now()) {
+				t.Errorf("processEvent() Timestamp = %v, want the time the event was processed", result.Timestamp)
 			}
 
 			if result.NodeID != tt.expectedResult.NodeID {
@@ -420,3 +434,1060 @@ func TestNodeInfoCreation(t *testing.T) {
 		})
 	}
 }
+
+func TestIsCandidate(t *testing.T) {
+	tests := []struct {
+		name              string
+		excludeIneligible bool
+		eligibility       string
+		drain             bool
+		requiredMeta      map[string]string
+		meta              map[string]string
+		classFilter       string
+		class             string
+		expected          bool
+		reason            string // part of the reason for excluding the node
+	}{
+		{
+			name:              "eligible node is a candidate",
+			excludeIneligible: true,
+			eligibility:       nomadapi.NodeSchedulingEligible,
+			expected:          true,
+		},
+		{
+			name:              "ineligible node is excluded when the toggle is set",
+			excludeIneligible: true,
+			eligibility:       nomadapi.NodeSchedulingIneligible,
+			expected:          false,
+			reason:            "ineligible",
+		},
+		{
+			name:              "ineligible node is kept when the toggle is not set",
+			excludeIneligible: false,
+			eligibility:       nomadapi.NodeSchedulingIneligible,
+			expected:          true,
+		},
+		{
+			name:        "draining node is excluded",
+			eligibility: nomadapi.NodeSchedulingIneligible,
+			drain:       true,
+			expected:    false,
+			reason:      "draining",
+		},
+		{
+			name:         "node carrying every required meta is a candidate",
+			eligibility:  nomadapi.NodeSchedulingEligible,
+			requiredMeta: map[string]string{"role": "edge", "tier": "public"},
+			meta:         map[string]string{"role": "edge", "tier": "public", "rack": "r1"},
+			expected:     true,
+		},
+		{
+			name:         "node with another value of a required meta is excluded",
+			eligibility:  nomadapi.NodeSchedulingEligible,
+			requiredMeta: map[string]string{"role": "edge", "tier": "public"},
+			meta:         map[string]string{"role": "edge", "tier": "private"},
+			expected:     false,
+			reason:       "node meta tier",
+		},
+		{
+			name:         "node missing a required meta is excluded",
+			eligibility:  nomadapi.NodeSchedulingEligible,
+			requiredMeta: map[string]string{"role": "edge"},
+			expected:     false,
+			reason:       "node meta role",
+		},
+		{
+			name:        "node of the required class is a candidate",
+			eligibility: nomadapi.NodeSchedulingEligible,
+			classFilter: "edge",
+			class:       "edge",
+			expected:    true,
+		},
+		{
+			name:        "node of another class is excluded",
+			eligibility: nomadapi.NodeSchedulingEligible,
+			classFilter: "edge",
+			class:       "batch",
+			expected:    false,
+			reason:      `node class is "batch"`,
+		},
+		{
+			name:         "node of the required class missing a required meta is excluded",
+			eligibility:  nomadapi.NodeSchedulingEligible,
+			classFilter:  "edge",
+			class:        "edge",
+			requiredMeta: map[string]string{"role": "edge"},
+			meta:         map[string]string{"role": "internal"},
+			expected:     false,
+			reason:       "node meta role",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{
+				config: &config.Config{
+					ExcludeIneligibleNodes: tt.excludeIneligible,
+					RequiredNodeMeta:       tt.requiredMeta,
+					NodeClassFilter:        tt.classFilter,
+				},
+			}
+			node := &nomadapi.Node{
+				ID:                    "node-1",
+				Status:                "ready",
+				SchedulingEligibility: tt.eligibility,
+				Drain:                 tt.drain,
+				Meta:                  tt.meta,
+				NodeClass:             tt.class,
+			}
+
+			ok, reason := client.isCandidate(node)
+			if ok != tt.expected {
+				t.Errorf("isCandidate() = %v, want %v", ok, tt.expected)
+			}
+			if !ok && (reason == "" || !strings.Contains(reason, tt.reason)) {
+				t.Errorf("isCandidate() reason = %q, want it to contain %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+// fakeNodeAPI is a stand-in for the Nomad API which fails a number of times before answering.
+// It is safe for concurrent use, since nodes are looked up in parallel.
+type fakeNodeAPI struct {
+	mu               sync.Mutex
+	allocationErrors []error
+	nodeErrors       []error
+	allocs           []*nomadapi.AllocationListStub            // of every job, unless listed in jobAllocs
+	jobAllocs        map[string][]*nomadapi.AllocationListStub // by job ID
+	nodes            map[string]*nomadapi.Node
+	nodeLatency      time.Duration   // how long a node lookup takes
+	unlisted         map[string]bool // IDs of the nodes missing from the node list
+	allocationCalls  int
+	nodeCalls        int
+	listCalls        int
+	queries          []*nomadapi.QueryOptions // of every call
+}
+
+func (f *fakeNodeAPI) allocations(jobID string, q *nomadapi.QueryOptions) ([]*nomadapi.AllocationListStub, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allocationCalls++
+	f.queries = append(f.queries, q)
+	if len(f.allocationErrors) > 0 {
+		err := f.allocationErrors[0]
+		f.allocationErrors = f.allocationErrors[1:]
+		return nil, err
+	}
+	if allocs, ok := f.jobAllocs[jobID]; ok {
+		return allocs, nil
+	}
+	return f.allocs, nil
+}
+
+func (f *fakeNodeAPI) nodeInfo(nodeID string, q *nomadapi.QueryOptions) (*nomadapi.Node, error) {
+	time.Sleep(f.nodeLatency)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodeCalls++
+	f.queries = append(f.queries, q)
+	if len(f.nodeErrors) > 0 {
+		err := f.nodeErrors[0]
+		f.nodeErrors = f.nodeErrors[1:]
+		return nil, err
+	}
+	return f.nodes[nodeID], nil
+}
+
+// listNodes lists the nodes as Nomad does: without their attributes nor meta, and with their advertised HTTP address
+func (f *fakeNodeAPI) listNodes(q *nomadapi.QueryOptions) ([]*nomadapi.NodeListStub, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listCalls++
+	f.queries = append(f.queries, q)
+	var stubs []*nomadapi.NodeListStub
+	for id, node := range f.nodes {
+		if f.unlisted[id] {
+			continue
+		}
+		stubs = append(stubs, &nomadapi.NodeListStub{
+			ID:                    node.ID,
+			Name:                  node.Name,
+			Address:               node.Attributes["unique.network.ip-address"] + ":4646",
+			Datacenter:            node.Datacenter,
+			Status:                node.Status,
+			Drain:                 node.Drain,
+			SchedulingEligibility: node.SchedulingEligibility,
+		})
+	}
+	return stubs, nil
+}
+
+func newFakeNodeAPI() *fakeNodeAPI {
+	return &fakeNodeAPI{
+		allocs: []*nomadapi.AllocationListStub{
+			{ID: "alloc-1", NodeID: "node-1", ClientStatus: "running"},
+		},
+		nodes: map[string]*nomadapi.Node{
+			"node-1": {
+				ID:         "node-1",
+				Name:       "worker-1",
+				Status:     "ready",
+				Datacenter: "eu-west",
+				Attributes: map[string]string{"unique.network.ip-address": "1.1.1.1"},
+			},
+		},
+	}
+}
+
+func TestGetTraefikNodesDisconnected(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected int
+	}{
+		{policy: config.DisconnectedKeep, expected: 2},
+		{policy: config.DisconnectedGrace, expected: 2},
+		{policy: config.DisconnectedRemove, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			api := newFakeNodeAPI()
+			// The allocations on a disconnected node are unknown to Nomad
+			api.allocs = append(api.allocs, &nomadapi.AllocationListStub{ID: "alloc-2", NodeID: "node-2", ClientStatus: "unknown"})
+			api.nodes["node-2"] = &nomadapi.Node{
+				ID:         "node-2",
+				Name:       "worker-2",
+				Status:     "disconnected",
+				Attributes: map[string]string{"unique.network.ip-address": "2.2.2.2"},
+			}
+			client := &Client{
+				nodes:      api,
+				config:     &config.Config{TraefikJobNames: []string{"ingress"}, NodeInfoConcurrency: 1, DisconnectedNodePolicy: tt.policy},
+				retryDelay: time.Millisecond,
+			}
+
+			nodes, err := client.GetTraefikNodes(context.Background())
+			if err != nil {
+				t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+			}
+			if len(nodes) != tt.expected {
+				t.Errorf("GetTraefikNodes() returned %d nodes, want %d", len(nodes), tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetTraefikNodesNodeStatus(t *testing.T) {
+	api := newFakeNodeAPI()
+	api.allocs = append(api.allocs,
+		&nomadapi.AllocationListStub{ID: "alloc-2", NodeID: "node-2", ClientStatus: "running"},
+		&nomadapi.AllocationListStub{ID: "alloc-3", NodeID: "node-3", ClientStatus: "running"},
+	)
+	api.nodes["node-2"] = &nomadapi.Node{
+		ID:                    "node-2",
+		Name:                  "worker-2",
+		Status:                "ready",
+		Drain:                 true,
+		SchedulingEligibility: nomadapi.NodeSchedulingIneligible,
+		Attributes:            map[string]string{"unique.network.ip-address": "2.2.2.2"},
+	}
+	api.nodes["node-3"] = &nomadapi.Node{
+		ID:         "node-3",
+		Name:       "worker-3",
+		Status:     "down",
+		Attributes: map[string]string{"unique.network.ip-address": "3.3.3.3"},
+	}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}, NodeInfoConcurrency: 1},
+		retryDelay: time.Millisecond,
+	}
+
+	nodes, err := client.GetTraefikNodes(context.Background())
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+
+	// The draining node is left out, while the down node is returned with its status,
+	// for the controller to count it as unhealthy against READY_NODE_STATUSES
+	statuses := make(map[string]string)
+	for _, node := range nodes {
+		statuses[node.ID] = node.Status
+	}
+	expected := map[string]string{"node-1": "ready", "node-3": "down"}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("GetTraefikNodes() statuses = %v, want %v", statuses, expected)
+	}
+}
+
+func TestGetTraefikNodesSeveralJobs(t *testing.T) {
+	api := newFakeNodeAPI()
+	// node-2 runs the allocations of both jobs
+	api.jobAllocs = map[string][]*nomadapi.AllocationListStub{
+		"ingress-eu": {
+			{ID: "alloc-1", NodeID: "node-1", ClientStatus: "running"},
+			{ID: "alloc-2", NodeID: "node-2", ClientStatus: "running"},
+		},
+		"ingress-us": {
+			{ID: "alloc-3", NodeID: "node-2", ClientStatus: "running"},
+			{ID: "alloc-4", NodeID: "node-3", ClientStatus: "running"},
+		},
+	}
+	for i, id := range []string{"node-2", "node-3"} {
+		api.nodes[id] = &nomadapi.Node{
+			ID:         id,
+			Name:       fmt.Sprintf("worker-%d", i+2),
+			Status:     "ready",
+			Attributes: map[string]string{"unique.network.ip-address": fmt.Sprintf("%d.%d.%d.%d", i+2, i+2, i+2, i+2)},
+		}
+	}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress-eu", "ingress-us"}, NodeInfoConcurrency: 1},
+		retryDelay: time.Millisecond,
+	}
+
+	nodes, err := client.GetTraefikNodes(context.Background())
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+
+	var ids []string
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	if expected := []string{"node-1", "node-2", "node-3"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("GetTraefikNodes() nodes = %v, want %v", ids, expected)
+	}
+	if api.allocationCalls != 2 || api.nodeCalls != 3 {
+		t.Errorf("GetTraefikNodes() made %d allocation and %d node calls, want 2 and 3", api.allocationCalls, api.nodeCalls)
+	}
+
+	// The allocations of every job are needed, so a job failing fails the lookup
+	api.jobAllocs = nil
+	api.allocationErrors = []error{nil, statusError{code: 403}}
+	if _, err := client.GetTraefikNodes(context.Background()); err == nil || !strings.Contains(err.Error(), "job ingress-us") {
+		t.Errorf("GetTraefikNodes() error = %v, want it to name the job ingress-us", err)
+	}
+}
+
+// fakeAgentAPI is an agent whose self endpoint fails with err
+type fakeAgentAPI struct {
+	err error
+}
+
+func (f fakeAgentAPI) self() error { return f.err }
+
+func TestPing(t *testing.T) {
+	client := &Client{agent: fakeAgentAPI{}, config: &config.Config{}}
+	if err := client.Ping(); err != nil {
+		t.Errorf("Ping() unexpected error = %v", err)
+	}
+
+	// Until the ACL system is up, the token is rejected
+	client.agent = fakeAgentAPI{err: statusError{code: 403}}
+	if err := client.Ping(); !errors.Is(err, ErrAuth) {
+		t.Errorf("Ping() error = %v, want ErrAuth", err)
+	}
+}
+
+// newLargeFakeNodeAPI returns a fake cluster running Traefik on count nodes
+func newLargeFakeNodeAPI(count int) *fakeNodeAPI {
+	api := &fakeNodeAPI{nodes: make(map[string]*nomadapi.Node)}
+	for i := range count {
+		id := fmt.Sprintf("node-%d", i)
+		api.allocs = append(api.allocs, &nomadapi.AllocationListStub{ID: fmt.Sprintf("alloc-%d", i), NodeID: id, ClientStatus: "running"})
+		api.nodes[id] = &nomadapi.Node{
+			ID:         id,
+			Name:       fmt.Sprintf("worker-%d", i),
+			Status:     "ready",
+			Attributes: map[string]string{"unique.network.ip-address": fmt.Sprintf("10.0.%d.%d", i/256, i%256)},
+		}
+	}
+	return api
+}
+
+func TestGetTraefikNodesConcurrentLookups(t *testing.T) {
+	api := newLargeFakeNodeAPI(50)
+	// A second allocation on a node does not cause a second lookup
+	api.allocs = append(api.allocs, &nomadapi.AllocationListStub{ID: "alloc-extra", NodeID: "node-0", ClientStatus: "running"})
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}, NodeInfoConcurrency: 8},
+		retryDelay: time.Millisecond,
+	}
+
+	nodes, err := client.GetTraefikNodes(context.Background())
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+	if len(nodes) != 50 {
+		t.Errorf("GetTraefikNodes() returned %d nodes, want 50", len(nodes))
+	}
+	if api.nodeCalls != 50 {
+		t.Errorf("node info called %d times, want 50", api.nodeCalls)
+	}
+}
+
+func TestGetTraefikNodesOnlyNodes(t *testing.T) {
+	api := newLargeFakeNodeAPI(20)
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}},
+		retryDelay: time.Millisecond,
+	}
+
+	// Only the given node is looked up
+	nodes, err := client.GetTraefikNodes(context.Background(), "node-7")
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != "node-7" || api.nodeCalls != 1 {
+		t.Errorf("GetTraefikNodes(node-7) = %+v after %d node info calls, want node-7 after 1", nodes, api.nodeCalls)
+	}
+
+	// A node which runs no Traefik allocation is not found
+	nodes, err = client.GetTraefikNodes(context.Background(), "node-42")
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("GetTraefikNodes(node-42) = %+v, want no node", nodes)
+	}
+}
+
+func TestTraefikNodesConflictingReads(t *testing.T) {
+	client := &Client{config: &config.Config{Name: "test"}}
+	read := func(id, ip string, modifyIndex uint64) *nomadapi.Node {
+		return &nomadapi.Node{ID: id, Name: id, Status: "ready", ModifyIndex: modifyIndex, Attributes: map[string]string{"unique.network.ip-address": ip}}
+	}
+
+	tests := []struct {
+		name     string
+		found    []*nomadapi.Node
+		expected []string // IPs of the nodes, in order
+	}{
+		{
+			name:     "single reads keep their order",
+			found:    []*nomadapi.Node{read("node-2", "10.0.0.2", 5), read("node-1", "10.0.0.1", 5)},
+			expected: []string{"10.0.0.2", "10.0.0.1"},
+		},
+		{
+			name:     "most recent read kept",
+			found:    []*nomadapi.Node{read("node-1", "10.0.0.1", 7), read("node-2", "10.0.0.2", 5), read("node-1", "10.0.0.9", 9)},
+			expected: []string{"10.0.0.9", "10.0.0.2"},
+		},
+		{
+			name:     "stale read ignored",
+			found:    []*nomadapi.Node{read("node-1", "10.0.0.9", 9), read("node-1", "10.0.0.1", 7)},
+			expected: []string{"10.0.0.9"},
+		},
+		{
+			name:     "first read kept on a tie",
+			found:    []*nomadapi.Node{read("node-1", "10.0.0.1", 7), read("node-1", "10.0.0.9", 7)},
+			expected: []string{"10.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The result does not depend on map iteration order
+			for range 10 {
+				var ips []string
+				for _, node := range client.traefikNodes(context.Background(), tt.found) {
+					ips = append(ips, node.PublicIPAddress)
+				}
+				if !reflect.DeepEqual(ips, tt.expected) {
+					t.Fatalf("traefikNodes() IPs = %v, want %v", ips, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTraefikNodesConcurrentLookupsGiveUp(t *testing.T) {
+	api := newLargeFakeNodeAPI(50)
+	api.nodeErrors = []error{statusError{code: 500}, statusError{code: 500}, statusError{code: 500}}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}, NodeInfoConcurrency: 1},
+		retryDelay: time.Millisecond,
+	}
+
+	// The first node keeps failing, so the other nodes are not looked up
+	nodes, err := client.GetTraefikNodes(context.Background())
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("GetTraefikNodes() error = %v, want %v", err, ErrTransient)
+	}
+	if nodes != nil || api.nodeCalls != QueryRetries {
+		t.Errorf("GetTraefikNodes() = %d nodes after %d node info calls, want none after %d", len(nodes), api.nodeCalls, QueryRetries)
+	}
+}
+
+func TestGetTraefikNodesListsNodes(t *testing.T) {
+	getNodes := func(t *testing.T, api *fakeNodeAPI, threshold int) []internaltypes.NodeInfo {
+		t.Helper()
+		client := &Client{
+			nodes:      api,
+			config:     &config.Config{TraefikJobNames: []string{"ingress"}, NodeListThreshold: threshold, ExcludeIneligibleNodes: true},
+			retryDelay: time.Millisecond,
+		}
+		nodes, err := client.GetTraefikNodes(context.Background())
+		if err != nil {
+			t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+		return nodes
+	}
+
+	newAPI := func() *fakeNodeAPI {
+		api := newLargeFakeNodeAPI(20)
+		api.nodes["node-3"].SchedulingEligibility = nomadapi.NodeSchedulingIneligible
+		api.nodes["node-4"].Status = "down"
+		api.unlisted = map[string]bool{"node-5": true}
+		return api
+	}
+
+	infoAPI := newAPI()
+	expected := getNodes(t, infoAPI, 0)
+
+	// The nodes are the same, with a single list call and a lookup of the unlisted node
+	listAPI := newAPI()
+	if nodes := getNodes(t, listAPI, 10); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("GetTraefikNodes() with the node list = %+v, want %+v", nodes, expected)
+	}
+	if listAPI.listCalls != 1 || listAPI.nodeCalls != 1 {
+		t.Errorf("node list called %d times and node info %d times, want 1 and 1", listAPI.listCalls, listAPI.nodeCalls)
+	}
+
+	// Below the threshold, the nodes are looked up one by one
+	belowAPI := newAPI()
+	getNodes(t, belowAPI, 21)
+	if belowAPI.listCalls != 0 || belowAPI.nodeCalls != 20 {
+		t.Errorf("node list called %d times and node info %d times, want 0 and 20", belowAPI.listCalls, belowAPI.nodeCalls)
+	}
+}
+
+// BenchmarkGetTraefikNodes compares sequential and parallel node lookups on a large cluster
+func BenchmarkGetTraefikNodes(b *testing.B) {
+	for _, concurrency := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			api := newLargeFakeNodeAPI(200)
+			api.nodeLatency = time.Millisecond
+			client := &Client{
+				nodes:  api,
+				config: &config.Config{TraefikJobNames: []string{"ingress"}, NodeInfoConcurrency: concurrency},
+			}
+
+			for b.Loop() {
+				if _, err := client.GetTraefikNodes(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTraefikNodesDatacenter(t *testing.T) {
+	api := newFakeNodeAPI()
+	api.allocs = append(api.allocs, &nomadapi.AllocationListStub{ID: "alloc-2", NodeID: "node-2", ClientStatus: "running"})
+	api.nodes["node-2"] = &nomadapi.Node{
+		ID:         "node-2",
+		Name:       "worker-2",
+		Status:     "ready",
+		Datacenter: "us-east",
+		Attributes: map[string]string{"unique.network.ip-address": "2.2.2.2"},
+	}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}},
+		retryDelay: time.Millisecond,
+	}
+
+	nodes, err := client.GetTraefikNodes(context.Background())
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+
+	datacenters := make(map[string]string)
+	for _, node := range nodes {
+		datacenters[node.ID] = node.Datacenter
+	}
+	expected := map[string]string{"node-1": "eu-west", "node-2": "us-east"}
+	if !reflect.DeepEqual(datacenters, expected) {
+		t.Errorf("node datacenters = %v, want %v", datacenters, expected)
+	}
+}
+
+func TestGetTraefikNodesRetries(t *testing.T) {
+	serverError := statusError{code: 500}
+
+	tests := []struct {
+		name             string
+		allocationErrors []error
+		nodeErrors       []error
+		expectedErr      error
+		expectedNodes    int
+		expectedAllocs   int
+	}{
+		{
+			name:             "allocations recover after transient errors",
+			allocationErrors: []error{serverError, serverError},
+			expectedNodes:    1,
+			expectedAllocs:   3,
+		},
+		{
+			name:           "node info recovers after a transient error",
+			nodeErrors:     []error{serverError},
+			expectedNodes:  1,
+			expectedAllocs: 1,
+		},
+		{
+			name:             "allocations keep failing",
+			allocationErrors: []error{serverError, serverError, serverError},
+			expectedErr:      ErrTransient,
+			expectedAllocs:   QueryRetries,
+		},
+		{
+			name:           "node info keeps failing",
+			nodeErrors:     []error{serverError, serverError, serverError},
+			expectedErr:    ErrTransient,
+			expectedAllocs: 1,
+		},
+		{
+			name:             "forbidden is not retried",
+			allocationErrors: []error{statusError{code: 403}},
+			expectedErr:      ErrAuth,
+			expectedAllocs:   1,
+		},
+		{
+			name:           "missing node is skipped",
+			nodeErrors:     []error{statusError{code: 404}},
+			expectedAllocs: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeNodeAPI()
+			api.allocationErrors = tt.allocationErrors
+			api.nodeErrors = tt.nodeErrors
+			client := &Client{
+				nodes:      api,
+				config:     &config.Config{TraefikJobNames: []string{"ingress"}},
+				retryDelay: time.Millisecond,
+			}
+
+			nodes, err := client.GetTraefikNodes(context.Background())
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("GetTraefikNodes() error = %v, want %v", err, tt.expectedErr)
+				}
+			} else if err != nil {
+				t.Errorf("GetTraefikNodes() unexpected error = %v", err)
+			}
+
+			if len(nodes) != tt.expectedNodes {
+				t.Errorf("GetTraefikNodes() returned %d nodes, want %d", len(nodes), tt.expectedNodes)
+			}
+			if api.allocationCalls != tt.expectedAllocs {
+				t.Errorf("allocations called %d times, want %d", api.allocationCalls, tt.expectedAllocs)
+			}
+		})
+	}
+}
+
+func TestGetTraefikNodesRetryBudget(t *testing.T) {
+	serverError := statusError{code: 500}
+	api := newFakeNodeAPI()
+	api.allocationErrors = []error{serverError, serverError, serverError}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}},
+		retryDelay: time.Millisecond,
+	}
+
+	// A single retry is left to the sync, so the allocations are only queried twice
+	ctx := retrybudget.WithBudget(context.Background(), 1)
+	_, err := client.GetTraefikNodes(ctx)
+	if !errors.Is(err, retrybudget.ErrExhausted) || !errors.Is(err, ErrTransient) {
+		t.Errorf("GetTraefikNodes() error = %v, want a transient %v", err, retrybudget.ErrExhausted)
+	}
+	if api.allocationCalls != 2 {
+		t.Errorf("allocations called %d times, want 2", api.allocationCalls)
+	}
+
+	// The remaining queries of the sync fail fast
+	_, err = client.GetTraefikNodes(ctx)
+	if !errors.Is(err, retrybudget.ErrExhausted) || !errors.Is(err, ErrTransient) {
+		t.Errorf("GetTraefikNodes() once the budget is exhausted error = %v, want a transient %v", err, retrybudget.ErrExhausted)
+	}
+	if api.allocationCalls != 2 {
+		t.Errorf("allocations called %d times once the budget is exhausted, want still 2", api.allocationCalls)
+	}
+}
+
+func TestGetTraefikNodesPassesContext(t *testing.T) {
+	api := newFakeNodeAPI()
+	client := &Client{nodes: api, config: &config.Config{TraefikJobNames: []string{"ingress"}}}
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "sync")
+	if _, err := client.GetTraefikNodes(ctx); err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+
+	if len(api.queries) != 2 {
+		t.Fatalf("Nomad calls = %d, want the allocations and the node info", len(api.queries))
+	}
+	for i, q := range api.queries {
+		if q == nil || q.Context().Value(key{}) != "sync" {
+			t.Errorf("call %d does not carry the context of the sync", i)
+		}
+	}
+}
+
+func TestGetTraefikNodesStopsRetryingWhenCancelled(t *testing.T) {
+	api := newFakeNodeAPI()
+	api.allocationErrors = []error{statusError{code: 500}, statusError{code: 500}, statusError{code: 500}}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}},
+		retryDelay: time.Hour,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := client.GetTraefikNodes(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetTraefikNodes() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if api.allocationCalls != 1 {
+		t.Errorf("allocations called %d times, want 1", api.allocationCalls)
+	}
+}
+
+func TestGetTraefikNodesRecordsAPICalls(t *testing.T) {
+	// Initialize metrics by creating a server
+	_ = metrics.NewServer(8091)
+
+	api := newFakeNodeAPI()
+	api.allocationErrors = []error{statusError{code: 500}}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{Name: "nomad-metrics-test", TraefikJobNames: []string{"ingress"}},
+		retryDelay: time.Millisecond,
+	}
+
+	if _, err := client.GetTraefikNodes(context.Background()); err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+
+	requests := metrics.AppMetrics.NomadAPIRequests
+	counts := map[[2]string]float64{
+		{"allocations", "error"}:   1,
+		{"allocations", "success"}: 1,
+		{"node_info", "success"}:   1,
+	}
+	for labels, expected := range counts {
+		if got := testutil.ToFloat64(requests.WithLabelValues("nomad-metrics-test", labels[0], labels[1])); got != expected {
+			t.Errorf("%s %s calls = %v, want %v", labels[0], labels[1], got, expected)
+		}
+	}
+}
+
+// fakeEventAPI returns its streams in turn, reporting each connection, or fails with err
+type fakeEventAPI struct {
+	streams   []chan *nomadapi.Events
+	connected chan struct{}
+	err       error
+	namespace string // of the last subscription
+	topics    map[nomadapi.Topic][]string
+}
+
+func (f *fakeEventAPI) eventStream(_ context.Context, topics map[nomadapi.Topic][]string, _ uint64, q *nomadapi.QueryOptions) (<-chan *nomadapi.Events, error) {
+	f.namespace = q.Namespace
+	f.topics = topics
+	if f.err != nil {
+		return nil, f.err
+	}
+	stream := f.streams[0]
+	f.streams = f.streams[1:]
+	f.connected <- struct{}{}
+	return stream, nil
+}
+
+func TestWatchEventsReconnectsStalledStream(t *testing.T) {
+	metrics.NewServer(8091)
+	silent, live := make(chan *nomadapi.Events), make(chan *nomadapi.Events)
+	api := &fakeEventAPI{streams: []chan *nomadapi.Events{silent, live}, connected: make(chan struct{}, 2)}
+	client := &Client{
+		events: api,
+		config: &config.Config{Name: "stall-test", TraefikJobNames: []string{"ingress"}, EventStreamStallTimeout: 50 * time.Millisecond},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan internaltypes.Event)
+	done := make(chan error)
+	go func() { done <- client.WatchEvents(ctx, events) }()
+
+	// The silent stream is reconnected once the stall timeout elapsed
+	for i := 0; i < 2; i++ {
+		select {
+		case <-api.connected:
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d was not made", i+1)
+		}
+	}
+
+	// Heartbeats keep the live stream connected, for longer than the stall timeout
+	for i := 0; i < 10; i++ {
+		live <- &nomadapi.Events{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(metrics.AppMetrics.EventStreamConnected.WithLabelValues("stall-test")); got != 1 {
+		t.Errorf("event stream connected = %v, want 1", got)
+	}
+
+	live <- &nomadapi.Events{Index: 42, Events: []nomadapi.Event{{Type: "NodeUpdated", Index: 42}}}
+	select {
+	case event := <-events:
+		if event.Type != "NodeUpdated" {
+			t.Errorf("event type = %s, want NodeUpdated", event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event of the live stream was not delivered")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchEvents() error = %v, want %v", err, context.Canceled)
+	}
+	if got := testutil.ToFloat64(metrics.AppMetrics.EventStreamConnected.WithLabelValues("stall-test")); got != 0 {
+		t.Errorf("event stream connected = %v after the stream stopped, want 0", got)
+	}
+}
+
+// closedStream returns a stream which delivers the frames, then is closed by Nomad
+func closedStream(frames ...*nomadapi.Events) chan *nomadapi.Events {
+	stream := make(chan *nomadapi.Events, len(frames))
+	for _, frame := range frames {
+		stream <- frame
+	}
+	close(stream)
+	return stream
+}
+
+func TestWatchEventsReconnectsClosedStream(t *testing.T) {
+	metrics.NewServer(8091)
+	live := make(chan *nomadapi.Events)
+	api := &fakeEventAPI{streams: []chan *nomadapi.Events{closedStream(), live}, connected: make(chan struct{}, 2)}
+	client := &Client{
+		events:           api,
+		config:           &config.Config{Name: "closed-test", TraefikJobNames: []string{"ingress"}, EventStreamMaxFailures: 3},
+		streamRetryDelay: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan internaltypes.Event)
+	done := make(chan error)
+	go func() { done <- client.WatchEvents(ctx, events) }()
+
+	// The closed stream is reconnected, rather than read forever
+	for i := 0; i < 2; i++ {
+		select {
+		case <-api.connected:
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d was not made", i+1)
+		}
+	}
+
+	live <- &nomadapi.Events{Index: 42, Events: []nomadapi.Event{{Type: "NodeUpdated", Index: 42}}}
+	select {
+	case event := <-events:
+		if event.Type != "NodeUpdated" {
+			t.Errorf("event type = %s, want NodeUpdated", event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event of the reconnected stream was not delivered")
+	}
+	if got := testutil.ToFloat64(metrics.AppMetrics.EventStreamReconnects.WithLabelValues("closed-test")); got != 1 {
+		t.Errorf("event stream reconnects = %v, want 1", got)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchEvents() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWatchEventsGivesUpAfterConsecutiveFailures(t *testing.T) {
+	// The second stream delivers a heartbeat before it is closed, which resets the consecutive failures
+	streams := []chan *nomadapi.Events{closedStream(), closedStream(&nomadapi.Events{}), closedStream(), closedStream()}
+	api := &fakeEventAPI{streams: streams, connected: make(chan struct{}, len(streams))}
+	client := &Client{
+		events:           api,
+		config:           &config.Config{Name: "test", TraefikJobNames: []string{"ingress"}, EventStreamMaxFailures: 3},
+		streamRetryDelay: time.Millisecond,
+	}
+
+	err := client.WatchEvents(context.Background(), make(chan internaltypes.Event))
+	if err == nil || !strings.Contains(err.Error(), "event stream failed 3 times in a row") {
+		t.Errorf("WatchEvents() error = %v, want it to give up after 3 consecutive failures", err)
+	}
+	if len(api.streams) != 0 {
+		t.Errorf("WatchEvents() gave up with %d streams left, want every stream connected", len(api.streams))
+	}
+}
+
+func TestWatchEventsUnavailable(t *testing.T) {
+	for _, code := range []int{403, 404} {
+		api := &fakeEventAPI{err: statusError{code: code}}
+		client := &Client{
+			events: api,
+			config: &config.Config{Name: "test", TraefikJobNames: []string{"ingress-eu", "ingress-us"}, EventStreamNamespace: "*"},
+		}
+		err := client.WatchEvents(context.Background(), make(chan internaltypes.Event))
+		if !errors.Is(err, ErrEventsUnavailable) {
+			t.Errorf("WatchEvents() error = %v for status %d, want %v", err, code, ErrEventsUnavailable)
+		}
+		if api.namespace != "*" {
+			t.Errorf("event stream namespace = %q, want *", api.namespace)
+		}
+		if jobs := api.topics[nomadapi.TopicJob]; !reflect.DeepEqual(jobs, []string{"ingress-eu", "ingress-us"}) {
+			t.Errorf("event stream job topics = %v, want every job", jobs)
+		}
+	}
+}
+
+// fakeVariableAPI records the variables written to it
+type fakeVariableAPI struct {
+	variables map[string]*nomadapi.Variable
+	err       error
+}
+
+func (f *fakeVariableAPI) updateVariable(v *nomadapi.Variable, _ *nomadapi.WriteOptions) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.variables[v.Path] = v
+	return nil
+}
+
+func TestWriteSyncResult(t *testing.T) {
+	api := &fakeVariableAPI{variables: make(map[string]*nomadapi.Variable)}
+	client := &Client{variables: api, config: &config.Config{Name: "test"}}
+
+	result := internaltypes.SyncResult{SyncID: "abcd1234", Name: "test.example.com", Created: []string{"1.1.1.1"}}
+	if err := client.WriteSyncResult(context.Background(), "nomad/jobs/ingress/dns-state", result); err != nil {
+		t.Fatalf("WriteSyncResult() unexpected error = %v", err)
+	}
+
+	variable, ok := api.variables["nomad/jobs/ingress/dns-state"]
+	if !ok {
+		t.Fatal("WriteSyncResult() did not write the variable")
+	}
+	expected := `{"sync_id":"abcd1234","name":"test.example.com","created":["1.1.1.1"]}`
+	if got := variable.Items["result"]; got != expected {
+		t.Errorf("result item = %s, want %s", got, expected)
+	}
+
+	api.err = statusError{code: 403}
+	if err := client.WriteSyncResult(context.Background(), "nomad/jobs/ingress/dns-state", result); !errors.Is(err, ErrAuth) {
+		t.Errorf("WriteSyncResult() error = %v, want %v", err, ErrAuth)
+	}
+}
+
+func TestGetTraefikNodesEntrypoints(t *testing.T) {
+	api := newFakeNodeAPI()
+	api.nodes["node-1"].Meta = map[string]string{"traefik_entrypoints": "web, websecure,,"}
+	client := &Client{
+		nodes:      api,
+		config:     &config.Config{TraefikJobNames: []string{"ingress"}, EntrypointMetaKey: "traefik_entrypoints"},
+		retryDelay: time.Millisecond,
+	}
+
+	nodes, err := client.GetTraefikNodes(context.Background())
+	if err != nil {
+		t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+	}
+	if len(nodes) != 1 || !reflect.DeepEqual(nodes[0].Entrypoints, []string{"web", "websecure"}) {
+		t.Errorf("GetTraefikNodes() = %+v, want node-1 serving web and websecure", nodes)
+	}
+}
+
+func TestGetTraefikNodesIPAttribute(t *testing.T) {
+	tests := []struct {
+		name       string
+		attribute  string // NODE_IP_ATTRIBUTE
+		attributes map[string]string
+		meta       map[string]string
+		expected   string
+	}{
+		{name: "default attribute", attribute: config.DefaultNodeIPAttribute, expected: "1.1.1.1"},
+		{name: "custom attribute", attribute: "unique.platform.aws.public-ipv4", attributes: map[string]string{"unique.platform.aws.public-ipv4": "203.0.113.5"}, expected: "203.0.113.5"},
+		{name: "node meta", attribute: "meta.public_ip", meta: map[string]string{"public_ip": "203.0.113.6"}, expected: "203.0.113.6"},
+		{name: "node without the attribute", attribute: "unique.platform.aws.public-ipv4", expected: "1.1.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeNodeAPI()
+			for key, value := range tt.attributes {
+				api.nodes["node-1"].Attributes[key] = value
+			}
+			api.nodes["node-1"].Meta = tt.meta
+			client := &Client{
+				nodes:      api,
+				config:     &config.Config{TraefikJobNames: []string{"ingress"}, NodeIPAttribute: tt.attribute},
+				retryDelay: time.Millisecond,
+			}
+
+			nodes, err := client.GetTraefikNodes(context.Background())
+			if err != nil {
+				t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+			}
+			if len(nodes) != 1 || nodes[0].PublicIPAddress != tt.expected {
+				t.Errorf("GetTraefikNodes() = %+v, want node-1 at %s", nodes, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetTraefikNodesPrimary(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     map[string]string
+		primary  map[string]string // PRIMARY_NODE_META
+		expected bool
+	}{
+		{name: "matching node meta", meta: map[string]string{"dns_tier": "primary", "role": "edge"}, primary: map[string]string{"dns_tier": "primary"}, expected: true},
+		{name: "other node meta value", meta: map[string]string{"dns_tier": "backup"}, primary: map[string]string{"dns_tier": "primary"}, expected: false},
+		{name: "node meta not set", meta: nil, primary: map[string]string{"dns_tier": "primary"}, expected: false},
+		{name: "no primary nodes configured", meta: map[string]string{"dns_tier": "primary"}, primary: nil, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeNodeAPI()
+			api.nodes["node-1"].Meta = tt.meta
+			client := &Client{
+				nodes:      api,
+				config:     &config.Config{TraefikJobNames: []string{"ingress"}, PrimaryNodeMeta: tt.primary},
+				retryDelay: time.Millisecond,
+			}
+
+			nodes, err := client.GetTraefikNodes(context.Background())
+			if err != nil {
+				t.Fatalf("GetTraefikNodes() unexpected error = %v", err)
+			}
+			if len(nodes) != 1 || nodes[0].Primary != tt.expected {
+				t.Errorf("GetTraefikNodes() = %+v, want node-1 with Primary %v", nodes, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package nomad
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Errors returned by the Nomad client wrap one of these sentinels,
+// so that callers can tell failures apart with errors.Is.
+var (
+	// ErrAuth is returned when the Nomad token is invalid or lacks the required permissions.
+	ErrAuth = errors.New("nomad authentication failed")
+	// ErrNotFound is returned when the requested object (e.g. the Traefik job) does not exist.
+	ErrNotFound = errors.New("nomad object not found")
+	// ErrTransient is returned for failures which may resolve by themselves, such as server errors during leader elections.
+	ErrTransient = errors.New("transient nomad error")
+	// ErrEventsUnavailable is returned, along with ErrAuth or ErrNotFound, when the Nomad version or the ACL token does not allow the event stream.
+	ErrEventsUnavailable = errors.New("nomad event stream unavailable")
+)
+
+// statusCoder is implemented by the errors of the Nomad API which carry the HTTP status code of the response.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// classify wraps err with the sentinel matching its cause. Errors which cannot be classified are returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var coder statusCoder
+	if errors.As(err, &coder) {
+		switch code := coder.StatusCode(); {
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return fmt.Errorf("%w: %w", ErrAuth, err)
+		case code == http.StatusNotFound:
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		case code == http.StatusTooManyRequests, code >= http.StatusInternalServerError:
+			return fmt.Errorf("%w: %w", ErrTransient, err)
+		}
+		return err
+	}
+
+	// Network failures (timeouts, refused connections, DNS failures) are worth retrying
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+
+	return err
+}
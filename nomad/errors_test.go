@@ -0,0 +1,66 @@
+package nomad
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// statusError is a stand-in for the Nomad API's UnexpectedResponseError, whose fields are not exported.
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string   { return fmt.Sprintf("Unexpected response code: %d", e.code) }
+func (e statusError) StatusCode() int { return e.code }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			name:     "forbidden",
+			err:      statusError{code: 403},
+			expected: ErrAuth,
+		},
+		{
+			name:     "job not found",
+			err:      statusError{code: 404},
+			expected: ErrNotFound,
+		},
+		{
+			name:     "server error during leader election",
+			err:      statusError{code: 500},
+			expected: ErrTransient,
+		},
+		{
+			name:     "network error",
+			err:      &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			expected: ErrTransient,
+		},
+		{
+			name:     "bad request is not classified",
+			err:      statusError{code: 400},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := classify(tt.err)
+
+			if !errors.Is(result, tt.err) {
+				t.Errorf("classify() = %v, should wrap the original error", result)
+			}
+
+			for _, sentinel := range []error{ErrAuth, ErrNotFound, ErrTransient} {
+				if errors.Is(result, sentinel) != (sentinel == tt.expected) {
+					t.Errorf("errors.Is(classify(), %v) = %v, want %v", sentinel, errors.Is(result, sentinel), sentinel == tt.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Package reconcile computes the changes needed to bring a set of DNS records in line with a set of target IPs.
+// It does not talk to any DNS provider, so that the algorithm can be tested in isolation.
+package reconcile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"slices"
+	"strings"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+const (
+	// AutoTTL is the TTL value which Cloudflare uses for "automatic"
+	AutoTTL = 1
+
+	// OwnerMarker identifies the records created by the controller. It is part of their comment.
+	OwnerMarker = "nomad-traefik-cloudflare-controller"
+	// OwnerComment is the comment set on the records created by the controller
+	OwnerComment = "Managed by " + OwnerMarker
+
+	// RetargetSeparator separates the previous content of a retargeted record from its new one in a sync result, e.g. "1.1.1.1 -> 2.2.2.2"
+	RetargetSeparator = " -> "
+)
+
+// Owned reports whether the record was created by the controller
+func Owned(record internaltypes.DNSRecord) bool {
+	return strings.Contains(record.Comment, OwnerMarker)
+}
+
+// CanonicalName returns the record name without its trailing dot, if any.
+// Depending on the version of the API library, Cloudflare reports fully qualified names with or without it.
+func CanonicalName(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
+
+// CanonicalIP returns the IP address of a record content in its canonical form, or the content as is if it is not an IP address.
+// Cloudflare may report an IPv6 address in another form than the one it was sent in, e.g. expanded rather than compressed,
+// so contents are compared by address rather than as strings.
+func CanonicalIP(content string) string {
+	addr, err := netip.ParseAddr(content)
+	if err != nil {
+		return content
+	}
+	return addr.String()
+}
+
+// Settings are the desired settings of the managed records.
+type Settings struct {
+	TTL     int    // TTL in seconds, 0 or 1 meaning automatic
+	Proxied bool   // whether records are proxied through Cloudflare
+	Comment string // comment of the records created by the controller, empty to leave the comments alone
+}
+
+// EffectiveTTL returns the TTL which records should have.
+// Cloudflare forces the TTL of proxied records to automatic, so the configured TTL only applies to DNS-only records.
+func (s Settings) EffectiveTTL() int {
+	if s.Proxied || s.TTL <= AutoTTL {
+		return AutoTTL
+	}
+	return s.TTL
+}
+
+// Drifted reports whether the settings of an existing record differ from the desired ones.
+// The TTL is not compared for proxied records, since Cloudflare would override any explicit value
+// and the controller would otherwise try to update the record on every sync.
+func (s Settings) Drifted(record internaltypes.DNSRecord) bool {
+	if record.Proxied != s.Proxied || s.CommentDrifted(record) {
+		return true
+	}
+	if s.Proxied {
+		return false
+	}
+	return record.TTL != s.EffectiveTTL()
+}
+
+// CommentDrifted reports whether the record was created by the controller with another comment than the desired one,
+// e.g. by a previous version. Only the end of the comment is compared, so that the comment an adopted record had before is kept.
+// The comments of the records created by someone else are left alone, since setting one would make them owned.
+func (s Settings) CommentDrifted(record internaltypes.DNSRecord) bool {
+	return s.Comment != "" && Owned(record) && !strings.HasSuffix(record.Comment, s.Comment)
+}
+
+// DesiredComment returns the comment the record should have: the desired one if its comment drifted, its own otherwise
+func (s Settings) DesiredComment(record internaltypes.DNSRecord) string {
+	if s.CommentDrifted(record) {
+		return s.Comment
+	}
+	return record.Comment
+}
+
+// Changes is the set of operations needed to reconcile the records with the targets.
+type Changes struct {
+	ToAdd      []string                  // targets for which a record must be created
+	ToRemove   []internaltypes.DNSRecord // records which are no longer needed
+	ToUpdate   []internaltypes.DNSRecord // records whose settings must be updated. Content holds the desired target.
+	ToRetarget []Retarget                // records which are no longer needed, updated in place to point at a new target instead
+}
+
+// Retarget is a record which is updated in place to point at another target
+type Retarget struct {
+	Record internaltypes.DNSRecord
+	Target string
+}
+
+// String returns the retarget as reported in a sync result, e.g. "1.1.1.1 -> 2.2.2.2"
+func (r Retarget) String() string {
+	return r.Record.Content + RetargetSeparator + r.Target
+}
+
+// Empty reports whether there is nothing to change.
+func (c Changes) Empty() bool {
+	return len(c.ToAdd) == 0 && len(c.ToRemove) == 0 && len(c.ToUpdate) == 0 && len(c.ToRetarget) == 0
+}
+
+// InPlace pairs the records which are no longer needed with the targets which have none, so that each pair is a single update
+// of the record rather than a deletion and a creation. Only the records created by the controller are updated in place:
+// the others are still removed, so that they do not become the controller's. The unpaired records and targets are left as they are.
+func (c Changes) InPlace() Changes {
+	var remove []internaltypes.DNSRecord
+	for _, record := range c.ToRemove {
+		if len(c.ToAdd) == 0 || !Owned(record) {
+			remove = append(remove, record)
+			continue
+		}
+		c.ToRetarget = append(c.ToRetarget, Retarget{Record: record, Target: c.ToAdd[0]})
+		c.ToAdd = c.ToAdd[1:]
+	}
+	c.ToRemove = remove
+	return c
+}
+
+// Plan compares the current records with the target IPs and returns the changes needed to reconcile them.
+// Records pointing to a target are kept (and updated if their settings drifted),
+// records pointing elsewhere or duplicating another record are removed,
+// and a record is added for every target which has none.
+// Contents and targets are compared by address, see CanonicalIP.
+func Plan(current []internaltypes.DNSRecord, target []string, desired Settings) Changes {
+	var changes Changes
+
+	targetSet := make(map[string]bool)
+	for _, ip := range target {
+		targetSet[CanonicalIP(ip)] = true
+	}
+
+	// Keep the first record for each target and remove the rest
+	kept := make(map[string]bool)
+	for _, record := range current {
+		content := CanonicalIP(record.Content)
+		if !targetSet[content] || kept[content] {
+			changes.ToRemove = append(changes.ToRemove, record)
+			continue
+		}
+		kept[content] = true
+
+		if desired.Drifted(record) {
+			changes.ToUpdate = append(changes.ToUpdate, record)
+		}
+	}
+
+	// Add records for the targets which have none, preserving the order of the targets
+	for _, ip := range target {
+		if !kept[CanonicalIP(ip)] {
+			changes.ToAdd = append(changes.ToAdd, ip)
+			kept[CanonicalIP(ip)] = true
+		}
+	}
+
+	return changes
+}
+
+// Applied returns the sorted contents of the records of a name once the changes planned from the current records were applied, as the result reports them.
+// Contents which were deleted and are not targets are gone, unless the deletion of one of their records failed.
+// The contents are in their canonical form, see CanonicalIP.
+func Applied(current []internaltypes.DNSRecord, target []string, result internaltypes.SyncResult) []string {
+	targets := make(map[string]bool)
+	for _, ip := range target {
+		targets[CanonicalIP(ip)] = true
+	}
+	gone := make(map[string]bool)
+	for _, content := range result.Deleted {
+		gone[CanonicalIP(content)] = !targets[CanonicalIP(content)] && !slices.Contains(result.Failed, "delete "+content)
+	}
+
+	// A retargeted record no longer holds its previous content, unless another record does
+	retargeted := make(map[string]int)
+	for _, retarget := range result.Retargeted {
+		previous, _, _ := strings.Cut(retarget, RetargetSeparator)
+		retargeted[CanonicalIP(previous)]++
+	}
+
+	var contents []string
+	for _, record := range current {
+		content := CanonicalIP(record.Content)
+		if retargeted[content] > 0 {
+			retargeted[content]--
+			continue
+		}
+		if !gone[content] {
+			contents = append(contents, content)
+		}
+	}
+	for _, content := range result.Created {
+		contents = append(contents, CanonicalIP(content))
+	}
+	for _, retarget := range result.Retargeted {
+		_, target, _ := strings.Cut(retarget, RetargetSeparator)
+		contents = append(contents, CanonicalIP(target))
+	}
+
+	slices.Sort(contents)
+	return slices.Compact(contents)
+}
+
+// Hash returns a short hash of the record set of a name, which does not depend on the order of the contents.
+// Two record sets have the same hash when they have the same name and contents.
+func Hash(name string, contents []string) string {
+	sorted := slices.Sorted(slices.Values(contents))
+	sum := sha256.Sum256([]byte(name + "\n" + strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
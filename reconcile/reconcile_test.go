@@ -0,0 +1,391 @@
+package reconcile
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+// records returns DNS-only records with automatic TTL for the given contents, with the content as ID
+func records(contents ...string) []internaltypes.DNSRecord {
+	var result []internaltypes.DNSRecord
+	for _, content := range contents {
+		result = append(result, internaltypes.DNSRecord{ID: content, Type: "A", Content: content, TTL: AutoTTL})
+	}
+	return result
+}
+
+// contents returns the content of each record
+func contents(records []internaltypes.DNSRecord) []string {
+	var result []string
+	for _, record := range records {
+		result = append(result, record.Content)
+	}
+	return result
+}
+
+// Test the business logic for determining what DNS changes are needed
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name             string
+		current          []internaltypes.DNSRecord
+		targetIPs        []string
+		expectedToAdd    []string
+		expectedToRemove []string
+	}{
+		{
+			name:          "add new IPs",
+			current:       records(),
+			targetIPs:     []string{"1.1.1.1", "2.2.2.2"},
+			expectedToAdd: []string{"1.1.1.1", "2.2.2.2"},
+		},
+		{
+			name:             "remove old IPs",
+			current:          records("1.1.1.1", "2.2.2.2"),
+			targetIPs:        []string{},
+			expectedToRemove: []string{"1.1.1.1", "2.2.2.2"},
+		},
+		{
+			name:             "partial update",
+			current:          records("1.1.1.1", "2.2.2.2"),
+			targetIPs:        []string{"1.1.1.1", "3.3.3.3"},
+			expectedToAdd:    []string{"3.3.3.3"},
+			expectedToRemove: []string{"2.2.2.2"},
+		},
+		{
+			name:      "nothing to do",
+			current:   records("1.1.1.1", "2.2.2.2"),
+			targetIPs: []string{"2.2.2.2", "1.1.1.1"},
+		},
+		{
+			name:             "duplicate records are removed",
+			current:          records("1.1.1.1", "1.1.1.1"),
+			targetIPs:        []string{"1.1.1.1"},
+			expectedToRemove: []string{"1.1.1.1"},
+		},
+		{
+			name:      "IPv6 contents are compared by address",
+			current:   records("2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::2"),
+			targetIPs: []string{"2001:db8::1", "2001:0db8::0002"},
+		},
+		{
+			name:             "expanded duplicate of a compressed IPv6 record is removed",
+			current:          records("2001:db8::1", "2001:db8:0:0:0:0:0:1"),
+			targetIPs:        []string{"2001:db8::1"},
+			expectedToRemove: []string{"2001:db8:0:0:0:0:0:1"},
+		},
+		{
+			name:          "duplicate targets are added once",
+			current:       records(),
+			targetIPs:     []string{"1.1.1.1", "1.1.1.1"},
+			expectedToAdd: []string{"1.1.1.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Plan(tt.current, tt.targetIPs, Settings{TTL: AutoTTL})
+
+			if !reflect.DeepEqual(changes.ToAdd, tt.expectedToAdd) {
+				t.Errorf("Plan() ToAdd = %v, want %v", changes.ToAdd, tt.expectedToAdd)
+			}
+			if !reflect.DeepEqual(contents(changes.ToRemove), tt.expectedToRemove) {
+				t.Errorf("Plan() ToRemove = %v, want %v", contents(changes.ToRemove), tt.expectedToRemove)
+			}
+			if len(changes.ToUpdate) != 0 {
+				t.Errorf("Plan() ToUpdate = %v, want none", contents(changes.ToUpdate))
+			}
+			expectEmpty := len(tt.expectedToAdd) == 0 && len(tt.expectedToRemove) == 0
+			if changes.Empty() != expectEmpty {
+				t.Errorf("Plan() Empty = %v, want %v", changes.Empty(), expectEmpty)
+			}
+		})
+	}
+}
+
+// TestDriftedProxiedTTL reproduces the reconcile loop caused by an explicit TTL on proxied records:
+// Cloudflare reports TTL 1 (auto) for proxied records whatever we send, so comparing the TTL would
+// trigger an update on every sync.
+func TestDriftedProxiedTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		record   internaltypes.DNSRecord
+		expected bool
+	}{
+		{
+			name:     "proxied record with explicit TTL configured is not updated",
+			settings: Settings{Proxied: true, TTL: 300},
+			record:   internaltypes.DNSRecord{ID: "1", Content: "1.1.1.1", TTL: 1, Proxied: true},
+			expected: false,
+		},
+		{
+			name:     "DNS-only record with matching TTL is not updated",
+			settings: Settings{Proxied: false, TTL: 300},
+			record:   internaltypes.DNSRecord{ID: "1", Content: "1.1.1.1", TTL: 300, Proxied: false},
+			expected: false,
+		},
+		{
+			name:     "DNS-only record with drifted TTL is updated",
+			settings: Settings{Proxied: false, TTL: 300},
+			record:   internaltypes.DNSRecord{ID: "1", Content: "1.1.1.1", TTL: 1, Proxied: false},
+			expected: true,
+		},
+		{
+			name:     "DNS-only record with automatic TTL configured as 0 is not updated",
+			settings: Settings{Proxied: false, TTL: 0},
+			record:   internaltypes.DNSRecord{ID: "1", Content: "1.1.1.1", TTL: 1, Proxied: false},
+			expected: false,
+		},
+		{
+			name:     "record with drifted proxied flag is updated",
+			settings: Settings{Proxied: true, TTL: 1},
+			record:   internaltypes.DNSRecord{ID: "1", Content: "1.1.1.1", TTL: 300, Proxied: false},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.settings.Drifted(tt.record); result != tt.expected {
+				t.Errorf("Drifted() = %v, want %v", result, tt.expected)
+			}
+
+			changes := Plan([]internaltypes.DNSRecord{tt.record}, []string{tt.record.Content}, tt.settings)
+			if (len(changes.ToUpdate) == 1) != tt.expected {
+				t.Errorf("Plan() ToUpdate = %v, want update %v", contents(changes.ToUpdate), tt.expected)
+			}
+
+			// Once updated, a record carries what Cloudflare reports for the desired settings,
+			// which must not trigger a further update.
+			updated := internaltypes.DNSRecord{ID: tt.record.ID, Content: tt.record.Content, TTL: tt.settings.EffectiveTTL(), Proxied: tt.settings.Proxied}
+			if tt.settings.Drifted(updated) {
+				t.Errorf("Drifted() = true after the record was updated, the reconcile would loop")
+			}
+		})
+	}
+}
+
+// Flipping the proxied flag must update the records in place:
+// deleting and recreating them would change their IDs and briefly leave the name without some targets.
+func TestPlanProxiedToggle(t *testing.T) {
+	current := records("1.1.1.1", "2.2.2.2") // DNS-only
+
+	changes := Plan(current, []string{"1.1.1.1", "2.2.2.2"}, Settings{TTL: AutoTTL, Proxied: true})
+
+	if len(changes.ToAdd) != 0 || len(changes.ToRemove) != 0 {
+		t.Errorf("Plan() ToAdd = %v, ToRemove = %v, want none", changes.ToAdd, contents(changes.ToRemove))
+	}
+	if !reflect.DeepEqual(contents(changes.ToUpdate), []string{"1.1.1.1", "2.2.2.2"}) {
+		t.Errorf("Plan() ToUpdate = %v, want [1.1.1.1 2.2.2.2]", contents(changes.ToUpdate))
+	}
+}
+
+func TestChangesInPlace(t *testing.T) {
+	owned := records("1.1.1.1", "2.2.2.2", "3.3.3.3")
+	for i := range owned {
+		owned[i].Comment = OwnerComment
+	}
+	manual := records("4.4.4.4")
+
+	tests := []struct {
+		name     string
+		changes  Changes
+		retarget []string
+		add      []string
+		remove   []string
+	}{
+		{
+			name:     "as many stale records as new targets",
+			changes:  Changes{ToAdd: []string{"5.5.5.5", "6.6.6.6"}, ToRemove: owned[:2]},
+			retarget: []string{"1.1.1.1 -> 5.5.5.5", "2.2.2.2 -> 6.6.6.6"},
+		},
+		{
+			name:     "more stale records than new targets",
+			changes:  Changes{ToAdd: []string{"5.5.5.5"}, ToRemove: owned},
+			retarget: []string{"1.1.1.1 -> 5.5.5.5"},
+			remove:   []string{"2.2.2.2", "3.3.3.3"},
+		},
+		{
+			name:     "more new targets than stale records",
+			changes:  Changes{ToAdd: []string{"5.5.5.5", "6.6.6.6"}, ToRemove: owned[:1]},
+			retarget: []string{"1.1.1.1 -> 5.5.5.5"},
+			add:      []string{"6.6.6.6"},
+		},
+		{
+			name:    "records not created by the controller",
+			changes: Changes{ToAdd: []string{"5.5.5.5"}, ToRemove: manual},
+			add:     []string{"5.5.5.5"},
+			remove:  []string{"4.4.4.4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := tt.changes.InPlace()
+			var retarget []string
+			for _, r := range changes.ToRetarget {
+				retarget = append(retarget, r.String())
+			}
+			if !slices.Equal(retarget, tt.retarget) {
+				t.Errorf("InPlace() ToRetarget = %v, want %v", retarget, tt.retarget)
+			}
+			if !slices.Equal(changes.ToAdd, tt.add) {
+				t.Errorf("InPlace() ToAdd = %v, want %v", changes.ToAdd, tt.add)
+			}
+			if !slices.Equal(contents(changes.ToRemove), tt.remove) {
+				t.Errorf("InPlace() ToRemove = %v, want %v", contents(changes.ToRemove), tt.remove)
+			}
+		})
+	}
+}
+
+func TestOwned(t *testing.T) {
+	tests := []struct {
+		comment  string
+		expected bool
+	}{
+		{comment: OwnerComment, expected: true},
+		{comment: "Managed by nomad-traefik-cloudflare-controller (instance eu)", expected: true},
+		{comment: "", expected: false},
+		{comment: "created by hand", expected: false},
+	}
+
+	for _, tt := range tests {
+		if owned := Owned(internaltypes.DNSRecord{Comment: tt.comment}); owned != tt.expected {
+			t.Errorf("Owned(%q) = %v, want %v", tt.comment, owned, tt.expected)
+		}
+	}
+}
+
+func TestCommentDrifted(t *testing.T) {
+	settings := Settings{Proxied: true, Comment: OwnerComment}
+	tests := []struct {
+		name     string
+		comment  string
+		expected bool
+	}{
+		{name: "desired comment", comment: OwnerComment, expected: false},
+		{name: "adopted record keeping its previous comment", comment: "added by hand; " + OwnerComment, expected: false},
+		{name: "comment of a previous version", comment: "Created by " + OwnerMarker, expected: true},
+		{name: "record created by someone else", comment: "added by hand", expected: false},
+		{name: "record without comment", comment: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := internaltypes.DNSRecord{ID: "1", Content: "1.1.1.1", TTL: AutoTTL, Proxied: true, Comment: tt.comment}
+			if drifted := settings.CommentDrifted(record); drifted != tt.expected {
+				t.Errorf("CommentDrifted() = %v, want %v", drifted, tt.expected)
+			}
+
+			// The content matches, so the record is only updated for its comment
+			changes := Plan([]internaltypes.DNSRecord{record}, []string{"1.1.1.1"}, settings)
+			if (len(changes.ToUpdate) == 1) != tt.expected {
+				t.Errorf("Plan() ToUpdate = %v, want update %v", contents(changes.ToUpdate), tt.expected)
+			}
+
+			want := tt.comment
+			if tt.expected {
+				want = OwnerComment
+			}
+			if comment := settings.DesiredComment(record); comment != want {
+				t.Errorf("DesiredComment() = %q, want %q", comment, want)
+			}
+		})
+	}
+
+	// Without a desired comment, comments are left alone
+	if (Settings{Proxied: true}).CommentDrifted(internaltypes.DNSRecord{Proxied: true, Comment: "Created by " + OwnerMarker}) {
+		t.Error("CommentDrifted() = true without a desired comment")
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"test.example.com":  "test.example.com",
+		"test.example.com.": "test.example.com",
+		"":                  "",
+	} {
+		if canonical := CanonicalName(name); canonical != expected {
+			t.Errorf("CanonicalName(%q) = %q, want %q", name, canonical, expected)
+		}
+	}
+}
+
+func TestApplied(t *testing.T) {
+	current := records("1.1.1.1", "2.2.2.2", "2.2.2.2", "3.3.3.3", "4.4.4.4")
+	target := []string{"1.1.1.1", "2.2.2.2", "5.5.5.5", "6.6.6.6"}
+	result := internaltypes.SyncResult{
+		Created: []string{"5.5.5.5"},
+		Deleted: []string{"2.2.2.2", "3.3.3.3"},
+		Failed:  []string{"delete 4.4.4.4", "create 6.6.6.6"},
+	}
+
+	// The duplicate of a target and the failed deletion are still there, the failed creation is not
+	expected := []string{"1.1.1.1", "2.2.2.2", "4.4.4.4", "5.5.5.5"}
+	if got := Applied(current, target, result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Applied() = %v, want %v", got, expected)
+	}
+}
+
+func TestAppliedIPv6(t *testing.T) {
+	// Cloudflare reports the address expanded, while the targets are compressed
+	current := records("2001:0db8:0000:0000:0000:0000:0000:0001", "2001:0db8:0000:0000:0000:0000:0000:0002")
+	target := []string{"2001:db8::1", "2001:db8::3"}
+	result := internaltypes.SyncResult{
+		Created: []string{"2001:db8::3"},
+		Deleted: []string{"2001:0db8:0000:0000:0000:0000:0000:0002"},
+	}
+
+	expected := []string{"2001:db8::1", "2001:db8::3"}
+	if got := Applied(current, target, result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Applied() = %v, want %v", got, expected)
+	}
+}
+
+func TestAppliedRetargeted(t *testing.T) {
+	current := records("1.1.1.1", "2.2.2.2", "2.2.2.2")
+	target := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}
+	result := internaltypes.SyncResult{
+		Retargeted: []string{"2.2.2.2 -> 3.3.3.3"},
+		Failed:     []string{"retarget 1.1.1.1 -> 4.4.4.4"},
+	}
+
+	// The duplicate of the retargeted record still holds its content, the record which failed to be retargeted keeps its own
+	expected := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
+	if got := Applied(current, target, result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Applied() = %v, want %v", got, expected)
+	}
+}
+
+func TestCanonicalIP(t *testing.T) {
+	for content, expected := range map[string]string{
+		"1.1.1.1": "1.1.1.1",
+		"2001:0db8:0000:0000:0000:0000:0000:0001": "2001:db8::1",
+		"2001:DB8::1":    "2001:db8::1",
+		"not an address": "not an address",
+	} {
+		if got := CanonicalIP(content); got != expected {
+			t.Errorf("CanonicalIP(%q) = %q, want %q", content, got, expected)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	hash := Hash("test.example.com", []string{"1.1.1.1", "2.2.2.2"})
+	if len(hash) != 16 {
+		t.Errorf("Hash() = %q, want 16 hex digits", hash)
+	}
+	if other := Hash("test.example.com", []string{"2.2.2.2", "1.1.1.1"}); other != hash {
+		t.Errorf("Hash() = %q for reordered contents, want %q", other, hash)
+	}
+	if other := Hash("other.example.com", []string{"1.1.1.1", "2.2.2.2"}); other == hash {
+		t.Error("Hash() is the same for another name")
+	}
+	if other := Hash("test.example.com", []string{"1.1.1.1"}); other == hash {
+		t.Error("Hash() is the same for other contents")
+	}
+}
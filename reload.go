@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/cloudflare"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+	"github.com/charmbracelet/log"
+)
+
+// Reload applies the settings of cfg which can be changed while the controller runs, once the running sync, if any, completed.
+// The Cloudflare client is created again with them, e.g. for a new API token, and so is the propagation verifier,
+// as the main name may now be proxied or not.
+// It fails without changing anything if cfg changes other settings, which need a restart.
+func (c *Controller) Reload(cfg *config.Config) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	previous := *c.config
+	changed, err := c.config.Reload(cfg)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		c.logger.Info("Configuration unchanged")
+		return nil
+	}
+
+	cloudflareClient, err := cloudflare.NewClient(c.config)
+	if err != nil {
+		c.config.Reload(&previous)
+		return fmt.Errorf("failed to create cloudflare client: %w", err)
+	}
+	verifier, err := newVerifier(c.config, c.logger)
+	if err != nil {
+		c.config.Reload(&previous)
+		return fmt.Errorf("failed to create the propagation verifier: %w", err)
+	}
+	c.cloudflareClient = cloudflareClient
+	c.verifier = verifier
+	// The pending propagation check, if any, expects the records as the previous settings published them
+	c.verifyGeneration.Add(1)
+	// The cached sync may not have published the names which are managed now
+	c.syncCache = syncCache{}
+
+	// The names no longer managed are not synced anymore, so their state would only get stale
+	for _, name := range previous.DNSRecordNames {
+		if !slices.Contains(c.config.DNSRecordNames, name) {
+			metrics.ForgetName(c.name, name)
+		}
+	}
+
+	c.logger.Info("Configuration reloaded", "changed", changed)
+	return nil
+}
+
+// reloadConfig loads the configuration again, and reloads every controller instance with its own.
+// The instances cannot change without a restart. The log level is process-wide, so it is that of the first instance.
+func reloadConfig(controllers []*Controller) {
+	cfgs, err := config.LoadConfigs()
+	if err != nil {
+		log.Error("Failed to reload the configuration, keeping the current one", "error", err)
+		return
+	}
+	if len(cfgs) != len(controllers) {
+		log.Error("Failed to reload the configuration, keeping the current one: the controller instances cannot change without a restart")
+		return
+	}
+	for i, controller := range controllers {
+		if cfgs[i].Name != controller.name {
+			log.Error("Failed to reload the configuration, keeping the current one: the controller instances cannot change without a restart")
+			return
+		}
+	}
+
+	for i, controller := range controllers {
+		if err := controller.Reload(cfgs[i]); err != nil {
+			controller.logger.Error("Failed to reload the configuration, keeping the current one", "error", err)
+			continue
+		}
+		if i == 0 {
+			log.SetLevel(parseLogLevel(cfgs[0].LogLevel))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/config"
+	"github.com/brucellino/nomad-traefik-cloudflare-controller/metrics"
+)
+
+func TestControllerReload(t *testing.T) {
+	controller := newTestController()
+	controller.config = &config.Config{Name: "test", CloudflareToken: "old-token", DNSRecordName: "a.example.com", DNSRecordNames: []string{"a.example.com"}, DNSRecordTTL: 1}
+	controller.syncCache = syncCache{fingerprint: syncFingerprint([]string{"1.1.1.1"})}
+
+	// A setting which needs a restart fails the reload, which changes nothing
+	next := *controller.config
+	next.CloudflareToken = "new-token"
+	next.TraefikJobNames = []string{"traefik"}
+	if err := controller.Reload(&next); err == nil {
+		t.Error("Reload() expected an error for a change of TraefikJobNames")
+	}
+	if controller.config.CloudflareToken != "old-token" || controller.cloudflareClient != nil {
+		t.Errorf("Reload() changed the token to %q, want it unchanged", controller.config.CloudflareToken)
+	}
+
+	next.TraefikJobNames = nil
+	next.DNSRecordTTL = 300
+	if err := controller.Reload(&next); err != nil {
+		t.Fatalf("Reload() unexpected error = %v", err)
+	}
+	if controller.config.CloudflareToken != "new-token" || controller.config.DNSRecordTTL != 300 {
+		t.Errorf("Reload() config = %+v, want the new token and TTL", controller.config.Redacted())
+	}
+	if controller.cloudflareClient == nil {
+		t.Error("Reload() did not create the Cloudflare client again")
+	}
+	if controller.syncCache != (syncCache{}) {
+		t.Error("Reload() kept the cached sync")
+	}
+}
+
+func TestControllerReloadVerifier(t *testing.T) {
+	controller := newTestController()
+	controller.config = &config.Config{Name: "test", CloudflareToken: "token", DNSRecordName: "a.example.com", DNSRecordNames: []string{"a.example.com"},
+		DNSRecordTTL: 1, VerifyPropagation: true, VerifyResolver: "1.1.1.1:53"}
+	var err error
+	if controller.verifier, err = newVerifier(controller.config, controller.logger); err != nil || controller.verifier == nil {
+		t.Fatalf("newVerifier() = %v, %v, want a verifier of the DNS-only name", controller.verifier, err)
+	}
+
+	// Proxied records resolve to Cloudflare's edge, so they are not verified
+	next := *controller.config
+	next.Proxied = true
+	if err := controller.Reload(&next); err != nil {
+		t.Fatalf("Reload() unexpected error = %v", err)
+	}
+	if controller.verifier != nil {
+		t.Error("Reload() kept verifying the propagation of the proxied name")
+	}
+
+	next.Proxied = false
+	if err := controller.Reload(&next); err != nil {
+		t.Fatalf("Reload() unexpected error = %v", err)
+	}
+	if controller.verifier == nil {
+		t.Error("Reload() did not verify the propagation of the DNS-only name")
+	}
+}
+
+func TestControllerReloadForgetsRemovedNames(t *testing.T) {
+	metrics.NewServer(0)
+	controller := newTestController()
+	controller.name = "reload-test"
+	controller.config = &config.Config{Name: "reload-test", CloudflareToken: "token", DNSRecordName: "a.example.com",
+		DNSRecordNames: []string{"a.example.com", "b.example.com"}, DNSRecordTTL: 1}
+	for _, name := range controller.config.DNSRecordNames {
+		metrics.SetRecordSetHash(controller.name, name, "hash-"+name)
+		metrics.SetManagedIPs(controller.name, name, []string{"203.0.113.1"})
+		metrics.RecordNameSync(controller.name, name, nil)
+	}
+
+	next := *controller.config
+	next.DNSRecordNames = []string{"a.example.com"}
+	if err := controller.Reload(&next); err != nil {
+		t.Fatalf("Reload() unexpected error = %v", err)
+	}
+
+	// Only the series of the name which is still managed remain
+	if metrics.AppMetrics.RecordSetHash.DeleteLabelValues(controller.name, "b.example.com", "hash-b.example.com") {
+		t.Error("Reload() kept the record set hash of the removed name")
+	}
+	if metrics.AppMetrics.NameSyncs.DeleteLabelValues(controller.name, "b.example.com", "success") {
+		t.Error("Reload() kept the syncs of the removed name")
+	}
+	if !metrics.AppMetrics.RecordSetHash.DeleteLabelValues(controller.name, "a.example.com", "hash-a.example.com") {
+		t.Error("Reload() removed the record set hash of the name still managed")
+	}
+}
@@ -0,0 +1,64 @@
+// Package retrybudget bounds the retries of the Nomad and Cloudflare API calls made during a sync.
+// Each call retries on its own, so a sync making many calls to a degraded API would multiply the retries.
+// A budget shared by all the calls of a sync caps them: once it is spent, the remaining calls fail fast
+// and the sync fails, to be retried on the next one.
+package retrybudget
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExhausted is returned by the calls made once the retry budget of the sync was spent
+var ErrExhausted = errors.New("retry budget of the sync exhausted")
+
+// budget is the number of retries left to the calls of a sync
+type budget struct {
+	remaining atomic.Int64
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context whose calls may retry retries times in total.
+// With zero or less, retries are not bounded.
+func WithBudget(ctx context.Context, retries int) context.Context {
+	if retries <= 0 {
+		return ctx
+	}
+	b := &budget{}
+	b.remaining.Store(int64(retries))
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// WithoutBudget returns a context whose calls are not bounded by the budget of ctx, e.g. for calls which must not affect the sync
+func WithoutBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, budgetKey{}, nil)
+}
+
+// Spend takes a retry from the budget of the context after a call failed, and reports whether the call may be retried.
+// Once it reported false, the budget is exhausted. Retries are not bounded if the context has no budget.
+func Spend(ctx context.Context) bool {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return true
+	}
+	return b.remaining.Add(-1) >= 0
+}
+
+// Exhausted reports whether a call was refused a retry by the budget of the context, after which calls should fail fast
+func Exhausted(ctx context.Context) bool {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	return ok && b.remaining.Load() < 0
+}
+
+// Jitter returns a random delay between half the delay and the delay,
+// so that the retries of calls which failed together are spread out.
+func Jitter(delay time.Duration) time.Duration {
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + rand.N(delay-delay/2)
+}
@@ -0,0 +1,83 @@
+package retrybudget
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBudgetExhaustion(t *testing.T) {
+	ctx := WithBudget(context.Background(), 3)
+
+	for i := range 3 {
+		if !Spend(ctx) {
+			t.Fatalf("Spend() = false for retry %d of 3", i+1)
+		}
+		if Exhausted(ctx) {
+			t.Fatalf("Exhausted() = true after %d retries of 3", i+1)
+		}
+	}
+
+	// The fourth retry is refused, and every call fails fast from then on
+	if Spend(ctx) {
+		t.Error("Spend() = true once the budget was spent")
+	}
+	if !Exhausted(ctx) {
+		t.Error("Exhausted() = false once a retry was refused")
+	}
+	if Spend(ctx) {
+		t.Error("Spend() = true once the budget was exhausted")
+	}
+}
+
+func TestBudgetSharedByConcurrentCalls(t *testing.T) {
+	ctx := WithBudget(context.Background(), 10)
+
+	var mu sync.Mutex
+	granted := 0
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if Spend(ctx) {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if granted != 10 {
+		t.Errorf("%d retries granted, want 10", granted)
+	}
+}
+
+func TestNoBudget(t *testing.T) {
+	spent := WithBudget(context.Background(), 1)
+	Spend(spent)
+	Spend(spent)
+	for _, ctx := range []context.Context{context.Background(), WithBudget(context.Background(), 0), WithoutBudget(spent)} {
+		for range 100 {
+			if !Spend(ctx) {
+				t.Fatal("Spend() = false without a budget")
+			}
+		}
+		if Exhausted(ctx) {
+			t.Error("Exhausted() = true without a budget")
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for range 100 {
+		if delay := Jitter(time.Second); delay < 500*time.Millisecond || delay >= time.Second {
+			t.Fatalf("Jitter(1s) = %v, want between 500ms and 1s", delay)
+		}
+	}
+	if delay := Jitter(0); delay != 0 {
+		t.Errorf("Jitter(0) = %v, want 0", delay)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+// lifetime counts what a controller did since it started, for the summary logged when it stops
+type lifetime struct {
+	started    time.Time // set by Run, before any sync
+	syncs      atomic.Int64
+	syncErrors atomic.Int64
+	created    atomic.Int64 // records, across every name
+	updated    atomic.Int64 // including the records pointed at another target
+	deleted    atomic.Int64
+}
+
+// summary is the lifetime of a controller, as logged when it stops
+type summary struct {
+	Syncs      int64
+	SyncErrors int64
+	Created    int64
+	Updated    int64
+	Deleted    int64
+	Uptime     time.Duration
+}
+
+// recordSyncOutcome counts a sync, and whether it failed
+func (l *lifetime) recordSyncOutcome(err error) {
+	l.syncs.Add(1)
+	if err != nil {
+		l.syncErrors.Add(1)
+	}
+}
+
+// recordChanges counts the records changed by the sync of a name
+func (l *lifetime) recordChanges(result internaltypes.SyncResult) {
+	l.created.Add(int64(len(result.Created)))
+	l.updated.Add(int64(len(result.Updated) + len(result.Retargeted)))
+	l.deleted.Add(int64(len(result.Deleted)))
+}
+
+// summary returns what the controller did since it started
+func (c *Controller) summary() summary {
+	return summary{
+		Syncs:      c.lifetime.syncs.Load(),
+		SyncErrors: c.lifetime.syncErrors.Load(),
+		Created:    c.lifetime.created.Load(),
+		Updated:    c.lifetime.updated.Load(),
+		Deleted:    c.lifetime.deleted.Load(),
+		Uptime:     c.clock.Now().Sub(c.lifetime.started),
+	}
+}
+
+// logSummary logs what the controller did since it started. It is only called once the controller stopped gracefully.
+func (c *Controller) logSummary() {
+	s := c.summary()
+	c.logger.Info("Controller summary",
+		"syncs", s.Syncs,
+		"sync_errors", s.SyncErrors,
+		"records_created", s.Created,
+		"records_updated", s.Updated,
+		"records_deleted", s.Deleted,
+		"uptime", s.Uptime.Round(time.Second))
+}
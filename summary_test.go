@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	internaltypes "github.com/brucellino/nomad-traefik-cloudflare-controller/types"
+)
+
+func TestSummary(t *testing.T) {
+	controller := newTestController()
+	clock := controller.clock.(*fakeClock)
+	controller.lifetime.started = clock.Now()
+
+	controller.lifetime.recordSyncOutcome(nil)
+	controller.recordSync(internaltypes.SyncResult{Name: "test.example.com", Created: []string{"1.1.1.1", "2.2.2.2"}, Deleted: []string{"3.3.3.3"}})
+	controller.recordSync(internaltypes.SyncResult{Name: "other.example.com", Updated: []string{"1.1.1.1"}})
+	controller.lifetime.recordSyncOutcome(errors.New("nomad unavailable"))
+	clock.Advance(90 * time.Minute)
+
+	expected := summary{Syncs: 2, SyncErrors: 1, Created: 2, Updated: 1, Deleted: 1, Uptime: 90 * time.Minute}
+	if s := controller.summary(); s != expected {
+		t.Errorf("summary() = %+v, want %+v", s, expected)
+	}
+}
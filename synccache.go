@@ -0,0 +1,52 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// syncCache is what the last full sync which succeeded published, so that the next syncs publishing the same IPs
+// can skip Cloudflare while it is fresh (SYNC_CACHE_TTL)
+type syncCache struct {
+	fingerprint string    // of the target IPs of every record, empty if nothing is cached
+	at          time.Time // when the records were last reconciled with Cloudflare
+}
+
+// fresh reports whether a sync with the fingerprint would publish what the cached sync published, less than ttl ago
+func (s syncCache) fresh(fingerprint string, now time.Time, ttl time.Duration) bool {
+	return ttl > 0 && s.fingerprint != "" && s.fingerprint == fingerprint && now.Sub(s.at) < ttl
+}
+
+// bypassesSyncCache reports whether a sync with the trigger always reconciles with Cloudflare:
+// the syncs requested by an operator and the full reconciles do, as they are meant to heal drift.
+func bypassesSyncCache(trigger string) bool {
+	return trigger == triggerManual || trigger == triggerSignal || trigger == triggerFullReconcile
+}
+
+// bypassesRecordCache reports whether a sync with the trigger lists the records from Cloudflare rather than reusing
+// those listed by the last syncs: on top of those bypassing the sync cache, the periodic syncs do, so that the drift
+// of the records changed by someone else is caught.
+func bypassesRecordCache(trigger string) bool {
+	return bypassesSyncCache(trigger) || trigger == triggerPeriodic
+}
+
+// syncFingerprint identifies the target IPs of the names, and of each group of records (e.g. by region), whatever their order
+func syncFingerprint(targetIPs []string, groups ...map[string][]string) string {
+	var b strings.Builder
+	write := func(ips []string) {
+		b.WriteString(strings.Join(slices.Compact(slices.Sorted(slices.Values(ips))), ","))
+		b.WriteByte(';')
+	}
+	write(targetIPs)
+	for _, group := range groups {
+		for _, key := range slices.Sorted(maps.Keys(group)) {
+			b.WriteString(key)
+			b.WriteByte('=')
+			write(group[key])
+		}
+		b.WriteByte('|')
+	}
+	return b.String()
+}
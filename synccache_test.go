@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncCacheFresh(t *testing.T) {
+	syncedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fingerprint := syncFingerprint([]string{"1.1.1.1", "2.2.2.2"})
+	cache := syncCache{fingerprint: fingerprint, at: syncedAt}
+
+	tests := []struct {
+		name        string
+		cache       syncCache
+		fingerprint string
+		now         time.Time
+		ttl         time.Duration
+		expected    bool
+	}{
+		{name: "same targets within the TTL", cache: cache, fingerprint: fingerprint, now: syncedAt.Add(time.Minute), ttl: 10 * time.Minute, expected: true},
+		{name: "other targets", cache: cache, fingerprint: syncFingerprint([]string{"1.1.1.1"}), now: syncedAt.Add(time.Minute), ttl: 10 * time.Minute, expected: false},
+		{name: "TTL expired", cache: cache, fingerprint: fingerprint, now: syncedAt.Add(10 * time.Minute), ttl: 10 * time.Minute, expected: false},
+		{name: "cache disabled", cache: cache, fingerprint: fingerprint, now: syncedAt, ttl: 0, expected: false},
+		{name: "nothing cached", cache: syncCache{}, fingerprint: "", now: syncedAt, ttl: 10 * time.Minute, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fresh := tt.cache.fresh(tt.fingerprint, tt.now, tt.ttl); fresh != tt.expected {
+				t.Errorf("fresh() = %v, want %v", fresh, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSyncFingerprint(t *testing.T) {
+	regions := map[string][]string{"eu-west": {"1.1.1.1"}, "us-east": {"2.2.2.2", "3.3.3.3"}}
+	fingerprint := syncFingerprint([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, regions)
+
+	// The order of the IPs and their duplicates, e.g. of nodes behind the same NAT, do not matter
+	if same := syncFingerprint([]string{"3.3.3.3", "1.1.1.1", "2.2.2.2", "1.1.1.1"}, map[string][]string{"us-east": {"3.3.3.3", "2.2.2.2"}, "eu-west": {"1.1.1.1"}}); same != fingerprint {
+		t.Errorf("syncFingerprint() = %q for the same targets in another order, want %q", same, fingerprint)
+	}
+
+	for name, other := range map[string]string{
+		"name target removed":         syncFingerprint([]string{"1.1.1.1", "2.2.2.2"}, regions),
+		"node moved to another group": syncFingerprint([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, map[string][]string{"eu-west": {"1.1.1.1", "2.2.2.2"}, "us-east": {"3.3.3.3"}}),
+		"group emptied":               syncFingerprint([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, map[string][]string{"us-east": {"2.2.2.2", "3.3.3.3"}}),
+		"IPs moved to another kind":   syncFingerprint([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, nil, regions),
+	} {
+		if other == fingerprint {
+			t.Errorf("syncFingerprint() = %q with the %s, want another fingerprint", other, name)
+		}
+	}
+}
+
+func TestBypassesSyncCache(t *testing.T) {
+	for trigger, expected := range map[string]bool{
+		triggerManual:        true,
+		triggerSignal:        true,
+		triggerFullReconcile: true,
+		triggerPeriodic:      false,
+		"event:NodeUpdated":  false,
+	} {
+		if bypasses := bypassesSyncCache(trigger); bypasses != expected {
+			t.Errorf("bypassesSyncCache(%q) = %v, want %v", trigger, bypasses, expected)
+		}
+	}
+}
+
+func TestBypassesRecordCache(t *testing.T) {
+	for trigger, expected := range map[string]bool{
+		triggerManual:        true,
+		triggerSignal:        true,
+		triggerFullReconcile: true,
+		triggerPeriodic:      true,
+		triggerDesiredState:  false,
+		"event:NodeUpdated":  false,
+	} {
+		if bypasses := bypassesRecordCache(trigger); bypasses != expected {
+			t.Errorf("bypassesRecordCache(%q) = %v, want %v", trigger, bypasses, expected)
+		}
+	}
+}
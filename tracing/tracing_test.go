@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	os.Unsetenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+
+	shutdown, err := Setup(context.Background())
+	if err != nil {
+		t.Fatalf("Setup() unexpected error = %v", err)
+	}
+	defer shutdown(context.Background())
+
+	_, span := Tracer().Start(context.Background(), "test")
+	defer span.End()
+	if span.IsRecording() {
+		t.Error("spans should not be recorded when no endpoint is configured")
+	}
+}
+
+func TestSetupWithEndpoint(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	shutdown, err := Setup(context.Background())
+	if err != nil {
+		t.Fatalf("Setup() unexpected error = %v", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "test")
+	if !span.IsRecording() {
+		t.Error("spans should be recorded when an endpoint is configured")
+	}
+
+	// No span was ended, so nothing is exported on shutdown
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() unexpected error = %v", err)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	_, span := provider.Tracer("test").Start(context.Background(), "failing")
+	End(span, errors.New("boom"))
+	_, span = provider.Tracer("test").Start(context.Background(), "succeeding")
+	End(span, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended spans = %d, want 2", len(spans))
+	}
+	if spans[0].Status().Description != "boom" || len(spans[0].Events()) != 1 {
+		t.Errorf("failing span status = %v, events = %d, want the error recorded", spans[0].Status(), len(spans[0].Events()))
+	}
+	if spans[1].Status().Description != "" {
+		t.Errorf("succeeding span status = %v, want unset", spans[1].Status())
+	}
+}
@@ -6,10 +6,13 @@ import "time"
 
 // NodeInfo is a type representing relevant information about a Nomad node.
 type NodeInfo struct {
-	ID              string // Node ID in Nomad cluster
-	Name            string // human-readable name fo the node in the cluster
-	PublicIPAddress string // Public IP Address of the node.
-	Status          string // Status of the node in the cluster.
+	ID              string   // Node ID in Nomad cluster
+	Name            string   // human-readable name fo the node in the cluster
+	PublicIPAddress string   // Public IP Address of the node.
+	Status          string   // Status of the node in the cluster.
+	Datacenter      string   // Datacenter of the node in the cluster.
+	Entrypoints     []string // Traefik entrypoints served by the node, from its node meta.
+	Primary         bool     // Whether the node matches PRIMARY_NODE_META, rather than being a failover node.
 }
 
 // DNSRecord represents a DNS record that can be passed to cloudflare API
@@ -18,13 +21,39 @@ type DNSRecord struct {
 	Name    string // name of the record in Cloudflare
 	Type    string // Can be A, AAAA, CNAME, etc
 	Content string // the value of the record
-	TTL     int    // can also be "auto", but we'll deal with that later.
+	TTL     int    // 1 means "auto". Cloudflare always reports auto for proxied records.
+	Proxied bool   // whether the record is proxied through Cloudflare
+	Comment string // free-form comment, used to mark the records created by the controller
+
+	// Only sent for the record types which carry them, e.g. MX and SRV, and ignored for A records.
+	// Nil falls back to RECORD_PRIORITY and RECORD_WEIGHT.
+	Priority *uint16
+	Weight   *uint16 // SRV and URI records only
+}
+
+// SyncResult is the outcome of reconciling the records of a name with the target IPs
+type SyncResult struct {
+	SyncID  string   `json:"sync_id,omitempty"` // ID of the sync, which its log lines carry
+	Trigger string   `json:"trigger,omitempty"` // what caused the sync, e.g. "periodic" or "event:NodeUpdated"
+	Name    string   `json:"name"`              // name of the records
+	Created []string `json:"created,omitempty"` // contents of the created records
+	Updated []string `json:"updated,omitempty"` // contents of the records whose settings were updated
+	Deleted []string `json:"deleted,omitempty"` // contents of the deleted records
+	Failed  []string `json:"failed,omitempty"`  // operations which failed, e.g. "create 1.1.1.1"
+
+	Retargeted []string `json:"retargeted,omitempty"` // records updated in place to point at another target, e.g. "1.1.1.1 -> 2.2.2.2"
+}
+
+// Changed reports whether the sync changed records, or failed to
+func (r SyncResult) Changed() bool {
+	return len(r.Created) > 0 || len(r.Updated) > 0 || len(r.Deleted) > 0 || len(r.Failed) > 0 || len(r.Retargeted) > 0
 }
 
 // Event is a Nomad EventStream Event. IT comes as newline separated JSON
 type Event struct {
 	Type      string
-	Timestamp time.Time
+	Index     uint64    // Raft index of the event, which orders the events but is not a time
+	Timestamp time.Time // when the controller received the event
 	NodeID    string
 	JobID     string
 	Details   map[string]interface{} // See https://developer.hashicorp.com/nomad/api-docs/events#sample-response for actual event schema
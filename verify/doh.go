@@ -0,0 +1,85 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohTimeout bounds a DNS over HTTPS query, in case the context has no deadline
+const dohTimeout = 10 * time.Second
+
+// dohContentType is the media type of DNS messages sent over HTTPS
+const dohContentType = "application/dns-message"
+
+// dohClient resolves names with DNS over HTTPS (RFC 8484), POSTing queries in the DNS wire format.
+type dohClient struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHClient(url string) *dohClient {
+	return &dohClient{url: url, client: &http.Client{Timeout: dohTimeout}}
+}
+
+// lookupIPv4 returns the addresses of the A records of name
+func (d *dohClient) lookupIPv4(ctx context.Context, name string) ([]string, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	question, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %s: %w", name, err)
+	}
+	// The ID is zero, as RFC 8484 recommends so that the answers can be cached
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: question, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS resolver answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535)) // the maximum size of a DNS message
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the answer: %w", err)
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("invalid answer: %w", err)
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("resolver answered %s", answer.RCode)
+	}
+
+	var resolved []string
+	for _, record := range answer.Answers {
+		if a, ok := record.Body.(*dnsmessage.AResource); ok {
+			resolved = append(resolved, net.IP(a.A[:]).String())
+		}
+	}
+	return resolved, nil
+}
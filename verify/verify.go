@@ -0,0 +1,122 @@
+// Package verify checks that the records published in Cloudflare are actually served by DNS.
+package verify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result is the outcome of a propagation check.
+type Result struct {
+	Resolved   []string // addresses returned by the resolver
+	Missing    []string // expected addresses which were not resolved
+	Unexpected []string // resolved addresses which were not expected
+}
+
+// Mismatch reports whether the resolved addresses differ from the expected ones.
+func (r Result) Mismatch() bool {
+	return len(r.Missing) > 0 || len(r.Unexpected) > 0
+}
+
+// Verifier looks up records against a specific resolver, bypassing the system configuration.
+type Verifier struct {
+	lookup  func(ctx context.Context, name string) ([]string, error) // IPv4 addresses of the name
+	address string
+}
+
+// NewVerifier returns a Verifier which sends its queries to the resolver at address:
+// "udp:host:port" or "host:port" over UDP, "tcp:host:port" over TCP, or an https:// URL with DNS over HTTPS.
+func NewVerifier(address string) (*Verifier, error) {
+	if strings.HasPrefix(address, "https://") {
+		if u, err := url.ParseRequestURI(address); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS over HTTPS resolver %q", address)
+		}
+		return &Verifier{address: address, lookup: newDoHClient(address).lookupIPv4}, nil
+	}
+
+	network, hostPort := "udp", address
+	if prefix, rest, ok := strings.Cut(address, ":"); ok && (prefix == "udp" || prefix == "tcp") {
+		network, hostPort = prefix, rest
+	}
+	if _, port, err := net.SplitHostPort(hostPort); err != nil || !isPort(port) {
+		return nil, fmt.Errorf("invalid resolver %q, want udp:host:port, tcp:host:port or an https:// URL", address)
+	}
+
+	dialer := &net.Dialer{}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, requested, _ string) (net.Conn, error) {
+			// Over UDP, the resolver retries truncated answers over TCP
+			if network == "tcp" {
+				requested = network
+			}
+			return dialer.DialContext(ctx, requested, hostPort)
+		},
+	}
+	return &Verifier{
+		address: address,
+		lookup: func(ctx context.Context, name string) ([]string, error) {
+			addrs, err := resolver.LookupIP(ctx, "ip4", name)
+			if err != nil {
+				return nil, err
+			}
+			var resolved []string
+			for _, addr := range addrs {
+				resolved = append(resolved, addr.String())
+			}
+			return resolved, nil
+		},
+	}, nil
+}
+
+// isPort reports whether port is a port number
+func isPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// Check resolves the A records of name and compares them with the expected addresses.
+func (v *Verifier) Check(ctx context.Context, name string, expected []string) (Result, error) {
+	resolved, err := v.lookup(ctx, name)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to resolve %s against %s: %w", name, v.address, err)
+	}
+
+	return compare(resolved, expected), nil
+}
+
+// compare returns the differences between the resolved and the expected addresses.
+func compare(resolved, expected []string) Result {
+	resolvedSet := make(map[string]bool)
+	for _, ip := range resolved {
+		resolvedSet[ip] = true
+	}
+
+	expectedSet := make(map[string]bool)
+	for _, ip := range expected {
+		expectedSet[ip] = true
+	}
+
+	result := Result{Resolved: resolved}
+	for ip := range expectedSet {
+		if !resolvedSet[ip] {
+			result.Missing = append(result.Missing, ip)
+		}
+	}
+	for ip := range resolvedSet {
+		if !expectedSet[ip] {
+			result.Unexpected = append(result.Unexpected, ip)
+		}
+	}
+
+	// Sort so that logs are stable
+	sort.Strings(result.Missing)
+	sort.Strings(result.Unexpected)
+
+	return result
+}
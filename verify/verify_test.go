@@ -0,0 +1,218 @@
+package verify
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name               string
+		resolved           []string
+		expected           []string
+		expectedMissing    []string
+		expectedUnexpected []string
+		expectMismatch     bool
+	}{
+		{
+			name:           "resolved addresses match",
+			resolved:       []string{"2.2.2.2", "1.1.1.1"},
+			expected:       []string{"1.1.1.1", "2.2.2.2"},
+			expectMismatch: false,
+		},
+		{
+			name:            "expected address not served yet",
+			resolved:        []string{"1.1.1.1"},
+			expected:        []string{"1.1.1.1", "2.2.2.2"},
+			expectedMissing: []string{"2.2.2.2"},
+			expectMismatch:  true,
+		},
+		{
+			name:               "stale address still served",
+			resolved:           []string{"1.1.1.1", "3.3.3.3"},
+			expected:           []string{"1.1.1.1"},
+			expectedUnexpected: []string{"3.3.3.3"},
+			expectMismatch:     true,
+		},
+		{
+			name:               "nothing expected",
+			resolved:           []string{"1.1.1.1"},
+			expected:           []string{},
+			expectedUnexpected: []string{"1.1.1.1"},
+			expectMismatch:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compare(tt.resolved, tt.expected)
+
+			if !reflect.DeepEqual(result.Missing, tt.expectedMissing) {
+				t.Errorf("compare() Missing = %v, want %v", result.Missing, tt.expectedMissing)
+			}
+			if !reflect.DeepEqual(result.Unexpected, tt.expectedUnexpected) {
+				t.Errorf("compare() Unexpected = %v, want %v", result.Unexpected, tt.expectedUnexpected)
+			}
+			if result.Mismatch() != tt.expectMismatch {
+				t.Errorf("compare() Mismatch = %v, want %v", result.Mismatch(), tt.expectMismatch)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	for _, address := range []string{"1.1.1.1:53", "udp:1.1.1.1:53", "tcp:[2606:4700:4700::1111]:53", "https://cloudflare-dns.com/dns-query"} {
+		verifier, err := NewVerifier(address)
+		if err != nil {
+			t.Errorf("NewVerifier(%q) error = %v", address, err)
+			continue
+		}
+		if verifier.lookup == nil || verifier.address != address {
+			t.Errorf("NewVerifier(%q) = %+v, want a lookup against %s", address, verifier, address)
+		}
+	}
+
+	for _, address := range []string{"1.1.1.1", "quic:1.1.1.1:853", "https://"} {
+		if _, err := NewVerifier(address); err == nil {
+			t.Errorf("NewVerifier(%q) expected error but got none", address)
+		}
+	}
+}
+
+// answer answers a DNS query as an authoritative server holding the A records of test.example.com
+func answer(t *testing.T, query []byte) []byte {
+	t.Helper()
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Errorf("invalid query: %v", err)
+		return nil
+	}
+	msg.Response = true
+	msg.Authoritative = true
+	question := msg.Questions[0]
+	switch {
+	case question.Name.String() != "test.example.com.":
+		msg.RCode = dnsmessage.RCodeNameError
+	case question.Type == dnsmessage.TypeA:
+		for _, ip := range [][4]byte{{1, 1, 1, 1}, {2, 2, 2, 2}} {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: ip},
+			})
+		}
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Errorf("failed to pack the answer: %v", err)
+	}
+	return packed
+}
+
+// serveUDP runs a mock resolver over UDP, and returns its address
+func serveUDP(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(answer(t, buf[:n]), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// serveTCP runs a mock resolver over TCP, where messages are prefixed with their length, and returns its address
+func serveTCP(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var length [2]byte
+					if _, err := io.ReadFull(conn, length[:]); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					packed := answer(t, query)
+					_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestCheck(t *testing.T) {
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "not a DNS query", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(answer(t, query))
+	}))
+	defer doh.Close()
+
+	verifiers := make(map[string]*Verifier)
+	for _, address := range []string{serveUDP(t), "udp:" + serveUDP(t), "tcp:" + serveTCP(t)} {
+		verifier, err := NewVerifier(address)
+		if err != nil {
+			t.Fatalf("NewVerifier(%q) error = %v", address, err)
+		}
+		verifiers[address] = verifier
+	}
+	// The mock DNS over HTTPS resolver has a self-signed certificate, which only its own client trusts
+	client := newDoHClient(doh.URL)
+	client.client = doh.Client()
+	verifiers[doh.URL] = &Verifier{address: doh.URL, lookup: client.lookupIPv4}
+
+	for address, verifier := range verifiers {
+		t.Run(address, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			result, err := verifier.Check(ctx, "test.example.com", []string{"1.1.1.1", "3.3.3.3"})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Missing, []string{"3.3.3.3"}) || !reflect.DeepEqual(result.Unexpected, []string{"2.2.2.2"}) {
+				t.Errorf("Check() = %+v, want 3.3.3.3 missing and 2.2.2.2 unexpected", result)
+			}
+
+			if _, err := verifier.Check(ctx, "unknown.example.com", nil); err == nil {
+				t.Error("Check() of an unknown name expected error but got none")
+			}
+		})
+	}
+}